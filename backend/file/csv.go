@@ -0,0 +1,136 @@
+package file
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// csvColumns set the field of a record from a CSV column. They are named like the fields of a YAML record,
+// without the netboot prefix for the netboot fields, and matched case-insensitively.
+var csvColumns = map[string]func(v *dhcp, s string) error{
+	"ipaddress":        func(v *dhcp, s string) error { v.IPAddress = s; return nil },
+	"subnetmask":       func(v *dhcp, s string) error { v.SubnetMask = s; return nil },
+	"defaultgateway":   func(v *dhcp, s string) error { v.DefaultGateway = s; return nil },
+	"nameservers":      func(v *dhcp, s string) error { v.NameServers = csvList(s); return nil },
+	"hostname":         func(v *dhcp, s string) error { v.Hostname = s; return nil },
+	"domainname":       func(v *dhcp, s string) error { v.DomainName = s; return nil },
+	"broadcastaddress": func(v *dhcp, s string) error { v.BroadcastAddress = s; return nil },
+	"ntpservers":       func(v *dhcp, s string) error { v.NTPServers = csvList(s); return nil },
+	"vlanid":           func(v *dhcp, s string) error { v.VLANID = s; return nil },
+	"leasetime":        func(v *dhcp, s string) error { return csvInt(&v.LeaseTime, s) },
+	"arch":             func(v *dhcp, s string) error { v.Arch = s; return nil },
+	"domainsearch":     func(v *dhcp, s string) error { v.DomainSearch = csvList(s); return nil },
+	"circuitid":        func(v *dhcp, s string) error { v.CircuitID = s; return nil },
+	"remoteid":         func(v *dhcp, s string) error { v.RemoteID = s; return nil },
+	"duid":             func(v *dhcp, s string) error { v.DUID = s; return nil },
+	"ipv6address":      func(v *dhcp, s string) error { v.IPv6Address = s; return nil },
+	"ipv6nameservers":  func(v *dhcp, s string) error { v.IPv6NameServers = csvList(s); return nil },
+	"allowpxe":         func(v *dhcp, s string) error { return csvBool(&v.Netboot.AllowPXE, s) },
+	"ipxescripturl":    func(v *dhcp, s string) error { v.Netboot.IPXEScriptURL = s; return nil },
+	"ipxescript":       func(v *dhcp, s string) error { v.Netboot.IPXEScript = s; return nil },
+	"console":          func(v *dhcp, s string) error { v.Netboot.Console = s; return nil },
+	"facility":         func(v *dhcp, s string) error { v.Netboot.Facility = s; return nil },
+	"bootfilename":     func(v *dhcp, s string) error { v.Netboot.BootFileName = s; return nil },
+	"nextserver":       func(v *dhcp, s string) error { v.Netboot.NextServer = s; return nil },
+	"httpbooturl":      func(v *dhcp, s string) error { v.Netboot.HTTPBootURL = s; return nil },
+	"directboot":       func(v *dhcp, s string) error { return csvBool(&v.Netboot.DirectBoot, s) },
+	"onieinstallerurl": func(v *dhcp, s string) error { v.Netboot.ONIEInstallerURL = s; return nil },
+	"ztpscripturl":     func(v *dhcp, s string) error { v.Netboot.ZTPScriptURL = s; return nil },
+}
+
+// decodeCSV decodes a CSV file with a header row that names the columns, see csvColumns.
+// The mac column is required, it is the MAC address of the record. Empty values are not set.
+// List values, like nameServers, are separated by spaces or semicolons. Lines that start with # are comments.
+func decodeCSV(b []byte) (map[string]dhcp, []error, error) {
+	cr := csv.NewReader(bytes.NewReader(b))
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("header: %w", err)
+	}
+	mac := -1
+	set := make([]func(v *dhcp, s string) error, len(header))
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(h))
+		if name == "mac" {
+			mac = i
+			continue
+		}
+		f, ok := csvColumns[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("header: unknown column %q", h)
+		}
+		set[i] = f
+	}
+	if mac < 0 {
+		return nil, nil, errors.New("header: no mac column")
+	}
+
+	var rs records
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			rs.invalidf(line, "%d columns, the header has %d", len(row), len(header))
+			continue
+		}
+		if row[mac] == "" {
+			rs.invalidf(line, "no mac")
+			continue
+		}
+		var v dhcp
+		var errs []error
+		for i, s := range row {
+			if s = strings.TrimSpace(s); s != "" && set[i] != nil {
+				if err := set[i](&v, s); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", header[i], err))
+				}
+			}
+		}
+		if len(errs) > 0 {
+			rs.invalidf(line, "%w", errors.Join(errs...))
+			continue
+		}
+		rs.add(line, strings.TrimSpace(row[mac]), v)
+	}
+
+	return rs.r, rs.invalid, nil
+}
+
+// csvList splits a list value of a CSV column.
+func csvList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ';' || unicode.IsSpace(r) })
+}
+
+func csvInt(i *int, s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*i = n
+
+	return nil
+}
+
+func csvBool(b *bool, s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+
+	return nil
+}
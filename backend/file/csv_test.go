@@ -0,0 +1,88 @@
+package file
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	tests := map[string]struct {
+		data        string
+		want        map[string]dhcp
+		wantInvalid []string
+		wantErr     bool
+	}{
+		"columns": {
+			data: "MAC, ipv6Address ,ipv6NameServers,vlanID,arch,circuitID,remoteID,duid,directBoot,httpBootUrl\n" +
+				"00:01:02:03:04:05,2001:db8::10,2001:db8::1;2001:db8::2,100,aarch64,eth0,switch1,00:01:02,1,http://boot/ipxe.efi\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {
+				IPv6Address:     "2001:db8::10",
+				IPv6NameServers: []string{"2001:db8::1", "2001:db8::2"},
+				VLANID:          "100",
+				Arch:            "aarch64",
+				CircuitID:       "eth0",
+				RemoteID:        "switch1",
+				DUID:            "00:01:02",
+				Netboot:         netboot{DirectBoot: true, HTTPBootURL: "http://boot/ipxe.efi"},
+			}},
+		},
+		"quoted and empty values": {
+			data: "mac,hostname,nameServers\n# a comment\n00:01:02:03:04:05,\"a,b\",\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {Hostname: "a,b"}},
+		},
+		"invalid rows": {
+			data: "mac,ipAddress,leaseTime,allowPxe\n" +
+				"00:01:02:03:04:05,192.168.2.10,60,true\n" +
+				",192.168.2.11,,\n" +
+				"00:01:02:03:04:06,192.168.2.12\n" +
+				"00:01:02:03:04:07,192.168.2.13,a day,yes\n" +
+				"00:01:02:03:04:05,192.168.2.14,,\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {IPAddress: "192.168.2.10", LeaseTime: 60, Netboot: netboot{AllowPXE: true}}},
+			wantInvalid: []string{
+				"line 3: no mac",
+				"line 4: 2 columns, the header has 4",
+				"line 5: leaseTime: strconv.Atoi: parsing \"a day\": invalid syntax\nallowPxe: strconv.ParseBool: parsing \"yes\": invalid syntax",
+				"line 6: duplicate MAC address: 00:01:02:03:04:05",
+			},
+		},
+		"unknown column": {data: "mac,ip\n", wantErr: true},
+		"no mac column":  {data: "ipAddress\n", wantErr: true},
+		"empty":          {wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, invalid, err := decodeCSV([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(errorStrings(invalid), tt.wantInvalid); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// errorStrings returns the messages of errs, for comparing them with cmp.
+func errorStrings(errs []error) []string {
+	var s []string
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+
+	return s
+}
+
+func TestDecodeCSVDuplicateMAC(t *testing.T) {
+	_, invalid, err := decodeCSV([]byte("mac\n00:01:02:03:04:05\n00:01:02:03:04:05\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || !errors.Is(invalid[0], errDuplicateMAC) {
+		t.Fatalf("got invalid %v, want %v", invalid, errDuplicateMAC)
+	}
+}
@@ -0,0 +1,182 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dnsmasqLeaseTime is a lease time of dnsmasq, in seconds or with a unit.
+var dnsmasqLeaseTime = regexp.MustCompile(`^(\d+)([smhdw]?)$`)
+
+// dnsmasqUnits are the seconds of the units of a dnsmasq lease time.
+var dnsmasqUnits = map[string]int{"": 1, "s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}
+
+// dnsmasqInfinite is the lease time that never expires. It is a variable,
+// as the constant overflows int on 32 bit platforms, where it converts back to math.MaxUint32 in translate.
+var dnsmasqInfinite uint32 = math.MaxUint32
+
+// dnsmasqHost is a dhcp-host line of a dnsmasq configuration file.
+type dnsmasqHost struct {
+	line   int
+	fields []string
+}
+
+// decodeDnsmasq decodes the dhcp-host lines of a dnsmasq configuration file, see the --dhcp-host option in dnsmasq(8):
+//
+//	dhcp-host=<mac>[,<mac>...][,id:<client id>][,set:<tag>][,<ipv4 address>][,[<ipv6 address>]][,<hostname>][,<lease time>][,ignore]
+//
+// A dhcp-host line with more than one MAC address is a record for each of them. Lines with ignore are skipped.
+// The subnet mask of a record comes from the dhcp-range that has its IP address. The default gateway, name servers,
+// domain name, broadcast address, NTP servers and domain search come from dhcp-option lines without a tag,
+// and the boot file and next server from a dhcp-boot line without a tag. Other lines are ignored.
+func decodeDnsmasq(b []byte) (map[string]dhcp, []error, error) {
+	var hosts []dnsmasqHost
+	var subnets []subnet
+	var defaults dhcp
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		k, v, _ := strings.Cut(l, "=")
+		fields := strings.Split(v, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		switch strings.TrimPrefix(strings.TrimSpace(k), "--") {
+		case "dhcp-host":
+			hosts = append(hosts, dnsmasqHost{line: line, fields: fields})
+		case "dhcp-range":
+			if p, ok := dnsmasqRange(fields); ok {
+				subnets = append(subnets, subnet{prefix: p, defaults: dhcp{SubnetMask: net.IP(net.CIDRMask(p.Bits(), 32)).String()}})
+			}
+		case "dhcp-option":
+			dnsmasqOption(&defaults, fields)
+		case "dhcp-boot":
+			if strings.HasPrefix(fields[0], "tag:") {
+				continue
+			}
+			defaults.Netboot.BootFileName = fields[0]
+			if len(fields) > 2 {
+				defaults.Netboot.NextServer = fields[2]
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var rs records
+	for _, h := range hosts {
+		macs, v, ignore, err := h.parse()
+		if err != nil {
+			rs.invalidf(h.line, "dhcp-host: %w", err)
+			continue
+		}
+		if ignore {
+			continue
+		}
+		if len(macs) == 0 {
+			rs.invalidf(h.line, "dhcp-host without a MAC address")
+			continue
+		}
+		if s, ok := subnetOf(subnets, v.IPAddress); ok {
+			v = withDefaults(v, s.defaults)
+		}
+		v = withDefaults(v, defaults)
+		for _, mac := range macs {
+			rs.add(h.line, mac, v)
+		}
+	}
+
+	return rs.r, rs.invalid, nil
+}
+
+// parse returns the MAC addresses and the values of a dhcp-host line.
+func (h dnsmasqHost) parse() (macs []string, v dhcp, ignore bool, err error) {
+	for _, f := range h.fields {
+		switch {
+		case f == "":
+		case f == "ignore":
+			ignore = true
+		case strings.HasPrefix(f, "id:"), strings.HasPrefix(f, "set:"), strings.HasPrefix(f, "tag:"):
+		case strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]"):
+			v.IPv6Address = strings.Trim(f, "[]")
+		case isMAC(f):
+			macs = append(macs, f)
+		case strings.Count(f, ":") == 5 && strings.Contains(f, "*"):
+			return nil, v, false, &net.AddrError{Err: "wildcard MAC address not supported", Addr: f}
+		case f == "infinite":
+			v.LeaseTime = int(dnsmasqInfinite)
+		case dnsmasqLeaseTime.MatchString(f):
+			m := dnsmasqLeaseTime.FindStringSubmatch(f)
+			n, _ := strconv.Atoi(m[1])
+			v.LeaseTime = n * dnsmasqUnits[m[2]]
+		default:
+			if a, err := netip.ParseAddr(f); err == nil {
+				if a.Is4() {
+					v.IPAddress = f
+				} else {
+					v.IPv6Address = f
+				}
+				continue
+			}
+			v.Hostname = f
+		}
+	}
+
+	return macs, v, ignore, nil
+}
+
+// dnsmasqRange returns the subnet of a dhcp-range line with a netmask:
+// [tag:<tag>,][set:<tag>,]<start>[,<end>|<mode>][,<netmask>[,<broadcast>]][,<lease time>].
+func dnsmasqRange(fields []string) (netip.Prefix, bool) {
+	for len(fields) > 0 && (strings.HasPrefix(fields[0], "tag:") || strings.HasPrefix(fields[0], "set:")) {
+		fields = fields[1:]
+	}
+	// the netmask is after the start and end, or the start and mode, like static, of the range.
+	if len(fields) < 3 {
+		return netip.Prefix{}, false
+	}
+
+	return maskPrefix(fields[0], fields[2])
+}
+
+// dnsmasqOption sets the value of a dhcp-option line without a tag in d:
+// [tag:<tag>,]<option number>|option:<option name>,<value>[,<value>...].
+func dnsmasqOption(d *dhcp, fields []string) {
+	if len(fields) < 2 || strings.Contains(fields[0], ":") && !strings.HasPrefix(fields[0], "option:") {
+		return
+	}
+	values := fields[1:]
+	switch strings.TrimPrefix(fields[0], "option:") {
+	case "1", "netmask":
+		d.SubnetMask = values[0]
+	case "3", "router":
+		d.DefaultGateway = values[0]
+	case "6", "dns-server":
+		d.NameServers = values
+	case "15", "domain-name":
+		d.DomainName = values[0]
+	case "28", "broadcast":
+		d.BroadcastAddress = values[0]
+	case "42", "ntp-server":
+		d.NTPServers = values
+	case "119", "domain-search":
+		d.DomainSearch = values
+	}
+}
+
+// isMAC returns true if s is a MAC address.
+func isMAC(s string) bool {
+	_, err := net.ParseMAC(s)
+
+	return err == nil
+}
@@ -0,0 +1,79 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeDnsmasq(t *testing.T) {
+	tests := map[string]struct {
+		data        string
+		want        map[string]dhcp
+		wantInvalid []string
+	}{
+		"multiple macs": {
+			data: "dhcp-host=00:01:02:03:04:05,00:01:02:03:04:06,id:*,set:lab,192.168.2.10,[2001:db8::10],node1,infinite\n",
+			want: map[string]dhcp{
+				"00:01:02:03:04:05": {IPAddress: "192.168.2.10", IPv6Address: "2001:db8::10", Hostname: "node1", LeaseTime: int(dnsmasqInfinite)},
+				"00:01:02:03:04:06": {IPAddress: "192.168.2.10", IPv6Address: "2001:db8::10", Hostname: "node1", LeaseTime: int(dnsmasqInfinite)},
+			},
+		},
+		"lease time units": {
+			data: "dhcp-host=00:01:02:03:04:05,90m\ndhcp-host=00:01:02:03:04:06,2w\n",
+			want: map[string]dhcp{
+				"00:01:02:03:04:05": {LeaseTime: 5400},
+				"00:01:02:03:04:06": {LeaseTime: 1209600},
+			},
+		},
+		"ranges": {
+			data: "dhcp-range=set:a,192.168.2.0,static,255.255.255.0\n" +
+				"dhcp-range=10.0.0.10,10.0.0.20,255.255.0.0,1h\n" +
+				"dhcp-range=172.16.0.10,172.16.0.20,12h\n" +
+				"dhcp-option=1,255.255.255.128\n" +
+				"dhcp-host=00:01:02:03:04:05,192.168.2.10\n" +
+				"dhcp-host=00:01:02:03:04:06,10.0.1.10\n" +
+				"dhcp-host=00:01:02:03:04:07,172.16.0.15\n",
+			want: map[string]dhcp{
+				"00:01:02:03:04:05": {IPAddress: "192.168.2.10", SubnetMask: "255.255.255.0"},
+				"00:01:02:03:04:06": {IPAddress: "10.0.1.10", SubnetMask: "255.255.0.0"},
+				"00:01:02:03:04:07": {IPAddress: "172.16.0.15", SubnetMask: "255.255.255.128"},
+			},
+		},
+		"tagged options and boot": {
+			data: "dhcp-option=tag:lab,3,192.168.3.1\n" +
+				"dhcp-option=option6:dns-server,[2001:db8::1]\n" +
+				"dhcp-boot=tag:lab,lab.kpxe\n" +
+				"dhcp-option=3,192.168.2.1\n" +
+				"--dhcp-host=00:01:02:03:04:05,192.168.2.10\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {IPAddress: "192.168.2.10", DefaultGateway: "192.168.2.1"}},
+		},
+		"invalid hosts": {
+			data: "dhcp-host=00:01:02:03:04:05,192.168.2.10\n" +
+				"dhcp-host=00:01:02:03:04:06,ignore\n" +
+				"dhcp-host=node2,192.168.2.11\n" +
+				"dhcp-host=00:01:02:03:04:*,192.168.2.12\n" +
+				"dhcp-host=00:01:02:03:04:05,192.168.2.13\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {IPAddress: "192.168.2.10"}},
+			wantInvalid: []string{
+				"line 3: dhcp-host without a MAC address",
+				"line 4: dhcp-host: address 00:01:02:03:04:*: wildcard MAC address not supported",
+				"line 5: duplicate MAC address: 00:01:02:03:04:05",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, invalid, err := decodeDnsmasq([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(errorStrings(invalid), tt.wantInvalid); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
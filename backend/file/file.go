@@ -3,200 +3,475 @@ package file
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
-	"github.com/ghodss/yaml"
 	"github.com/go-logr/logr"
 	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/metrics"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/codes"
 )
 
-const tracerName = "github.com/tinkerbell/dhcp"
+const (
+	tracerName = "github.com/tinkerbell/dhcp"
+	// backendName is the backend label of lookup metrics.
+	backendName = "file"
+)
+
+// DefaultDebounce is the default of Watcher.Debounce.
+const DefaultDebounce = 100 * time.Millisecond
+
+// watchRetry is the time between attempts to watch the directory of the file again, after it was removed.
+const watchRetry = time.Second
 
 // Errors used by the file watcher.
 var (
 	// errFileFormat is returned when the file is not in the correct format, e.g. not valid YAML.
 	errFileFormat     = fmt.Errorf("invalid file format")
-	errRecordNotFound = fmt.Errorf("record not found")
+	errRecordNotFound = recordNotFoundError{}
 	errParseIP        = fmt.Errorf("failed to parse IP from File")
 	errParseSubnet    = fmt.Errorf("failed to parse subnet mask from File")
 	errParseURL       = fmt.Errorf("failed to parse URL")
+	errParseDUID      = fmt.Errorf("failed to parse DUID")
+	errParseOption    = fmt.Errorf("failed to parse custom DHCP option")
+	errParseRoute     = fmt.Errorf("failed to parse classless static route")
+	// errMultipleRecords is returned when more than one record matches a lookup that must be unique.
+	errMultipleRecords = fmt.Errorf("multiple records found")
+	// errDuplicateMAC and errDuplicateIP fail the validation of a file with records that have the same address.
+	errDuplicateMAC = fmt.Errorf("duplicate MAC address")
+	errDuplicateIP  = fmt.Errorf("duplicate IP address")
 )
 
+// recordNotFoundError is returned when no record is found in the file.
+// It implements the NotFound() method that handlers use to tell a missing record apart from a failing backend.
+type recordNotFoundError struct{}
+
+func (recordNotFoundError) NotFound() bool { return true }
+
+func (recordNotFoundError) Error() string { return "record not found" }
+
 // netboot is the structure for the data expected in a file.
 type netboot struct {
-	AllowPXE      bool   `yaml:"allowPxe"`      // If true, the client will be provided netboot options in the DHCP offer/ack.
-	IPXEScriptURL string `yaml:"ipxeScriptUrl"` // Overrides default value of that is passed into DHCP on startup.
-	IPXEScript    string `yaml:"ipxeScript"`    // Overrides a default value that is passed into DHCP on startup.
-	Console       string `yaml:"console"`
-	Facility      string `yaml:"facility"`
+	AllowPXE         bool   `yaml:"allowPxe"`      // If true, the client will be provided netboot options in the DHCP offer/ack.
+	IPXEScriptURL    string `yaml:"ipxeScriptUrl"` // Overrides default value of that is passed into DHCP on startup.
+	IPXEScript       string `yaml:"ipxeScript"`    // Overrides a default value that is passed into DHCP on startup.
+	Console          string `yaml:"console"`
+	Facility         string `yaml:"facility"`
+	BootFileName     string `yaml:"bootFileName"`     // Overrides the iPXE binary file name, for example grubx64.efi.
+	NextServer       string `yaml:"nextServer"`       // Overrides the TFTP server of the boot file, an IPv4 address.
+	HTTPBootURL      string `yaml:"httpBootUrl"`      // Overrides the HTTP server of the boot file, for UEFI HTTP boot clients.
+	DirectBoot       bool   `yaml:"directBoot"`       // If true, the client boots the boot file directly instead of chaining into an iPXE script.
+	ONIEInstallerURL string `yaml:"onieInstallerUrl"` // DHCP option 114, the installer of an ONIE switch.
+	ZTPScriptURL     string `yaml:"ztpScriptUrl"`     // DHCP option 67, the Zero Touch Provisioning script of an Arista or Cisco switch.
+}
+
+// option is the structure for a custom DHCP option expected in a file, see data.Option.
+type option struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+// route is the structure for a classless static route expected in a file, see data.Route.
+type route struct {
+	Destination string `yaml:"destination"` // The destination network in CIDR notation, for example 10.0.0.0/8.
+	Router      string `yaml:"router"`
 }
 
 // dhcp is the structure for the data expected in a file.
 type dhcp struct {
-	MACAddress       net.HardwareAddr // The MAC address of the client.
-	IPAddress        string           `yaml:"ipAddress"`        // yiaddr DHCP header.
-	SubnetMask       string           `yaml:"subnetMask"`       // DHCP option 1.
-	DefaultGateway   string           `yaml:"defaultGateway"`   // DHCP option 3.
-	NameServers      []string         `yaml:"nameServers"`      // DHCP option 6.
-	Hostname         string           `yaml:"hostname"`         // DHCP option 12.
-	DomainName       string           `yaml:"domainName"`       // DHCP option 15.
-	BroadcastAddress string           `yaml:"broadcastAddress"` // DHCP option 28.
-	NTPServers       []string         `yaml:"ntpServers"`       // DHCP option 42.
-	VLANID           string           `yaml:"vlanID"`           // DHCP option 43.116.
-	LeaseTime        int              `yaml:"leaseTime"`        // DHCP option 51.
-	Arch             string           `yaml:"arch"`             // DHCP option 93.
-	DomainSearch     []string         `yaml:"domainSearch"`     // DHCP option 119.
-	Netboot          netboot          `yaml:"netboot"`
+	MACAddress            net.HardwareAddr // The MAC address of the client.
+	IPAddress             string           `yaml:"ipAddress"`             // yiaddr DHCP header.
+	SubnetMask            string           `yaml:"subnetMask"`            // DHCP option 1.
+	DefaultGateway        string           `yaml:"defaultGateway"`        // DHCP option 3.
+	NameServers           []string         `yaml:"nameServers"`           // DHCP option 6.
+	Hostname              string           `yaml:"hostname"`              // DHCP option 12.
+	DomainName            string           `yaml:"domainName"`            // DHCP option 15.
+	BroadcastAddress      string           `yaml:"broadcastAddress"`      // DHCP option 28.
+	NTPServers            []string         `yaml:"ntpServers"`            // DHCP option 42.
+	VLANID                string           `yaml:"vlanID"`                // DHCP option 43.116.
+	LeaseTime             int              `yaml:"leaseTime"`             // DHCP option 51.
+	Arch                  string           `yaml:"arch"`                  // DHCP option 93.
+	DomainSearch          []string         `yaml:"domainSearch"`          // DHCP option 119.
+	ClasslessStaticRoutes []route          `yaml:"classlessStaticRoutes"` // DHCP option 121 and 249.
+	CircuitID             string           `yaml:"circuitID"`             // DHCP option 82.1, the relay agent circuit (switch port) of the client.
+	RemoteID              string           `yaml:"remoteID"`              // DHCP option 82.2, the relay agent (switch) of the client.
+	DUID                  string           `yaml:"duid"`                  // DHCPv6 option 1, colon separated hex bytes.
+	IPv6Address           string           `yaml:"ipv6Address"`           // DHCPv6 option 5.
+	IPv6NameServers       []string         `yaml:"ipv6NameServers"`       // DHCPv6 option 23.
+	Options               map[uint8]option `yaml:"options"`               // Custom DHCP options, keyed by option code.
+	Netboot               netboot          `yaml:"netboot"`
 }
 
-// Watcher represents the backend for watching a file for changes and updating the in memory DHCP data.
+// Watcher represents the backend for watching files for changes and updating the in memory DHCP data.
 type Watcher struct {
 	fileMu sync.RWMutex // protects FilePath for reads
 
-	// FilePath is the path to the file to watch.
+	// FilePath is the path to the file to watch. It can also be a directory, then all
+	// .yaml, .yml, .json, .csv and .conf files in it are used, or a glob pattern, then all files that match it are used.
+	// The records of all files are merged, files can be added and removed while the Watcher runs.
 	FilePath string
 
+	// Format is the format of the files. FormatAuto detects the format of each file.
+	// It must not be changed after the Watcher is created.
+	Format Format
+
 	// Log is the logger to be used in the File backend.
-	Log     logr.Logger
-	dataMu  sync.RWMutex // protects data
-	data    []byte       // data from file
+	Log logr.Logger
+
+	// Metrics records the duration and result of lookups.
+	// When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
+
+	// Debounce is the time to wait for more changes after the file changed, before it is read.
+	// A burst of changes, for example from an editor that writes a temporary file and renames it over the file,
+	// reads the file once. Defaults to DefaultDebounce.
+	Debounce time.Duration
+
+	dataMu  sync.RWMutex       // protects sources, seq and store
+	sources map[string]*source // the files, keyed by path
+	seq     int                // the seq of the last file that was added
+	store   *store             // data from the files, merged and indexed
 	watcher *fsnotify.Watcher
+	// targets are the paths that the files resolve to after following symlinks, keyed by the path of the file,
+	// dirs are the watched directories. Both are only used by NewWatcher and Start.
+	targets map[string]string
+	dirs    []string
+}
+
+// source is a file of a Watcher.
+type source struct {
+	// rev is the revision of the file that is used for lookups.
+	rev *revision
+	// sum is the checksum of the file when it was last read.
+	sum [sha256.Size]byte
+	// seq orders the files by when they were first read.
+	// A MAC address or IP address that is in more than one file belongs to the file that was read first.
+	seq    int
+	status Status
 }
 
-// NewWatcher creates a new file watcher.
+// NewWatcher creates a new file watcher, that detects the format of its files.
 func NewWatcher(l logr.Logger, f string) (*Watcher, error) {
+	return NewFormatWatcher(l, f, FormatAuto)
+}
+
+// NewFormatWatcher creates a new file watcher for files in format.
+func NewFormatWatcher(l logr.Logger, f string, format Format) (*Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
-	if err := watcher.Add(f); err != nil {
-		return nil, err
-	}
 
 	w := &Watcher{
 		FilePath: f,
+		Format:   format,
 		watcher:  watcher,
 		Log:      l,
 	}
+	if err := w.watch(); err != nil {
+		watcher.Close()
+		return nil, err
+	}
 
-	w.fileMu.RLock()
-	w.data, err = os.ReadFile(filepath.Clean(f))
-	w.fileMu.RUnlock()
+	ps, _, err := paths(f)
 	if err != nil {
+		watcher.Close()
 		return nil, err
 	}
+	for _, p := range ps {
+		d, err := os.ReadFile(p)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		w.setData(p, d)
+	}
 
 	return w, nil
 }
 
-// GetByMac is the implementation of the Backend interface.
-// It reads a given file from the in memory data (w.data).
-func (w *Watcher) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
-	tracer := otel.Tracer(tracerName)
-	_, span := tracer.Start(ctx, "backend.file.GetByMac")
-	defer span.End()
+// Status is the state of the data of a Watcher, or of one of its files.
+type Status struct {
+	// Loaded is the time the data used for lookups was read from the file.
+	// For a Watcher, it is the latest time of its files.
+	Loaded time.Time
+	// Records is the number of records in the data used for lookups.
+	Records int
+	// Valid is true when the data used for lookups passed validation.
+	// It is false when the file was never valid, then the newest data of the file is used.
+	// For a Watcher, it is true when all of its files are valid.
+	Valid bool
+	// Checked is the time the file was last read. For a Watcher, it is the latest time of its files.
+	Checked time.Time
+	// Err is the error from validating the file when it was last read, nil when it was valid.
+	// When set and Valid is true, lookups use the data that was last valid.
+	// For a Watcher, it has the errors of all of its files.
+	Err error
+	// Files is the status of each file of a Watcher, keyed by path. It is nil for a file.
+	Files map[string]Status
+}
+
+// Status returns the state of the data of the watcher and of each of its files.
+func (w *Watcher) Status() Status {
+	w.dataMu.RLock()
+	defer w.dataMu.RUnlock()
+
+	s := Status{Valid: true, Files: make(map[string]Status, len(w.sources))}
+	if w.store != nil {
+		s.Records = w.store.records
+	}
+	ps := make([]string, 0, len(w.sources))
+	for p := range w.sources {
+		ps = append(ps, p)
+	}
+	slices.Sort(ps)
+	var errs []error
+	for _, p := range ps {
+		fs := w.sources[p].status
+		s.Files[p] = fs
+		s.Valid = s.Valid && fs.Valid
+		if fs.Loaded.After(s.Loaded) {
+			s.Loaded = fs.Loaded
+		}
+		if fs.Checked.After(s.Checked) {
+			s.Checked = fs.Checked
+		}
+		if fs.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, fs.Err))
+		}
+	}
+	s.Err = errors.Join(errs...)
+
+	return s
+}
 
-	// get data from file, translate it, then pass it into setDHCPOpts and setNetworkBootOpts
+// setData parses and validates d, the content of the file at path, and replaces the in memory data of the file with it.
+// d must not have MAC addresses or IP addresses of the other files.
+// When d fails validation the last valid data of the file is kept, if there is any.
+func (w *Watcher) setData(path string, d []byte) {
+	sum := sha256.Sum256(d)
 	w.dataMu.RLock()
-	d := w.data
+	src, ok := w.sources[path]
+	unchanged := ok && src.sum == sum && src.status.Err == nil
 	w.dataMu.RUnlock()
-	r := make(map[string]dhcp)
-	if err := yaml.Unmarshal(d, &r); err != nil {
-		err := fmt.Errorf("%w: %w", err, errFileFormat)
-		w.Log.Error(err, "failed to unmarshal file data")
-		span.SetStatus(codes.Error, err.Error())
-
-		return nil, nil, err
-	}
-	for k, v := range r {
-		if strings.EqualFold(k, mac.String()) {
-			// found a record for this mac address
-			v.MACAddress = mac
-			d, n, err := w.translate(v)
-			if err != nil {
-				span.SetStatus(codes.Error, err.Error())
-
-				return nil, nil, err
-			}
-			span.SetAttributes(d.EncodeToAttributes()...)
-			span.SetAttributes(n.EncodeToAttributes()...)
-			span.SetStatus(codes.Ok, "")
+	if unchanged {
+		return
+	}
 
-			return d, n, nil
+	rev := w.parse(path, d)
+	now := time.Now()
+	w.dataMu.Lock()
+	if w.sources == nil {
+		w.sources = make(map[string]*source)
+	}
+	src, ok = w.sources[path]
+	if !ok {
+		w.seq++
+		src = &source{seq: w.seq}
+		w.sources[path] = src
+	}
+	if rev.invalid == nil {
+		rev.invalid = w.merge(path).conflicts(rev)
+	}
+	keep := rev.invalid != nil && src.status.Valid
+	if !keep {
+		src.rev = rev
+		src.status.Loaded = now
+		src.status.Records = len(rev.records)
+		src.status.Valid = rev.invalid == nil
+	}
+	src.sum = sum
+	src.status.Checked = now
+	src.status.Err = rev.invalid
+	status := src.status
+	w.store = w.merge("")
+	w.dataMu.Unlock()
+
+	switch {
+	case rev.invalid == nil:
+		w.Metrics.BackendReload(backendName, metrics.ReloadSuccess)
+	case keep:
+		w.Log.Error(rev.invalid, "file failed validation, keeping the last valid data", "file", path, "loaded", status.Loaded)
+		w.Metrics.BackendReload(backendName, metrics.ReloadFailed)
+	default:
+		w.Log.Error(rev.invalid, "file failed validation, no valid data to keep", "file", path)
+		w.Metrics.BackendReload(backendName, metrics.ReloadFailed)
+	}
+	all := w.Status()
+	w.Metrics.BackendData(backendName, all.Records, all.Loaded)
+}
+
+// removeData removes the in memory data of the files that are not in ps.
+func (w *Watcher) removeData(ps []string) {
+	var removed []string
+	w.dataMu.Lock()
+	for p := range w.sources {
+		if !slices.Contains(ps, p) {
+			delete(w.sources, p)
+			removed = append(removed, p)
+		}
+	}
+	if len(removed) > 0 {
+		w.store = w.merge("")
+	}
+	w.dataMu.Unlock()
+
+	slices.Sort(removed)
+	for _, p := range removed {
+		w.Log.Info("file removed, dropping its records", "file", p)
+	}
+	if len(removed) > 0 {
+		all := w.Status()
+		w.Metrics.BackendData(backendName, all.Records, all.Loaded)
+	}
+}
+
+// merge returns the merged data of all files except skip, w.dataMu must be held.
+func (w *Watcher) merge(skip string) *store {
+	srcs := make([]*source, 0, len(w.sources))
+	for p, src := range w.sources {
+		if p != skip && src.rev != nil {
+			srcs = append(srcs, src)
 		}
 	}
+	slices.SortFunc(srcs, func(a, b *source) int { return a.seq - b.seq })
+	revs := make([]*revision, 0, len(srcs))
+	for _, src := range srcs {
+		revs = append(revs, src.rev)
+	}
+
+	return newStore(revs)
+}
+
+// records returns the parsed and indexed in memory data.
+func (w *Watcher) records() *store {
+	w.dataMu.RLock()
+	defer w.dataMu.RUnlock()
+	if w.store == nil {
+		return &store{}
+	}
+
+	return w.store
+}
+
+// GetByMac is the implementation of the Backend interface.
+// It reads a given file from the in memory data (w.store).
+func (w *Watcher) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := w.getByMac(ctx, mac)
+	w.Metrics.BackendLookup(backendName, "GetByMac", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
 
-	err := fmt.Errorf("%w: %s", errRecordNotFound, mac.String())
-	span.SetStatus(codes.Error, err.Error())
+// getByMac does the lookup for GetByMac.
+func (w *Watcher) getByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.GetByMac")
+	defer span.End()
 
-	return nil, nil, err
+	rec, err := w.records().mac(mac)
+
+	return result(span, rec, err)
 }
 
 // GetByIP is the implementation of the Backend interface.
-// It reads a given file from the in memory data (w.data).
+// It reads a given file from the in memory data (w.store).
 func (w *Watcher) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := w.getByIP(ctx, ip)
+	w.Metrics.BackendLookup(backendName, "GetByIP", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByIP does the lookup for GetByIP.
+func (w *Watcher) getByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
 	tracer := otel.Tracer(tracerName)
 	_, span := tracer.Start(ctx, "backend.file.GetByIP")
 	defer span.End()
 
-	// get data from file, translate it, then pass it into setDHCPOpts and setNetworkBootOpts
-	w.dataMu.RLock()
-	d := w.data
-	w.dataMu.RUnlock()
-	r := make(map[string]dhcp)
-	if err := yaml.Unmarshal(d, &r); err != nil {
-		err := fmt.Errorf("%w: %w", err, errFileFormat)
-		w.Log.Error(err, "failed to unmarshal file data")
-		span.SetStatus(codes.Error, err.Error())
-
-		return nil, nil, err
-	}
-	for k, v := range r {
-		if v.IPAddress == ip.String() {
-			// found a record for this ip address
-			v.IPAddress = ip.String()
-			mac, err := net.ParseMAC(k)
-			if err != nil {
-				err := fmt.Errorf("%w: %w", err, errFileFormat)
-				w.Log.Error(err, "failed to parse mac address")
-				span.SetStatus(codes.Error, err.Error())
-
-				return nil, nil, err
-			}
-			v.MACAddress = mac
-			d, n, err := w.translate(v)
-			if err != nil {
-				span.SetStatus(codes.Error, err.Error())
+	rec, err := w.records().ip(ip)
 
-				return nil, nil, err
-			}
-			span.SetAttributes(d.EncodeToAttributes()...)
-			span.SetAttributes(n.EncodeToAttributes()...)
-			span.SetStatus(codes.Ok, "")
+	return result(span, rec, err)
+}
 
-			return d, n, nil
-		}
-	}
+// GetByCircuitID is the implementation of the handler.CircuitReader interface.
+// It reads a given file from the in memory data (w.store).
+// A record without a remoteID matches any remoteID.
+func (w *Watcher) GetByCircuitID(ctx context.Context, circuitID, remoteID string) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := w.getByCircuitID(ctx, circuitID, remoteID)
+	w.Metrics.BackendLookup(backendName, "GetByCircuitID", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByCircuitID does the lookup for GetByCircuitID.
+func (w *Watcher) getByCircuitID(ctx context.Context, circuitID, remoteID string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.GetByCircuitID")
+	defer span.End()
+
+	rec, err := w.records().circuitID(circuitID, remoteID)
+
+	return result(span, rec, err)
+}
+
+// GetByDUID is the implementation of the handler.DUIDReader interface.
+// It reads a given file from the in memory data (w.store).
+func (w *Watcher) GetByDUID(ctx context.Context, duid []byte) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := w.getByDUID(ctx, duid)
+	w.Metrics.BackendLookup(backendName, "GetByDUID", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByDUID does the lookup for GetByDUID.
+func (w *Watcher) getByDUID(ctx context.Context, duid []byte) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.GetByDUID")
+	defer span.End()
 
-	err := fmt.Errorf("%w: %s", errRecordNotFound, ip.String())
-	span.SetStatus(codes.Error, err.Error())
+	rec, err := w.records().duid(duid)
 
-	return nil, nil, err
+	return result(span, rec, err)
 }
 
-// Start starts watching a file for changes and updates the in memory data (w.data) on changes.
+// parseDUID parses a DUID in colon separated hex bytes, for example "00:03:00:01:b4:96:91:6f:33:d0".
+func parseDUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("%w: %q", errParseDUID, s)
+	}
+
+	return b, nil
+}
+
+// Start starts watching the files for changes and updates the in memory data (w.store) on changes.
+// A file is parsed once per change, lookups use the parsed data.
+// The directories of the files are watched, not the files themselves, so changes are seen when a file is replaced,
+// removed and created again, or when a symlink in its path is retargeted, like in a Kubernetes ConfigMap volume.
 // Start is a blocking method. Use a context cancellation to exit.
 func (w *Watcher) Start(ctx context.Context) {
+	// Metrics is usually set after NewWatcher read the files.
+	if status := w.Status(); !status.Loaded.IsZero() {
+		w.Metrics.BackendData(backendName, status.Records, status.Loaded)
+	}
+	var reload <-chan time.Time
 	for {
 		select {
 		case <-ctx.Done():
@@ -206,18 +481,15 @@ func (w *Watcher) Start(ctx context.Context) {
 			if !ok {
 				continue
 			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				w.Log.Info("file changed, updating cache")
-				w.fileMu.RLock()
-				d, err := os.ReadFile(w.FilePath)
-				w.fileMu.RUnlock()
-				if err != nil {
-					w.Log.Error(err, "failed to read file", "file", w.FilePath)
-					break
-				}
-				w.dataMu.Lock()
-				w.data = d
-				w.dataMu.Unlock()
+			if w.changed(event) {
+				// a change is often a burst of events, the files are read when they stop.
+				reload = time.After(w.debounce())
+			}
+		case <-reload:
+			reload = nil
+			if err := w.reload(); err != nil {
+				// the directory of a file is gone, try again until it is back.
+				reload = time.After(watchRetry)
 			}
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
@@ -228,25 +500,213 @@ func (w *Watcher) Start(ctx context.Context) {
 	}
 }
 
+// reload watches the directories of the files again and reads the files into the in memory data.
+// When a file can't be read, its in memory data is kept. Files of a directory or glob pattern that are gone are removed.
+// It returns the error from watching the directories.
+func (w *Watcher) reload() error {
+	w.Log.Info("file changed, updating cache")
+	werr := w.watch()
+	if werr != nil {
+		w.Log.Error(werr, "failed to watch file", "file", w.filePath())
+	}
+	f := w.filePath()
+	ps, multiple, err := paths(f)
+	if err != nil {
+		w.Log.Error(err, "failed to list files", "file", f)
+		return werr
+	}
+	read := make(map[string][]byte, len(ps))
+	for _, p := range ps {
+		d, err := os.ReadFile(p)
+		if err != nil {
+			w.Log.Error(err, "failed to read file", "file", p)
+			continue
+		}
+		read[p] = d
+		w.setData(p, d)
+	}
+	if multiple || len(read) > 0 {
+		w.removeData(ps)
+	}
+	// a MAC address or IP address that moved between files conflicts when the file it moved to is read first.
+	for _, p := range ps {
+		if d, ok := read[p]; ok && w.conflicting(p) {
+			w.setData(p, d)
+		}
+	}
+
+	return werr
+}
+
+// conflicting returns true if the file at path failed validation because of MAC addresses or IP addresses of other files.
+func (w *Watcher) conflicting(path string) bool {
+	w.dataMu.RLock()
+	defer w.dataMu.RUnlock()
+	src, ok := w.sources[path]
+	if !ok {
+		return false
+	}
+
+	return errors.Is(src.status.Err, errDuplicateMAC) || errors.Is(src.status.Err, errDuplicateIP)
+}
+
+// filePath returns FilePath.
+func (w *Watcher) filePath() string {
+	w.fileMu.RLock()
+	defer w.fileMu.RUnlock()
+
+	return w.FilePath
+}
+
+// watch watches the directory of FilePath, FilePath itself when it is a directory,
+// and the directories of the files that the files resolve to.
+// Directories that are no longer needed, after a symlink was retargeted, are not watched anymore.
+func (w *Watcher) watch() error {
+	f := filepath.Clean(w.filePath())
+	ps, multiple, _ := paths(f)
+	var dirs []string
+	add := func(d string) {
+		if !hasMeta(d) && !slices.Contains(dirs, d) {
+			dirs = append(dirs, d)
+		}
+	}
+	add(filepath.Dir(f))
+	if multiple {
+		add(f)
+	}
+	targets := make(map[string]string, len(ps))
+	for _, p := range ps {
+		target, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			// the file doesn't exist, its directory is watched for it to be created.
+			target = p
+		}
+		targets[p] = target
+		add(filepath.Dir(target))
+	}
+	for _, d := range w.dirs {
+		if !slices.Contains(dirs, d) {
+			_ = w.watcher.Remove(d)
+		}
+	}
+	w.targets = targets
+	w.dirs = dirs
+	var errs []error
+	for _, d := range dirs {
+		if err := w.watcher.Add(d); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// changed returns true if event can change the content of the files.
+func (w *Watcher) changed(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	if matches(w.filePath(), name) || slices.Contains(w.dirs, name) {
+		return true
+	}
+	for p, target := range w.targets {
+		if name == p || name == target {
+			return true
+		}
+	}
+	// a symlink in the path of a file was retargeted.
+	for p, target := range w.targets {
+		if t, err := filepath.EvalSymlinks(p); err == nil && t != target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// debounce returns the time to wait after a change before the file is read.
+func (w *Watcher) debounce() time.Duration {
+	if w.Debounce > 0 {
+		return w.Debounce
+	}
+
+	return DefaultDebounce
+}
+
 // translate converts the data from the file into a data.DHCP and data.Netboot structs.
 func (w *Watcher) translate(r dhcp) (*data.DHCP, *data.Netboot, error) {
 	d := new(data.DHCP)
 	n := new(data.Netboot)
 
 	d.MACAddress = r.MACAddress
-	// ip address, required
-	ip, err := netip.ParseAddr(r.IPAddress)
-	if err != nil {
-		return nil, nil, fmt.Errorf("%w: %w", err, errParseIP)
+	// ip address and subnet mask, required unless the record only has an IPv6 address
+	if r.IPAddress != "" || r.IPv6Address == "" {
+		ip, err := netip.ParseAddr(r.IPAddress)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", err, errParseIP)
+		}
+		d.IPAddress = ip
+
+		sm := net.ParseIP(r.SubnetMask)
+		if sm == nil {
+			return nil, nil, errParseSubnet
+		}
+		d.SubnetMask = net.IPMask(sm.To4())
+	}
+
+	// ipv6 address, optional
+	if r.IPv6Address != "" {
+		ip, err := netip.ParseAddr(r.IPv6Address)
+		if err != nil || !ip.Is6() {
+			return nil, nil, fmt.Errorf("%w: ipv6Address %q", errParseIP, r.IPv6Address)
+		}
+		d.IPv6Address = ip
+	}
+
+	// duid, optional
+	if r.DUID != "" {
+		duid, err := parseDUID(r.DUID)
+		if err != nil {
+			return nil, nil, err
+		}
+		d.DUID = duid
+	}
+
+	// ipv6 name servers, optional
+	for _, s := range r.IPv6NameServers {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			w.Log.Info("failed to parse ipv6 name server", "ipv6NameServer", s)
+			break
+		}
+		d.IPv6NameServers = append(d.IPv6NameServers, ip)
+	}
+
+	// classless static routes, optional, but must be valid if present
+	for _, rt := range r.ClasslessStaticRoutes {
+		dst, err := netip.ParsePrefix(rt.Destination)
+		if err != nil || !dst.Addr().Is4() {
+			return nil, nil, fmt.Errorf("%w: destination %q", errParseRoute, rt.Destination)
+		}
+		rtr, err := netip.ParseAddr(rt.Router)
+		if err != nil || !rtr.Is4() {
+			return nil, nil, fmt.Errorf("%w: router %q", errParseRoute, rt.Router)
+		}
+		d.ClasslessStaticRoutes = append(d.ClasslessStaticRoutes, data.Route{Destination: dst, Router: rtr})
 	}
-	d.IPAddress = ip
 
-	// subnet mask, required
-	sm := net.ParseIP(r.SubnetMask)
-	if sm == nil {
-		return nil, nil, errParseSubnet
+	// custom options, optional, but must be valid if present
+	for code, o := range r.Options {
+		opt := data.Option{Type: data.OptionType(o.Type), Value: o.Value}
+		if err := opt.Validate(code); err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", err, errParseOption)
+		}
+		if d.Options == nil {
+			d.Options = make(map[uint8]data.Option)
+		}
+		d.Options[code] = opt
 	}
-	d.SubnetMask = net.IPMask(sm.To4())
 
 	// default gateway, optional
 	if dg, err := netip.ParseAddr(r.DefaultGateway); err != nil {
@@ -327,5 +787,39 @@ func (w *Watcher) translate(r dhcp) (*data.DHCP, *data.Netboot, error) {
 		n.Facility = r.Netboot.Facility
 	}
 
+	// boot overrides
+	n.BootFileName = r.Netboot.BootFileName
+	if r.Netboot.NextServer != "" {
+		ns, err := netip.ParseAddr(r.Netboot.NextServer)
+		if err != nil || !ns.Is4() {
+			return nil, nil, fmt.Errorf("%w: nextServer %q", errParseIP, r.Netboot.NextServer)
+		}
+		n.NextServer = ns
+	}
+	if r.Netboot.HTTPBootURL != "" {
+		u, err := url.ParseRequestURI(r.Netboot.HTTPBootURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", err, errParseURL)
+		}
+		n.HTTPBootURL = u
+	}
+	n.DirectBoot = r.Netboot.DirectBoot
+
+	// switch provisioning
+	if r.Netboot.ONIEInstallerURL != "" {
+		u, err := url.ParseRequestURI(r.Netboot.ONIEInstallerURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", err, errParseURL)
+		}
+		n.ONIEInstallerURL = u
+	}
+	if r.Netboot.ZTPScriptURL != "" {
+		u, err := url.ParseRequestURI(r.Netboot.ZTPScriptURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", err, errParseURL)
+		}
+		n.ZTPScriptURL = u
+	}
+
 	return d, n, nil
 }
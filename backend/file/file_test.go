@@ -11,6 +11,8 @@ import (
 	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -51,7 +53,7 @@ func TestNewWatcher(t *testing.T) {
 			if tt.wantErr != nil {
 				got = ""
 			} else {
-				got = string(w.data)
+				got = w.fileData(name)
 			}
 			if diff := cmp.Diff(got, tt.want); diff != "" {
 				t.Fatal(diff)
@@ -60,6 +62,17 @@ func TestNewWatcher(t *testing.T) {
 	}
 }
 
+// fileData returns the data of the file at path that is used for lookups.
+func (w *Watcher) fileData(path string) string {
+	w.dataMu.RLock()
+	defer w.dataMu.RUnlock()
+	if src, ok := w.sources[path]; ok {
+		return string(src.rev.data)
+	}
+
+	return ""
+}
+
 func createFile(content []byte) (string, error) {
 	file, err := os.CreateTemp("", "prefix")
 	if err != nil {
@@ -102,11 +115,15 @@ func TestStartFileUpdateError(t *testing.T) {
 	l := stdr.New(log.New(out, "", 0))
 	got, name := tt.helper(t, l)
 	defer os.Remove(name)
+	got.Debounce = time.Millisecond
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		<-time.After(time.Millisecond)
+		got.fileMu.Lock()
 		got.FilePath = "not-found.txt"
-		got.watcher.Events <- fsnotify.Event{Op: fsnotify.Write}
+		got.fileMu.Unlock()
+		got.watcher.Events <- fsnotify.Event{Name: "not-found.txt", Op: fsnotify.Write}
+		time.Sleep(100 * time.Millisecond)
 		cancel()
 	}()
 	got.Start(ctx)
@@ -120,6 +137,7 @@ func TestStartFileUpdate(t *testing.T) {
 	tt := &testData{initial: "once upon a time", after: "\nhello world", expectedOut: "once upon a time\nhello world"}
 	got, name := tt.helper(t, logr.Discard())
 	defer os.Remove(name)
+	got.Debounce = time.Millisecond
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		<-time.After(time.Millisecond)
@@ -131,13 +149,11 @@ func TestStartFileUpdate(t *testing.T) {
 		f.Write([]byte(tt.after))
 		f.Close()
 		got.fileMu.Unlock()
-		time.Sleep(time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
 		cancel()
 	}()
 	got.Start(ctx)
-	got.dataMu.RLock()
-	d := got.data
-	got.dataMu.RUnlock()
+	d := got.fileData(name)
 	if diff := cmp.Diff(string(d), tt.expectedOut); diff != "" {
 		t.Log(string(d))
 		t.Fatal(diff)
@@ -211,9 +227,7 @@ func (tt *testData) helper(t *testing.T, l logr.Logger) (*Watcher, string) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.dataMu.RLock()
-	before := string(w.data)
-	w.dataMu.RUnlock()
+	before := w.fileData(name)
 	if diff := cmp.Diff(before, tt.initial); diff != "" {
 		t.Fatal("before", diff)
 	}
@@ -236,12 +250,25 @@ func TestTranslate(t *testing.T) {
 		LeaseTime:        86400,
 		Arch:             "x86_64",
 		DomainSearch:     []string{"example.com"},
+		ClasslessStaticRoutes: []route{
+			{Destination: "10.0.0.0/8", Router: "192.168.2.2"},
+		},
+		Options: map[uint8]option{
+			66:  {Type: "string", Value: "tftp.example.com"},
+			150: {Type: "ip-list", Value: "192.168.2.1"},
+		},
 		Netboot: netboot{
-			AllowPXE:      true,
-			IPXEScriptURL: "http://boot.netboot.xyz",
-			IPXEScript:    "#!ipxe\nchain http://boot.netboot.xyz",
-			Console:       "ttyS0",
-			Facility:      "onprem",
+			AllowPXE:         true,
+			IPXEScriptURL:    "http://boot.netboot.xyz",
+			IPXEScript:       "#!ipxe\nchain http://boot.netboot.xyz",
+			Console:          "ttyS0",
+			Facility:         "onprem",
+			BootFileName:     "grubx64.efi",
+			NextServer:       "192.168.2.5",
+			HTTPBootURL:      "http://192.168.2.5:8080/grub",
+			DirectBoot:       true,
+			ONIEInstallerURL: "http://192.168.2.5/onie-installer",
+			ZTPScriptURL:     "tftp://192.168.2.5/poap.py",
 		},
 	}
 	wantDHCP := &data.DHCP{
@@ -258,23 +285,36 @@ func TestTranslate(t *testing.T) {
 		LeaseTime:        86400,
 		Arch:             "x86_64",
 		DomainSearch:     []string{"example.com"},
+		ClasslessStaticRoutes: []data.Route{
+			{Destination: netip.MustParsePrefix("10.0.0.0/8"), Router: netip.MustParseAddr("192.168.2.2")},
+		},
+		Options: map[uint8]data.Option{
+			66:  {Type: data.OptionTypeString, Value: "tftp.example.com"},
+			150: {Type: data.OptionTypeIPList, Value: "192.168.2.1"},
+		},
 	}
 	wantNetboot := &data.Netboot{
-		AllowNetboot:  true,
-		IPXEScriptURL: &url.URL{Scheme: "http", Host: "boot.netboot.xyz"},
-		IPXEScript:    "#!ipxe\nchain http://boot.netboot.xyz",
-		Console:       "ttyS0",
-		Facility:      "onprem",
+		AllowNetboot:     true,
+		IPXEScriptURL:    &url.URL{Scheme: "http", Host: "boot.netboot.xyz"},
+		IPXEScript:       "#!ipxe\nchain http://boot.netboot.xyz",
+		Console:          "ttyS0",
+		Facility:         "onprem",
+		BootFileName:     "grubx64.efi",
+		NextServer:       netip.MustParseAddr("192.168.2.5"),
+		HTTPBootURL:      &url.URL{Scheme: "http", Host: "192.168.2.5:8080", Path: "/grub"},
+		DirectBoot:       true,
+		ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.2.5", Path: "/onie-installer"},
+		ZTPScriptURL:     &url.URL{Scheme: "tftp", Host: "192.168.2.5", Path: "/poap.py"},
 	}
 	w := &Watcher{Log: logr.Discard()}
 	gotDHCP, gotNetboot, err := w.translate(input)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if diff := cmp.Diff(gotDHCP, wantDHCP, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
+	if diff := cmp.Diff(gotDHCP, wantDHCP, cmpopts.IgnoreUnexported(netip.Addr{}, netip.Prefix{})); diff != "" {
 		t.Error(diff)
 	}
-	if diff := cmp.Diff(gotNetboot, wantNetboot); diff != "" {
+	if diff := cmp.Diff(gotNetboot, wantNetboot, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
 		t.Error(diff)
 	}
 }
@@ -284,13 +324,21 @@ func TestTranslateErrors(t *testing.T) {
 		input   dhcp
 		wantErr error
 	}{
-		"invalid IP":                {input: dhcp{IPAddress: "not an IP"}, wantErr: errParseIP},
-		"invalid subnet mask":       {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "not a mask"}, wantErr: errParseSubnet},
-		"invalid gateway":           {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255", DefaultGateway: "not a gateway"}, wantErr: nil},
-		"invalid broadcast address": {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255"}, wantErr: nil},
-		"invalid NameServers":       {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255", NameServers: []string{"no good"}}, wantErr: nil},
-		"invalid ntpservers":        {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255", NTPServers: []string{"no good"}}, wantErr: nil},
-		"invalid ipxe script url":   {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Netboot: netboot{IPXEScriptURL: ":not a url"}}, wantErr: errParseURL},
+		"invalid IP":                 {input: dhcp{IPAddress: "not an IP"}, wantErr: errParseIP},
+		"invalid subnet mask":        {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "not a mask"}, wantErr: errParseSubnet},
+		"invalid gateway":            {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255", DefaultGateway: "not a gateway"}, wantErr: nil},
+		"invalid broadcast address":  {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255"}, wantErr: nil},
+		"invalid NameServers":        {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255", NameServers: []string{"no good"}}, wantErr: nil},
+		"invalid ntpservers":         {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "192.168.1.255", NTPServers: []string{"no good"}}, wantErr: nil},
+		"invalid ipxe script url":    {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Netboot: netboot{IPXEScriptURL: ":not a url"}}, wantErr: errParseURL},
+		"invalid next server":        {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Netboot: netboot{NextServer: "2001:db8::1"}}, wantErr: errParseIP},
+		"invalid http boot url":      {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Netboot: netboot{HTTPBootURL: "grub"}}, wantErr: errParseURL},
+		"invalid onie installer url": {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Netboot: netboot{ONIEInstallerURL: "onie-installer"}}, wantErr: errParseURL},
+		"invalid ztp script url":     {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Netboot: netboot{ZTPScriptURL: "poap.py"}}, wantErr: errParseURL},
+		"invalid route destination":  {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", ClasslessStaticRoutes: []route{{Destination: "10.0.0.0", Router: "192.168.2.2"}}}, wantErr: errParseRoute},
+		"invalid route router":       {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", ClasslessStaticRoutes: []route{{Destination: "10.0.0.0/8", Router: "2001:db8::1"}}}, wantErr: errParseRoute},
+		"invalid option type":        {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Options: map[uint8]option{150: {Type: "string", Value: "tftp"}}}, wantErr: errParseOption},
+		"invalid option value":       {input: dhcp{IPAddress: "1.1.1.1", SubnetMask: "255.255.255.0", Options: map[uint8]option{224: {Type: "uint8", Value: "256"}}}, wantErr: errParseOption},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -370,3 +418,503 @@ func TestGetByIP(t *testing.T) {
 		})
 	}
 }
+
+func TestGetByCircuitID(t *testing.T) {
+	tests := map[string]struct {
+		circuitID string
+		remoteID  string
+		data      string
+		wantMAC   net.HardwareAddr
+		wantErr   error
+	}{
+		"record found":          {circuitID: "Ethernet1/15", remoteID: "leaf01", wantMAC: net.HardwareAddr{0xb4, 0x96, 0x91, 0x6f, 0x33, 0xd0}},
+		"different remote id":   {circuitID: "Ethernet1/15", remoteID: "leaf02", wantErr: errRecordNotFound},
+		"no record found":       {circuitID: "Ethernet1/16", remoteID: "leaf01", wantErr: errRecordNotFound},
+		"empty circuit id":      {wantErr: errRecordNotFound},
+		"any remote id":         {circuitID: "eth0", remoteID: "leaf01", data: "00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n  circuitID: 'eth0'\n", wantMAC: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}},
+		"fail multiple records": {circuitID: "eth0", data: "00:01:02:03:04:05:\n  circuitID: 'eth0'\n00:01:02:03:04:06:\n  circuitID: 'eth0'\n", wantErr: errMultipleRecords},
+		"fail parsing file":     {data: "not a yaml file", wantErr: errFileFormat},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := "testdata/example.yaml"
+			if tt.data != "" {
+				var err error
+				data, err = createFile([]byte(tt.data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(data)
+			}
+			w, err := NewWatcher(logr.Discard(), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, _, err := w.GetByCircuitID(context.Background(), tt.circuitID, tt.remoteID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatal(err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(d.MACAddress, tt.wantMAC); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestGetByDUID(t *testing.T) {
+	tests := map[string]struct {
+		duid    []byte
+		data    string
+		wantMAC net.HardwareAddr
+		wantIP  netip.Addr
+		wantErr error
+	}{
+		"record found":          {duid: []byte{0x00, 0x03, 0x00, 0x01, 0xb4, 0x96, 0x91, 0x6f, 0x33, 0xd0}, wantMAC: net.HardwareAddr{0xb4, 0x96, 0x91, 0x6f, 0x33, 0xd0}, wantIP: netip.MustParseAddr("2001:db8::15")},
+		"no record found":       {duid: []byte{0x00, 0x03, 0x00, 0x01, 0xb4, 0x96, 0x91, 0x6f, 0x33, 0xd1}, wantErr: errRecordNotFound},
+		"empty duid":            {wantErr: errRecordNotFound},
+		"ipv6 only":             {duid: []byte{0x00, 0x01}, data: "00:01:02:03:04:05:\n  duid: '00:01'\n  ipv6Address: '2001:db8::10'\n", wantMAC: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, wantIP: netip.MustParseAddr("2001:db8::10")},
+		"fail multiple records": {duid: []byte{0x00, 0x01}, data: "00:01:02:03:04:05:\n  duid: '00:01'\n00:01:02:03:04:06:\n  duid: '00:01'\n", wantErr: errMultipleRecords},
+		"fail parsing file":     {data: "not a yaml file", wantErr: errFileFormat},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := "testdata/example.yaml"
+			if tt.data != "" {
+				var err error
+				data, err = createFile([]byte(tt.data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(data)
+			}
+			w, err := NewWatcher(logr.Discard(), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, _, err := w.GetByDUID(context.Background(), tt.duid)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatal(err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(d.MACAddress, tt.wantMAC); diff != "" {
+				t.Fatal(diff)
+			}
+			if d.IPv6Address != tt.wantIP {
+				t.Fatalf("got ipv6 address %v, want %v", d.IPv6Address, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestGetByMacAfterUpdate(t *testing.T) {
+	name, err := createFile([]byte("00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	w, err := NewWatcher(logr.Discard(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.watcher.Close()
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	d, _, err := w.GetByMac(context.Background(), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the returned data is a copy, changing it must not change the in memory data.
+	d.IPAddress = netip.MustParseAddr("192.168.2.99")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+	if _, _, err := w.GetByIP(context.Background(), net.IPv4(192, 168, 2, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte("00:01:02:03:04:05:\n  ipAddress: '192.168.2.11'\n  subnetMask: '255.255.255.0'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := netip.MustParseAddr("192.168.2.11")
+	for i := 0; ; i++ {
+		d, _, err = w.GetByMac(context.Background(), mac)
+		if err == nil && d.IPAddress == want {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("got %v, %v, want ip address %v", d, err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, err := w.GetByIP(context.Background(), net.IPv4(192, 168, 2, 10)); !errors.Is(err, errRecordNotFound) {
+		t.Fatalf("got %v, want %v", err, errRecordNotFound)
+	}
+}
+
+func BenchmarkGetByMac(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < 8000; i++ {
+		fmt.Fprintf(&buf, "00:00:00:00:%02x:%02x:\n  ipAddress: '10.0.%d.%d'\n  subnetMask: '255.255.0.0'\n", i/256, i%256, i/256, i%256)
+	}
+	name, err := createFile(buf.Bytes())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(name)
+	w, err := NewWatcher(logr.Discard(), name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x1f, 0x3f}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := w.GetByMac(context.Background(), mac); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStartFileReplaced(t *testing.T) {
+	write := func(t *testing.T, name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(t *testing.T, target, name string) {
+		t.Helper()
+		// replace the symlink atomically, like kubelet does for ConfigMap volumes.
+		if err := os.Symlink(target, name+"_tmp"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(name+"_tmp", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]struct {
+		setup  func(t *testing.T, dir string) string
+		change func(t *testing.T, dir string)
+	}{
+		"written": {
+			setup: func(t *testing.T, dir string) string {
+				write(t, filepath.Join(dir, "hosts.yaml"), "before")
+				return filepath.Join(dir, "hosts.yaml")
+			},
+			change: func(t *testing.T, dir string) { write(t, filepath.Join(dir, "hosts.yaml"), "after") },
+		},
+		"renamed over": {
+			setup: func(t *testing.T, dir string) string {
+				write(t, filepath.Join(dir, "hosts.yaml"), "before")
+				return filepath.Join(dir, "hosts.yaml")
+			},
+			change: func(t *testing.T, dir string) {
+				write(t, filepath.Join(dir, ".hosts.yaml.swp"), "after")
+				if err := os.Rename(filepath.Join(dir, ".hosts.yaml.swp"), filepath.Join(dir, "hosts.yaml")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"removed and created": {
+			setup: func(t *testing.T, dir string) string {
+				write(t, filepath.Join(dir, "hosts.yaml"), "before")
+				return filepath.Join(dir, "hosts.yaml")
+			},
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "hosts.yaml")); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(50 * time.Millisecond)
+				write(t, filepath.Join(dir, "hosts.yaml"), "after")
+			},
+		},
+		"configmap symlink swap": {
+			setup: func(t *testing.T, dir string) string {
+				write(t, filepath.Join(dir, "..2024_01_01", "hosts.yaml"), "before")
+				symlink(t, "..2024_01_01", filepath.Join(dir, "..data"))
+				symlink(t, filepath.Join("..data", "hosts.yaml"), filepath.Join(dir, "hosts.yaml"))
+				return filepath.Join(dir, "hosts.yaml")
+			},
+			change: func(t *testing.T, dir string) {
+				write(t, filepath.Join(dir, "..2024_01_02", "hosts.yaml"), "after")
+				symlink(t, "..2024_01_02", filepath.Join(dir, "..data"))
+				if err := os.RemoveAll(filepath.Join(dir, "..2024_01_01")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"symlink target in another directory written": {
+			setup: func(t *testing.T, dir string) string {
+				write(t, filepath.Join(dir, "data", "hosts.yaml"), "before")
+				write(t, filepath.Join(dir, "etc", ".keep"), "")
+				symlink(t, filepath.Join(dir, "data", "hosts.yaml"), filepath.Join(dir, "etc", "hosts.yaml"))
+				return filepath.Join(dir, "etc", "hosts.yaml")
+			},
+			change: func(t *testing.T, dir string) { write(t, filepath.Join(dir, "data", "hosts.yaml"), "after") },
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := tt.setup(t, dir)
+			w, err := NewWatcher(logr.Discard(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.watcher.Close()
+			w.Debounce = 10 * time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go w.Start(ctx)
+
+			tt.change(t, dir)
+			for i := 0; ; i++ {
+				got := w.fileData(path)
+				if got == "after" {
+					break
+				}
+				if i == 100 {
+					t.Fatalf("got %q, want %q", got, "after")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestStartDebounce(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := stdr.New(log.New(out, "", 0))
+	name, err := createFile([]byte("before"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	w, err := NewWatcher(l, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.watcher.Close()
+	w.Debounce = 200 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("write %d", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	<-done
+	if got := strings.Count(out.String(), "file changed, updating cache"); got != 1 {
+		t.Fatalf("file read %d times, want 1:\n%s", got, out.String())
+	}
+	if got := w.fileData(name); got != "write 9" {
+		t.Fatalf("got %q, want %q", got, "write 9")
+	}
+}
+
+func TestSetData(t *testing.T) {
+	const valid = "00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n"
+	tests := map[string]struct {
+		initial   string
+		next      string
+		want      string
+		wantValid bool
+		wantErr   error
+	}{
+		"valid":                   {initial: valid, next: valid + "00:01:02:03:04:06:\n  ipAddress: '192.168.2.11'\n  subnetMask: '255.255.255.0'\n", wantValid: true},
+		"invalid yaml":            {initial: valid, next: "not a yaml file", want: valid, wantValid: true, wantErr: errFileFormat},
+		"invalid mac":             {initial: valid, next: valid + "not-a-mac:\n  ipAddress: '192.168.2.11'\n  subnetMask: '255.255.255.0'\n", want: valid, wantValid: true, wantErr: errFileFormat},
+		"invalid ip":              {initial: valid, next: valid + "00:01:02:03:04:06:\n  ipAddress: '3'\n", want: valid, wantValid: true, wantErr: errParseIP},
+		"invalid name server":     {initial: valid, next: valid + "00:01:02:03:04:06:\n  ipAddress: '192.168.2.11'\n  subnetMask: '255.255.255.0'\n  nameServers: ['1.1.1.1', 'dns']\n", want: valid, wantValid: true, wantErr: errParseIP},
+		"invalid default gateway": {initial: valid, next: valid + "00:01:02:03:04:06:\n  ipAddress: '192.168.2.11'\n  subnetMask: '255.255.255.0'\n  defaultGateway: '192.168.2'\n", want: valid, wantValid: true, wantErr: errParseIP},
+		"duplicate mac":           {initial: valid, next: valid + "00:01:02:03:04:0a:\n  ipAddress: '192.168.2.11'\n  subnetMask: '255.255.255.0'\n00:01:02:03:04:0A:\n  ipAddress: '192.168.2.12'\n  subnetMask: '255.255.255.0'\n", want: valid, wantValid: true, wantErr: errDuplicateMAC},
+		"duplicate ip":            {initial: valid, next: valid + "00:01:02:03:04:06:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n", want: valid, wantValid: true, wantErr: errDuplicateIP},
+		"never valid":             {initial: "not a yaml file", next: "00:01:02:03:04:06:\n  ipAddress: '3'\n", wantErr: errParseIP},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := &Watcher{Log: logr.Discard()}
+			w.setData("hosts.yaml", []byte(tt.initial))
+			loaded := w.Status().Loaded
+			w.setData("hosts.yaml", []byte(tt.next))
+			if tt.want == "" {
+				tt.want = tt.next
+			}
+			if diff := cmp.Diff(w.fileData("hosts.yaml"), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			got := w.Status()
+			if !errors.Is(got.Err, tt.wantErr) || (tt.wantErr == nil && got.Err != nil) {
+				t.Fatalf("got error %v, want %v", got.Err, tt.wantErr)
+			}
+			if got.Valid != tt.wantValid {
+				t.Fatalf("got valid %v, want %v", got.Valid, tt.wantValid)
+			}
+			if tt.want != tt.next && !got.Loaded.Equal(loaded) {
+				t.Fatalf("got loaded %v, want the first load %v", got.Loaded, loaded)
+			}
+			if got.Checked.Before(got.Loaded) {
+				t.Fatalf("got checked %v before loaded %v", got.Checked, got.Loaded)
+			}
+		})
+	}
+}
+
+func TestGetByMacLastValid(t *testing.T) {
+	w := &Watcher{Log: logr.Discard()}
+	w.setData("hosts.yaml", []byte("00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n"))
+	w.setData("hosts.yaml", []byte("00:01:02:03:04:05:\n  ipAddress: '192.168.2.10\n"))
+	d, _, err := w.GetByMac(context.Background(), net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParseAddr("192.168.2.10"); d.IPAddress != want {
+		t.Fatalf("got ip address %v, want %v", d.IPAddress, want)
+	}
+	if s := w.Status(); s.Records != 1 || !errors.Is(s.Err, errFileFormat) {
+		t.Fatalf("got status %+v, want 1 record and error %v", s, errFileFormat)
+	}
+}
+
+func TestSetDataMultipleFiles(t *testing.T) {
+	const (
+		a = "00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n"
+		b = "{\"00:01:02:03:04:06\": {\"ipAddress\": \"192.168.2.11\", \"subnetMask\": \"255.255.255.0\"}}"
+	)
+	tests := map[string]struct {
+		next        string
+		wantB       string
+		wantValid   bool
+		wantErr     error
+		wantRecords int
+	}{
+		"valid":         {next: b, wantB: b, wantValid: true, wantRecords: 2},
+		"duplicate mac": {next: "00:01:02:03:04:05:\n  ipAddress: '192.168.2.12'\n  subnetMask: '255.255.255.0'\n", wantB: b, wantValid: true, wantErr: errDuplicateMAC, wantRecords: 2},
+		"duplicate ip":  {next: "00:01:02:03:04:07:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n", wantB: b, wantValid: true, wantErr: errDuplicateIP, wantRecords: 2},
+		"invalid yaml":  {next: "not a yaml file", wantB: b, wantValid: true, wantErr: errFileFormat, wantRecords: 2},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := &Watcher{Log: logr.Discard()}
+			w.setData("a.yaml", []byte(a))
+			w.setData("b.json", []byte(b))
+			w.setData("b.json", []byte(tt.next))
+			if diff := cmp.Diff(w.fileData("b.json"), tt.wantB); diff != "" {
+				t.Fatal(diff)
+			}
+			got := w.Status()
+			if !errors.Is(got.Err, tt.wantErr) || (tt.wantErr == nil && got.Err != nil) {
+				t.Fatalf("got error %v, want %v", got.Err, tt.wantErr)
+			}
+			if got.Valid != tt.wantValid || got.Records != tt.wantRecords || len(got.Files) != 2 {
+				t.Fatalf("got status %+v, want valid %v and %d records in 2 files", got, tt.wantValid, tt.wantRecords)
+			}
+			if got.Files["a.yaml"].Err != nil {
+				t.Fatalf("got error %v for a.yaml, want none", got.Files["a.yaml"].Err)
+			}
+			// the records of a.yaml are never changed by b.json.
+			d, _, err := w.GetByMac(context.Background(), net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := netip.MustParseAddr("192.168.2.10"); d.IPAddress != want {
+				t.Fatalf("got ip address %v, want %v", d.IPAddress, want)
+			}
+			if _, _, err := w.GetByIP(context.Background(), net.IPv4(192, 168, 2, 11)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestGetByMacUnparsableFile(t *testing.T) {
+	w := &Watcher{Log: logr.Discard()}
+	w.setData("a.yaml", []byte("00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n  subnetMask: '255.255.255.0'\n"))
+	w.setData("b.yaml", []byte("not a yaml file"))
+	if _, _, err := w.GetByMac(context.Background(), net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}); err != nil {
+		t.Fatal(err)
+	}
+	// the record could be in b.yaml, so it isn't reported as not found.
+	if _, _, err := w.GetByMac(context.Background(), net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}); !errors.Is(err, errFileFormat) {
+		t.Fatalf("got %v, want %v", err, errFileFormat)
+	}
+}
+
+func TestStartDirectory(t *testing.T) {
+	dir := t.TempDir()
+	record := func(mac, ip string) string {
+		return fmt.Sprintf("%s:\n  ipAddress: '%s'\n  subnetMask: '255.255.255.0'\n", mac, ip)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("b.yaml", record("00:01:02:03:04:05", "192.168.2.10"))
+	write("notes.txt", "not a data file")
+	w, err := NewWatcher(logr.Discard(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.watcher.Close()
+	w.Debounce = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	lookup := func(mac net.HardwareAddr, want string) {
+		t.Helper()
+		for i := 0; ; i++ {
+			d, _, err := w.GetByMac(context.Background(), mac)
+			if err == nil && d.IPAddress.String() == want || want == "" && errors.Is(err, errRecordNotFound) {
+				return
+			}
+			if i == 100 {
+				t.Fatalf("got %v, %v for %v, want ip address %q", d, err, mac, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	mac1 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	mac2 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+	lookup(mac1, "192.168.2.10")
+
+	// a file is added.
+	write("c.yaml", record("00:01:02:03:04:06", "192.168.2.11"))
+	lookup(mac2, "192.168.2.11")
+
+	// a record moves to a file that is read before the file it moves from.
+	write("b.yaml", "")
+	write("a.yaml", record("00:01:02:03:04:05", "192.168.2.12"))
+	lookup(mac1, "192.168.2.12")
+	if err := w.Status().Err; err != nil {
+		t.Fatal(err)
+	}
+
+	// a file is removed.
+	if err := os.Remove(filepath.Join(dir, "c.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	lookup(mac2, "")
+	if got := len(w.Status().Files); got != 2 {
+		t.Fatalf("got %d files, want 2", got)
+	}
+}
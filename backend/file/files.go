@@ -0,0 +1,87 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// dataExtensions are the extensions of the files that are read from a directory.
+var dataExtensions = []string{".yaml", ".yml", ".json", ".csv", ".conf"}
+
+// paths returns the files of f: f itself, the data files in f when it is a directory,
+// or the files that match f when it is a glob pattern, see filepath.Match.
+// multiple is true when f is a directory or a glob pattern, that can have any number of files.
+// Hidden files, like the temporary files of editors and the "..data" directory of a Kubernetes ConfigMap volume, are skipped.
+func paths(f string) (ps []string, multiple bool, err error) {
+	if f == "" {
+		// not the current directory, which filepath.Clean returns for an empty path.
+		return []string{f}, false, nil
+	}
+	f = filepath.Clean(f)
+	if hasMeta(f) {
+		m, err := filepath.Glob(f)
+		if err != nil {
+			return nil, true, err
+		}
+		for _, p := range m {
+			if !hidden(p) && isRegular(p) {
+				ps = append(ps, p)
+			}
+		}
+
+		return ps, true, nil
+	}
+	if fi, err := os.Stat(f); err != nil || !fi.IsDir() {
+		return []string{f}, false, nil
+	}
+	es, err := os.ReadDir(f)
+	if err != nil {
+		return nil, true, err
+	}
+	for _, e := range es {
+		p := filepath.Join(f, e.Name())
+		if isDataFile(p) && isRegular(p) {
+			ps = append(ps, p)
+		}
+	}
+
+	return ps, true, nil
+}
+
+// matches returns true if name is, or would be, a file of f, see paths.
+func matches(f, name string) bool {
+	f = filepath.Clean(f)
+	if hasMeta(f) {
+		ok, _ := filepath.Match(f, name)
+		return ok && !hidden(name)
+	}
+	if name == f {
+		return true
+	}
+
+	return filepath.Dir(name) == f && isDataFile(name)
+}
+
+// hasMeta returns true if path has any of the special characters of a glob pattern.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// hidden returns true if the name of the file at path starts with a dot.
+func hidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// isDataFile returns true if the file at path is read from a directory.
+func isDataFile(path string) bool {
+	return !hidden(path) && slices.Contains(dataExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// isRegular returns true if path is a regular file, or a symlink to one.
+func isRegular(path string) bool {
+	fi, err := os.Stat(path)
+
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,86 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.yaml", "b.JSON", "c.yml", "d.txt", ".e.yaml", "sub/f.yaml", "i.csv", "j.conf"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.yaml", filepath.Join(dir, "g.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing.yaml", filepath.Join(dir, "h.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	join := func(names ...string) []string {
+		ps := make([]string, 0, len(names))
+		for _, n := range names {
+			ps = append(ps, filepath.Join(dir, n))
+		}
+		return ps
+	}
+
+	tests := map[string]struct {
+		path         string
+		want         []string
+		wantMultiple bool
+	}{
+		"file":             {path: filepath.Join(dir, "d.txt"), want: join("d.txt")},
+		"missing file":     {path: filepath.Join(dir, "missing.yaml"), want: join("missing.yaml")},
+		"directory":        {path: dir + "/", want: join("a.yaml", "b.JSON", "c.yml", "g.yaml", "i.csv", "j.conf"), wantMultiple: true},
+		"glob":             {path: filepath.Join(dir, "*.yaml"), want: join("a.yaml", "g.yaml"), wantMultiple: true},
+		"glob in dir name": {path: filepath.Join(dir, "s*", "*"), want: join("sub/f.yaml"), wantMultiple: true},
+		"glob no match":    {path: filepath.Join(dir, "*.toml"), wantMultiple: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, multiple, err := paths(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if multiple != tt.wantMultiple {
+				t.Fatalf("got multiple %v, want %v", multiple, tt.wantMultiple)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := map[string]struct {
+		path string
+		name string
+		want bool
+	}{
+		"file":                  {path: "/etc/dhcp/hosts.yaml", name: "/etc/dhcp/hosts.yaml", want: true},
+		"other file":            {path: "/etc/dhcp/hosts.yaml", name: "/etc/dhcp/other.yaml"},
+		"file in directory":     {path: "/etc/dhcp/", name: "/etc/dhcp/hosts.json", want: true},
+		"csv file in directory": {path: "/etc/dhcp", name: "/etc/dhcp/hosts.csv", want: true},
+		"not a data file":       {path: "/etc/dhcp", name: "/etc/dhcp/hosts.txt"},
+		"hidden file":           {path: "/etc/dhcp", name: "/etc/dhcp/.hosts.yaml"},
+		"file in sub directory": {path: "/etc/dhcp", name: "/etc/dhcp/sub/hosts.yaml"},
+		"glob":                  {path: "/etc/dhcp/*.yaml", name: "/etc/dhcp/hosts.yaml", want: true},
+		"hidden file of glob":   {path: "/etc/dhcp/*.yaml", name: "/etc/dhcp/.hosts.yaml"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := matches(tt.path, tt.name); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Format is the format of a file.
+type Format int
+
+const (
+	// FormatAuto detects the format of each file, see detect.
+	FormatAuto Format = iota
+	// FormatYAML is a YAML map of records keyed by MAC address, see testdata/example.yaml.
+	FormatYAML
+	// FormatJSON is the same map of records as FormatYAML, in JSON.
+	FormatJSON
+	// FormatCSV is a CSV file with a header row, see decodeCSV.
+	FormatCSV
+	// FormatDnsmasq is a dnsmasq configuration file with dhcp-host lines, see decodeDnsmasq.
+	FormatDnsmasq
+	// FormatISC is an ISC dhcpd configuration file with host declarations, see decodeISC.
+	FormatISC
+)
+
+// formats are the names of the formats, in the order of their values.
+var formats = []string{"auto", "yaml", "json", "csv", "dnsmasq", "isc"}
+
+// String returns the name of the Format.
+func (f Format) String() string {
+	if f >= 0 && int(f) < len(formats) {
+		return formats[f]
+	}
+
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (f Format) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (f *Format) UnmarshalText(text []byte) error {
+	for i, name := range formats {
+		if string(text) == name {
+			*f = Format(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown file format %q, must be one of: %v", text, strings.Join(formats, ", "))
+}
+
+var (
+	// dnsmasqHostLine and iscHostDecl detect the formats of configuration files.
+	dnsmasqHostLine = regexp.MustCompile(`(?m)^\s*(--)?dhcp-host\s*=`)
+	iscHostDecl     = regexp.MustCompile(`(?m)^\s*host\s+\S+\s*\{`)
+)
+
+// detect returns the format of the file at path with content b.
+// The format is detected from the extension of the file, or from its content for other extensions,
+// for example the .conf files of dnsmasq and ISC dhcpd. A file that isn't detected is YAML.
+func detect(path string, b []byte) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	case ".csv":
+		return FormatCSV
+	}
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")):
+		return FormatJSON
+	case dnsmasqHostLine.Match(b):
+		return FormatDnsmasq
+	case iscHostDecl.Match(b):
+		return FormatISC
+	default:
+		return FormatYAML
+	}
+}
+
+// decode decodes the records of the file at path with content b, keyed by MAC address.
+// invalid has the errors of entries of the file that can't be decoded, the other entries are returned.
+// err is set when the file can't be decoded at all.
+func decode(f Format, path string, b []byte) (r map[string]dhcp, invalid []error, err error) {
+	if f == FormatAuto {
+		f = detect(path, b)
+	}
+	switch f {
+	case FormatYAML, FormatJSON:
+		// JSON is YAML, and .json files were always decoded as YAML.
+		r = make(map[string]dhcp)
+		err = yaml.Unmarshal(b, &r)
+	case FormatCSV:
+		r, invalid, err = decodeCSV(b)
+	case FormatDnsmasq:
+		r, invalid, err = decodeDnsmasq(b)
+	case FormatISC:
+		r, invalid, err = decodeISC(b)
+	default:
+		err = fmt.Errorf("unknown file format %v", f)
+	}
+
+	return r, invalid, err
+}
+
+// records collects the records of a file in a format that isn't keyed by MAC address.
+type records struct {
+	r       map[string]dhcp
+	invalid []error
+}
+
+// add adds the record v for mac, from line of the file. A MAC address that was already added is invalid.
+func (rs *records) add(line int, mac string, v dhcp) {
+	if rs.r == nil {
+		rs.r = make(map[string]dhcp)
+	}
+	if _, ok := rs.r[mac]; ok {
+		rs.invalidf(line, "%w: %s", errDuplicateMAC, mac)
+		return
+	}
+	rs.r[mac] = v
+}
+
+// invalidf adds an error for an entry of the file on line that can't be decoded.
+func (rs *records) invalidf(line int, format string, a ...any) {
+	rs.invalid = append(rs.invalid, fmt.Errorf("line %d: %w", line, fmt.Errorf(format, a...)))
+}
+
+// subnet is the subnet of a configuration file, with the values of the records in it.
+type subnet struct {
+	prefix   netip.Prefix
+	defaults dhcp
+}
+
+// withDefaults returns v with its empty fields set from d.
+func withDefaults(v, d dhcp) dhcp {
+	set := func(s *string, d string) {
+		if *s == "" {
+			*s = d
+		}
+	}
+	setList := func(s *[]string, d []string) {
+		if len(*s) == 0 {
+			*s = d
+		}
+	}
+	set(&v.SubnetMask, d.SubnetMask)
+	set(&v.DefaultGateway, d.DefaultGateway)
+	setList(&v.NameServers, d.NameServers)
+	set(&v.DomainName, d.DomainName)
+	set(&v.BroadcastAddress, d.BroadcastAddress)
+	setList(&v.NTPServers, d.NTPServers)
+	setList(&v.DomainSearch, d.DomainSearch)
+	setList(&v.IPv6NameServers, d.IPv6NameServers)
+	if v.LeaseTime == 0 {
+		v.LeaseTime = d.LeaseTime
+	}
+	if v.Netboot.BootFileName == "" && d.Netboot.BootFileName != "" {
+		v.Netboot.BootFileName = d.Netboot.BootFileName
+		v.Netboot.AllowPXE = true
+	}
+	set(&v.Netboot.NextServer, d.Netboot.NextServer)
+
+	return v
+}
+
+// subnetOf returns the subnet of subnets that has the IP address ip.
+func subnetOf(subnets []subnet, ip string) (subnet, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return subnet{}, false
+	}
+	for _, s := range subnets {
+		if s.prefix.Contains(a) {
+			return s, true
+		}
+	}
+
+	return subnet{}, false
+}
+
+// maskPrefix returns the prefix of the IPv4 address ip with the subnet mask mask.
+func maskPrefix(ip, mask string) (netip.Prefix, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil || !a.Is4() {
+		return netip.Prefix{}, false
+	}
+	m, err := netip.ParseAddr(mask)
+	if err != nil || !m.Is4() {
+		return netip.Prefix{}, false
+	}
+	b := m.As4()
+	ones, bits := netMask(b[:])
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+
+	return netip.PrefixFrom(a, ones).Masked(), true
+}
+
+// netMask returns the number of leading ones of a subnet mask, bits is 0 when it isn't a subnet mask.
+func netMask(m []byte) (ones, bits int) {
+	var n uint32
+	for _, b := range m {
+		n = n<<8 | uint32(b)
+	}
+	for n&(1<<31) != 0 {
+		ones++
+		n <<= 1
+	}
+	if n != 0 {
+		return 0, 0
+	}
+
+	return ones, 32
+}
@@ -0,0 +1,115 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatText(t *testing.T) {
+	for _, want := range []Format{FormatAuto, FormatYAML, FormatJSON, FormatCSV, FormatDnsmasq, FormatISC} {
+		b, err := want.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Format
+		if err := got.UnmarshalText(b); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	var f Format
+	if err := f.UnmarshalText([]byte("toml")); err == nil {
+		t.Fatal("expected error for unknown file format")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]struct {
+		path string
+		data string
+		want Format
+	}{
+		"yaml":              {path: "hosts.yaml", want: FormatYAML},
+		"yml":               {path: "hosts.YML", want: FormatYAML},
+		"json":              {path: "hosts.json", want: FormatJSON},
+		"csv":               {path: "hosts.csv", want: FormatCSV},
+		"json content":      {path: "hosts", data: "\n{\"00:01:02:03:04:05\": {}}", want: FormatJSON},
+		"dnsmasq":           {path: "dnsmasq.conf", data: "domain=example.com\ndhcp-host=00:01:02:03:04:05,192.168.2.10\n", want: FormatDnsmasq},
+		"dnsmasq long":      {path: "dnsmasq.conf", data: "--dhcp-host = 00:01:02:03:04:05,192.168.2.10\n", want: FormatDnsmasq},
+		"isc":               {path: "dhcpd.conf", data: "subnet 192.168.2.0 netmask 255.255.255.0 {\n  host a{\n  }\n}\n", want: FormatISC},
+		"yaml content":      {path: "hosts", data: "00:01:02:03:04:05:\n  ipAddress: '192.168.2.10'\n", want: FormatYAML},
+		"extension wins":    {path: "hosts.yaml", data: "dhcp-host=00:01:02:03:04:05\n", want: FormatYAML},
+		"commented dnsmasq": {path: "dnsmasq.conf", data: "# dhcp-host=00:01:02:03:04:05\n", want: FormatYAML},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := detect(tt.path, []byte(tt.data)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeExamples(t *testing.T) {
+	record := func(ip, hostname string) dhcp {
+		return dhcp{
+			IPAddress:        ip,
+			SubnetMask:       "255.255.255.0",
+			DefaultGateway:   "192.168.2.1",
+			NameServers:      []string{"8.8.8.8", "1.1.1.1"},
+			Hostname:         hostname,
+			DomainName:       "example.com",
+			BroadcastAddress: "192.168.2.255",
+			NTPServers:       []string{"132.163.96.2", "132.163.96.3"},
+			LeaseTime:        86400,
+			DomainSearch:     []string{"example.com"},
+			Netboot:          netboot{AllowPXE: true, BootFileName: "undionly.kpxe", NextServer: "192.168.2.5"},
+		}
+	}
+	want := map[string]dhcp{
+		"08:00:27:29:4E:67": record("192.168.2.153", "pxe-virtualbox"),
+		"52:54:00:aa:88:2a": record("192.168.2.15", "sandbox"),
+	}
+	for _, path := range []string{"testdata/example.csv", "testdata/example.dnsmasq.conf", "testdata/example.dhcpd.conf"} {
+		t.Run(path, func(t *testing.T) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, invalid, err := decode(FormatAuto, path, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := errors.Join(invalid...); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Fatal(diff)
+			}
+			w := &Watcher{Log: logr.Discard()}
+			if rev := w.parse(path, b); rev.invalid != nil {
+				t.Fatal(rev.invalid)
+			}
+		})
+	}
+}
+
+func TestDecodeFormat(t *testing.T) {
+	const data = "dhcp-host=00:01:02:03:04:05,192.168.2.10\n"
+	if _, _, err := decode(FormatYAML, "dnsmasq.conf", []byte(data)); err == nil {
+		t.Fatal("expected error for a dnsmasq file decoded as YAML")
+	}
+	got, _, err := decode(FormatDnsmasq, "hosts.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]dhcp{"00:01:02:03:04:05": {IPAddress: "192.168.2.10"}}; !cmp.Equal(got, want) {
+		t.Fatal(cmp.Diff(got, want))
+	}
+}
@@ -0,0 +1,362 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// iscToken is a word, quoted string or punctuation of an ISC dhcpd configuration file.
+type iscToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// iscScope is the scope of a declaration, like a subnet, group or host, with the statements in it.
+type iscScope struct {
+	parent *iscScope
+	stmts  [][]iscToken
+}
+
+// iscHost is a host declaration.
+type iscHost struct {
+	name  string
+	line  int
+	scope *iscScope
+	// inSubnet is true when the host is declared in a subnet.
+	inSubnet bool
+}
+
+// iscSubnet is a subnet declaration.
+type iscSubnet struct {
+	prefix netip.Prefix
+	scope  *iscScope
+}
+
+// iscParser parses the declarations of an ISC dhcpd configuration file.
+type iscParser struct {
+	toks    []iscToken
+	pos     int
+	hosts   []iscHost
+	subnets []iscSubnet
+}
+
+// iscValues are the values of a host from the statements of its scopes.
+type iscValues struct {
+	dhcp
+	mac string
+	// declNames is set by use-host-decl-names, the name of the host declaration is its hostname.
+	declNames bool
+}
+
+// decodeISC decodes the host declarations of an ISC dhcpd configuration file, see dhcpd.conf(5):
+//
+//	host <name> {
+//	  hardware ethernet <mac>;
+//	  fixed-address <ipv4 address>;
+//	  option routers <ipv4 address>;
+//	}
+//
+// A host is a record when it has a hardware ethernet address. It gets the options of the subnet, shared-network,
+// group and pool declarations it is in, and of the subnet that has its fixed-address when it isn't in one.
+// The options, the filename, next-server, default-lease-time, fixed-address6 and host-identifier option dhcp6.client-id
+// statements are used, see iscValues.apply. Other statements are ignored, other declarations, like class, are skipped.
+func decodeISC(b []byte) (map[string]dhcp, []error, error) {
+	toks, err := iscTokens(string(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &iscParser{toks: toks}
+	root := &iscScope{}
+	if err := p.block(root, false); err != nil {
+		return nil, nil, err
+	}
+
+	var rs records
+	for _, h := range p.hosts {
+		chain := h.scope.chain()
+		if !h.inSubnet {
+			if s, ok := p.subnetOf(h.scope); ok {
+				chain = append(append([]*iscScope{root}, s.scope.chain()[1:]...), chain[1:]...)
+			}
+		}
+		var v iscValues
+		var errs []error
+		for _, s := range chain {
+			for _, stmt := range s.stmts {
+				if err := v.apply(stmt); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			rs.invalidf(h.line, "host %s: %w", h.name, err)
+			continue
+		}
+		if v.mac == "" {
+			rs.invalidf(h.line, "host %s without a hardware ethernet address", h.name)
+			continue
+		}
+		if v.Hostname == "" && v.declNames {
+			v.Hostname = h.name
+		}
+		rs.add(h.line, v.mac, v.dhcp)
+	}
+
+	return rs.r, rs.invalid, nil
+}
+
+// iscTokens splits an ISC dhcpd configuration file into tokens. Comments start with # and end at the end of the line.
+func iscTokens(s string) ([]iscToken, error) {
+	var toks []iscToken
+	line := 1
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			var sb strings.Builder
+			start := line
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				if s[i] == '\n' {
+					line++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+			i++
+			toks = append(toks, iscToken{text: sb.String(), quoted: true, line: start})
+		case strings.IndexByte("{};,", c) >= 0:
+			toks = append(toks, iscToken{text: string(c), line: line})
+			i++
+		default:
+			j := i
+			for j < len(s) && strings.IndexByte(" \t\r\n{};,\"", s[j]) < 0 {
+				j++
+			}
+			toks = append(toks, iscToken{text: s[i:j], line: line})
+			i = j
+		}
+	}
+
+	return toks, nil
+}
+
+// next returns the next statement and the punctuation that ends it: ";", "{" or "}".
+// end is empty at the end of the file.
+func (p *iscParser) next() (stmt []iscToken, end iscToken) {
+	for p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+		p.pos++
+		if !t.quoted && (t.text == ";" || t.text == "{" || t.text == "}") {
+			return stmt, t
+		}
+		stmt = append(stmt, t)
+	}
+
+	return stmt, iscToken{}
+}
+
+// block parses the statements and declarations of scope s, until the } that closes it or the end of the file for the root scope.
+func (p *iscParser) block(s *iscScope, inSubnet bool) error {
+	for {
+		stmt, end := p.next()
+		switch end.text {
+		case "":
+			if len(stmt) > 0 {
+				return fmt.Errorf("line %d: missing ;", stmt[len(stmt)-1].line)
+			}
+			if s.parent != nil {
+				return errors.New("missing }")
+			}
+			return nil
+		case "}":
+			if len(stmt) > 0 {
+				return fmt.Errorf("line %d: missing ;", stmt[len(stmt)-1].line)
+			}
+			if s.parent == nil {
+				return fmt.Errorf("line %d: unexpected }", end.line)
+			}
+			return nil
+		case ";":
+			if len(stmt) > 0 {
+				s.stmts = append(s.stmts, stmt)
+			}
+		case "{":
+			if err := p.declaration(s, stmt, end, inSubnet); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// declaration parses the scope of the declaration stmt in s.
+func (p *iscParser) declaration(s *iscScope, stmt []iscToken, open iscToken, inSubnet bool) error {
+	if len(stmt) == 0 {
+		return fmt.Errorf("line %d: unexpected {", open.line)
+	}
+	child := &iscScope{parent: s}
+	switch stmt[0].text {
+	case "host":
+		if len(stmt) != 2 {
+			return fmt.Errorf("line %d: host declaration without a name", stmt[0].line)
+		}
+		p.hosts = append(p.hosts, iscHost{name: stmt[1].text, line: stmt[0].line, scope: child, inSubnet: inSubnet})
+	case "subnet":
+		if len(stmt) != 4 || stmt[2].text != "netmask" {
+			return fmt.Errorf("line %d: subnet declaration without a netmask", stmt[0].line)
+		}
+		if prefix, ok := maskPrefix(stmt[1].text, stmt[3].text); ok {
+			p.subnets = append(p.subnets, iscSubnet{prefix: prefix, scope: child})
+		}
+		// the netmask is the subnet mask of the hosts, unless the subnet has a subnet-mask option.
+		child.stmts = append(child.stmts, []iscToken{{text: "option"}, {text: "subnet-mask"}, stmt[3]})
+		inSubnet = true
+	case "shared-network", "group", "pool", "subnet6", "pool6":
+	default:
+		return p.skip(open)
+	}
+
+	return p.block(child, inSubnet)
+}
+
+// skip skips the tokens of a declaration, until the } that closes it.
+func (p *iscParser) skip(open iscToken) error {
+	depth := 1
+	for ; p.pos < len(p.toks); p.pos++ {
+		t := p.toks[p.pos]
+		switch {
+		case t.quoted:
+		case t.text == "{":
+			depth++
+		case t.text == "}":
+			depth--
+		}
+		if depth == 0 {
+			p.pos++
+			return nil
+		}
+	}
+
+	return fmt.Errorf("line %d: missing }", open.line)
+}
+
+// subnetOf returns the subnet that has the fixed-address of the host with scope s.
+func (p *iscParser) subnetOf(s *iscScope) (iscSubnet, bool) {
+	var v iscValues
+	for _, stmt := range s.stmts {
+		if stmt[0].text == "fixed-address" {
+			_ = v.apply(stmt)
+		}
+	}
+	a, err := netip.ParseAddr(v.IPAddress)
+	if err != nil {
+		return iscSubnet{}, false
+	}
+	for _, sub := range p.subnets {
+		if sub.prefix.Contains(a) {
+			return sub, true
+		}
+	}
+
+	return iscSubnet{}, false
+}
+
+// chain returns the scopes from the root scope to s.
+func (s *iscScope) chain() []*iscScope {
+	var c []*iscScope
+	for ; s != nil; s = s.parent {
+		c = append([]*iscScope{s}, c...)
+	}
+
+	return c
+}
+
+// apply sets the values of a statement. Statements that aren't used for records are ignored.
+func (v *iscValues) apply(stmt []iscToken) error {
+	var words []string
+	for _, t := range stmt {
+		if t.quoted || t.text != "," {
+			words = append(words, t.text)
+		}
+	}
+	if len(words) < 2 {
+		return nil
+	}
+	switch words[0] {
+	case "hardware":
+		if words[1] == "ethernet" && len(words) > 2 {
+			v.mac = words[2]
+		}
+	case "fixed-address":
+		if a, err := netip.ParseAddr(words[1]); err != nil || !a.Is4() {
+			return fmt.Errorf("line %d: fixed-address %s is not an IPv4 address", stmt[0].line, words[1])
+		}
+		v.IPAddress = words[1]
+	case "fixed-address6":
+		v.IPv6Address = words[1]
+	case "host-identifier":
+		if len(words) > 3 && words[1] == "option" && words[2] == "dhcp6.client-id" {
+			v.DUID = words[3]
+		}
+	case "filename":
+		v.Netboot.BootFileName = words[1]
+		v.Netboot.AllowPXE = true
+	case "next-server":
+		v.Netboot.NextServer = words[1]
+	case "default-lease-time":
+		n, err := strconv.Atoi(words[1])
+		if err != nil {
+			return fmt.Errorf("line %d: default-lease-time: %w", stmt[0].line, err)
+		}
+		v.LeaseTime = n
+	case "use-host-decl-names":
+		v.declNames = words[1] == "on" || words[1] == "true"
+	case "option":
+		if len(words) > 2 {
+			v.option(words[1], words[2:])
+		}
+	}
+
+	return nil
+}
+
+// option sets the value of an option statement.
+func (v *iscValues) option(name string, values []string) {
+	switch name {
+	case "subnet-mask":
+		v.SubnetMask = values[0]
+	case "routers":
+		v.DefaultGateway = values[0]
+	case "domain-name-servers":
+		v.NameServers = values
+	case "host-name":
+		v.Hostname = values[0]
+	case "domain-name":
+		v.DomainName = values[0]
+	case "broadcast-address":
+		v.BroadcastAddress = values[0]
+	case "ntp-servers":
+		v.NTPServers = values
+	case "domain-search":
+		v.DomainSearch = values
+	case "dhcp6.name-servers":
+		v.IPv6NameServers = values
+	}
+}
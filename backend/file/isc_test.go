@@ -0,0 +1,96 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeISC(t *testing.T) {
+	tests := map[string]struct {
+		data        string
+		want        map[string]dhcp
+		wantInvalid []string
+		wantErr     bool
+	}{
+		"scopes": {
+			data: `
+option routers 10.0.0.1;
+shared-network lab {
+  option domain-name "lab.example.com";
+  subnet 10.0.0.0 netmask 255.255.0.0 {
+    option subnet-mask 255.255.255.0;
+    pool {
+      host a { hardware ethernet 00:01:02:03:04:05; fixed-address 10.0.0.10; option host-name "node-a"; }
+    }
+  }
+}
+host b {
+  hardware ethernet 00:01:02:03:04:06;
+  fixed-address 192.168.2.10;
+  option subnet-mask 255.255.255.0;
+  option routers 192.168.2.1;
+  option domain-search "example.com", "lab.example.com";
+  fixed-address6 2001:db8::10;
+  option dhcp6.name-servers 2001:db8::1;
+  host-identifier option dhcp6.client-id 00:01:00:01:02:03;
+  default-lease-time 600;
+}
+`,
+			want: map[string]dhcp{
+				"00:01:02:03:04:05": {IPAddress: "10.0.0.10", SubnetMask: "255.255.255.0", DefaultGateway: "10.0.0.1", DomainName: "lab.example.com", Hostname: "node-a"},
+				"00:01:02:03:04:06": {
+					IPAddress:       "192.168.2.10",
+					SubnetMask:      "255.255.255.0",
+					DefaultGateway:  "192.168.2.1",
+					DomainSearch:    []string{"example.com", "lab.example.com"},
+					IPv6Address:     "2001:db8::10",
+					IPv6NameServers: []string{"2001:db8::1"},
+					DUID:            "00:01:00:01:02:03",
+					LeaseTime:       600,
+				},
+			},
+		},
+		"subnet of a host outside of it": {
+			data: "option routers 10.0.0.254;\nhost a { hardware ethernet 00:01:02:03:04:05; fixed-address 10.0.0.10; }\n" +
+				"subnet 10.0.0.0 netmask 255.255.255.0 { option routers 10.0.0.1; }\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {IPAddress: "10.0.0.10", SubnetMask: "255.255.255.0", DefaultGateway: "10.0.0.1"}},
+		},
+		"skipped declarations": {
+			data: "class \"pxe\" { match if option vendor-class-identifier = \"}\"; if exists user-class { filename \"a\"; } }\n" +
+				"host a { hardware ethernet 00:01:02:03:04:05; } # host b {\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {}},
+		},
+		"invalid hosts": {
+			data: "host a { hardware ethernet 00:01:02:03:04:05; }\n" +
+				"host b { fixed-address 10.0.0.11; }\n" +
+				"host c { hardware ethernet 00:01:02:03:04:07; fixed-address c.example.com; }\n" +
+				"host d { hardware ethernet 00:01:02:03:04:05; }\n",
+			want: map[string]dhcp{"00:01:02:03:04:05": {}},
+			wantInvalid: []string{
+				"line 2: host b without a hardware ethernet address",
+				"line 3: host c: line 3: fixed-address c.example.com is not an IPv4 address",
+				"line 4: duplicate MAC address: 00:01:02:03:04:05",
+			},
+		},
+		"missing semicolon":      {data: "host a { hardware ethernet 00:01:02:03:04:05 }\n", wantErr: true},
+		"missing brace":          {data: "host a { hardware ethernet 00:01:02:03:04:05;\n", wantErr: true},
+		"unexpected brace":       {data: "option routers 10.0.0.1; }\n", wantErr: true},
+		"unterminated string":    {data: "option domain-name \"example.com;\n", wantErr: true},
+		"subnet without netmask": {data: "subnet 10.0.0.0 { }\n", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, invalid, err := decodeISC([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(errorStrings(invalid), tt.wantInvalid); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/tinkerbell/dhcp/data"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// store is the merged content of the files of a Watcher, indexed for lookups.
+// It is built when a file changes and not modified afterwards, so it can be read without locking.
+type store struct {
+	// err is the error from parsing the files that couldn't be parsed.
+	// When set, lookups that find no record return it, as the record could be in one of those files.
+	err error
+	// records is the number of records in the files.
+	records int
+	// byMAC is keyed by the MAC address of a record, in the format of net.HardwareAddr.String().
+	byMAC map[string]*record
+	// byIP is keyed by the ipAddress of a record, as it is written in the file.
+	byIP map[string][]*record
+	// byCircuitID is keyed by the circuitID of a record.
+	byCircuitID map[string][]*record
+	// byDUID is keyed by the DUID bytes of a record.
+	byDUID map[string][]*record
+	// byAddr is keyed by the IPv4 and IPv6 addresses of a record.
+	byAddr map[netip.Addr]*record
+}
+
+// revision is the parsed content of a file at one point in time.
+type revision struct {
+	data    []byte
+	records []*record
+	// err is the error from parsing the file. When set, the revision has no records.
+	err error
+	// invalid is the error from validating the revision, see parse. It is nil for a valid revision.
+	invalid error
+}
+
+// record is a single record of a file.
+type record struct {
+	// key is the MAC address of the record as it is written in the file.
+	key string
+	// file is the path of the file that has the record.
+	file string
+	// mac is the MAC address of the record in the format of net.HardwareAddr.String(), empty when key isn't a MAC address.
+	mac       string
+	ip        string
+	circuitID string
+	remoteID  string
+	// duid is the DUID bytes of the record, empty when it has no valid DUID.
+	duid string
+	// addrs are the IPv4 and IPv6 addresses of the record.
+	addrs []netip.Addr
+	d     *data.DHCP
+	n     *data.Netboot
+	// err is the error from translating the record. Lookups that find the record return it.
+	err error
+}
+
+// parse parses and translates the content of the file at path, see decode.
+// Records are translated in the order of their keys, so the result doesn't depend on map iteration order.
+// The revision is valid when all records can be translated and validated,
+// and no two records have the same MAC address or IP address.
+func (w *Watcher) parse(path string, b []byte) *revision {
+	rev := &revision{data: b}
+	r, invalid, err := decode(w.Format, path, b)
+	if err != nil {
+		rev.err = fmt.Errorf("%w: %w", err, errFileFormat)
+		rev.invalid = rev.err
+		return rev
+	}
+
+	macs := make([]string, 0, len(r))
+	for k := range r {
+		macs = append(macs, k)
+	}
+	slices.Sort(macs)
+	var errs []error
+	for _, err := range invalid {
+		errs = append(errs, fmt.Errorf("%w: %w", err, errFileFormat))
+	}
+	seen := make(map[string]string, len(r))
+	ips := make(map[netip.Addr]string)
+	for _, k := range macs {
+		v := r[k]
+		rec := &record{key: k, file: path, ip: v.IPAddress, circuitID: v.CircuitID, remoteID: v.RemoteID}
+		if mac, err := net.ParseMAC(k); err != nil {
+			rec.err = fmt.Errorf("%w: %w", err, errFileFormat)
+		} else {
+			v.MACAddress = mac
+			rec.mac = mac.String()
+			rec.d, rec.n, rec.err = w.translate(v)
+			if other, ok := seen[rec.mac]; ok {
+				errs = append(errs, fmt.Errorf("%w: %s and %s", errDuplicateMAC, other, k))
+			}
+			seen[rec.mac] = k
+		}
+		if v.DUID != "" {
+			if duid, err := parseDUID(v.DUID); err == nil {
+				rec.duid = string(duid)
+			}
+		}
+		// translate skips optional fields that can't be parsed, they fail the validation of the file but not lookups of the record.
+		err := rec.err
+		if err == nil {
+			for _, a := range []netip.Addr{rec.d.IPAddress, rec.d.IPv6Address} {
+				if a.IsValid() {
+					rec.addrs = append(rec.addrs, a)
+				}
+			}
+			err = validate(v)
+			errs = append(errs, duplicateIPs(ips, k, rec.addrs)...)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", k, err))
+		}
+		rev.records = append(rev.records, rec)
+	}
+	rev.invalid = errors.Join(errs...)
+
+	return rev
+}
+
+// validate returns an error for the optional fields of r that translate skips when they can't be parsed.
+func validate(r dhcp) error {
+	var errs []error
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{name: "defaultGateway", value: r.DefaultGateway},
+		{name: "broadcastAddress", value: r.BroadcastAddress},
+	} {
+		if _, err := netip.ParseAddr(f.value); f.value != "" && err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s %q", errParseIP, f.name, f.value))
+		}
+	}
+	for _, f := range []struct {
+		name   string
+		values []string
+	}{
+		{name: "nameServers", values: r.NameServers},
+		{name: "ntpServers", values: r.NTPServers},
+		{name: "ipv6NameServers", values: r.IPv6NameServers},
+	} {
+		for _, v := range f.values {
+			if net.ParseIP(v) == nil {
+				errs = append(errs, fmt.Errorf("%w: %s %q", errParseIP, f.name, v))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// duplicateIPs adds the addresses of the record with key k to ips, the MAC address keys by IP address.
+// It returns an error for each address that another record already has.
+func duplicateIPs(ips map[netip.Addr]string, k string, addrs []netip.Addr) []error {
+	var errs []error
+	for _, a := range addrs {
+		if other, ok := ips[a]; ok {
+			errs = append(errs, fmt.Errorf("%w: %s is the address of %s and %s", errDuplicateIP, a, other, k))
+			continue
+		}
+		ips[a] = k
+	}
+
+	return errs
+}
+
+// newStore merges and indexes the records of revs.
+// A MAC address or IP address that is in more than one revision belongs to the record of the first one.
+func newStore(revs []*revision) *store {
+	s := &store{
+		byMAC:       make(map[string]*record),
+		byIP:        make(map[string][]*record),
+		byCircuitID: make(map[string][]*record),
+		byDUID:      make(map[string][]*record),
+		byAddr:      make(map[netip.Addr]*record),
+	}
+	var errs []error
+	for _, rev := range revs {
+		if rev.err != nil {
+			errs = append(errs, rev.err)
+		}
+		for _, rec := range rev.records {
+			s.records++
+			if _, ok := s.byMAC[rec.mac]; rec.mac != "" && !ok {
+				s.byMAC[rec.mac] = rec
+			}
+			for _, a := range rec.addrs {
+				if _, ok := s.byAddr[a]; !ok {
+					s.byAddr[a] = rec
+				}
+			}
+			if rec.ip != "" {
+				s.byIP[rec.ip] = append(s.byIP[rec.ip], rec)
+			}
+			if rec.circuitID != "" {
+				s.byCircuitID[rec.circuitID] = append(s.byCircuitID[rec.circuitID], rec)
+			}
+			if rec.duid != "" {
+				s.byDUID[rec.duid] = append(s.byDUID[rec.duid], rec)
+			}
+		}
+	}
+	s.err = errors.Join(errs...)
+
+	return s
+}
+
+// conflicts returns an error for each MAC address and IP address of rev that belongs to a record in s.
+func (s *store) conflicts(rev *revision) error {
+	var errs []error
+	for _, rec := range rev.records {
+		if other, ok := s.byMAC[rec.mac]; ok {
+			errs = append(errs, fmt.Errorf("%w: %s is in %s and %s", errDuplicateMAC, rec.mac, other.file, rec.file))
+		}
+		for _, a := range rec.addrs {
+			if other, ok := s.byAddr[a]; ok {
+				errs = append(errs, fmt.Errorf("%w: %s is the address of %s in %s and %s in %s", errDuplicateIP, a, other.key, other.file, rec.key, rec.file))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// notFound returns err, or the error from parsing files when some files couldn't be parsed.
+func (s *store) notFound(err error) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	return err
+}
+
+// mac returns the record of a MAC address.
+func (s *store) mac(mac net.HardwareAddr) (*record, error) {
+	if rec, ok := s.byMAC[mac.String()]; ok {
+		return rec, nil
+	}
+
+	return nil, s.notFound(fmt.Errorf("%w: %s", errRecordNotFound, mac.String()))
+}
+
+// ip returns the record of an IP address. When more than one record has the IP address, the first one is returned.
+func (s *store) ip(ip net.IP) (*record, error) {
+	if recs := s.byIP[ip.String()]; len(recs) > 0 {
+		return recs[0], nil
+	}
+
+	return nil, s.notFound(fmt.Errorf("%w: %s", errRecordNotFound, ip.String()))
+}
+
+// circuitID returns the record of a relay agent circuit. A record without a remoteID matches any remoteID.
+func (s *store) circuitID(circuitID, remoteID string) (*record, error) {
+	var found []*record
+	for _, rec := range s.byCircuitID[circuitID] {
+		if rec.remoteID == "" || rec.remoteID == remoteID {
+			found = append(found, rec)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, s.notFound(fmt.Errorf("%w: circuitID %s, remoteID %s", errRecordNotFound, circuitID, remoteID))
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%w: circuitID %s, remoteID %s matches %s", errMultipleRecords, circuitID, remoteID, keys(found))
+	}
+}
+
+// duid returns the record of a DHCPv6 DUID.
+func (s *store) duid(duid []byte) (*record, error) {
+	found := s.byDUID[string(duid)]
+	switch len(found) {
+	case 0:
+		return nil, s.notFound(fmt.Errorf("%w: duid %s", errRecordNotFound, net.HardwareAddr(duid)))
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%w: duid %s matches %s", errMultipleRecords, net.HardwareAddr(duid), keys(found))
+	}
+}
+
+// keys returns the comma separated keys of records, for error messages.
+func keys(recs []*record) string {
+	ks := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		ks = append(ks, rec.key)
+	}
+
+	return strings.Join(ks, ", ")
+}
+
+// get returns copies of the data of the record, so callers can't modify the store.
+// Slices and maps in the copies are shared with the store and must not be modified.
+func (r *record) get() (*data.DHCP, *data.Netboot, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	d, n := *r.d, *r.n
+
+	return &d, &n, nil
+}
+
+// result returns the data of the record found by a lookup and sets the status of the lookup's span.
+func result(span trace.Span, rec *record, err error) (*data.DHCP, *data.Netboot, error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	d, n, err := rec.get()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	span.SetAttributes(d.EncodeToAttributes()...)
+	span.SetAttributes(n.EncodeToAttributes()...)
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
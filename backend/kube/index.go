@@ -1,6 +1,8 @@
 package kube
 
 import (
+	"strings"
+
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -51,3 +53,70 @@ func GetIPs(h *v1alpha1.Hardware) []string {
 	}
 	return ips
 }
+
+const (
+	// CircuitIDAnnotation is the Hardware annotation that holds the relay agent circuit ID (DHCP option 82.1),
+	// usually the switch port, that the Hardware is connected to.
+	CircuitIDAnnotation = "dhcp.tinkerbell.org/circuit-id"
+	// RemoteIDAnnotation is the Hardware annotation that holds the relay agent remote ID (DHCP option 82.2),
+	// usually the switch, that the Hardware is connected to.
+	RemoteIDAnnotation = "dhcp.tinkerbell.org/remote-id"
+	// DUIDAnnotation is the Hardware annotation that holds the DHCPv6 DUID (DHCPv6 option 1) of the Hardware,
+	// in colon separated hex bytes, for example "00:03:00:01:3c:ec:ef:4c:4f:54".
+	DUIDAnnotation = "dhcp.tinkerbell.org/duid"
+	// OptionsAnnotation is the Hardware annotation that holds custom DHCP options, as a JSON object keyed by option code.
+	// For example: {"66": {"type": "string", "value": "192.168.2.1"}, "150": {"type": "ip-list", "value": "192.168.2.1"}}.
+	// See data.OptionType for the supported types.
+	OptionsAnnotation = "dhcp.tinkerbell.org/options"
+	// ClasslessStaticRoutesAnnotation is the Hardware annotation that holds classless static routes (DHCP option 121),
+	// as a JSON list. For example: [{"destination": "10.0.0.0/8", "router": "192.168.2.1"}].
+	ClasslessStaticRoutesAnnotation = "dhcp.tinkerbell.org/classless-static-routes"
+	// BootFileNameAnnotation is the Hardware annotation that overrides the iPXE binary file name of the Hardware,
+	// for example "grubx64.efi".
+	BootFileNameAnnotation = "dhcp.tinkerbell.org/boot-file-name"
+	// NextServerAnnotation is the Hardware annotation that overrides the TFTP server (next-server) of the Hardware,
+	// as an IPv4 address.
+	NextServerAnnotation = "dhcp.tinkerbell.org/next-server"
+	// HTTPBootURLAnnotation is the Hardware annotation that overrides the HTTP server of the boot file of the Hardware,
+	// for example "http://192.168.2.1:8080/grub".
+	HTTPBootURLAnnotation = "dhcp.tinkerbell.org/http-boot-url"
+	// DirectBootAnnotation is the Hardware annotation that, when "true", boots the boot file of the Hardware directly
+	// instead of chaining into an iPXE script.
+	DirectBootAnnotation = "dhcp.tinkerbell.org/direct-boot"
+	// ONIEInstallerURLAnnotation is the Hardware annotation that holds the URL of the ONIE installer (DHCP option 114)
+	// of a network switch.
+	ONIEInstallerURLAnnotation = "dhcp.tinkerbell.org/onie-installer-url"
+	// ZTPScriptURLAnnotation is the Hardware annotation that holds the URL of the Zero Touch Provisioning script
+	// (DHCP option 67) of an Arista or Cisco network switch.
+	ZTPScriptURLAnnotation = "dhcp.tinkerbell.org/ztp-script-url"
+)
+
+// CircuitIDIndex is an index used with a controller-runtime client to lookup hardware by relay agent circuit ID.
+const CircuitIDIndex = ".Metadata.Annotations.CircuitID"
+
+// CircuitIDs returns a list with the relay agent circuit ID of a Hardware object.
+func CircuitIDs(obj client.Object) []string {
+	hw, ok := obj.(*v1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+	if c := hw.GetAnnotations()[CircuitIDAnnotation]; c != "" {
+		return []string{c}
+	}
+	return nil
+}
+
+// DUIDIndex is an index used with a controller-runtime client to lookup hardware by DHCPv6 DUID.
+const DUIDIndex = ".Metadata.Annotations.DUID"
+
+// DUIDs returns a list with the lower cased DHCPv6 DUID of a Hardware object.
+func DUIDs(obj client.Object) []string {
+	hw, ok := obj.(*v1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+	if d := hw.GetAnnotations()[DUIDAnnotation]; d != "" {
+		return []string{strings.ToLower(d)}
+	}
+	return nil
+}
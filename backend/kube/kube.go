@@ -3,13 +3,17 @@ package kube
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/metrics"
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
@@ -20,17 +24,26 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/cluster"
 )
 
-const tracerName = "github.com/tinkerbell/dhcp"
+const (
+	tracerName = "github.com/tinkerbell/dhcp"
+	// backendName is the backend label of lookup metrics.
+	backendName = "kube"
+)
 
 // Backend is a backend implementation that uses the Tinkerbell CRDs to get DHCP data.
 type Backend struct {
 	cluster cluster.Cluster
+
+	// Metrics records the duration and result of lookups.
+	// When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
 }
 
 // NewBackend returns a controller-runtime cluster.Cluster with the Tinkerbell runtime
 // scheme registered, and indexers for:
 // * Hardware by MAC address
 // * Hardware by IP address
+// * Hardware by relay agent circuit ID
 //
 // Callers must instantiate the client-side cache by calling Start() before use.
 func NewBackend(conf *rest.Config, opts ...cluster.Option) (*Backend, error) {
@@ -60,6 +73,14 @@ func NewBackend(conf *rest.Config, opts ...cluster.Option) (*Backend, error) {
 		return nil, fmt.Errorf("failed to setup indexer(.spec.interfaces.dhcp.ip.address): %w", err)
 	}
 
+	if err := c.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.Hardware{}, CircuitIDIndex, CircuitIDs); err != nil {
+		return nil, fmt.Errorf("failed to setup indexer(.metadata.annotations.circuit-id): %w", err)
+	}
+
+	if err := c.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.Hardware{}, DUIDIndex, DUIDs); err != nil {
+		return nil, fmt.Errorf("failed to setup indexer(.metadata.annotations.duid): %w", err)
+	}
+
 	return &Backend{cluster: c}, nil
 }
 
@@ -70,6 +91,15 @@ func (b *Backend) Start(ctx context.Context) error {
 
 // GetByMac implements the handler.BackendReader interface and returns DHCP and netboot data based on a mac address.
 func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := b.getByMac(ctx, mac)
+	b.Metrics.BackendLookup(backendName, "GetByMac", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByMac does the lookup for GetByMac.
+func (b *Backend) getByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
 	tracer := otel.Tracer(tracerName)
 	ctx, span := tracer.Start(ctx, "backend.kube.GetByMac")
 	defer span.End()
@@ -104,6 +134,9 @@ func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHC
 	}
 
 	d, err := toDHCPData(i.DHCP)
+	if err == nil {
+		err = fromAnnotations(d, hardwareList.Items[0].GetAnnotations())
+	}
 	if err != nil {
 		err = fmt.Errorf("failed to convert hardware to DHCP data: %w", err)
 		span.SetStatus(codes.Error, err.Error())
@@ -111,6 +144,9 @@ func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHC
 		return nil, nil, err
 	}
 	n, err := toNetbootData(i.Netboot)
+	if err == nil {
+		err = netbootFromAnnotations(n, hardwareList.Items[0].GetAnnotations())
+	}
 	if err != nil {
 		err = fmt.Errorf("failed to convert hardware to netboot data: %w", err)
 		span.SetStatus(codes.Error, err.Error())
@@ -127,6 +163,15 @@ func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHC
 
 // GetByIP implements the handler.BackendReader interface and returns DHCP and netboot data based on an IP address.
 func (b *Backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := b.getByIP(ctx, ip)
+	b.Metrics.BackendLookup(backendName, "GetByIP", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByIP does the lookup for GetByIP.
+func (b *Backend) getByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
 	tracer := otel.Tracer(tracerName)
 	ctx, span := tracer.Start(ctx, "backend.kube.GetByIP")
 	defer span.End()
@@ -161,13 +206,176 @@ func (b *Backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Net
 	}
 
 	d, err := toDHCPData(i.DHCP)
+	if err == nil {
+		err = fromAnnotations(d, hardwareList.Items[0].GetAnnotations())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to convert hardware to DHCP data: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	n, err := toNetbootData(i.Netboot)
+	if err == nil {
+		err = netbootFromAnnotations(n, hardwareList.Items[0].GetAnnotations())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to convert hardware to netboot data: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	span.SetAttributes(d.EncodeToAttributes()...)
+	span.SetAttributes(n.EncodeToAttributes()...)
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// GetByCircuitID implements the handler.CircuitReader interface and returns DHCP and netboot data based on
+// the relay agent circuit ID and remote ID annotations of a Hardware object.
+// The first interface with DHCP data is used. Hardware without a remote ID annotation matches any remoteID.
+func (b *Backend) GetByCircuitID(ctx context.Context, circuitID, remoteID string) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := b.getByCircuitID(ctx, circuitID, remoteID)
+	b.Metrics.BackendLookup(backendName, "GetByCircuitID", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByCircuitID does the lookup for GetByCircuitID.
+func (b *Backend) getByCircuitID(ctx context.Context, circuitID, remoteID string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.GetByCircuitID")
+	defer span.End()
+	hardwareList := &v1alpha1.HardwareList{}
+
+	if err := b.cluster.GetClient().List(ctx, hardwareList, &client.MatchingFields{CircuitIDIndex: circuitID}); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed listing hardware for circuit id (%v): %w", circuitID, err)
+	}
+
+	var hw []v1alpha1.Hardware
+	for _, h := range hardwareList.Items {
+		if r := h.GetAnnotations()[RemoteIDAnnotation]; r == "" || r == remoteID {
+			hw = append(hw, h)
+		}
+	}
+
+	if len(hw) == 0 {
+		err := hardwareNotFoundError{}
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	if len(hw) > 1 {
+		err := fmt.Errorf("got %d hardware objects for circuit id %s and remote id %s, expected only 1", len(hw), circuitID, remoteID)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	i := v1alpha1.Interface{}
+	for _, iface := range hw[0].Spec.Interfaces {
+		if iface.DHCP != nil {
+			i = iface
+			break
+		}
+	}
+
+	d, err := toDHCPData(i.DHCP)
+	if err == nil {
+		err = fromAnnotations(d, hw[0].GetAnnotations())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to convert hardware to DHCP data: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	n, err := toNetbootData(i.Netboot)
+	if err == nil {
+		err = netbootFromAnnotations(n, hw[0].GetAnnotations())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to convert hardware to netboot data: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	span.SetAttributes(d.EncodeToAttributes()...)
+	span.SetAttributes(n.EncodeToAttributes()...)
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// GetByDUID implements the handler.DUIDReader interface and returns DHCP and netboot data based on
+// the DHCPv6 DUID annotation of a Hardware object.
+// The first interface with DHCP data is used.
+func (b *Backend) GetByDUID(ctx context.Context, duid []byte) (*data.DHCP, *data.Netboot, error) {
+	start := time.Now()
+	d, n, err := b.getByDUID(ctx, duid)
+	b.Metrics.BackendLookup(backendName, "GetByDUID", metrics.Result(err), time.Since(start))
+
+	return d, n, err
+}
+
+// getByDUID does the lookup for GetByDUID.
+func (b *Backend) getByDUID(ctx context.Context, duid []byte) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.GetByDUID")
+	defer span.End()
+	hardwareList := &v1alpha1.HardwareList{}
+
+	id := net.HardwareAddr(duid).String()
+	if err := b.cluster.GetClient().List(ctx, hardwareList, &client.MatchingFields{DUIDIndex: id}); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed listing hardware for duid (%v): %w", id, err)
+	}
+
+	if len(hardwareList.Items) == 0 {
+		err := hardwareNotFoundError{}
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	if len(hardwareList.Items) > 1 {
+		err := fmt.Errorf("got %d hardware objects for duid %s, expected only 1", len(hardwareList.Items), id)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	i := v1alpha1.Interface{}
+	for _, iface := range hardwareList.Items[0].Spec.Interfaces {
+		if iface.DHCP != nil {
+			i = iface
+			break
+		}
+	}
+
+	d, err := toDHCPData(i.DHCP)
+	if err == nil {
+		err = fromAnnotations(d, hardwareList.Items[0].GetAnnotations())
+	}
 	if err != nil {
 		err = fmt.Errorf("failed to convert hardware to DHCP data: %w", err)
 		span.SetStatus(codes.Error, err.Error())
 
 		return nil, nil, err
 	}
+	d.DUID = duid
 	n, err := toNetbootData(i.Netboot)
+	if err == nil {
+		err = netbootFromAnnotations(n, hardwareList.Items[0].GetAnnotations())
+	}
 	if err != nil {
 		err = fmt.Errorf("failed to convert hardware to netboot data: %w", err)
 		span.SetStatus(codes.Error, err.Error())
@@ -185,6 +393,7 @@ func (b *Backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Net
 // toDHCPData converts a v1alpha1.DHCP to a data.DHCP data structure.
 // if required fields are missing, an error is returned.
 // Required fields: v1alpha1.Interface.DHCP.MAC, v1alpha1.Interface.DHCP.IP.Address, v1alpha1.Interface.DHCP.IP.Netmask.
+// An IPv6 v1alpha1.Interface.DHCP.IP.Address is used for DHCPv6 and doesn't require a netmask.
 func toDHCPData(h *v1alpha1.DHCP) (*data.DHCP, error) {
 	if h == nil {
 		return nil, errors.New("no DHCP data")
@@ -197,25 +406,30 @@ func toDHCPData(h *v1alpha1.DHCP) (*data.DHCP, error) {
 		return nil, err
 	}
 
-	if h.IP != nil {
-		// IPAddress is required
-		if d.IPAddress, err = netip.ParseAddr(h.IP.Address); err != nil {
-			return nil, err
-		}
+	if h.IP == nil {
+		return nil, errors.New("no IP data")
+	}
+	// IPAddress is required
+	ip, err := netip.ParseAddr(h.IP.Address)
+	if err != nil {
+		return nil, err
+	}
+	if ip.Is6() && !ip.Is4In6() {
+		d.IPv6Address = ip
+	} else {
+		d.IPAddress = ip
 		// Netmask is required
 		sm := net.ParseIP(h.IP.Netmask)
 		if sm == nil {
 			return nil, errors.New("no netmask")
 		}
 		d.SubnetMask = net.IPMask(sm.To4())
-	} else {
-		return nil, errors.New("no IP data")
-	}
 
-	// Gateway is optional, but should be a valid IP address if present
-	if h.IP.Gateway != "" {
-		if d.DefaultGateway, err = netip.ParseAddr(h.IP.Gateway); err != nil {
-			return nil, err
+		// Gateway is optional, but should be a valid IP address if present
+		if h.IP.Gateway != "" {
+			if d.DefaultGateway, err = netip.ParseAddr(h.IP.Gateway); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -225,6 +439,10 @@ func toDHCPData(h *v1alpha1.DHCP) (*data.DHCP, error) {
 		if ip == nil {
 			break
 		}
+		if ip.To4() == nil {
+			d.IPv6NameServers = append(d.IPv6NameServers, ip)
+			continue
+		}
 		d.NameServers = append(d.NameServers, ip)
 	}
 
@@ -243,6 +461,120 @@ func toDHCPData(h *v1alpha1.DHCP) (*data.DHCP, error) {
 	return d, nil
 }
 
+// fromAnnotations sets the DHCP data that is held in annotations of a Hardware object.
+func fromAnnotations(d *data.DHCP, annotations map[string]string) error {
+	opts, err := toDHCPOptions(annotations)
+	if err != nil {
+		return err
+	}
+	routes, err := toClasslessStaticRoutes(annotations)
+	if err != nil {
+		return err
+	}
+	d.Options = opts
+	d.ClasslessStaticRoutes = routes
+
+	return nil
+}
+
+// toDHCPOptions converts the custom DHCP options annotation of a Hardware object to data.Option values.
+// The annotation is a JSON object keyed by option code, for example {"66": {"type": "string", "value": "192.168.2.1"}}.
+func toDHCPOptions(annotations map[string]string) (map[uint8]data.Option, error) {
+	a, ok := annotations[OptionsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var opts map[uint8]struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(a), &opts); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation: %w", OptionsAnnotation, err)
+	}
+	d := make(map[uint8]data.Option, len(opts))
+	for code, o := range opts {
+		opt := data.Option{Type: data.OptionType(o.Type), Value: o.Value}
+		if err := opt.Validate(code); err != nil {
+			return nil, fmt.Errorf("invalid %v annotation: %w", OptionsAnnotation, err)
+		}
+		d[code] = opt
+	}
+
+	return d, nil
+}
+
+// toClasslessStaticRoutes converts the classless static routes annotation of a Hardware object to data.Route values.
+// Destinations must be IPv4 prefixes and routers IPv4 addresses.
+func toClasslessStaticRoutes(annotations map[string]string) ([]data.Route, error) {
+	a, ok := annotations[ClasslessStaticRoutesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var routes []struct {
+		Destination string `json:"destination"`
+		Router      string `json:"router"`
+	}
+	if err := json.Unmarshal([]byte(a), &routes); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation: %w", ClasslessStaticRoutesAnnotation, err)
+	}
+	d := make([]data.Route, 0, len(routes))
+	for _, r := range routes {
+		dst, err := netip.ParsePrefix(r.Destination)
+		if err != nil || !dst.Addr().Is4() {
+			return nil, fmt.Errorf("invalid %v annotation: destination %q is not an IPv4 prefix", ClasslessStaticRoutesAnnotation, r.Destination)
+		}
+		rtr, err := netip.ParseAddr(r.Router)
+		if err != nil || !rtr.Is4() {
+			return nil, fmt.Errorf("invalid %v annotation: router %q is not an IPv4 address", ClasslessStaticRoutesAnnotation, r.Router)
+		}
+		d = append(d, data.Route{Destination: dst, Router: rtr})
+	}
+
+	return d, nil
+}
+
+// netbootFromAnnotations sets the netboot data that is held in annotations of a Hardware object.
+func netbootFromAnnotations(n *data.Netboot, annotations map[string]string) error {
+	n.BootFileName = annotations[BootFileNameAnnotation]
+	if a := annotations[NextServerAnnotation]; a != "" {
+		ip, err := netip.ParseAddr(a)
+		if err != nil || !ip.Is4() {
+			return fmt.Errorf("invalid %v annotation: %q is not an IPv4 address", NextServerAnnotation, a)
+		}
+		n.NextServer = ip
+	}
+	if a := annotations[HTTPBootURLAnnotation]; a != "" {
+		u, err := url.ParseRequestURI(a)
+		if err != nil {
+			return fmt.Errorf("invalid %v annotation: %w", HTTPBootURLAnnotation, err)
+		}
+		n.HTTPBootURL = u
+	}
+	if a := annotations[DirectBootAnnotation]; a != "" {
+		b, err := strconv.ParseBool(a)
+		if err != nil {
+			return fmt.Errorf("invalid %v annotation: %w", DirectBootAnnotation, err)
+		}
+		n.DirectBoot = b
+	}
+	if a := annotations[ONIEInstallerURLAnnotation]; a != "" {
+		u, err := url.ParseRequestURI(a)
+		if err != nil {
+			return fmt.Errorf("invalid %v annotation: %w", ONIEInstallerURLAnnotation, err)
+		}
+		n.ONIEInstallerURL = u
+	}
+	if a := annotations[ZTPScriptURLAnnotation]; a != "" {
+		u, err := url.ParseRequestURI(a)
+		if err != nil {
+			return fmt.Errorf("invalid %v annotation: %w", ZTPScriptURLAnnotation, err)
+		}
+		n.ZTPScriptURL = u
+	}
+
+	return nil
+}
+
 // toNetbootData converts a hardware interface to a data.Netboot data structure.
 func toNetbootData(i *v1alpha1.Netboot) (*data.Netboot, error) {
 	if i == nil {
@@ -132,6 +132,22 @@ func TestToDHCPData(t *testing.T) {
 				MACAddress:     net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x04},
 			},
 		},
+		"ipv6": {
+			in: &v1alpha1.DHCP{
+				MAC:         "00:00:00:00:00:04",
+				NameServers: []string{"2001:4860:4860::8888", "1.1.1.1"},
+				IP: &v1alpha1.IP{
+					Address: "2001:db8::4",
+					Family:  6,
+				},
+			},
+			want: &data.DHCP{
+				NameServers:     []net.IP{net.IPv4(1, 1, 1, 1)},
+				IPv6NameServers: []net.IP{net.ParseIP("2001:4860:4860::8888")},
+				IPv6Address:     netip.MustParseAddr("2001:db8::4"),
+				MACAddress:      net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x04},
+			},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -146,6 +162,115 @@ func TestToDHCPData(t *testing.T) {
 	}
 }
 
+func TestToDHCPOptions(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        map[uint8]data.Option
+		shouldErr   bool
+	}{
+		"no annotation": {},
+		"options": {
+			annotations: map[string]string{OptionsAnnotation: `{"66": {"type": "string", "value": "tftp.example.com"}, "150": {"type": "ip-list", "value": "192.168.2.1"}}`},
+			want: map[uint8]data.Option{
+				66:  {Type: data.OptionTypeString, Value: "tftp.example.com"},
+				150: {Type: data.OptionTypeIPList, Value: "192.168.2.1"},
+			},
+		},
+		"not json":            {annotations: map[string]string{OptionsAnnotation: "66=tftp"}, shouldErr: true},
+		"option code too big": {annotations: map[string]string{OptionsAnnotation: `{"256": {"type": "string", "value": "tftp"}}`}, shouldErr: true},
+		"wrong type":          {annotations: map[string]string{OptionsAnnotation: `{"150": {"type": "string", "value": "tftp"}}`}, shouldErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := toDHCPOptions(tt.annotations)
+			if tt.shouldErr != (err != nil) {
+				t.Fatalf("toDHCPOptions() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestToClasslessStaticRoutes(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        []data.Route
+		shouldErr   bool
+	}{
+		"no annotation": {},
+		"routes": {
+			annotations: map[string]string{ClasslessStaticRoutesAnnotation: `[{"destination": "10.0.0.0/8", "router": "192.168.2.2"}, {"destination": "0.0.0.0/0", "router": "192.168.2.1"}]`},
+			want: []data.Route{
+				{Destination: netip.MustParsePrefix("10.0.0.0/8"), Router: netip.MustParseAddr("192.168.2.2")},
+				{Destination: netip.MustParsePrefix("0.0.0.0/0"), Router: netip.MustParseAddr("192.168.2.1")},
+			},
+		},
+		"not json":       {annotations: map[string]string{ClasslessStaticRoutesAnnotation: "10.0.0.0/8 192.168.2.2"}, shouldErr: true},
+		"invalid prefix": {annotations: map[string]string{ClasslessStaticRoutesAnnotation: `[{"destination": "10.0.0.0", "router": "192.168.2.2"}]`}, shouldErr: true},
+		"ipv6 router":    {annotations: map[string]string{ClasslessStaticRoutesAnnotation: `[{"destination": "10.0.0.0/8", "router": "2001:db8::1"}]`}, shouldErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := toClasslessStaticRoutes(tt.annotations)
+			if tt.shouldErr != (err != nil) {
+				t.Fatalf("toClasslessStaticRoutes() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+			if diff := cmp.Diff(got, tt.want, cmpopts.EquateEmpty(), cmp.Comparer(func(a, b netip.Prefix) bool { return a == b }), cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestNetbootFromAnnotations(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        *data.Netboot
+		shouldErr   bool
+	}{
+		"no annotations": {want: &data.Netboot{}},
+		"overrides": {
+			annotations: map[string]string{
+				BootFileNameAnnotation:     "grubx64.efi",
+				NextServerAnnotation:       "192.168.2.5",
+				HTTPBootURLAnnotation:      "http://192.168.2.5:8080/grub",
+				DirectBootAnnotation:       "true",
+				ONIEInstallerURLAnnotation: "http://192.168.2.5/onie-installer",
+				ZTPScriptURLAnnotation:     "tftp://192.168.2.5/poap.py",
+			},
+			want: &data.Netboot{
+				BootFileName:     "grubx64.efi",
+				NextServer:       netip.MustParseAddr("192.168.2.5"),
+				HTTPBootURL:      &url.URL{Scheme: "http", Host: "192.168.2.5:8080", Path: "/grub"},
+				DirectBoot:       true,
+				ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.2.5", Path: "/onie-installer"},
+				ZTPScriptURL:     &url.URL{Scheme: "tftp", Host: "192.168.2.5", Path: "/poap.py"},
+			},
+		},
+		"ipv6 next server":       {annotations: map[string]string{NextServerAnnotation: "2001:db8::1"}, shouldErr: true},
+		"invalid http url":       {annotations: map[string]string{HTTPBootURLAnnotation: "grub"}, shouldErr: true},
+		"invalid ztp script url": {annotations: map[string]string{ZTPScriptURLAnnotation: "poap.py"}, shouldErr: true},
+		"invalid direct boot":    {annotations: map[string]string{DirectBootAnnotation: "yes please"}, shouldErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := new(data.Netboot)
+			err := netbootFromAnnotations(got, tt.annotations)
+			if tt.shouldErr != (err != nil) {
+				t.Fatalf("netbootFromAnnotations() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+			if tt.shouldErr {
+				return
+			}
+			if diff := cmp.Diff(got, tt.want, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestToNetbootData(t *testing.T) {
 	tests := map[string]struct {
 		in        *v1alpha1.Netboot
@@ -259,7 +384,7 @@ func TestGetByIP(t *testing.T) {
 				t.Fatal(diff)
 			}
 
-			if diff := cmp.Diff(gotNetboot, tc.wantNetboot); diff != "" {
+			if diff := cmp.Diff(gotNetboot, tc.wantNetboot, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
 				t.Fatal(diff)
 			}
 		})
@@ -356,13 +481,193 @@ func TestGetByMac(t *testing.T) {
 				t.Fatal(diff)
 			}
 
-			if diff := cmp.Diff(gotNetboot, tc.wantNetboot); diff != "" {
+			if diff := cmp.Diff(gotNetboot, tc.wantNetboot, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
 				t.Fatal(diff)
 			}
 		})
 	}
 }
 
+func TestGetByCircuitID(t *testing.T) {
+	tests := map[string]struct {
+		hwObject    []v1alpha1.Hardware
+		remoteID    string
+		wantDHCP    *data.DHCP
+		wantNetboot *data.Netboot
+		shouldErr   bool
+		failToList  bool
+	}{
+		"empty hardware list":    {shouldErr: true},
+		"different circuit":      {shouldErr: true, hwObject: []v1alpha1.Hardware{withCircuit(hwObject1, "Ethernet1/2", "")}},
+		"different remote id":    {shouldErr: true, remoteID: "leaf02", hwObject: []v1alpha1.Hardware{withCircuit(hwObject1, "Ethernet1/1", "leaf01")}},
+		"more than one hardware": {shouldErr: true, hwObject: []v1alpha1.Hardware{withCircuit(hwObject1, "Ethernet1/1", ""), withCircuit(hwObject2, "Ethernet1/1", "")}},
+		"fail to list hardware":  {shouldErr: true, failToList: true},
+		"good data": {remoteID: "leaf01", hwObject: []v1alpha1.Hardware{withCircuit(hwObject1, "Ethernet1/1", "leaf01"), withCircuit(hwObject2, "Ethernet1/1", "leaf02")}, wantDHCP: &data.DHCP{
+			MACAddress:     net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54},
+			IPAddress:      netip.MustParseAddr("172.16.10.100"),
+			SubnetMask:     []byte{0xff, 0xff, 0xff, 0x00},
+			DefaultGateway: netip.MustParseAddr("255.255.255.0"),
+			NameServers: []net.IP{
+				{0x1, 0x1, 0x1, 0x1},
+			},
+			Hostname:  "sm01",
+			LeaseTime: 86400,
+			Arch:      "x86_64",
+		}, wantNetboot: &data.Netboot{
+			AllowNetboot: true,
+			IPXEScriptURL: &url.URL{
+				Scheme: "http",
+				Host:   "netboot.xyz",
+			},
+		}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rs := runtime.NewScheme()
+			if err := scheme.AddToScheme(rs); err != nil {
+				t.Fatal(err)
+			}
+			if err := v1alpha1.AddToScheme(rs); err != nil {
+				t.Fatal(err)
+			}
+
+			ct := fake.NewClientBuilder()
+			if !tc.failToList {
+				ct = ct.WithScheme(rs)
+				ct = ct.WithRuntimeObjects(&v1alpha1.HardwareList{})
+				ct = ct.WithIndex(&v1alpha1.Hardware{}, CircuitIDIndex, CircuitIDs)
+			}
+			if len(tc.hwObject) > 0 {
+				ct = ct.WithLists(&v1alpha1.HardwareList{Items: tc.hwObject})
+			}
+			cl := ct.Build()
+
+			fn := func(o *cluster.Options) {
+				o.NewClient = func(config *rest.Config, options client.Options) (client.Client, error) {
+					return cl, nil
+				}
+				o.MapperProvider = func(c *rest.Config, httpClient *http.Client) (meta.RESTMapper, error) {
+					return cl.RESTMapper(), nil
+				}
+				o.NewCache = func(config *rest.Config, options cache.Options) (cache.Cache, error) {
+					return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+				}
+			}
+			rc := new(rest.Config)
+			b, err := NewBackend(rc, fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			go b.Start(context.Background())
+			gotDHCP, gotNetboot, err := b.GetByCircuitID(context.Background(), "Ethernet1/1", tc.remoteID)
+			if tc.shouldErr && err == nil {
+				t.Fatal("expected error")
+			}
+
+			if diff := cmp.Diff(gotDHCP, tc.wantDHCP, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
+				t.Fatal(diff)
+			}
+
+			if diff := cmp.Diff(gotNetboot, tc.wantNetboot, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestGetByDUID(t *testing.T) {
+	duid := []byte{0x00, 0x03, 0x00, 0x01, 0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+	tests := map[string]struct {
+		hwObject   []v1alpha1.Hardware
+		wantMAC    net.HardwareAddr
+		shouldErr  bool
+		failToList bool
+	}{
+		"empty hardware list":    {shouldErr: true},
+		"different duid":         {shouldErr: true, hwObject: []v1alpha1.Hardware{withDUID(hwObject1, "00:03:00:01:3c:ec:ef:4c:4f:55")}},
+		"more than one hardware": {shouldErr: true, hwObject: []v1alpha1.Hardware{withDUID(hwObject1, "00:03:00:01:3c:ec:ef:4c:4f:54"), withDUID(hwObject2, "00:03:00:01:3c:ec:ef:4c:4f:54")}},
+		"fail to list hardware":  {shouldErr: true, failToList: true},
+		"good data":              {hwObject: []v1alpha1.Hardware{withDUID(hwObject1, "00:03:00:01:3C:EC:EF:4C:4F:54"), hwObject2}, wantMAC: net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rs := runtime.NewScheme()
+			if err := scheme.AddToScheme(rs); err != nil {
+				t.Fatal(err)
+			}
+			if err := v1alpha1.AddToScheme(rs); err != nil {
+				t.Fatal(err)
+			}
+
+			ct := fake.NewClientBuilder()
+			if !tc.failToList {
+				ct = ct.WithScheme(rs)
+				ct = ct.WithRuntimeObjects(&v1alpha1.HardwareList{})
+				ct = ct.WithIndex(&v1alpha1.Hardware{}, DUIDIndex, DUIDs)
+			}
+			if len(tc.hwObject) > 0 {
+				ct = ct.WithLists(&v1alpha1.HardwareList{Items: tc.hwObject})
+			}
+			cl := ct.Build()
+
+			fn := func(o *cluster.Options) {
+				o.NewClient = func(config *rest.Config, options client.Options) (client.Client, error) {
+					return cl, nil
+				}
+				o.MapperProvider = func(c *rest.Config, httpClient *http.Client) (meta.RESTMapper, error) {
+					return cl.RESTMapper(), nil
+				}
+				o.NewCache = func(config *rest.Config, options cache.Options) (cache.Cache, error) {
+					return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+				}
+			}
+			rc := new(rest.Config)
+			b, err := NewBackend(rc, fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			go b.Start(context.Background())
+			gotDHCP, _, err := b.GetByDUID(context.Background(), duid)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(gotDHCP.MACAddress, tc.wantMAC); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(gotDHCP.DUID, duid); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// withDUID returns a copy of h with the DHCPv6 DUID annotation set.
+func withDUID(h v1alpha1.Hardware, duid string) v1alpha1.Hardware {
+	h.Annotations = map[string]string{DUIDAnnotation: duid}
+
+	return h
+}
+
+// withCircuit returns a copy of h with the relay agent circuit ID and remote ID annotations set.
+func withCircuit(h v1alpha1.Hardware, circuitID, remoteID string) v1alpha1.Hardware {
+	h.Annotations = map[string]string{CircuitIDAnnotation: circuitID}
+	if remoteID != "" {
+		h.Annotations[RemoteIDAnnotation] = remoteID
+	}
+
+	return h
+}
+
 var hwObject1 = v1alpha1.Hardware{
 	TypeMeta: v1.TypeMeta{
 		Kind:       "Hardware",
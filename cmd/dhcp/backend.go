@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp/backend/file"
+	"github.com/tinkerbell/dhcp/backend/kube"
+	"github.com/tinkerbell/dhcp/backend/noop"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/metrics"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+// backend is a handler.BackendReader that may need to run in the background, for example to watch for changes.
+type backend interface {
+	handler.BackendReader
+	// Start runs the backend until ctx is done.
+	Start(ctx context.Context) error
+}
+
+// fileBackend wraps a file.Watcher so that it satisfies the backend interface.
+type fileBackend struct {
+	*file.Watcher
+}
+
+// Start runs the file watcher until ctx is done.
+func (f fileBackend) Start(ctx context.Context) error {
+	f.Watcher.Start(ctx)
+	return nil
+}
+
+// noopBackend wraps a noop.Handler so that it satisfies the backend interface.
+type noopBackend struct {
+	noop.Handler
+}
+
+// Start blocks until ctx is done.
+func (noopBackend) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// newBackend returns the backend selected in the config.
+// Lookups are recorded in m, when it isn't nil.
+func (c *config) newBackend(l logr.Logger, m *metrics.Metrics) (backend, error) {
+	switch c.Backend {
+	case backendFile:
+		w, err := file.NewFormatWatcher(l.WithName("backend.file"), c.FilePath, c.FileFormat)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file backend: %w", err)
+		}
+		w.Metrics = m
+		return fileBackend{Watcher: w}, nil
+	case backendKube:
+		k, err := c.kubeBackend()
+		if err != nil {
+			return nil, err
+		}
+		k.Metrics = m
+		return k, nil
+	case backendNoop:
+		return noopBackend{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownBackend, c.Backend)
+	}
+}
+
+// kubeBackend returns a kube backend using the kubeconfig, API server, and namespace from the config.
+// When no kubeconfig is specified, the default loading rules are used, which includes in-cluster config.
+func (c *config) kubeBackend() (*kube.Backend, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.KubeConfig
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.ClusterInfo.Server = c.KubeAPI
+
+	conf, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kube config: %w", err)
+	}
+
+	var opts []cluster.Option
+	if c.KubeNamespace != "" {
+		opts = append(opts, func(o *cluster.Options) {
+			o.Cache.DefaultNamespaces = map[string]cache.Config{c.KubeNamespace: {}}
+		})
+	}
+
+	k, err := kube.NewBackend(conf, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kube backend: %w", err)
+	}
+
+	return k, nil
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/backend/file"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/handler/pool"
+	"github.com/tinkerbell/dhcp/handler/proxy"
+	"github.com/tinkerbell/dhcp/handler/reservation"
+	"github.com/tinkerbell/dhcp/middleware"
+	"golang.org/x/time/rate"
+)
+
+// envPrefix is prepended to the upper cased flag name to get the environment variable name.
+// For example, the flag "ip-addr" can be set with the environment variable "DHCP_IP_ADDR".
+const envPrefix = "DHCP"
+
+// Backends that can be selected at runtime.
+const (
+	backendFile = "file"
+	backendKube = "kube"
+	backendNoop = "noop"
+)
+
+// Modes that determine which handler is used.
+const (
+	modeReservation = "reservation"
+	modePool        = "pool"
+	modeProxy       = "proxy"
+)
+
+// Errors used when validating the configuration.
+var (
+	errNoIPAddr       = errors.New("ip-addr is required")
+	errUnknownBackend = errors.New("unknown backend")
+	errNoFilePath     = errors.New("file-path is required when using the file backend")
+	errNoIPXEBin      = errors.New("ipxe-bin-tftp, or tftp-addr, and ipxe-bin-http are required when netboot is enabled")
+	errNoTFTPDir      = errors.New("tftp-dir is required when tftp-addr is set")
+	errUnknownMode    = errors.New("unknown mode")
+	errNoPoolRanges   = errors.New("pool-ranges is required in pool mode")
+	errPoolRange      = errors.New("pool range must be in the format <start IPv4>-<end IPv4>, with start not after end")
+	errPoolNameServer = errors.New("invalid pool name server")
+	errProxyNetboot   = errors.New("netboot-enabled must be true in proxy mode")
+	errDHCPv6Mode     = errors.New("listen-addr6 is only supported in reservation mode")
+	errNoServerDUID   = errors.New("no network interface with a MAC address to build the DHCPv6 server DUID from")
+)
+
+// config holds all user configurable values for running the DHCP server.
+type config struct {
+	// LogLevel is the verbosity of the logger. Higher is more verbose.
+	LogLevel int
+	// Interface is the name of the network interface to bind to.
+	Interface string
+	// ListenAddr is the IP:Port to listen on for DHCP requests.
+	ListenAddr netip.AddrPort
+	// ListenAddr6 is the [IP]:Port to listen on for DHCPv6 requests. DHCPv6 is disabled when it isn't valid.
+	ListenAddr6 netip.AddrPort
+	// Backend is the name of the backend to use for DHCP data.
+	Backend string
+	// Mode is the name of the handler to use.
+	Mode string
+	// Workers, QueueSize and DropPolicy bound the number of DHCP messages handled at once, for DHCPv4 and DHCPv6 each.
+	Workers    int
+	QueueSize  int
+	DropPolicy dhcp.DropPolicy
+
+	// File backend configuration.
+	FilePath   string
+	FileFormat file.Format
+
+	// Lease store configuration.
+	LeaseFile      string
+	LeaseRetention time.Duration
+
+	// Kube backend configuration.
+	KubeConfig    string
+	KubeAPI       string
+	KubeNamespace string
+
+	// reservation.Handler configuration.
+	IPAddr      netip.Addr
+	SyslogAddr  netip.Addr
+	OTELEnabled bool
+	AlwaysSend  string
+
+	// reservation.Netboot configuration.
+	NetbootEnabled     bool
+	IPXEBinServerTFTP  netip.AddrPort
+	IPXEBinServerHTTP  string
+	IPXEScriptURL      string
+	IPXEScriptAddr     netip.AddrPort
+	UserClass          string
+	SwitchProvisioning bool
+
+	// Built-in TFTP server configuration.
+	TFTPAddr netip.AddrPort
+	TFTPDir  string
+
+	// pool.Handler configuration.
+	PoolRanges       string
+	PoolSubnetMask   netip.Addr
+	PoolGateway      netip.Addr
+	PoolNameServers  string
+	PoolDomainName   string
+	PoolLeaseTime    uint
+	PoolAllowNetboot bool
+
+	// proxy.Handler configuration.
+	ProxyBootServerAddr netip.AddrPort
+
+	// Middleware configuration.
+	RateLimit      float64
+	RateLimitBurst int
+	HandlerTimeout time.Duration
+
+	// MetricsAddr is the IP:Port to serve Prometheus metrics on.
+	MetricsAddr string
+}
+
+// register defines all flags for the config on fs.
+func (c *config) register(fs *flag.FlagSet) {
+	fs.IntVar(&c.LogLevel, "log-level", 0, "log verbosity, higher is more verbose")
+	fs.StringVar(&c.Interface, "interface", "", "network interface to bind to, all interfaces when empty")
+	fs.TextVar(&c.ListenAddr, "listen-addr", netip.MustParseAddrPort("0.0.0.0:67"), "IP:Port to listen on for DHCP requests")
+	fs.TextVar(&c.ListenAddr6, "listen-addr6", netip.AddrPort{}, "[IP]:Port to listen on for DHCPv6 requests, for example [::]:547, DHCPv6 is disabled when empty")
+	fs.StringVar(&c.Backend, "backend", backendKube, fmt.Sprintf("backend to use for DHCP data, one of: %v", strings.Join([]string{backendFile, backendKube, backendNoop}, ", ")))
+
+	fs.IntVar(&c.Workers, "workers", 64, "number of DHCP messages handled at once, every message is handled immediately when 0")
+	fs.IntVar(&c.QueueSize, "queue-size", 1024, "number of DHCP messages that can wait to be handled, messages are dropped when the queue is full")
+	fs.TextVar(&c.DropPolicy, "drop-policy", dhcp.DropNewest, "which DHCP message to drop when the queue is full, one of: newest, oldest")
+
+	fs.StringVar(&c.Mode, "mode", modeReservation, fmt.Sprintf("handler to use, one of: %v", strings.Join([]string{modeReservation, modePool, modeProxy}, ", ")))
+
+	fs.StringVar(&c.FilePath, "file-path", "", "[file backend] path to the file, directory of files or glob pattern of files holding DHCP data")
+	fs.TextVar(&c.FileFormat, "file-format", file.FormatAuto, "[file backend] format of the files, one of: auto, yaml, json, csv, dnsmasq, isc, detected from each file when auto")
+
+	fs.StringVar(&c.LeaseFile, "lease-file", "", "path to the lease journal file, leases are not recorded when empty")
+	fs.DurationVar(&c.LeaseRetention, "lease-retention", 30*24*time.Hour, "how long ended leases are kept in the lease journal")
+
+	fs.StringVar(&c.KubeConfig, "kube-config", "", "[kube backend] path to a kubeconfig file, in-cluster config is used when empty")
+	fs.StringVar(&c.KubeAPI, "kube-api", "", "[kube backend] URL of the Kubernetes API server, overrides the kubeconfig value")
+	fs.StringVar(&c.KubeNamespace, "kube-namespace", "", "[kube backend] namespace to watch for Hardware objects, all namespaces when empty")
+
+	fs.TextVar(&c.IPAddr, "ip-addr", netip.Addr{}, "IP address of this server, used in DHCP option 54 and the siaddr header")
+	fs.TextVar(&c.SyslogAddr, "syslog-addr", netip.Addr{}, "IP address to send in DHCP option 7 (log server)")
+	fs.BoolVar(&c.OTELEnabled, "otel-enabled", false, "append OTel traceparent information to netboot filenames")
+	fs.StringVar(&c.AlwaysSend, "always-send-options", "", "comma separated DHCP option codes sent even when a client doesn't request them in option 55, for example: 1,3,43,60,97, a default set is used when empty")
+
+	fs.BoolVar(&c.NetbootEnabled, "netboot-enabled", true, "send netboot options to netboot clients")
+	fs.TextVar(&c.IPXEBinServerTFTP, "ipxe-bin-tftp", netip.AddrPort{}, "IP:Port of the TFTP server serving iPXE binaries")
+	fs.StringVar(&c.IPXEBinServerHTTP, "ipxe-bin-http", "", "URL of the HTTP server serving iPXE binaries")
+	fs.StringVar(&c.IPXEScriptURL, "ipxe-script-url", "", "URL of the iPXE script to serve to clients")
+	fs.TextVar(&c.IPXEScriptAddr, "ipxe-script-addr", netip.AddrPort{}, "IP:Port to serve the inline iPXE scripts of machines on over HTTP, disabled when empty")
+	fs.StringVar(&c.UserClass, "user-class", "", "custom DHCP option 77 user class used to break out of an iPXE loop")
+	fs.BoolVar(&c.SwitchProvisioning, "switch-provisioning", false, "send ONIE installer and ZTP script URLs to network switches")
+
+	fs.TextVar(&c.TFTPAddr, "tftp-addr", netip.AddrPort{}, "IP:Port to serve iPXE binaries on over TFTP, for example 0.0.0.0:69, the built-in TFTP server is disabled when empty")
+	fs.StringVar(&c.TFTPDir, "tftp-dir", "", "directory holding the iPXE binaries served by the built-in TFTP server")
+
+	fs.StringVar(&c.PoolRanges, "pool-ranges", "", "[pool mode] comma separated address ranges for clients without a reservation, for example: 192.168.2.100-192.168.2.200")
+	fs.TextVar(&c.PoolSubnetMask, "pool-subnet-mask", netip.MustParseAddr("255.255.255.0"), "[pool mode] subnet mask for pool addresses")
+	fs.TextVar(&c.PoolGateway, "pool-gateway", netip.Addr{}, "[pool mode] default gateway for pool addresses")
+	fs.StringVar(&c.PoolNameServers, "pool-name-servers", "", "[pool mode] comma separated name servers for pool addresses")
+	fs.StringVar(&c.PoolDomainName, "pool-domain-name", "", "[pool mode] domain name for pool addresses")
+	fs.UintVar(&c.PoolLeaseTime, "pool-lease-time", 3600, "[pool mode] lease time in seconds for pool addresses")
+	fs.BoolVar(&c.PoolAllowNetboot, "pool-allow-netboot", false, "[pool mode] send netboot options to clients with a pool address")
+
+	fs.TextVar(&c.ProxyBootServerAddr, "proxy-boot-server-addr", netip.AddrPortFrom(netip.IPv4Unspecified(), proxy.BootServerPort), "[proxy mode] IP:Port to listen on for PXE boot server requests")
+
+	fs.Float64Var(&c.RateLimit, "rate-limit", 0, "maximum DHCP messages per second handled per MAC address, no limit when 0")
+	fs.IntVar(&c.RateLimitBurst, "rate-limit-burst", 5, "maximum burst of DHCP messages handled per MAC address when rate-limit is set")
+	fs.DurationVar(&c.HandlerTimeout, "handler-timeout", 0, "maximum time to handle a single DHCP message, no timeout when 0")
+
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", "", "IP:Port to serve Prometheus metrics on at /metrics, metrics are disabled when empty")
+}
+
+// parse parses args into fs. Flags not set in args are set from the environment, using lookup.
+// The order of precedence is: command line flags, environment variables, flag defaults.
+func parse(fs *flag.FlagSet, args []string, lookup func(string) (string, bool)) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		if v, ok := lookup(envName(f.Name)); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for environment variable %v: %w", v, envName(f.Name), e)
+			}
+		}
+	})
+
+	return err
+}
+
+// envName returns the environment variable name for a flag name.
+func envName(flagName string) string {
+	return fmt.Sprintf("%v_%v", envPrefix, strings.ToUpper(strings.ReplaceAll(flagName, "-", "_")))
+}
+
+// validate checks that the config is usable.
+func (c *config) validate() error {
+	if !c.IPAddr.IsValid() {
+		return errNoIPAddr
+	}
+	switch c.Backend {
+	case backendFile:
+		if c.FilePath == "" {
+			return errNoFilePath
+		}
+	case backendKube, backendNoop:
+	default:
+		return fmt.Errorf("%w: %q", errUnknownBackend, c.Backend)
+	}
+	switch c.Mode {
+	case modeReservation:
+	case modePool:
+		if c.PoolRanges == "" {
+			return errNoPoolRanges
+		}
+	case modeProxy:
+		if !c.NetbootEnabled {
+			return errProxyNetboot
+		}
+	default:
+		return fmt.Errorf("%w: %q", errUnknownMode, c.Mode)
+	}
+	if c.ListenAddr6.IsValid() && c.Mode != modeReservation {
+		return errDHCPv6Mode
+	}
+	if c.NetbootEnabled && (!c.ipxeBinTFTP().IsValid() || c.IPXEBinServerHTTP == "") {
+		return errNoIPXEBin
+	}
+	if c.TFTPAddr.IsValid() && c.TFTPDir == "" {
+		return errNoTFTPDir
+	}
+
+	return nil
+}
+
+// netboot returns the reservation.Netboot configuration.
+func (c *config) netboot() (reservation.Netboot, error) {
+	n := reservation.Netboot{
+		IPXEBinServerTFTP:  c.ipxeBinTFTP(),
+		Enabled:            c.NetbootEnabled,
+		UserClass:          reservation.UserClass(c.UserClass),
+		SwitchProvisioning: c.SwitchProvisioning,
+	}
+	if c.IPXEBinServerHTTP != "" {
+		u, err := url.Parse(c.IPXEBinServerHTTP)
+		if err != nil {
+			return reservation.Netboot{}, fmt.Errorf("invalid ipxe-bin-http: %w", err)
+		}
+		n.IPXEBinServerHTTP = u
+	}
+	if c.IPXEScriptURL != "" {
+		u, err := url.Parse(c.IPXEScriptURL)
+		if err != nil {
+			return reservation.Netboot{}, fmt.Errorf("invalid ipxe-script-url: %w", err)
+		}
+		n.IPXEScriptURL = func(*dhcpv4.DHCPv4) *url.URL { return u }
+		n.IPXEScriptURL6 = func(*dhcpv6.Message) *url.URL { return u }
+	}
+	if c.IPXEScriptAddr.IsValid() {
+		n.IPXEScriptServer = &url.URL{Scheme: "http", Host: c.advertised(c.IPXEScriptAddr).String()}
+	}
+
+	return n, nil
+}
+
+// ipxeBinTFTP returns the IP:Port of the TFTP server serving iPXE binaries.
+// The built-in TFTP server is used when ipxe-bin-tftp is not set.
+func (c *config) ipxeBinTFTP() netip.AddrPort {
+	if c.IPXEBinServerTFTP.IsValid() || !c.TFTPAddr.IsValid() {
+		return c.IPXEBinServerTFTP
+	}
+
+	return c.advertised(c.TFTPAddr)
+}
+
+// advertised returns the IP:Port that clients reach a server listening on addr at.
+// When the server listens on all addresses, the IP address of this server is used.
+func (c *config) advertised(addr netip.AddrPort) netip.AddrPort {
+	if addr.Addr().IsUnspecified() {
+		return netip.AddrPortFrom(c.IPAddr, addr.Port())
+	}
+
+	return addr
+}
+
+// alwaysSend returns the DHCP options to send even when a client doesn't request them.
+// It returns nil, so that the handler default is used, when none are configured.
+func (c *config) alwaysSend() ([]dhcpv4.OptionCode, error) {
+	var codes []dhcpv4.OptionCode
+	for _, s := range strings.Split(c.AlwaysSend, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		code, err := strconv.ParseUint(s, 10, 8)
+		if err != nil || code == 0 || code == 255 {
+			return nil, fmt.Errorf("invalid always send option: %q", s)
+		}
+		codes = append(codes, dhcpv4.GenericOptionCode(code))
+	}
+
+	return codes, nil
+}
+
+// reservation returns a reservation.Handler built from the config.
+func (c *config) reservation(b handler.BackendReader) (*reservation.Handler, error) {
+	n, err := c.netboot()
+	if err != nil {
+		return nil, err
+	}
+
+	always, err := c.alwaysSend()
+	if err != nil {
+		return nil, err
+	}
+	h := &reservation.Handler{
+		Backend:     b,
+		IPAddr:      c.IPAddr,
+		Netboot:     n,
+		OTELEnabled: c.OTELEnabled,
+		SyslogAddr:  c.SyslogAddr,
+		AlwaysSend:  always,
+	}
+	if c.ListenAddr6.IsValid() {
+		if h.DUID, err = serverDUID(c.Interface); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// serverDUID returns a DUID-LL (RFC 8415, section 11.4) built from the MAC address of the named interface.
+// When ifname is empty, the first interface that is up, isn't a loopback and has a MAC address is used.
+func serverDUID(ifname string) (dhcpv6.DUID, error) {
+	var ifaces []net.Interface
+	if ifname != "" {
+		i, err := net.InterfaceByName(ifname)
+		if err != nil {
+			return nil, err
+		}
+		ifaces = []net.Interface{*i}
+	} else {
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range ifaces {
+		if ifname == "" && (i.Flags&net.FlagUp == 0 || i.Flags&net.FlagLoopback != 0) {
+			continue
+		}
+		if len(i.HardwareAddr) == 0 {
+			continue
+		}
+
+		return &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: i.HardwareAddr}, nil
+	}
+
+	return nil, errNoServerDUID
+}
+
+// pool returns a pool.Handler built from the config.
+func (c *config) pool(b handler.BackendReader) (*pool.Handler, error) {
+	n, err := c.netboot()
+	if err != nil {
+		return nil, err
+	}
+	opts := data.DHCP{
+		SubnetMask:     net.IPMask(c.PoolSubnetMask.AsSlice()),
+		DefaultGateway: c.PoolGateway,
+		DomainName:     c.PoolDomainName,
+		LeaseTime:      uint32(c.PoolLeaseTime),
+	}
+	for _, s := range strings.Split(c.PoolNameServers, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", errPoolNameServer, s)
+		}
+		opts.NameServers = append(opts.NameServers, ip)
+	}
+	always, err := c.alwaysSend()
+	if err != nil {
+		return nil, err
+	}
+	h := &pool.Handler{
+		Backend:      b,
+		IPAddr:       c.IPAddr,
+		Netboot:      n,
+		OTELEnabled:  c.OTELEnabled,
+		SyslogAddr:   c.SyslogAddr,
+		AllowNetboot: c.PoolAllowNetboot,
+		AlwaysSend:   always,
+	}
+	for _, r := range strings.Split(c.PoolRanges, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(r), "-")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", errPoolRange, r)
+		}
+		s, err := netip.ParseAddr(start)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", errPoolRange, r, err)
+		}
+		e, err := netip.ParseAddr(end)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", errPoolRange, r, err)
+		}
+		if !s.Is4() || !e.Is4() || e.Less(s) {
+			return nil, fmt.Errorf("%w: %q", errPoolRange, r)
+		}
+		h.Pools = append(h.Pools, pool.Pool{Start: s, End: e, Options: opts})
+	}
+
+	return h, nil
+}
+
+// proxy returns a proxy.Handler built from the config.
+func (c *config) proxy(b handler.BackendReader) (*proxy.Handler, error) {
+	n, err := c.netboot()
+	if err != nil {
+		return nil, err
+	}
+	always, err := c.alwaysSend()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxy.Handler{
+		Backend:        b,
+		IPAddr:         c.IPAddr,
+		Netboot:        n,
+		OTELEnabled:    c.OTELEnabled,
+		BootServerPort: int(c.ProxyBootServerAddr.Port()),
+		AlwaysSend:     always,
+	}, nil
+}
+
+// middleware returns the dhcp.Middleware for all handlers, built from the config.
+// Panics are always recovered, so that a single DHCP message can't stop the server.
+func (c *config) middleware(l logr.Logger) []dhcp.Middleware {
+	m := []dhcp.Middleware{middleware.Recover(l)}
+	if c.RateLimit > 0 {
+		m = append(m, middleware.RateLimit(l, rate.Limit(c.RateLimit), c.RateLimitBurst))
+	}
+	if c.HandlerTimeout > 0 {
+		m = append(m, middleware.Timeout(l, c.HandlerTimeout))
+	}
+
+	return m
+}
+
+// middleware6 returns the dhcp.Middleware6 for the DHCPv6 handler, the same as middleware.
+func (c *config) middleware6(l logr.Logger) []dhcp.Middleware6 {
+	m := []dhcp.Middleware6{middleware.Recover6(l)}
+	if c.RateLimit > 0 {
+		m = append(m, middleware.RateLimit6(l, rate.Limit(c.RateLimit), c.RateLimitBurst))
+	}
+	if c.HandlerTimeout > 0 {
+		m = append(m, middleware.Timeout6(l, c.HandlerTimeout))
+	}
+
+	return m
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/backend/file"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		env     map[string]string
+		want    *config
+		wantErr bool
+	}{
+		"defaults": {
+			want: &config{
+				ListenAddr:          netip.MustParseAddrPort("0.0.0.0:67"),
+				Mode:                modeReservation,
+				Workers:             64,
+				QueueSize:           1024,
+				PoolSubnetMask:      netip.MustParseAddr("255.255.255.0"),
+				PoolLeaseTime:       3600,
+				LeaseRetention:      30 * 24 * time.Hour,
+				ProxyBootServerAddr: netip.MustParseAddrPort("0.0.0.0:4011"),
+				RateLimitBurst:      5,
+				Backend:             backendKube,
+				NetbootEnabled:      true,
+			},
+		},
+		"flags": {
+			args: []string{"-backend", "file", "-file-path", "/tmp/dhcp.yaml", "-file-format", "dnsmasq", "-ip-addr", "192.168.2.2", "-ipxe-bin-tftp", "192.168.2.2:69", "-netboot-enabled=false"},
+			want: &config{
+				ListenAddr:          netip.MustParseAddrPort("0.0.0.0:67"),
+				Mode:                modeReservation,
+				Workers:             64,
+				QueueSize:           1024,
+				PoolSubnetMask:      netip.MustParseAddr("255.255.255.0"),
+				PoolLeaseTime:       3600,
+				LeaseRetention:      30 * 24 * time.Hour,
+				ProxyBootServerAddr: netip.MustParseAddrPort("0.0.0.0:4011"),
+				RateLimitBurst:      5,
+				Backend:             backendFile,
+				FilePath:            "/tmp/dhcp.yaml",
+				FileFormat:          file.FormatDnsmasq,
+				IPAddr:              netip.MustParseAddr("192.168.2.2"),
+				IPXEBinServerTFTP:   netip.MustParseAddrPort("192.168.2.2:69"),
+			},
+		},
+		"env": {
+			env: map[string]string{"DHCP_BACKEND": "noop", "DHCP_IP_ADDR": "192.168.2.3", "DHCP_LOG_LEVEL": "2", "DHCP_USER_CLASS": "custom"},
+			want: &config{
+				LogLevel:            2,
+				ListenAddr:          netip.MustParseAddrPort("0.0.0.0:67"),
+				Mode:                modeReservation,
+				Workers:             64,
+				QueueSize:           1024,
+				PoolSubnetMask:      netip.MustParseAddr("255.255.255.0"),
+				PoolLeaseTime:       3600,
+				LeaseRetention:      30 * 24 * time.Hour,
+				ProxyBootServerAddr: netip.MustParseAddrPort("0.0.0.0:4011"),
+				RateLimitBurst:      5,
+				Backend:             backendNoop,
+				IPAddr:              netip.MustParseAddr("192.168.2.3"),
+				NetbootEnabled:      true,
+				UserClass:           "custom",
+			},
+		},
+		"flags take precedence over env": {
+			args: []string{"-ip-addr", "192.168.2.2"},
+			env:  map[string]string{"DHCP_IP_ADDR": "192.168.2.3"},
+			want: &config{
+				ListenAddr:          netip.MustParseAddrPort("0.0.0.0:67"),
+				Mode:                modeReservation,
+				Workers:             64,
+				QueueSize:           1024,
+				PoolSubnetMask:      netip.MustParseAddr("255.255.255.0"),
+				PoolLeaseTime:       3600,
+				LeaseRetention:      30 * 24 * time.Hour,
+				ProxyBootServerAddr: netip.MustParseAddrPort("0.0.0.0:4011"),
+				RateLimitBurst:      5,
+				Backend:             backendKube,
+				IPAddr:              netip.MustParseAddr("192.168.2.2"),
+				NetbootEnabled:      true,
+			},
+		},
+		"bad env value":   {env: map[string]string{"DHCP_IP_ADDR": "bad"}, wantErr: true},
+		"bad flag value":  {args: []string{"-listen-addr", "bad"}, wantErr: true},
+		"bad drop policy": {args: []string{"-drop-policy", "random"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := &config{}
+			fs := flag.NewFlagSet(name, flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			got.register(fs)
+			lookup := func(k string) (string, bool) {
+				v, ok := tt.env[k]
+				return v, ok
+			}
+			err := parse(fs, tt.args, lookup)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want, cmpopts.EquateComparable(netip.Addr{}, netip.AddrPort{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *config {
+		return &config{
+			Backend:           backendFile,
+			Mode:              modeReservation,
+			Workers:           64,
+			QueueSize:         1024,
+			FilePath:          "/tmp/dhcp.yaml",
+			IPAddr:            netip.MustParseAddr("192.168.2.2"),
+			NetbootEnabled:    true,
+			IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.2:69"),
+			IPXEBinServerHTTP: "http://192.168.2.2:8080",
+		}
+	}
+	tests := map[string]struct {
+		modify  func(*config)
+		wantErr error
+	}{
+		"valid":           {modify: func(*config) {}},
+		"no ip addr":      {modify: func(c *config) { c.IPAddr = netip.Addr{} }, wantErr: errNoIPAddr},
+		"unknown backend": {modify: func(c *config) { c.Backend = "unknown" }, wantErr: errUnknownBackend},
+		"no file path":    {modify: func(c *config) { c.FilePath = "" }, wantErr: errNoFilePath},
+		"no ipxe http":    {modify: func(c *config) { c.IPXEBinServerHTTP = "" }, wantErr: errNoIPXEBin},
+		"no ipxe tftp":    {modify: func(c *config) { c.IPXEBinServerTFTP = netip.AddrPort{} }, wantErr: errNoIPXEBin},
+		"built-in tftp": {modify: func(c *config) {
+			c.IPXEBinServerTFTP = netip.AddrPort{}
+			c.TFTPAddr = netip.MustParseAddrPort("0.0.0.0:69")
+			c.TFTPDir = "/var/lib/tftpboot"
+		}},
+		"built-in tftp without dir": {modify: func(c *config) { c.TFTPAddr = netip.MustParseAddrPort("0.0.0.0:69") }, wantErr: errNoTFTPDir},
+		"unknown mode":              {modify: func(c *config) { c.Mode = "unknown" }, wantErr: errUnknownMode},
+		"no pool ranges":            {modify: func(c *config) { c.Mode = modePool }, wantErr: errNoPoolRanges},
+		"proxy without netboot":     {modify: func(c *config) { c.Mode = modeProxy; c.NetbootEnabled = false }, wantErr: errProxyNetboot},
+		"netboot disabled":          {modify: func(c *config) { c.NetbootEnabled = false; c.IPXEBinServerHTTP = "" }},
+		"dhcpv6":                    {modify: func(c *config) { c.ListenAddr6 = netip.MustParseAddrPort("[::]:547") }},
+		"dhcpv6 in proxy mode":      {modify: func(c *config) { c.Mode = modeProxy; c.ListenAddr6 = netip.MustParseAddrPort("[::]:547") }, wantErr: errDHCPv6Mode},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			if err := c.validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReservation(t *testing.T) {
+	c := &config{
+		IPAddr:             netip.MustParseAddr("192.168.2.2"),
+		NetbootEnabled:     true,
+		IPXEBinServerTFTP:  netip.MustParseAddrPort("192.168.2.2:69"),
+		IPXEBinServerHTTP:  "http://192.168.2.2:8080",
+		IPXEScriptURL:      "http://192.168.2.2/auto.ipxe",
+		UserClass:          "custom",
+		AlwaysSend:         "1, 3,43",
+		SwitchProvisioning: true,
+	}
+	h, err := c.reservation(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(h.Netboot.IPXEBinServerHTTP, &url.URL{Scheme: "http", Host: "192.168.2.2:8080"}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(h.Netboot.IPXEScriptURL(nil), &url.URL{Scheme: "http", Host: "192.168.2.2", Path: "/auto.ipxe"}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(h.Netboot.IPXEScriptURL6(nil), &url.URL{Scheme: "http", Host: "192.168.2.2", Path: "/auto.ipxe"}); diff != "" {
+		t.Fatal(diff)
+	}
+	if h.Netboot.UserClass != "custom" {
+		t.Fatalf("UserClass = %v, want custom", h.Netboot.UserClass)
+	}
+	if !h.Netboot.SwitchProvisioning {
+		t.Fatal("SwitchProvisioning = false, want true")
+	}
+	if diff := cmp.Diff(h.AlwaysSend, []dhcpv4.OptionCode{dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter, dhcpv4.OptionVendorSpecificInformation}, cmp.Comparer(func(a, b dhcpv4.OptionCode) bool { return a.Code() == b.Code() })); diff != "" {
+		t.Fatal(diff)
+	}
+
+	p, err := c.proxy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(p.AlwaysSend, h.AlwaysSend, cmp.Comparer(func(a, b dhcpv4.OptionCode) bool { return a.Code() == b.Code() })); diff != "" {
+		t.Fatal(diff)
+	}
+
+	c.AlwaysSend = "256"
+	if _, err := c.reservation(nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := c.proxy(nil); err == nil {
+		t.Fatal("expected error")
+	}
+	c.AlwaysSend = ""
+
+	c.IPXEBinServerTFTP = netip.AddrPort{}
+	c.TFTPAddr = netip.MustParseAddrPort("0.0.0.0:6969")
+	h, err = c.reservation(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Netboot.IPXEBinServerTFTP != netip.MustParseAddrPort("192.168.2.2:6969") {
+		t.Fatalf("IPXEBinServerTFTP = %v, want 192.168.2.2:6969", h.Netboot.IPXEBinServerTFTP)
+	}
+
+	c.IPXEScriptAddr = netip.MustParseAddrPort("0.0.0.0:8080")
+	h, err = c.reservation(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(h.Netboot.IPXEScriptServer, &url.URL{Scheme: "http", Host: "192.168.2.2:8080"}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	c.IPXEScriptURL = ":bad"
+	if _, err := c.reservation(nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestServerDUID(t *testing.T) {
+	// the loopback interface has no MAC address.
+	if _, err := serverDUID("lo"); !errors.Is(err, errNoServerDUID) {
+		t.Fatalf("serverDUID(lo) = %v, want %v", err, errNoServerDUID)
+	}
+	if _, err := serverDUID("does-not-exist"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPool(t *testing.T) {
+	tests := map[string]struct {
+		ranges      string
+		nameServers string
+		want        []netip.Addr // start and end of each pool.
+		wantErr     error
+	}{
+		"one range":       {ranges: "192.168.2.100-192.168.2.200", want: []netip.Addr{netip.MustParseAddr("192.168.2.100"), netip.MustParseAddr("192.168.2.200")}},
+		"two ranges":      {ranges: "192.168.2.10-192.168.2.20, 192.168.2.30-192.168.2.40", nameServers: "1.1.1.1,8.8.8.8", want: []netip.Addr{netip.MustParseAddr("192.168.2.10"), netip.MustParseAddr("192.168.2.20"), netip.MustParseAddr("192.168.2.30"), netip.MustParseAddr("192.168.2.40")}},
+		"no separator":    {ranges: "192.168.2.100", wantErr: errPoolRange},
+		"bad start":       {ranges: "bad-192.168.2.200", wantErr: errPoolRange},
+		"bad end":         {ranges: "192.168.2.100-bad", wantErr: errPoolRange},
+		"start after end": {ranges: "192.168.2.200-192.168.2.100", wantErr: errPoolRange},
+		"ipv6 range":      {ranges: "2001:db8::10-2001:db8::20", wantErr: errPoolRange},
+		"ipv6 start":      {ranges: "2001:db8::10-192.168.2.200", wantErr: errPoolRange},
+		"ipv6 end":        {ranges: "192.168.2.100-2001:db8::20", wantErr: errPoolRange},
+		"ipv4 mapped":     {ranges: "::ffff:192.168.2.100-::ffff:192.168.2.200", wantErr: errPoolRange},
+		"bad name server": {ranges: "192.168.2.100-192.168.2.200", nameServers: "bad", wantErr: errPoolNameServer},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &config{
+				IPAddr:          netip.MustParseAddr("192.168.2.2"),
+				PoolRanges:      tt.ranges,
+				PoolSubnetMask:  netip.MustParseAddr("255.255.255.0"),
+				PoolNameServers: tt.nameServers,
+				PoolLeaseTime:   60,
+			}
+			h, err := c.pool(nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("pool() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []netip.Addr
+			for _, p := range h.Pools {
+				got = append(got, p.Start, p.End)
+				if p.Options.LeaseTime != 60 {
+					t.Fatalf("LeaseTime = %v, want 60", p.Options.LeaseTime)
+				}
+			}
+			if diff := cmp.Diff(got, tt.want, cmpopts.EquateComparable(netip.Addr{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		config *config
+		want   int
+	}{
+		"recover only": {config: &config{RateLimitBurst: 5}, want: 1},
+		"rate limit":   {config: &config{RateLimit: 1, RateLimitBurst: 5}, want: 2},
+		"all":          {config: &config{RateLimit: 1, RateLimitBurst: 5, HandlerTimeout: time.Second}, want: 3},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := len(tt.config.middleware(logr.Discard())); got != tt.want {
+				t.Fatalf("got %d middleware, want %d", got, tt.want)
+			}
+			if got := len(tt.config.middleware6(logr.Discard())); got != tt.want {
+				t.Fatalf("got %d DHCPv6 middleware, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Package main is the DHCP server binary.
+// It wires a dhcp.Server, a handler and a backend, all selected at runtime.
+// All configuration is done with command line flags or environment variables.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/equinix-labs/otel-init-go/otelinit"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/stdr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/ipxe"
+	"github.com/tinkerbell/dhcp/lease"
+	"github.com/tinkerbell/dhcp/metrics"
+	"github.com/tinkerbell/dhcp/tftp"
+	"golang.org/x/sync/errgroup"
+)
+
+const name = "github.com/tinkerbell/dhcp"
+
+func main() {
+	c := &config{}
+	fs := flag.NewFlagSet("dhcp", flag.ExitOnError)
+	c.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %v:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nAll flags can also be set with environment variables, for example: %v.\n", envName("ip-addr"))
+	}
+	if err := parse(fs, os.Args[1:], os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	os.Exit(serve(c))
+}
+
+// serve sets up signal handling, OpenTelemetry and logging, then runs the server.
+// It returns the exit code for the process.
+func serve(c *config) int {
+	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGHUP, syscall.SIGTERM)
+	defer done()
+	ctx, otelShutdown := otelinit.InitOpenTelemetry(ctx, name)
+	defer otelShutdown(ctx)
+
+	stdr.SetVerbosity(c.LogLevel)
+	l := stdr.New(log.New(os.Stdout, "", log.Lshortfile)).WithName(name)
+
+	if err := run(ctx, c, l); err != nil {
+		l.Error(err, "exiting")
+		return 1
+	}
+	l.Info("done")
+
+	return 0
+}
+
+// run creates the backend, the handler and the server and runs them until ctx is done or one of them fails.
+func run(ctx context.Context, c *config, l logr.Logger) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	// 1. create the backend
+	// 2. create the handler(backend)
+	// 3. create the listener(handler)
+	var reg *prometheus.Registry
+	var m *metrics.Metrics
+	if c.MetricsAddr != "" {
+		reg = prometheus.NewRegistry()
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		m = metrics.New(reg)
+	}
+
+	b, err := c.newBackend(l, m)
+	if err != nil {
+		return err
+	}
+	var leases handler.LeaseStore
+	var journal *lease.Journal
+	if c.LeaseFile != "" {
+		journal, err = lease.NewJournal(l.WithName("lease.journal"), c.LeaseFile)
+		if err != nil {
+			return fmt.Errorf("failed to open lease journal: %w", err)
+		}
+		defer journal.Close()
+		leases = journal
+	}
+	h, err := c.handler(b, leases, m, l)
+	if err != nil {
+		return err
+	}
+
+	server, err := dhcp.NewServer(c.Interface, net.UDPAddrFromAddrPort(c.ListenAddr), h)
+	if err != nil {
+		return fmt.Errorf("failed to create DHCP listener: %w", err)
+	}
+	server.Logger = l
+	server.Middleware = c.middleware(l.WithName("middleware"))
+	server.Workers = c.Workers
+	server.QueueSize = c.QueueSize
+	server.DropPolicy = c.DropPolicy
+	server.Metrics = m
+	var bootServer *dhcp.Server
+	if c.Mode == modeProxy {
+		// netboot clients send PXE boot server requests to a different port than DHCP messages.
+		bootServer, err = dhcp.NewServer(c.Interface, net.UDPAddrFromAddrPort(c.ProxyBootServerAddr), h)
+		if err != nil {
+			return fmt.Errorf("failed to create PXE boot server listener: %w", err)
+		}
+		bootServer.Logger = l
+		bootServer.Middleware = server.Middleware
+		bootServer.Workers = c.Workers
+		bootServer.QueueSize = c.QueueSize
+		bootServer.DropPolicy = c.DropPolicy
+		bootServer.Metrics = m
+	}
+	var server6 *dhcp.Server6
+	if c.ListenAddr6.IsValid() {
+		h6, ok := h.(dhcp.Handler6)
+		if !ok {
+			return errDHCPv6Mode
+		}
+		server6, err = dhcp.NewServer6(c.Interface, net.UDPAddrFromAddrPort(c.ListenAddr6), h6)
+		if err != nil {
+			return fmt.Errorf("failed to create DHCPv6 listener: %w", err)
+		}
+		server6.Logger = l
+		server6.Middleware = c.middleware6(l.WithName("middleware"))
+		server6.Workers = c.Workers
+		server6.QueueSize = c.QueueSize
+		server6.DropPolicy = c.DropPolicy
+		server6.Metrics = m
+	}
+
+	var tftpServer *tftp.Server
+	if c.TFTPAddr.IsValid() {
+		tftpServer, err = tftp.NewServer(net.UDPAddrFromAddrPort(c.TFTPAddr), os.DirFS(c.TFTPDir))
+		if err != nil {
+			return fmt.Errorf("failed to create TFTP listener: %w", err)
+		}
+		tftpServer.Logger = l.WithName("tftp")
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return b.Start(ctx)
+	})
+	if journal != nil {
+		g.Go(func() error {
+			return compactLeases(ctx, journal, c.LeaseRetention, l)
+		})
+	}
+	g.Go(func() error {
+		l.Info("starting server", "addr", c.ListenAddr, "interface", c.Interface, "backend", c.Backend, "mode", c.Mode, "ipAddr", c.IPAddr)
+		return server.Serve(ctx)
+	})
+	if bootServer != nil {
+		g.Go(func() error {
+			l.Info("starting PXE boot server", "addr", c.ProxyBootServerAddr, "interface", c.Interface)
+			return bootServer.Serve(ctx)
+		})
+	}
+	if server6 != nil {
+		g.Go(func() error {
+			l.Info("starting DHCPv6 server", "addr", c.ListenAddr6, "interface", c.Interface)
+			return server6.Serve(ctx)
+		})
+	}
+	if tftpServer != nil {
+		g.Go(func() error {
+			l.Info("starting TFTP server", "addr", c.TFTPAddr, "dir", c.TFTPDir)
+			return tftpServer.Serve(ctx)
+		})
+	}
+	if c.IPXEScriptAddr.IsValid() {
+		s := &ipxe.Handler{Backend: b, Log: l.WithName("ipxe")}
+		g.Go(func() error {
+			l.Info("starting iPXE script server", "addr", c.IPXEScriptAddr)
+			return serveHTTP(ctx, "iPXE scripts", c.IPXEScriptAddr.String(), s)
+		})
+	}
+	if reg != nil {
+		g.Go(func() error {
+			l.Info("starting metrics server", "addr", c.MetricsAddr)
+			return serveMetrics(ctx, c.MetricsAddr, reg)
+		})
+	}
+
+	return g.Wait()
+}
+
+// compactLeases compacts the lease journal on start and then once a day, until ctx is done.
+// Leases that ended longer than retention ago are removed.
+func compactLeases(ctx context.Context, j *lease.Journal, retention time.Duration, l logr.Logger) error {
+	t := time.NewTicker(24 * time.Hour)
+	defer t.Stop()
+	for {
+		if err := j.Compact(ctx, time.Now().Add(-retention)); err != nil {
+			l.Error(err, "failed to compact lease journal")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// handler returns the handler for the mode selected in the config.
+// Leases are recorded in s and metrics in m, when they aren't nil.
+func (c *config) handler(b backend, s handler.LeaseStore, m *metrics.Metrics, l logr.Logger) (dhcp.Handler, error) {
+	switch c.Mode {
+	case modePool:
+		var r handler.BackendReader = b
+		if _, ok := b.(noopBackend); ok {
+			// without a backend, only pool addresses are handed out.
+			r = nil
+		}
+		h, err := c.pool(r)
+		if err != nil {
+			return nil, err
+		}
+		h.Log = l.WithName("handler.pool")
+		h.Leases = s
+		h.Metrics = m
+		return h, nil
+	case modeProxy:
+		h, err := c.proxy(b)
+		if err != nil {
+			return nil, err
+		}
+		h.Log = l.WithName("handler.proxy")
+		h.Metrics = m
+		return h, nil
+	default:
+		h, err := c.reservation(b)
+		if err != nil {
+			return nil, err
+		}
+		h.Log = l.WithName("handler.reservation")
+		h.Leases = s
+		h.Metrics = m
+		return h, nil
+	}
+}
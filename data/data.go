@@ -2,12 +2,16 @@
 package data
 
 import (
+	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"go.opentelemetry.io/otel/attribute"
 )
 
@@ -21,30 +25,66 @@ type Packet struct {
 	Md *Metadata
 }
 
+// Packet6 holds the data that is passed to a DHCPv6 handler.
+type Packet6 struct {
+	// Peer is the address of the client or relay agent that sent the DHCPv6 message.
+	Peer net.Addr
+	// Pkt is the DHCPv6 message. It is a relay-forward message when the client is behind a relay agent.
+	Pkt dhcpv6.DHCPv6
+	// Md is the metadata that was passed to the DHCP server.
+	Md *Metadata
+}
+
 // Metadata holds metadata about the DHCP packet that was received.
 type Metadata struct {
 	// IfName is the name of the interface that the DHCP message was received on.
 	IfName string
 	// IfIndex is the index of the interface that the DHCP message was received on.
 	IfIndex int
+	// LocalPort is the UDP port that the DHCP message was received on.
+	LocalPort int
+	// CircuitID is the agent circuit ID sub-option of the relay agent information (DHCP option 82).
+	// Relay agents use it to identify the switch port or circuit that the DHCP message was received on.
+	// For DHCPv6, it is the interface-id (DHCPv6 option 18) of the relay agent closest to the client.
+	CircuitID string
+	// RemoteID is the agent remote ID sub-option of the relay agent information (DHCP option 82).
+	// Relay agents use it to identify the remote host end of the circuit, for example the switch itself.
+	// For DHCPv6, it is the remote-id (DHCPv6 option 37) of the relay agent closest to the client.
+	RemoteID string
 }
 
 // DHCP holds the DHCP headers and options to be set in a DHCP handler response.
 // This is the API between a DHCP handler and a backend.
 type DHCP struct {
-	MACAddress       net.HardwareAddr // chaddr DHCP header.
-	IPAddress        netip.Addr       // yiaddr DHCP header.
-	SubnetMask       net.IPMask       // DHCP option 1.
-	DefaultGateway   netip.Addr       // DHCP option 3.
-	NameServers      []net.IP         // DHCP option 6.
-	Hostname         string           // DHCP option 12.
-	DomainName       string           // DHCP option 15.
-	BroadcastAddress netip.Addr       // DHCP option 28.
-	NTPServers       []net.IP         // DHCP option 42.
-	VLANID           string           // DHCP option 43.116.
-	LeaseTime        uint32           // DHCP option 51.
-	Arch             string           // DHCP option 93.
-	DomainSearch     []string         // DHCP option 119.
+	MACAddress            net.HardwareAddr // chaddr DHCP header.
+	IPAddress             netip.Addr       // yiaddr DHCP header.
+	SubnetMask            net.IPMask       // DHCP option 1.
+	DefaultGateway        netip.Addr       // DHCP option 3.
+	NameServers           []net.IP         // DHCP option 6.
+	Hostname              string           // DHCP option 12.
+	DomainName            string           // DHCP option 15.
+	BroadcastAddress      netip.Addr       // DHCP option 28.
+	NTPServers            []net.IP         // DHCP option 42.
+	VLANID                string           // DHCP option 43.116.
+	LeaseTime             uint32           // DHCP option 51.
+	Arch                  string           // DHCP option 93.
+	DomainSearch          []string         // DHCP option 119.
+	ClasslessStaticRoutes []Route          // DHCP option 121 and 249.
+	DUID                  []byte           // DHCPv6 option 1, the client identifier.
+	IPv6Address           netip.Addr       // DHCPv6 option 5, in the IA_NA (option 3).
+	IPv6NameServers       []net.IP         // DHCPv6 option 23.
+	Options               map[uint8]Option // Custom DHCP options, keyed by option code. They override the options above and are sent even when a client doesn't request them in option 55.
+}
+
+// Route is a classless static route. See https://www.rfc-editor.org/rfc/rfc3442.
+type Route struct {
+	Destination netip.Prefix // The destination network, for example 10.0.0.0/8.
+	Router      netip.Addr   // The router to use for the destination network.
+}
+
+// String returns r in the form "10.0.0.0/8 via 192.168.2.1".
+func (r Route) String() string {
+	return fmt.Sprintf("%v via %v", r.Destination, r.Router)
 }
 
 // Netboot holds info used in netbooting a client.
@@ -54,6 +94,45 @@ type Netboot struct {
 	IPXEScript    string   // Overrides a default value that is passed into DHCP on startup.
 	Console       string
 	Facility      string
+
+	// The fields below override the boot settings of the DHCP handler for a single machine.
+	BootFileName string     // Overrides the iPXE binary file name, for example "grubx64.efi".
+	NextServer   netip.Addr // Overrides the TFTP server (siaddr DHCP header) of the boot file.
+	HTTPBootURL  *url.URL   // Overrides the HTTP server of the boot file, for UEFI HTTP boot clients.
+	DirectBoot   bool       // If true, the client boots the boot file directly instead of chaining into an iPXE script.
+
+	// The fields below are for provisioning network switches.
+	ONIEInstallerURL *url.URL // DHCP option 114, the installer of ONIE switches.
+	ZTPScriptURL     *url.URL // DHCP option 67 (and 66 for TFTP URLs), the Zero Touch Provisioning script of Arista and Cisco switches.
+}
+
+// LeaseState is the state of a Lease.
+type LeaseState string
+
+const (
+	// LeaseStateBound is the state of a lease that was acknowledged to a client.
+	LeaseStateBound LeaseState = "bound"
+	// LeaseStateReleased is the state of a lease that the client gave back with a DHCP release.
+	LeaseStateReleased LeaseState = "released"
+	// LeaseStateDeclined is the state of a lease that the client rejected with a DHCP decline.
+	// A client declines an address when it detects that the address is already in use.
+	LeaseStateDeclined LeaseState = "declined"
+)
+
+// Lease is a record of an IP address handed out to a client.
+// This is the API between a DHCP handler and a lease store.
+type Lease struct {
+	MACAddress net.HardwareAddr // chaddr DHCP header.
+	IPAddress  netip.Addr       // yiaddr DHCP header.
+	Hostname   string           // DHCP option 12.
+	State      LeaseState
+	Expires    time.Time // When the lease ends.
+	Updated    time.Time // When the lease was recorded.
+}
+
+// Active returns true if the lease was bound to the client at time t.
+func (l *Lease) Active(t time.Time) bool {
+	return l.State == LeaseStateBound && !t.Before(l.Updated) && t.Before(l.Expires)
 }
 
 // EncodeToAttributes returns a slice of opentelemetry attributes that can be used to set span.SetAttributes.
@@ -88,6 +167,31 @@ func (d *DHCP) EncodeToAttributes() []attribute.KeyValue {
 		ba = d.BroadcastAddress.String()
 	}
 
+	var ip6 string
+	if d.IPv6Address.IsValid() {
+		ip6 = d.IPv6Address.String()
+	}
+
+	var ns6 []string
+	for _, e := range d.IPv6NameServers {
+		ns6 = append(ns6, e.String())
+	}
+
+	var duid string
+	if len(d.DUID) > 0 {
+		duid = net.HardwareAddr(d.DUID).String()
+	}
+
+	var routes []string
+	for _, r := range d.ClasslessStaticRoutes {
+		routes = append(routes, r.String())
+	}
+
+	var opts []string
+	for _, c := range d.OptionCodes() {
+		opts = append(opts, fmt.Sprintf("%d=%v", c, d.Options[c].Value))
+	}
+
 	return []attribute.KeyValue{
 		attribute.String("DHCP.MACAddress", d.MACAddress.String()),
 		attribute.String("DHCP.IPAddress", ip),
@@ -100,17 +204,71 @@ func (d *DHCP) EncodeToAttributes() []attribute.KeyValue {
 		attribute.String("DHCP.NTPServers", strings.Join(ntp, ",")),
 		attribute.Int64("DHCP.LeaseTime", int64(d.LeaseTime)),
 		attribute.String("DHCP.DomainSearch", strings.Join(d.DomainSearch, ",")),
+		attribute.String("DHCP.DUID", duid),
+		attribute.String("DHCP.IPv6Address", ip6),
+		attribute.String("DHCP.IPv6NameServers", strings.Join(ns6, ",")),
+		attribute.String("DHCP.ClasslessStaticRoutes", strings.Join(routes, ",")),
+		attribute.String("DHCP.Options", strings.Join(opts, ";")),
 	}
 }
 
+// OptionCodes returns the codes of the custom DHCP options in d, in ascending order.
+func (d *DHCP) OptionCodes() []uint8 {
+	codes := make([]uint8, 0, len(d.Options))
+	for c := range d.Options {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	return codes
+}
+
 // EncodeToAttributes returns a slice of opentelemetry attributes that can be used to set span.SetAttributes.
 func (n *Netboot) EncodeToAttributes() []attribute.KeyValue {
 	var s string
 	if n.IPXEScriptURL != nil {
 		s = n.IPXEScriptURL.String()
 	}
+	var ns string
+	if n.NextServer.IsValid() {
+		ns = n.NextServer.String()
+	}
+	var hb string
+	if n.HTTPBootURL != nil {
+		hb = n.HTTPBootURL.String()
+	}
+	var onie string
+	if n.ONIEInstallerURL != nil {
+		onie = n.ONIEInstallerURL.String()
+	}
+	var ztp string
+	if n.ZTPScriptURL != nil {
+		ztp = n.ZTPScriptURL.String()
+	}
 	return []attribute.KeyValue{
 		attribute.Bool("Netboot.AllowNetboot", n.AllowNetboot),
 		attribute.String("Netboot.IPXEScriptURL", s),
+		attribute.String("Netboot.BootFileName", n.BootFileName),
+		attribute.String("Netboot.NextServer", ns),
+		attribute.String("Netboot.HTTPBootURL", hb),
+		attribute.Bool("Netboot.DirectBoot", n.DirectBoot),
+		attribute.String("Netboot.ONIEInstallerURL", onie),
+		attribute.String("Netboot.ZTPScriptURL", ztp),
+	}
+}
+
+// EncodeToAttributes returns a slice of opentelemetry attributes that can be used to set span.SetAttributes.
+func (l *Lease) EncodeToAttributes() []attribute.KeyValue {
+	var ip string
+	if l.IPAddress.IsValid() {
+		ip = l.IPAddress.String()
+	}
+
+	return []attribute.KeyValue{
+		attribute.String("Lease.MACAddress", l.MACAddress.String()),
+		attribute.String("Lease.IPAddress", ip),
+		attribute.String("Lease.Hostname", l.Hostname),
+		attribute.String("Lease.State", string(l.State)),
+		attribute.String("Lease.Expires", l.Expires.UTC().Format(time.RFC3339)),
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"net/netip"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"go.opentelemetry.io/otel/attribute"
@@ -29,6 +30,11 @@ func TestDHCPEncodeToAttributes(t *testing.T) {
 				attribute.String("DHCP.NTPServers", ""),
 				attribute.Int64("DHCP.LeaseTime", 0),
 				attribute.String("DHCP.DomainSearch", ""),
+				attribute.String("DHCP.DUID", ""),
+				attribute.String("DHCP.IPv6Address", ""),
+				attribute.String("DHCP.IPv6NameServers", ""),
+				attribute.String("DHCP.ClasslessStaticRoutes", ""),
+				attribute.String("DHCP.Options", ""),
 			},
 		},
 		"successful encode of populated DHCP struct": {
@@ -44,6 +50,17 @@ func TestDHCPEncodeToAttributes(t *testing.T) {
 				NTPServers:       []net.IP{{132, 163, 96, 2}},
 				LeaseTime:        86400,
 				DomainSearch:     []string{"example.com", "example.org"},
+				DUID:             []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+				IPv6Address:      netip.MustParseAddr("2001:db8::150"),
+				IPv6NameServers:  []net.IP{net.ParseIP("2001:4860:4860::8888")},
+				ClasslessStaticRoutes: []Route{
+					{Destination: netip.MustParsePrefix("10.0.0.0/8"), Router: netip.MustParseAddr("192.168.2.2")},
+					{Destination: netip.MustParsePrefix("0.0.0.0/0"), Router: netip.MustParseAddr("192.168.2.1")},
+				},
+				Options: map[uint8]Option{
+					150: {Type: OptionTypeIPList, Value: "192.168.2.1,192.168.2.2"},
+					66:  {Type: OptionTypeString, Value: "tftp.example.com"},
+				},
 			},
 			want: []attribute.KeyValue{
 				attribute.String("DHCP.MACAddress", "00:01:02:03:04:05"),
@@ -57,6 +74,11 @@ func TestDHCPEncodeToAttributes(t *testing.T) {
 				attribute.String("DHCP.NTPServers", "132.163.96.2"),
 				attribute.Int64("DHCP.LeaseTime", 86400),
 				attribute.String("DHCP.DomainSearch", "example.com,example.org"),
+				attribute.String("DHCP.DUID", "00:03:00:01:00:01:02:03:04:05"),
+				attribute.String("DHCP.IPv6Address", "2001:db8::150"),
+				attribute.String("DHCP.IPv6NameServers", "2001:4860:4860::8888"),
+				attribute.String("DHCP.ClasslessStaticRoutes", "10.0.0.0/8 via 192.168.2.2,0.0.0.0/0 via 192.168.2.1"),
+				attribute.String("DHCP.Options", "66=tftp.example.com;150=192.168.2.1,192.168.2.2"),
 			},
 		},
 	}
@@ -82,16 +104,34 @@ func TestNetbootEncodeToAttributes(t *testing.T) {
 			want: []attribute.KeyValue{
 				attribute.Bool("Netboot.AllowNetboot", false),
 				attribute.String("Netboot.IPXEScriptURL", ""),
+				attribute.String("Netboot.BootFileName", ""),
+				attribute.String("Netboot.NextServer", ""),
+				attribute.String("Netboot.HTTPBootURL", ""),
+				attribute.Bool("Netboot.DirectBoot", false),
+				attribute.String("Netboot.ONIEInstallerURL", ""),
+				attribute.String("Netboot.ZTPScriptURL", ""),
 			},
 		},
 		"successful encode of populated Netboot struct": {
 			netboot: &Netboot{
-				AllowNetboot:  true,
-				IPXEScriptURL: &url.URL{Scheme: "http", Host: "example.com"},
+				AllowNetboot:     true,
+				IPXEScriptURL:    &url.URL{Scheme: "http", Host: "example.com"},
+				BootFileName:     "grubx64.efi",
+				NextServer:       netip.MustParseAddr("192.168.2.1"),
+				HTTPBootURL:      &url.URL{Scheme: "http", Host: "192.168.2.1:8080", Path: "/grub"},
+				DirectBoot:       true,
+				ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.2.1", Path: "/onie-installer"},
+				ZTPScriptURL:     &url.URL{Scheme: "tftp", Host: "192.168.2.1", Path: "/poap.py"},
 			},
 			want: []attribute.KeyValue{
 				attribute.Bool("Netboot.AllowNetboot", true),
 				attribute.String("Netboot.IPXEScriptURL", "http://example.com"),
+				attribute.String("Netboot.BootFileName", "grubx64.efi"),
+				attribute.String("Netboot.NextServer", "192.168.2.1"),
+				attribute.String("Netboot.HTTPBootURL", "http://192.168.2.1:8080/grub"),
+				attribute.Bool("Netboot.DirectBoot", true),
+				attribute.String("Netboot.ONIEInstallerURL", "http://192.168.2.1/onie-installer"),
+				attribute.String("Netboot.ZTPScriptURL", "tftp://192.168.2.1/poap.py"),
 			},
 		},
 	}
@@ -106,3 +146,49 @@ func TestNetbootEncodeToAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestLeaseActive(t *testing.T) {
+	updated := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		state LeaseState
+		at    time.Time
+		want  bool
+	}{
+		"bound":           {state: LeaseStateBound, at: updated.Add(time.Minute), want: true},
+		"bound at update": {state: LeaseStateBound, at: updated, want: true},
+		"before update":   {state: LeaseStateBound, at: updated.Add(-time.Minute)},
+		"expired":         {state: LeaseStateBound, at: updated.Add(time.Hour)},
+		"released":        {state: LeaseStateReleased, at: updated.Add(time.Minute)},
+		"declined":        {state: LeaseStateDeclined, at: updated.Add(time.Minute)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			l := &Lease{State: tt.state, Updated: updated, Expires: updated.Add(time.Hour)}
+			if got := l.Active(tt.at); got != tt.want {
+				t.Fatalf("Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaseEncodeToAttributes(t *testing.T) {
+	l := &Lease{
+		MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		IPAddress:  netip.MustParseAddr("192.168.2.150"),
+		Hostname:   "test-server",
+		State:      LeaseStateBound,
+		Expires:    time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC),
+	}
+	want := attribute.NewSet(
+		attribute.String("Lease.MACAddress", "00:01:02:03:04:05"),
+		attribute.String("Lease.IPAddress", "192.168.2.150"),
+		attribute.String("Lease.Hostname", "test-server"),
+		attribute.String("Lease.State", "bound"),
+		attribute.String("Lease.Expires", "2023-01-01T01:00:00Z"),
+	)
+	got := attribute.NewSet(l.EncodeToAttributes()...)
+	enc := attribute.DefaultEncoder()
+	if diff := cmp.Diff(got.Encoded(enc), want.Encoded(enc)); diff != "" {
+		t.Fatal(diff)
+	}
+}
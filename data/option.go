@@ -0,0 +1,165 @@
+package data
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidOption is returned for a custom DHCP option that can't be encoded.
+var ErrInvalidOption = errors.New("invalid custom DHCP option")
+
+// OptionType is the type of the value of a custom DHCP option.
+type OptionType string
+
+const (
+	// OptionTypeIP is a single IPv4 address, for example "192.168.2.1".
+	OptionTypeIP OptionType = "ip"
+	// OptionTypeIPList is a comma separated list of IPv4 addresses, for example "192.168.2.1,192.168.2.2".
+	OptionTypeIPList OptionType = "ip-list"
+	// OptionTypeString is a string, sent as is.
+	OptionTypeString OptionType = "string"
+	// OptionTypeUint8 is a decimal number from 0 to 255, sent as 1 byte.
+	OptionTypeUint8 OptionType = "uint8"
+	// OptionTypeUint16 is a decimal number from 0 to 65535, sent as 2 bytes in network byte order.
+	OptionTypeUint16 OptionType = "uint16"
+	// OptionTypeUint32 is a decimal number from 0 to 4294967295, sent as 4 bytes in network byte order.
+	OptionTypeUint32 OptionType = "uint32"
+	// OptionTypeHex is raw bytes in hex, optionally colon separated, for example "01:04:c0:a8:02:01".
+	OptionTypeHex OptionType = "hex"
+	// OptionTypeBool is "true" or "false", sent as 1 byte.
+	OptionTypeBool OptionType = "bool"
+)
+
+// Option is the value of a custom DHCP option and its type.
+type Option struct {
+	Type  OptionType
+	Value string
+}
+
+// KnownOptions is the registry of DHCP options with a well known type.
+// Custom options with one of these codes must use the registered type.
+// Options that are not in the registry can use any type.
+var KnownOptions = map[uint8]OptionType{
+	1:   OptionTypeIP,     // subnet mask
+	2:   OptionTypeUint32, // time offset
+	3:   OptionTypeIPList, // routers
+	4:   OptionTypeIPList, // time servers
+	6:   OptionTypeIPList, // domain name servers
+	7:   OptionTypeIPList, // log servers
+	12:  OptionTypeString, // host name
+	13:  OptionTypeUint16, // boot file size
+	15:  OptionTypeString, // domain name
+	17:  OptionTypeString, // root path
+	19:  OptionTypeBool,   // IP forwarding
+	23:  OptionTypeUint8,  // default IP time-to-live
+	26:  OptionTypeUint16, // interface MTU
+	28:  OptionTypeIP,     // broadcast address
+	42:  OptionTypeIPList, // NTP servers
+	43:  OptionTypeHex,    // vendor specific information
+	44:  OptionTypeIPList, // NetBIOS name servers
+	46:  OptionTypeUint8,  // NetBIOS node type
+	60:  OptionTypeString, // vendor class identifier
+	66:  OptionTypeString, // TFTP server name
+	67:  OptionTypeString, // boot file name
+	69:  OptionTypeIPList, // SMTP servers
+	100: OptionTypeString, // POSIX time zone
+	101: OptionTypeString, // tz database time zone
+	114: OptionTypeString, // URL
+	119: OptionTypeHex,    // domain search
+	121: OptionTypeHex,    // classless static routes
+	150: OptionTypeIPList, // TFTP server addresses
+	209: OptionTypeString, // PXELINUX configuration file
+	210: OptionTypeString, // PXELINUX path prefix
+	211: OptionTypeUint32, // PXELINUX reboot time
+	249: OptionTypeHex,    // Microsoft classless static routes
+	252: OptionTypeString, // WPAD URL
+}
+
+// reservedOptions are set by the DHCP server itself and can't be custom options.
+var reservedOptions = map[uint8]bool{
+	0:   true, // pad
+	50:  true, // requested IP address
+	51:  true, // lease time
+	52:  true, // option overload
+	53:  true, // message type
+	54:  true, // server identifier
+	55:  true, // parameter request list
+	57:  true, // maximum message size
+	58:  true, // renewal time
+	59:  true, // rebinding time
+	61:  true, // client identifier
+	82:  true, // relay agent information
+	255: true, // end
+}
+
+// Validate returns an error if o can't be sent as the DHCP option with the given code.
+func (o Option) Validate(code uint8) error {
+	if reservedOptions[code] {
+		return fmt.Errorf("%w: option %d is set by the DHCP server", ErrInvalidOption, code)
+	}
+	if t, ok := KnownOptions[code]; ok && t != o.Type {
+		return fmt.Errorf("%w: option %d must be of type %q, got %q", ErrInvalidOption, code, t, o.Type)
+	}
+	if _, err := o.Bytes(); err != nil {
+		return fmt.Errorf("option %d: %w", code, err)
+	}
+
+	return nil
+}
+
+// Bytes returns the value of o as it is sent in a DHCP option.
+func (o Option) Bytes() ([]byte, error) {
+	switch o.Type {
+	case OptionTypeIP:
+		ip := net.ParseIP(strings.TrimSpace(o.Value)).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q is not an IPv4 address", ErrInvalidOption, o.Value)
+		}
+		return ip, nil
+	case OptionTypeIPList:
+		var b []byte
+		for _, s := range strings.Split(o.Value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s)).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q is not an IPv4 address", ErrInvalidOption, s)
+			}
+			b = append(b, ip...)
+		}
+		return b, nil
+	case OptionTypeString:
+		if o.Value == "" {
+			return nil, fmt.Errorf("%w: empty string", ErrInvalidOption)
+		}
+		return []byte(o.Value), nil
+	case OptionTypeUint8, OptionTypeUint16, OptionTypeUint32:
+		bits := map[OptionType]int{OptionTypeUint8: 8, OptionTypeUint16: 16, OptionTypeUint32: 32}[o.Type]
+		v, err := strconv.ParseUint(strings.TrimSpace(o.Value), 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidOption, err)
+		}
+		b := binary.BigEndian.AppendUint32(nil, uint32(v))
+		return b[4-bits/8:], nil
+	case OptionTypeHex:
+		b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(o.Value), ":", ""))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("%w: %q is not hex", ErrInvalidOption, o.Value)
+		}
+		return b, nil
+	case OptionTypeBool:
+		v, err := strconv.ParseBool(strings.TrimSpace(o.Value))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidOption, err)
+		}
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidOption, o.Type)
+	}
+}
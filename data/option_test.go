@@ -0,0 +1,66 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOptionBytes(t *testing.T) {
+	tests := map[string]struct {
+		opt     Option
+		want    []byte
+		wantErr error
+	}{
+		"ip":             {opt: Option{Type: OptionTypeIP, Value: "192.168.2.1"}, want: []byte{192, 168, 2, 1}},
+		"ip list":        {opt: Option{Type: OptionTypeIPList, Value: "192.168.2.1, 192.168.2.2"}, want: []byte{192, 168, 2, 1, 192, 168, 2, 2}},
+		"string":         {opt: Option{Type: OptionTypeString, Value: "tftp.example.com"}, want: []byte("tftp.example.com")},
+		"uint8":          {opt: Option{Type: OptionTypeUint8, Value: "64"}, want: []byte{64}},
+		"uint16":         {opt: Option{Type: OptionTypeUint16, Value: "1500"}, want: []byte{0x05, 0xdc}},
+		"uint32":         {opt: Option{Type: OptionTypeUint32, Value: "86400"}, want: []byte{0x00, 0x01, 0x51, 0x80}},
+		"hex":            {opt: Option{Type: OptionTypeHex, Value: "01:04:c0:a8"}, want: []byte{0x01, 0x04, 0xc0, 0xa8}},
+		"hex no colons":  {opt: Option{Type: OptionTypeHex, Value: "0104c0a8"}, want: []byte{0x01, 0x04, 0xc0, 0xa8}},
+		"bool":           {opt: Option{Type: OptionTypeBool, Value: "true"}, want: []byte{1}},
+		"bad ip":         {opt: Option{Type: OptionTypeIP, Value: "2001:db8::1"}, wantErr: ErrInvalidOption},
+		"bad ip list":    {opt: Option{Type: OptionTypeIPList, Value: "192.168.2.1,"}, wantErr: ErrInvalidOption},
+		"empty string":   {opt: Option{Type: OptionTypeString}, wantErr: ErrInvalidOption},
+		"uint8 overflow": {opt: Option{Type: OptionTypeUint8, Value: "256"}, wantErr: ErrInvalidOption},
+		"bad hex":        {opt: Option{Type: OptionTypeHex, Value: "0x01"}, wantErr: ErrInvalidOption},
+		"bad bool":       {opt: Option{Type: OptionTypeBool, Value: "yes"}, wantErr: ErrInvalidOption},
+		"unknown type":   {opt: Option{Type: "float", Value: "1.0"}, wantErr: ErrInvalidOption},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.opt.Bytes()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Bytes() error = %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestOptionValidate(t *testing.T) {
+	tests := map[string]struct {
+		code    uint8
+		opt     Option
+		wantErr error
+	}{
+		"known option":         {code: 66, opt: Option{Type: OptionTypeString, Value: "tftp.example.com"}},
+		"unknown option":       {code: 224, opt: Option{Type: OptionTypeHex, Value: "01"}},
+		"wrong type":           {code: 150, opt: Option{Type: OptionTypeString, Value: "192.168.2.1"}, wantErr: ErrInvalidOption},
+		"reserved option":      {code: 54, opt: Option{Type: OptionTypeIP, Value: "192.168.2.1"}, wantErr: ErrInvalidOption},
+		"invalid value":        {code: 150, opt: Option{Type: OptionTypeIPList, Value: "tftp"}, wantErr: ErrInvalidOption},
+		"relay agent info set": {code: 82, opt: Option{Type: OptionTypeHex, Value: "01"}, wantErr: ErrInvalidOption},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.opt.Validate(tt.code); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
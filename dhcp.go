@@ -3,12 +3,16 @@ package dhcp
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
 	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/metrics"
 	"golang.org/x/net/ipv4"
 )
 
@@ -19,11 +23,92 @@ type Handler interface {
 	Handle(ctx context.Context, conn *ipv4.PacketConn, d data.Packet)
 }
 
+// DropPolicy decides which DHCP message is dropped when the queue of a Server is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the DHCP message that was just received. Messages already in the queue are handled.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the DHCP message that has waited the longest in the queue, to make room for the one that was just received.
+	// Clients retransmit, so the oldest message is the most likely to be stale.
+	DropOldest
+)
+
+// String returns the name of the DropPolicy.
+func (d DropPolicy) String() string {
+	switch d {
+	case DropNewest:
+		return "newest"
+	case DropOldest:
+		return "oldest"
+	default:
+		return fmt.Sprintf("DropPolicy(%d)", int(d))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d DropPolicy) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *DropPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "newest":
+		*d = DropNewest
+	case "oldest":
+		*d = DropOldest
+	default:
+		return fmt.Errorf("unknown drop policy %q, must be one of: newest, oldest", text)
+	}
+
+	return nil
+}
+
+// Stats holds counters for the DHCP messages a Server has received.
+type Stats struct {
+	// Received is the number of DHCP messages received and parsed.
+	Received uint64
+	// Invalid is the number of packets that could not be parsed as a DHCP message.
+	Invalid uint64
+	// Dropped is the number of DHCP messages that were not handled because the queue was full.
+	Dropped uint64
+}
+
 // Server represents a DHCPv4 server object.
 type Server struct {
 	Conn     net.PacketConn
 	Handlers []Handler
 	Logger   logr.Logger
+	// Middleware wraps every one of the Handlers. The first Middleware is the outermost one.
+	Middleware []Middleware
+
+	// Workers is the number of goroutines that handle DHCP messages.
+	// When 0, every DHCP message is handled in its own goroutine, without a limit.
+	Workers int
+	// QueueSize is the number of DHCP messages that can wait for a worker.
+	// When the queue is full, DHCP messages are dropped according to the DropPolicy.
+	// Defaults to Workers when not set.
+	QueueSize int
+	// DropPolicy decides which DHCP message is dropped when the queue is full.
+	DropPolicy DropPolicy
+	// Metrics records Prometheus metrics for received DHCP messages. When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
+
+	received atomic.Uint64
+	invalid  atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// bufPool holds read buffers, so that receiving a DHCP message doesn't allocate.
+// dhcpv4.FromBytes copies everything it needs, so a buffer can be reused as soon as it is parsed.
+var bufPool = sync.Pool{
+	New: func() any {
+		// Max UDP packet size is 65535. Max DHCPv4 packet size is 576. An ethernet frame is 1500 bytes.
+		// We use 4096 as a reasonable buffer size. dhcpv4.FromBytes will handle the rest.
+		b := make([]byte, 4096)
+		return &b
+	},
 }
 
 // Serve serves requests.
@@ -43,12 +128,32 @@ func (s *Server) Serve(ctx context.Context) error {
 	defer func() {
 		_ = nConn.Close()
 	}()
+	handlers := make([]Handler, 0, len(s.Handlers))
+	for _, h := range s.Handlers {
+		handlers = append(handlers, Chain(h, s.Middleware...))
+	}
+	var localPort int
+	if a, ok := s.Conn.LocalAddr().(*net.UDPAddr); ok {
+		localPort = a.Port
+	}
+
+	queue := make(chan data.Packet, queueSize(s.Workers, s.QueueSize))
+	wait := startWorkers(s.Workers, queue, func(p data.Packet) {
+		for _, handler := range handlers {
+			handler.Handle(ctx, nConn, p)
+		}
+	})
+	defer func() {
+		// let the workers finish the DHCP messages they already have.
+		close(queue)
+		wait()
+	}()
+
 	for {
-		// Max UDP packet size is 65535. Max DHCPv4 packet size is 576. An ethernet frame is 1500 bytes.
-		// We use 4096 as a reasonable buffer size. dhcpv4.FromBytes will handle the rest.
-		rbuf := make([]byte, 4096)
-		n, cm, peer, err := nConn.ReadFrom(rbuf)
+		rbuf := bufPool.Get().(*[]byte)
+		n, cm, peer, err := nConn.ReadFrom(*rbuf)
 		if err != nil {
+			bufPool.Put(rbuf)
 			select {
 			case <-ctx.Done():
 				return nil
@@ -58,8 +163,11 @@ func (s *Server) Serve(ctx context.Context) error {
 			return err
 		}
 
-		m, err := dhcpv4.FromBytes(rbuf[:n])
+		m, err := dhcpv4.FromBytes((*rbuf)[:n])
+		bufPool.Put(rbuf)
 		if err != nil {
+			s.invalid.Add(1)
+			s.Metrics.PacketInvalid()
 			s.Logger.Info("error parsing DHCPv4 request", "err", err)
 			continue
 		}
@@ -69,6 +177,7 @@ func (s *Server) Serve(ctx context.Context) error {
 			s.Logger.Info("not a UDP connection? Peer is", "peer", peer)
 			continue
 		}
+		s.received.Add(1)
 		// Set peer to broadcast if the client did not have an IP.
 		if upeer.IP == nil || upeer.IP.To4().Equal(net.IPv4zero) {
 			upeer = &net.UDPAddr{
@@ -81,10 +190,96 @@ func (s *Server) Serve(ctx context.Context) error {
 		if n, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
 			ifName = n.Name
 		}
+		s.Metrics.PacketReceived(m.MessageType().String(), ifName)
 
-		for _, handler := range s.Handlers {
-			go handler.Handle(ctx, nConn, data.Packet{Peer: upeer, Pkt: m, Md: &data.Metadata{IfName: ifName, IfIndex: cm.IfIndex}})
+		md := &data.Metadata{IfName: ifName, IfIndex: cm.IfIndex, LocalPort: localPort}
+		if rai := m.RelayAgentInfo(); rai != nil {
+			md.CircuitID = string(rai.Get(dhcpv4.AgentCircuitIDSubOption))
+			md.RemoteID = string(rai.Get(dhcpv4.AgentRemoteIDSubOption))
 		}
+
+		p := data.Packet{Peer: upeer, Pkt: m, Md: md}
+		if s.Workers <= 0 {
+			for _, handler := range handlers {
+				go handler.Handle(ctx, nConn, p)
+			}
+			continue
+		}
+		s.enqueue(queue, p)
+	}
+}
+
+// queueSize returns the size of the queue of DHCP messages that wait for one of workers, see Server.QueueSize.
+func queueSize(workers, size int) int {
+	if size > 0 {
+		return size
+	}
+	if workers > 0 {
+		return workers
+	}
+
+	return 0
+}
+
+// startWorkers starts n goroutines that call handle for every DHCP message in queue, until queue is closed.
+// wait returns when all of them are done.
+func startWorkers[P any](n int, queue chan P, handle func(P)) (wait func()) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				handle(p)
+			}
+		}()
+	}
+
+	return wg.Wait
+}
+
+// enqueue adds p to queue for a worker to handle. When queue is full, a DHCP message is dropped according to policy.
+func enqueue[P any](queue chan P, p P, policy DropPolicy, drop func(P)) {
+	select {
+	case queue <- p:
+		return
+	default:
+	}
+
+	if policy == DropOldest {
+		select {
+		case old := <-queue:
+			drop(old)
+		default:
+		}
+		select {
+		case queue <- p:
+			return
+		default:
+		}
+	}
+	drop(p)
+}
+
+// enqueue adds p to queue for a worker to handle. When queue is full, a DHCP message is dropped according to the DropPolicy.
+func (s *Server) enqueue(queue chan data.Packet, p data.Packet) {
+	enqueue(queue, p, s.DropPolicy, s.drop)
+}
+
+// drop counts and logs a DHCP message that will not be handled.
+func (s *Server) drop(p data.Packet) {
+	s.dropped.Add(1)
+	s.Metrics.PacketDropped()
+	s.Logger.V(1).Info("queue full, dropping DHCP message", "mac", p.Pkt.ClientHWAddr.String(), "xid", p.Pkt.TransactionID.String(), "dropPolicy", s.DropPolicy.String())
+}
+
+// Stats returns counters for the DHCP messages the Server has received.
+// It is safe to call while the Server is serving.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received: s.received.Load(),
+		Invalid:  s.invalid.Load(),
+		Dropped:  s.dropped.Load(),
 	}
 }
 
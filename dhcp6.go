@@ -0,0 +1,200 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/metrics"
+	"golang.org/x/net/ipv6"
+)
+
+// Handler6 is a type that defines the handler function to be called every time a
+// valid DHCPv6 message is received.
+type Handler6 interface {
+	Handle6(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6)
+}
+
+// Server6 represents a DHCPv6 server object.
+type Server6 struct {
+	Conn     net.PacketConn
+	Handlers []Handler6
+	Logger   logr.Logger
+	// Middleware wraps every one of the Handlers. The first Middleware6 is the outermost one.
+	Middleware []Middleware6
+
+	// Workers, QueueSize and DropPolicy bound the number of DHCPv6 messages handled at once, like they do for a Server.
+	// When Workers is 0, every DHCPv6 message is handled in its own goroutine, without a limit.
+	Workers    int
+	QueueSize  int
+	DropPolicy DropPolicy
+	// Metrics records Prometheus metrics for received DHCPv6 messages. When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
+}
+
+// Serve serves requests.
+func (s *Server6) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	s.Logger.Info("Server listening on", "addr", s.Conn.LocalAddr())
+
+	nConn := ipv6.NewPacketConn(s.Conn)
+	if err := nConn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		s.Logger.Info("error setting control message", "err", err)
+		return err
+	}
+
+	defer func() {
+		_ = nConn.Close()
+	}()
+	handlers := make([]Handler6, 0, len(s.Handlers))
+	for _, h := range s.Handlers {
+		handlers = append(handlers, Chain6(h, s.Middleware...))
+	}
+	var localPort int
+	if a, ok := s.Conn.LocalAddr().(*net.UDPAddr); ok {
+		localPort = a.Port
+	}
+
+	queue := make(chan data.Packet6, queueSize(s.Workers, s.QueueSize))
+	wait := startWorkers(s.Workers, queue, func(p data.Packet6) {
+		for _, handler := range handlers {
+			handler.Handle6(ctx, nConn, p)
+		}
+	})
+	defer func() {
+		// let the workers finish the DHCPv6 messages they already have.
+		close(queue)
+		wait()
+	}()
+
+	for {
+		rbuf := bufPool.Get().(*[]byte)
+		n, cm, peer, err := nConn.ReadFrom(*rbuf)
+		if err != nil {
+			bufPool.Put(rbuf)
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			s.Logger.Info("error reading from packet conn", "err", err)
+			return err
+		}
+
+		m, err := dhcpv6.FromBytes((*rbuf)[:n])
+		bufPool.Put(rbuf)
+		if err != nil {
+			s.Metrics.PacketInvalid()
+			s.Logger.Info("error parsing DHCPv6 request", "err", err)
+			continue
+		}
+		msg, err := m.GetInnerMessage()
+		if err != nil {
+			s.Metrics.PacketInvalid()
+			s.Logger.Info("error parsing DHCPv6 relay message", "err", err)
+			continue
+		}
+
+		var ifName string
+		var ifIndex int
+		if cm != nil {
+			ifIndex = cm.IfIndex
+			if n, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
+				ifName = n.Name
+			}
+		}
+		s.Metrics.PacketReceived(msg.Type().String(), ifName)
+
+		md := &data.Metadata{IfName: ifName, IfIndex: ifIndex, LocalPort: localPort}
+		md.CircuitID, md.RemoteID = relayAgentInfo6(m)
+
+		p := data.Packet6{Peer: peer, Pkt: m, Md: md}
+		if s.Workers <= 0 {
+			for _, handler := range handlers {
+				go handler.Handle6(ctx, nConn, p)
+			}
+			continue
+		}
+		enqueue(queue, p, s.DropPolicy, s.drop)
+	}
+}
+
+// drop counts and logs a DHCPv6 message that will not be handled.
+func (s *Server6) drop(p data.Packet6) {
+	s.Metrics.PacketDropped()
+	kv := []any{"dropPolicy", s.DropPolicy.String()}
+	if msg, err := p.Pkt.GetInnerMessage(); err == nil {
+		kv = append(kv, "xid", msg.TransactionID.String())
+		if cid := msg.Options.ClientID(); cid != nil {
+			kv = append(kv, "duid", cid.String())
+		}
+	}
+	s.Logger.V(1).Info("queue full, dropping DHCPv6 message", kv...)
+}
+
+// relayAgentInfo6 returns the interface-id (option 18) and remote-id (option 37)
+// of the relay agent closest to the client, when m is a relay-forward message.
+func relayAgentInfo6(m dhcpv6.DHCPv6) (circuitID, remoteID string) {
+	for r, ok := m.(*dhcpv6.RelayMessage); ok; r, ok = r.Options.RelayMessage().(*dhcpv6.RelayMessage) {
+		if id := r.Options.InterfaceID(); id != nil {
+			circuitID = string(id)
+		}
+		if rid := r.Options.RemoteID(); rid != nil {
+			remoteID = string(rid.RemoteID)
+		}
+	}
+
+	return circuitID, remoteID
+}
+
+// Close sends a termination request to the server, and closes the UDP listener.
+func (s *Server6) Close() error {
+	return s.Conn.Close()
+}
+
+// NewServer6 initializes and returns a new Server6 object.
+// When addr is a multicast address, the server joins that group.
+// When addr is the unspecified address on the DHCPv6 server port, the server joins
+// the All_DHCP_Relay_Agents_and_Servers and All_DHCP_Servers groups.
+func NewServer6(ifname string, addr *net.UDPAddr, handler ...Handler6) (*Server6, error) {
+	s := &Server6{
+		Handlers: handler,
+		Logger:   logr.Discard(),
+	}
+
+	var iface *net.Interface
+	if ifname != "" {
+		var err error
+		if iface, err = net.InterfaceByName(ifname); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := server6.NewIPv6UDPConn(ifname, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []net.IP
+	switch {
+	case addr.IP.IsMulticast():
+		groups = []net.IP{addr.IP}
+	case (addr.IP == nil || addr.IP.IsUnspecified()) && addr.Port == dhcpv6.DefaultServerPort:
+		groups = []net.IP{dhcpv6.AllDHCPRelayAgentsAndServers, dhcpv6.AllDHCPServers}
+	}
+	p := ipv6.NewPacketConn(conn)
+	for _, g := range groups {
+		if err := p.JoinGroup(iface, &net.UDPAddr{IP: g, Port: addr.Port}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	s.Conn = conn
+
+	return s, nil
+}
@@ -0,0 +1,120 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv6"
+)
+
+// mock6 answers every DHCPv6 message with a reply with the same transaction ID.
+type mock6 struct{}
+
+func (mock6) Handle6(_ context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+	msg, err := d.Pkt.GetInnerMessage()
+	if err != nil {
+		return
+	}
+	reply := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply, TransactionID: msg.TransactionID}
+	_, _ = conn.WriteTo(reply.ToBytes(), nil, d.Peer)
+}
+
+func TestServe6(t *testing.T) {
+	tests := map[string]struct {
+		workers int
+	}{
+		"success":              {},
+		"success with workers": {workers: 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewServer6("lo", &net.UDPAddr{IP: net.IPv6loopback}, mock6{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Workers = tt.workers
+			var handled atomic.Int32
+			s.Middleware = []Middleware6{func(next Handler6) Handler6 {
+				return HandlerFunc6(func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+					handled.Add(1)
+					next.Handle6(ctx, conn, d)
+				})
+			}}
+			ctx, done := context.WithCancel(context.Background())
+			defer done()
+
+			go s.Serve(ctx)
+
+			c, err := net.DialUDP("udp6", nil, s.Conn.LocalAddr().(*net.UDPAddr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			sol, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{LinkLayerAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Write(sol.ToBytes()); err != nil {
+				t.Fatal(err)
+			}
+
+			buf := make([]byte, 1500)
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := dhcpv6.MessageFromBytes(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.TransactionID != sol.TransactionID {
+				t.Fatalf("got transaction ID %v, want %v", reply.TransactionID, sol.TransactionID)
+			}
+			if got := handled.Load(); got != 1 {
+				t.Fatalf("middleware handled %d messages, want 1", got)
+			}
+		})
+	}
+}
+
+func TestRelayAgentInfo6(t *testing.T) {
+	sol, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := dhcpv6.EncapsulateRelay(sol, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.AddOption(dhcpv6.OptInterfaceID([]byte("Ethernet1/15")))
+	inner.AddOption(&dhcpv6.OptRemoteID{EnterpriseNumber: 9, RemoteID: []byte("leaf01")})
+	outer, err := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer.AddOption(dhcpv6.OptInterfaceID([]byte("spine01")))
+
+	tests := map[string]struct {
+		m    dhcpv6.DHCPv6
+		want [2]string
+	}{
+		"not relayed":       {m: sol},
+		"relayed":           {m: inner, want: [2]string{"Ethernet1/15", "leaf01"}},
+		"relayed two times": {m: outer, want: [2]string{"Ethernet1/15", "leaf01"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, r := relayAgentInfo6(tt.m)
+			if diff := cmp.Diff([2]string{c, r}, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"sync/atomic"
 	"testing"
 
 	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/tinkerbell/dhcp/data"
@@ -22,12 +24,16 @@ type mock struct {
 	NameServers []net.IP
 	SubnetMask  net.IPMask
 	Router      net.IP
+
+	// localPort is the port of the last DHCP message received.
+	localPort atomic.Int64
 }
 
 func (m *mock) Handle(_ context.Context, conn *ipv4.PacketConn, d data.Packet) {
 	if m.Log.GetSink() == nil {
 		m.Log = logr.Discard()
 	}
+	m.localPort.Store(int64(d.Md.LocalPort))
 
 	mods := m.setOpts()
 	switch mt := d.Pkt.MessageType(); mt {
@@ -87,10 +93,12 @@ func dhcp(ctx context.Context) (*dhcpv4.DHCPv4, error) {
 
 func TestServe(t *testing.T) {
 	tests := map[string]struct {
-		h    Handler
-		addr netip.AddrPort
+		h       *mock
+		addr    netip.AddrPort
+		workers int
 	}{
-		"success": {addr: netip.MustParseAddrPort("127.0.0.1:7676"), h: &mock{}},
+		"success":              {addr: netip.MustParseAddrPort("127.0.0.1:7676"), h: &mock{}},
+		"success with workers": {addr: netip.MustParseAddrPort("127.0.0.1:7676"), h: &mock{}, workers: 2},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -98,6 +106,7 @@ func TestServe(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			s.Workers = tt.workers
 			ctx, done := context.WithCancel(context.Background())
 			defer done()
 
@@ -109,8 +118,59 @@ func TestServe(t *testing.T) {
 				t.Fatal(err)
 			}
 			t.Log(d)
+			if got := tt.h.localPort.Load(); got != int64(tt.addr.Port()) {
+				t.Fatalf("local port = %d, want %d", got, tt.addr.Port())
+			}
 
 			done()
 		})
 	}
 }
+
+func TestEnqueue(t *testing.T) {
+	pkt := func(xid byte) data.Packet {
+		return data.Packet{Pkt: &dhcpv4.DHCPv4{TransactionID: dhcpv4.TransactionID{xid}}}
+	}
+	tests := map[string]struct {
+		policy  DropPolicy
+		wantXID byte
+	}{
+		"drop newest": {policy: DropNewest, wantXID: 1},
+		"drop oldest": {policy: DropOldest, wantXID: 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Server{Logger: logr.Discard(), DropPolicy: tt.policy}
+			queue := make(chan data.Packet, 1)
+			s.enqueue(queue, pkt(1))
+			s.enqueue(queue, pkt(2))
+
+			if got := (<-queue).Pkt.TransactionID[0]; got != tt.wantXID {
+				t.Fatalf("queued xid = %v, want %v", got, tt.wantXID)
+			}
+			if diff := cmp.Diff(s.Stats(), Stats{Dropped: 1}); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestDropPolicyText(t *testing.T) {
+	for _, want := range []DropPolicy{DropNewest, DropOldest} {
+		b, err := want.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got DropPolicy
+		if err := got.UnmarshalText(b); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	var d DropPolicy
+	if err := d.UnmarshalText([]byte("random")); err == nil {
+		t.Fatal("expected error for unknown drop policy")
+	}
+}
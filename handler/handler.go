@@ -4,6 +4,7 @@ package handler
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/tinkerbell/dhcp/data"
 )
@@ -17,3 +18,34 @@ type BackendReader interface {
 	GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error)
 	GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error)
 }
+
+// CircuitReader is the interface for getting data from a backend based on relay agent information (DHCP option 82).
+//
+// Backends can optionally implement this interface to select a reservation by the switch port that a client is connected to,
+// instead of by its MAC address. Handlers use it for relayed clients that don't have a reservation for their MAC address.
+// Reservations that don't specify a remote ID match any remoteID.
+type CircuitReader interface {
+	GetByCircuitID(ctx context.Context, circuitID, remoteID string) (*data.DHCP, *data.Netboot, error)
+}
+
+// DUIDReader is the interface for getting data from a backend based on a DHCP unique identifier (DUID).
+//
+// Backends can optionally implement this interface to select a reservation for a DHCPv6 client by its DUID (DHCPv6 option 1).
+// Handlers fall back to a lookup by MAC address, when the MAC address can be derived from the DUID or the relay agent,
+// for backends that don't implement it or don't have a reservation for the DUID.
+type DUIDReader interface {
+	GetByDUID(ctx context.Context, duid []byte) (*data.DHCP, *data.Netboot, error)
+}
+
+// LeaseStore is the interface for recording and looking up leases.
+//
+// Handlers record a lease for every address they acknowledge, release or decline.
+// Errors for leases that don't exist implement a NotFound() bool method that returns true.
+type LeaseStore interface {
+	// Put records a lease. It supersedes all earlier leases for the same MAC address.
+	Put(context.Context, *data.Lease) error
+	// GetByMac returns the latest lease recorded for a MAC address, regardless of its state.
+	GetByMac(context.Context, net.HardwareAddr) (*data.Lease, error)
+	// GetByIP returns the lease that was active for an IP address at the given time.
+	GetByIP(context.Context, net.IP, time.Time) (*data.Lease, error)
+}
@@ -0,0 +1,355 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/tinkerbell/dhcp/data"
+)
+
+// Errors used by allocate.
+var (
+	// errPoolExhausted is returned when no free address is available in any of the eligible pools.
+	errPoolExhausted = errors.New("no free address available in any pool")
+	// errAddressUnavailable is returned when the address of a DHCPREQUEST can't be bound to the client.
+	errAddressUnavailable = errors.New("requested address is not available")
+)
+
+// notInPoolError is returned by lookup when an address is not in the subnet of any of the eligible pools.
+// It implements the NotFound() method that handlers use to tell a client without a reservation apart from a failing backend.
+type notInPoolError struct {
+	ip netip.Addr
+}
+
+func (e notInPoolError) Error() string {
+	return fmt.Sprintf("address %v is not in the subnet of any pool", e.ip)
+}
+
+func (notInPoolError) NotFound() bool { return true }
+
+const (
+	// offerTime is how long an address offered to a client is held for it. The client has to request the address
+	// within this time, only then it is bound for the lease time of its pool.
+	offerTime = 30 * time.Second
+	// maxScan is the number of free addresses that are looked up in the lease store and the backend for a single DHCPDISCOVER.
+	// Without a limit, a DHCPDISCOVER for a nearly full pool would cost a lookup for every address of the pool.
+	maxScan = 32
+	// inUseTime is how long an address that can't be used according to the lease store or the backend is skipped,
+	// so that the next DHCPDISCOVER looks up other addresses.
+	inUseTime = time.Minute
+)
+
+// allocate returns DHCP data with an address from the pools eligible for a request from mac.
+//
+// When offer is true, for a DHCPDISCOVER, an existing binding for mac is used. Otherwise, the requested address
+// is used if it is free, or else the first free address from the eligible pools. The address is only held for offerTime, see bind.
+// At most maxScan free addresses are looked up in the lease store and the backend, errPoolExhausted is returned
+// when none of them can be used.
+// When offer is false, for a DHCPREQUEST, only the requested address is bound. errAddressUnavailable is returned
+// when it isn't free or not in an eligible pool, the client must start over with a DHCPDISCOVER.
+// Binding any other address would only keep it from other clients for a lease time, as the request is NAKed.
+//
+// The Handler's mutex is only held to pick and bind addresses, not while the lease store and the backend are read,
+// so that a slow store doesn't hold up the requests of other clients.
+func (h *Handler) allocate(ctx context.Context, mac net.HardwareAddr, giaddr net.IP, requested netip.Addr, offer bool) (*data.DHCP, error) {
+	now := time.Now()
+	key := mac.String()
+	eligible := h.eligible(giaddr)
+	h.restore(ctx, mac, now)
+	if requested.IsUnspecified() {
+		requested = netip.Addr{}
+	}
+
+	if !offer {
+		if !requested.IsValid() {
+			return nil, notInPoolError{ip: requested}
+		}
+		for _, i := range eligible {
+			if h.Pools[i].contains(requested) {
+				if d, ok := h.tryBind(ctx, key, mac, candidate{ip: requested, pool: i}, now, offer); ok {
+					return d, nil
+				}
+				break
+			}
+		}
+
+		return nil, errAddressUnavailable
+	}
+
+	var preferred []candidate
+	h.mu.Lock()
+	if b, ok := h.bindings[key]; ok && slices.Contains(eligible, b.pool) {
+		preferred = append(preferred, candidate{ip: b.ip, pool: b.pool})
+	}
+	h.mu.Unlock()
+	if requested.IsValid() {
+		for _, i := range eligible {
+			if h.Pools[i].contains(requested) {
+				preferred = append(preferred, candidate{ip: requested, pool: i})
+				break
+			}
+		}
+	}
+	for _, c := range preferred {
+		if d, ok := h.tryBind(ctx, key, mac, c, now, offer); ok {
+			return d, nil
+		}
+	}
+	scanned := 0
+	for _, i := range eligible {
+		p := h.Pools[i]
+		for ip := p.Start; ip.IsValid() && p.contains(ip); ip = ip.Next() {
+			h.mu.Lock()
+			skip := !h.free(key, ip, now) || h.inUse[ip].After(now)
+			h.mu.Unlock()
+			if skip {
+				continue
+			}
+			if scanned == maxScan {
+				return nil, errPoolExhausted
+			}
+			scanned++
+			if d, ok := h.tryBind(ctx, key, mac, candidate{ip: ip, pool: i}, now, offer); ok {
+				return d, nil
+			}
+		}
+	}
+
+	return nil, errPoolExhausted
+}
+
+// candidate is an address from a pool that may be bound to a client.
+type candidate struct {
+	ip   netip.Addr
+	pool int // index into Handler.Pools.
+}
+
+// tryBind binds c to the client identified by key if c is free, and returns the DHCP data for it.
+// The bindings are checked with the Handler's mutex held, then the lease store and the backend without it.
+// As another client may have been bound to c in the meantime, the bindings are checked again before c is bound.
+func (h *Handler) tryBind(ctx context.Context, key string, mac net.HardwareAddr, c candidate, now time.Time, offer bool) (*data.DHCP, bool) {
+	h.mu.Lock()
+	free := h.free(key, c.ip, now)
+	h.mu.Unlock()
+	if !free {
+		return nil, false
+	}
+	if !h.unused(ctx, key, c.ip, now) {
+		h.mu.Lock()
+		if h.inUse == nil {
+			h.inUse = make(map[netip.Addr]time.Time)
+		}
+		h.inUse[c.ip] = now.Add(inUseTime)
+		h.mu.Unlock()
+
+		return nil, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.free(key, c.ip, now) {
+		return nil, false
+	}
+
+	return h.bind(key, mac, c.ip, c.pool, now, offer), true
+}
+
+// lookup returns DHCP data with the options of the eligible pool whose subnet contains ip, the address of a client.
+// Unlike allocate, nothing is bound, so lookup is used for clients that already have an address.
+func (h *Handler) lookup(mac net.HardwareAddr, giaddr net.IP, ip netip.Addr) (*data.DHCP, error) {
+	for _, i := range h.eligible(giaddr) {
+		if p := h.Pools[i]; ip.IsValid() && p.subnet().Contains(ip) {
+			return p.dhcp(mac, ip), nil
+		}
+	}
+
+	return nil, notInPoolError{ip: ip}
+}
+
+// eligible returns the indexes of the pools that can be used for a request.
+// For relayed requests, only pools whose subnet contains giaddr are eligible.
+// Otherwise, only pools whose subnet contains the Handler's IPAddr are eligible, or all pools if none do.
+func (h *Handler) eligible(giaddr net.IP) []int {
+	match := h.IPAddr.Unmap()
+	relayed := false
+	if gi, ok := netip.AddrFromSlice(giaddr); ok && !gi.Unmap().IsUnspecified() {
+		match = gi.Unmap()
+		relayed = true
+	}
+	var idx []int
+	for i, p := range h.Pools {
+		if p.subnet().Contains(match) {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 && !relayed {
+		for i := range h.Pools {
+			idx = append(idx, i)
+		}
+	}
+
+	return idx
+}
+
+// free returns true if ip is not bound to or declined by another client than the one identified by key.
+// The Handler's mutex must be held when calling free.
+func (h *Handler) free(key string, ip netip.Addr, now time.Time) bool {
+	if ip == h.IPAddr {
+		return false
+	}
+	if owner, ok := h.byIP[ip]; ok && owner != key && h.bindings[owner].expires.After(now) {
+		return false
+	}
+	if until, ok := h.declined[ip]; ok && until.After(now) {
+		return false
+	}
+
+	return true
+}
+
+// unused returns true if ip has no active lease of another client than the one identified by key in the lease store,
+// and is not reserved for a host in the backend.
+// The Handler's mutex must not be held when calling unused, the lease store and the backend can be slow.
+func (h *Handler) unused(ctx context.Context, key string, ip netip.Addr, now time.Time) bool {
+	if h.Leases != nil {
+		// The lease store knows about leases from before a restart.
+		l, err := h.Leases.GetByIP(ctx, ip.AsSlice(), now)
+		if err == nil && l.MACAddress.String() != key {
+			return false
+		}
+		if err != nil && !hardwareNotFound(err) {
+			return false
+		}
+	}
+	if h.Backend != nil {
+		// Addresses that are reserved for a host in the backend are never handed out from a pool.
+		// If the backend can't tell us whether the address is reserved, we don't hand it out either.
+		if _, _, err := h.Backend.GetByIP(ctx, ip.AsSlice()); !hardwareNotFound(err) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// restore adds a binding for mac from an active lease in the lease store, if there is one for a pool address
+// and mac has no binding yet. The Handler's mutex must not be held when calling restore.
+func (h *Handler) restore(ctx context.Context, mac net.HardwareAddr, now time.Time) {
+	if h.Leases == nil {
+		return
+	}
+	h.mu.Lock()
+	_, ok := h.bindings[mac.String()]
+	h.mu.Unlock()
+	if ok {
+		return
+	}
+	l, err := h.Leases.GetByMac(ctx, mac)
+	if err != nil || !l.Active(now) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.bindings[mac.String()]; ok {
+		return
+	}
+	h.initBindings()
+	for i, p := range h.Pools {
+		if p.contains(l.IPAddress) {
+			if _, ok := h.byIP[l.IPAddress]; ok {
+				return
+			}
+			h.bindings[mac.String()] = binding{ip: l.IPAddress, pool: i, expires: l.Expires}
+			h.byIP[l.IPAddress] = mac.String()
+
+			return
+		}
+	}
+}
+
+// bind records ip from pool i as bound to the client identified by key and returns the DHCP data for it.
+// The binding expires after the lease time of the pool. When offer is true, it expires after offerTime,
+// unless the client was already bound to ip for longer, so that clients that never request an offered address
+// don't use up the pool.
+// The Handler's mutex must be held when calling bind.
+func (h *Handler) bind(key string, mac net.HardwareAddr, ip netip.Addr, i int, now time.Time, offer bool) *data.DHCP {
+	h.initBindings()
+	if owner, ok := h.byIP[ip]; ok && owner != key {
+		delete(h.bindings, owner)
+	}
+	if old, ok := h.bindings[key]; ok && old.ip != ip {
+		delete(h.byIP, old.ip)
+	}
+	p := h.Pools[i]
+	expires := now.Add(p.leaseTime())
+	if offer {
+		expires = now.Add(min(offerTime, p.leaseTime()))
+		if old, ok := h.bindings[key]; ok && old.ip == ip && old.expires.After(expires) {
+			expires = old.expires
+		}
+	}
+	h.bindings[key] = binding{ip: ip, pool: i, expires: expires}
+	h.byIP[ip] = key
+
+	return p.dhcp(mac, ip)
+}
+
+// initBindings makes the maps of the bindings. The Handler's mutex must be held when calling initBindings.
+func (h *Handler) initBindings() {
+	if h.bindings == nil {
+		h.bindings = make(map[string]binding)
+		h.byIP = make(map[netip.Addr]string)
+	}
+}
+
+// release removes the binding for mac.
+// If ip is valid, the binding is only removed if it is for ip.
+func (h *Handler) release(mac net.HardwareAddr, ip netip.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := mac.String()
+	b, ok := h.bindings[key]
+	if !ok || (ip.IsValid() && b.ip != ip) {
+		return
+	}
+	delete(h.bindings, key)
+	delete(h.byIP, b.ip)
+}
+
+// decline removes the binding for mac and keeps ip from being handed out for the lease time of its pool.
+// A client declines an address when it detects that the address is already in use on the network.
+func (h *Handler) decline(mac net.HardwareAddr, ip netip.Addr) {
+	if !ip.IsValid() {
+		return
+	}
+	h.release(mac, ip)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.declined == nil {
+		h.declined = make(map[netip.Addr]time.Time)
+	}
+	quarantine := defaultLeaseTime * time.Second
+	for _, p := range h.Pools {
+		if p.contains(ip) {
+			quarantine = p.leaseTime()
+			break
+		}
+	}
+	h.declined[ip] = time.Now().Add(quarantine)
+}
+
+// hardwareNotFound returns true if the error is from a hardware record not being found.
+func hardwareNotFound(err error) bool {
+	type hardwareNotFound interface {
+		NotFound() bool
+	}
+	var te hardwareNotFound
+
+	return errors.As(err, &te) && te.NotFound()
+}
@@ -0,0 +1,517 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/lease"
+)
+
+type hwNotFoundError struct{}
+
+func (hwNotFoundError) NotFound() bool { return true }
+func (hwNotFoundError) Error() string  { return "not found" }
+
+// mockBackend holds host reservations keyed by MAC address string and by relay agent circuit ID.
+type mockBackend struct {
+	reservations map[string]*data.DHCP
+	circuits     map[string]*data.DHCP
+	err          error
+}
+
+func (m *mockBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	if d, ok := m.reservations[mac.String()]; ok {
+		return d, &data.Netboot{AllowNetboot: true}, nil
+	}
+	return nil, nil, hwNotFoundError{}
+}
+
+func (m *mockBackend) GetByIP(_ context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	for _, d := range m.reservations {
+		if d.IPAddress.String() == ip.String() {
+			return d, &data.Netboot{AllowNetboot: true}, nil
+		}
+	}
+	return nil, nil, hwNotFoundError{}
+}
+
+func (m *mockBackend) GetByCircuitID(_ context.Context, circuitID, _ string) (*data.DHCP, *data.Netboot, error) {
+	if d, ok := m.circuits[circuitID]; ok {
+		return d, &data.Netboot{AllowNetboot: true}, nil
+	}
+	return nil, nil, hwNotFoundError{}
+}
+
+func testPools() []Pool {
+	return []Pool{
+		{
+			Start:   netip.MustParseAddr("192.168.1.10"),
+			End:     netip.MustParseAddr("192.168.1.12"),
+			Options: data.DHCP{SubnetMask: net.IPv4Mask(255, 255, 255, 0), LeaseTime: 60},
+		},
+		{
+			Start:   netip.MustParseAddr("10.0.0.10"),
+			End:     netip.MustParseAddr("10.0.0.11"),
+			Options: data.DHCP{SubnetMask: net.IPv4Mask(255, 255, 255, 0)},
+		},
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	mac1 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		backend   *mockBackend
+		bound     map[string]string // MAC to IP bindings made before the allocation.
+		mac       net.HardwareAddr
+		giaddr    net.IP
+		requested netip.Addr
+		want      netip.Addr
+		wantErr   error
+	}{
+		"first free address": {
+			mac:  mac1,
+			want: netip.MustParseAddr("192.168.1.10"),
+		},
+		"existing binding is reused": {
+			bound: map[string]string{mac1.String(): "192.168.1.11"},
+			mac:   mac1,
+			want:  netip.MustParseAddr("192.168.1.11"),
+		},
+		"requested address": {
+			mac:       mac1,
+			requested: netip.MustParseAddr("192.168.1.12"),
+			want:      netip.MustParseAddr("192.168.1.12"),
+		},
+		"requested address outside of pools": {
+			mac:       mac1,
+			requested: netip.MustParseAddr("192.168.1.50"),
+			want:      netip.MustParseAddr("192.168.1.10"),
+		},
+		"skip bound and reserved addresses": {
+			backend: &mockBackend{reservations: map[string]*data.DHCP{
+				"aa:aa:aa:aa:aa:aa": {IPAddress: netip.MustParseAddr("192.168.1.11")},
+			}},
+			bound: map[string]string{"bb:bb:bb:bb:bb:bb": "192.168.1.10"},
+			mac:   mac1,
+			want:  netip.MustParseAddr("192.168.1.12"),
+		},
+		"relayed request uses matching pool": {
+			mac:    mac1,
+			giaddr: net.IP{10, 0, 0, 1},
+			want:   netip.MustParseAddr("10.0.0.10"),
+		},
+		"relayed request without matching pool": {
+			mac:     mac1,
+			giaddr:  net.IP{172, 16, 0, 1},
+			wantErr: errPoolExhausted,
+		},
+		"backend error": {
+			backend: &mockBackend{err: errors.New("backend down")},
+			mac:     mac1,
+			wantErr: errPoolExhausted,
+		},
+		"pools exhausted": {
+			bound: map[string]string{
+				"bb:bb:bb:bb:bb:b1": "192.168.1.10",
+				"bb:bb:bb:bb:bb:b2": "192.168.1.11",
+				"bb:bb:bb:bb:bb:b3": "192.168.1.12",
+			},
+			mac:     mac1,
+			giaddr:  net.IP{192, 168, 1, 1},
+			wantErr: errPoolExhausted,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{IPAddr: netip.MustParseAddr("192.168.1.2"), Pools: testPools()}
+			if tt.backend != nil {
+				h.Backend = tt.backend
+			}
+			for mac, ip := range tt.bound {
+				m, _ := net.ParseMAC(mac)
+				if _, err := h.allocate(context.Background(), m, nil, netip.MustParseAddr(ip), true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := h.allocate(context.Background(), tt.mac, tt.giaddr, tt.requested, true)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("allocate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(got.IPAddress, tt.want, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(got.MACAddress, tt.mac); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestAllocateExpired(t *testing.T) {
+	h := &Handler{Pools: testPools()[:1]}
+	h.Pools[0].End = h.Pools[0].Start
+	old := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	if _, err := h.allocate(context.Background(), old, nil, netip.Addr{}, true); err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
+	if _, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true); !errors.Is(err, errPoolExhausted) {
+		t.Fatalf("expected pool to be exhausted, got: %v", err)
+	}
+
+	// expire the binding of the old client.
+	h.mu.Lock()
+	b := h.bindings[old.String()]
+	b.expires = time.Now().Add(-time.Second)
+	h.bindings[old.String()] = b
+	h.mu.Unlock()
+
+	got, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IPAddress != h.Pools[0].Start {
+		t.Fatalf("got %v, want %v", got.IPAddress, h.Pools[0].Start)
+	}
+	if _, ok := h.bindings[old.String()]; ok {
+		t.Fatal("expected binding of expired client to be removed")
+	}
+}
+
+func TestAllocateRequest(t *testing.T) {
+	mac1 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		bound     map[string]string // MAC to IP bindings made before the request.
+		declined  netip.Addr
+		requested netip.Addr
+		want      netip.Addr
+		wantErr   error
+	}{
+		"requested address": {
+			requested: netip.MustParseAddr("192.168.1.11"),
+			want:      netip.MustParseAddr("192.168.1.11"),
+		},
+		"renewal of own binding": {
+			bound:     map[string]string{mac1.String(): "192.168.1.11"},
+			requested: netip.MustParseAddr("192.168.1.11"),
+			want:      netip.MustParseAddr("192.168.1.11"),
+		},
+		"address bound to another client": {
+			bound:     map[string]string{"bb:bb:bb:bb:bb:bb": "192.168.1.10"},
+			requested: netip.MustParseAddr("192.168.1.10"),
+			wantErr:   errAddressUnavailable,
+		},
+		"other address than own binding": {
+			bound:     map[string]string{mac1.String(): "192.168.1.11", "bb:bb:bb:bb:bb:bb": "192.168.1.10"},
+			requested: netip.MustParseAddr("192.168.1.10"),
+			wantErr:   errAddressUnavailable,
+		},
+		"declined address": {
+			declined:  netip.MustParseAddr("192.168.1.10"),
+			requested: netip.MustParseAddr("192.168.1.10"),
+			wantErr:   errAddressUnavailable,
+		},
+		"address outside of pools": {
+			requested: netip.MustParseAddr("192.168.1.50"),
+			wantErr:   errAddressUnavailable,
+		},
+		"no address": {
+			wantErr: notInPoolError{},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{IPAddr: netip.MustParseAddr("192.168.1.2"), Pools: testPools()}
+			for m, ip := range tt.bound {
+				hw, _ := net.ParseMAC(m)
+				if _, err := h.allocate(context.Background(), hw, nil, netip.MustParseAddr(ip), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.declined.IsValid() {
+				h.decline(net.HardwareAddr{0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc}, tt.declined)
+			}
+			before := make(map[string]binding)
+			for k, b := range h.bindings {
+				before[k] = b
+			}
+
+			got, err := h.allocate(context.Background(), mac1, nil, tt.requested, false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("allocate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				// nothing is bound or renewed for a request that is NAKed.
+				if diff := cmp.Diff(h.bindings, before, cmp.AllowUnexported(binding{}), cmp.Comparer(func(a, b netip.Addr) bool { return a == b }), cmpopts.EquateEmpty()); diff != "" {
+					t.Fatal(diff)
+				}
+				return
+			}
+			if got.IPAddress != tt.want {
+				t.Fatalf("got %v, want %v", got.IPAddress, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateOffer(t *testing.T) {
+	h := &Handler{Pools: testPools()[:1]}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	expires := func() time.Duration {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return time.Until(h.bindings[mac.String()].expires)
+	}
+
+	// an offered address is only held for a short time.
+	d, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := expires(); got > offerTime {
+		t.Fatalf("offered address held for %v, want at most %v", got, offerTime)
+	}
+
+	// a requested address is bound for the lease time.
+	if _, err := h.allocate(context.Background(), mac, nil, d.IPAddress, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := expires(); got <= offerTime || got > h.Pools[0].leaseTime() {
+		t.Fatalf("requested address bound for %v, want %v", got, h.Pools[0].leaseTime())
+	}
+
+	// offering the address again doesn't shorten the binding.
+	if _, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := expires(); got <= offerTime {
+		t.Fatalf("binding shortened to %v by an offer", got)
+	}
+}
+
+// countingBackend has a reservation for every IP address except free. It counts the lookups by IP address.
+type countingBackend struct {
+	free    netip.Addr
+	lookups atomic.Int64
+}
+
+func (b *countingBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, hwNotFoundError{}
+}
+
+func (b *countingBackend) GetByIP(_ context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	b.lookups.Add(1)
+	if ip.Equal(b.free.AsSlice()) {
+		return nil, nil, hwNotFoundError{}
+	}
+	return &data.DHCP{}, &data.Netboot{}, nil
+}
+
+func TestAllocateScanLimit(t *testing.T) {
+	backend := &countingBackend{free: netip.MustParseAddr("10.0.0.60")}
+	h := &Handler{Backend: backend, Pools: []Pool{{
+		Start:   netip.MustParseAddr("10.0.0.10"),
+		End:     netip.MustParseAddr("10.0.0.109"),
+		Options: data.DHCP{SubnetMask: net.IPv4Mask(255, 255, 255, 0)},
+	}}}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+
+	// the first addresses are reserved, the free address is not looked up.
+	if _, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true); !errors.Is(err, errPoolExhausted) {
+		t.Fatalf("expected pool to be exhausted, got: %v", err)
+	}
+	if got := backend.lookups.Load(); got != maxScan {
+		t.Fatalf("%d backend lookups, want %d", got, maxScan)
+	}
+
+	// the next DHCPDISCOVER skips the reserved addresses that were looked up.
+	got, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IPAddress != backend.free {
+		t.Fatalf("got %v, want %v", got.IPAddress, backend.free)
+	}
+	if got := backend.lookups.Load(); got > 2*maxScan {
+		t.Fatalf("%d backend lookups, want at most %d", got, 2*maxScan)
+	}
+}
+
+// blockingBackend has no reservations. GetByIP blocks until release is closed.
+type blockingBackend struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, hwNotFoundError{}
+}
+
+func (b *blockingBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	select {
+	case b.entered <- struct{}{}:
+	default:
+	}
+	<-b.release
+	return nil, nil, hwNotFoundError{}
+}
+
+func TestAllocateConcurrent(t *testing.T) {
+	backend := &blockingBackend{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	h := &Handler{Backend: backend, Pools: testPools()[:1]}
+	macs := []net.HardwareAddr{
+		{0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+		{0x00, 0x00, 0x00, 0x00, 0x00, 0x02},
+		{0x00, 0x00, 0x00, 0x00, 0x00, 0x03},
+	}
+	type result struct {
+		d   *data.DHCP
+		err error
+	}
+	results := make(chan result, len(macs))
+	for _, mac := range macs {
+		mac := mac
+		go func() {
+			d, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true)
+			results <- result{d: d, err: err}
+		}()
+	}
+
+	// the mutex is not held while the backend is read.
+	<-backend.entered
+	locked := make(chan struct{})
+	go func() {
+		h.release(net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x04}, netip.Addr{})
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("mutex held while reading the backend")
+	}
+	close(backend.release)
+
+	// every client gets a different address, although all of them found the first address free in the backend.
+	seen := make(map[netip.Addr]bool)
+	for range macs {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if seen[r.d.IPAddress] {
+			t.Fatalf("%v allocated more than once", r.d.IPAddress)
+		}
+		seen[r.d.IPAddress] = true
+	}
+}
+
+func TestReleaseAndDecline(t *testing.T) {
+	h := &Handler{Pools: testPools()[:1]}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	d, err := h.allocate(context.Background(), mac, nil, netip.Addr{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// releasing a different address does nothing.
+	h.release(mac, netip.MustParseAddr("192.168.1.100"))
+	if _, ok := h.bindings[mac.String()]; !ok {
+		t.Fatal("expected binding to be kept")
+	}
+	h.release(mac, d.IPAddress)
+	if _, ok := h.bindings[mac.String()]; ok {
+		t.Fatal("expected binding to be removed")
+	}
+	if _, ok := h.byIP[d.IPAddress]; ok {
+		t.Fatal("expected address to be free")
+	}
+
+	// a declined address is not handed out again.
+	d, err = h.allocate(context.Background(), mac, nil, netip.Addr{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.decline(mac, d.IPAddress)
+	got, err := h.allocate(context.Background(), mac, nil, d.IPAddress, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IPAddress == d.IPAddress {
+		t.Fatalf("declined address %v was handed out again", d.IPAddress)
+	}
+}
+
+func TestAllocateLeases(t *testing.T) {
+	j, err := lease.NewJournal(logr.Discard(), filepath.Join(t.TempDir(), "leases.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	mac1 := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	mac2 := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
+	now := time.Now()
+	leases := []*data.Lease{
+		{MACAddress: mac1, IPAddress: netip.MustParseAddr("192.168.1.11"), State: data.LeaseStateBound, Updated: now, Expires: now.Add(time.Hour)},
+		{MACAddress: mac2, IPAddress: netip.MustParseAddr("192.168.1.10"), State: data.LeaseStateBound, Updated: now, Expires: now.Add(time.Hour)},
+	}
+	for _, l := range leases {
+		if err := j.Put(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new Handler, as after a restart, doesn't hand out leased addresses to other clients.
+	h := &Handler{Pools: testPools()[:1], Leases: j}
+	other, err := h.allocate(context.Background(), net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x03}, nil, netip.MustParseAddr("192.168.1.10"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParseAddr("192.168.1.12"); other.IPAddress != want {
+		t.Fatalf("got %v, want %v", other.IPAddress, want)
+	}
+
+	// and clients get their leased address back, even when requesting a different one.
+	got, err := h.allocate(context.Background(), mac1, nil, netip.MustParseAddr("192.168.1.12"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IPAddress != leases[0].IPAddress {
+		t.Fatalf("got %v, want %v", got.IPAddress, leases[0].IPAddress)
+	}
+}
+
+func TestPoolDHCP(t *testing.T) {
+	p := testPools()[1]
+	got := p.dhcp(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, p.Start)
+	want := &data.DHCP{
+		MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		IPAddress:  p.Start,
+		SubnetMask: net.IPv4Mask(255, 255, 255, 0),
+		LeaseTime:  defaultLeaseTime,
+	}
+	if diff := cmp.Diff(got, want, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := p.subnet(); got != netip.MustParsePrefix("10.0.0.0/24") {
+		t.Fatalf("subnet() = %v, want 10.0.0.0/24", got)
+	}
+}
@@ -0,0 +1,125 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/handler/reservation"
+	"golang.org/x/net/ipv4"
+)
+
+// setDefaults will update the Handler struct to have default values so as
+// to avoid panic for nil pointers and such.
+func (h *Handler) setDefaults() {
+	if h.Log.GetSink() == nil {
+		h.Log = logr.Discard()
+	}
+}
+
+// Handle responds to DHCP messages with a host reservation from the backend or an address from a pool.
+//
+// Responses are built by a reservation.Handler whose backend falls back to the pools
+// when the Handler's Backend has no reservation for the client.
+func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Packet) {
+	h.setDefaults()
+	if p.Pkt == nil {
+		h.Log.Error(errors.New("incoming packet is nil"), "not able to respond when the incoming packet is nil")
+		return
+	}
+
+	switch p.Pkt.MessageType() {
+	case dhcpv4.MessageTypeRelease:
+		ip, _ := netip.AddrFromSlice(p.Pkt.ClientIPAddr.To4())
+		h.release(p.Pkt.ClientHWAddr, ip)
+		h.Log.V(1).Info("released pool address", "mac", p.Pkt.ClientHWAddr.String(), "ipAddress", ip)
+	case dhcpv4.MessageTypeDecline:
+		ip, _ := netip.AddrFromSlice(p.Pkt.RequestedIPAddress().To4())
+		h.decline(p.Pkt.ClientHWAddr, ip)
+		h.Log.V(1).Info("client declined pool address, address will not be handed out for a lease time", "mac", p.Pkt.ClientHWAddr.String(), "ipAddress", ip)
+	default:
+	}
+
+	h.reservation(p).Handle(ctx, conn, p)
+}
+
+// reservation returns a reservation.Handler for p that uses the pools as a fallback backend.
+func (h *Handler) reservation(p data.Packet) *reservation.Handler {
+	return &reservation.Handler{
+		Backend:     &backend{h: h, pkt: p.Pkt, md: p.Md},
+		IPAddr:      h.IPAddr,
+		Log:         h.Log,
+		Netboot:     h.Netboot,
+		OTELEnabled: h.OTELEnabled,
+		SyslogAddr:  h.SyslogAddr,
+		Leases:      h.Leases,
+		Metrics:     h.Metrics,
+		AlwaysSend:  h.AlwaysSend,
+	}
+}
+
+// backend is a handler.BackendReader that returns host reservations from the Handler's Backend
+// and falls back to an address from the Handler's pools for clients without a reservation.
+type backend struct {
+	h   *Handler
+	pkt *dhcpv4.DHCPv4
+	md  *data.Metadata
+}
+
+// GetByMac returns the host reservation for mac, or allocates an address from a pool if there is none.
+// When the Handler's Backend implements handler.CircuitReader, a reservation for the client's relay agent circuit
+// is preferred over a pool address.
+// For a DHCPINFORM, nothing is allocated, the options of the pool of the client's address are returned.
+func (b *backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if b.h.Backend != nil {
+		d, n, err := b.h.Backend.GetByMac(ctx, mac)
+		if cr, ok := b.h.Backend.(handler.CircuitReader); ok && hardwareNotFound(err) && b.md != nil && b.md.CircuitID != "" {
+			d, n, err = cr.GetByCircuitID(ctx, b.md.CircuitID, b.md.RemoteID)
+		}
+		if err == nil {
+			return d, n, nil
+		}
+		if !hardwareNotFound(err) {
+			return nil, nil, err
+		}
+	}
+
+	if b.pkt.MessageType() == dhcpv4.MessageTypeInform {
+		// The client already has an address, see https://www.rfc-editor.org/rfc/rfc2131#section-4.3.5.
+		ciaddr, _ := netip.AddrFromSlice(b.pkt.ClientIPAddr.To4())
+		d, err := b.h.lookup(mac, b.pkt.GatewayIPAddr, ciaddr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return d, &data.Netboot{AllowNetboot: b.h.AllowNetboot}, nil
+	}
+	requested, _ := netip.AddrFromSlice(b.pkt.RequestedIPAddress().To4())
+	if !requested.IsValid() {
+		requested, _ = netip.AddrFromSlice(b.pkt.ClientIPAddr.To4())
+	}
+	d, err := b.h.allocate(ctx, mac, b.pkt.GatewayIPAddr, requested, b.pkt.MessageType() == dhcpv4.MessageTypeDiscover)
+	if errors.Is(err, errAddressUnavailable) {
+		// Without an address, the requested address is not the one of the client, so the request is NAKed.
+		return &data.DHCP{MACAddress: mac}, &data.Netboot{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return d, &data.Netboot{AllowNetboot: b.h.AllowNetboot}, nil
+}
+
+// GetByIP returns the host reservation for ip from the Handler's Backend.
+func (b *backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	if b.h.Backend == nil {
+		return nil, nil, errors.New("no backend specified")
+	}
+
+	return b.h.Backend.GetByIP(ctx, ip)
+}
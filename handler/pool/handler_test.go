@@ -0,0 +1,263 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/nettest"
+)
+
+func TestHandle(t *testing.T) {
+	reserved := net.HardwareAddr{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}
+	tests := map[string]struct {
+		mac       net.HardwareAddr
+		msgType   dhcpv4.MessageType
+		requested net.IP
+		circuitID string
+		backend   *mockBackend
+		want      net.IP
+		wantErr   bool
+	}{
+		"pool address for unknown client": {
+			mac:     net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType: dhcpv4.MessageTypeDiscover,
+			want:    net.IP{192, 168, 1, 10},
+		},
+		"reservation takes precedence": {
+			mac:     reserved,
+			msgType: dhcpv4.MessageTypeDiscover,
+			backend: &mockBackend{reservations: map[string]*data.DHCP{
+				reserved.String(): {MACAddress: reserved, IPAddress: netip.MustParseAddr("192.168.1.100"), SubnetMask: net.IPv4Mask(255, 255, 255, 0)},
+			}},
+			want: net.IP{192, 168, 1, 100},
+		},
+		"circuit reservation takes precedence": {
+			mac:       net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType:   dhcpv4.MessageTypeDiscover,
+			circuitID: "eth0/1",
+			backend: &mockBackend{circuits: map[string]*data.DHCP{
+				"eth0/1": {MACAddress: reserved, IPAddress: netip.MustParseAddr("192.168.1.101"), SubnetMask: net.IPv4Mask(255, 255, 255, 0)},
+			}},
+			want: net.IP{192, 168, 1, 101},
+		},
+		"pool address for unknown circuit": {
+			mac:       net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType:   dhcpv4.MessageTypeDiscover,
+			circuitID: "eth0/2",
+			backend: &mockBackend{circuits: map[string]*data.DHCP{
+				"eth0/1": {MACAddress: reserved, IPAddress: netip.MustParseAddr("192.168.1.101"), SubnetMask: net.IPv4Mask(255, 255, 255, 0)},
+			}},
+			want: net.IP{192, 168, 1, 10},
+		},
+		"request gets an ack": {
+			mac:       net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType:   dhcpv4.MessageTypeRequest,
+			requested: net.IP{192, 168, 1, 11},
+			want:      net.IP{192, 168, 1, 11},
+		},
+		"request without an address has no response": {
+			mac:     net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType: dhcpv4.MessageTypeRequest,
+			wantErr: true,
+		},
+		"backend error": {
+			mac:     net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType: dhcpv4.MessageTypeDiscover,
+			backend: &mockBackend{err: errors.New("backend down")},
+			wantErr: true,
+		},
+		"release has no response": {
+			mac:     net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			msgType: dhcpv4.MessageTypeRelease,
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{IPAddr: netip.MustParseAddr("127.0.0.1"), Pools: testPools()}
+			if tt.backend != nil {
+				h.Backend = tt.backend
+			}
+			conn, err := nettest.NewLocalPacketListener("udp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			pc, err := net.ListenPacket("udp4", ":0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			peer := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: pc.LocalAddr().(*net.UDPAddr).Port}
+
+			req := &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: tt.mac,
+				Options:      dhcpv4.OptionsFromList(dhcpv4.OptMessageType(tt.msgType)),
+			}
+			if tt.requested != nil {
+				req.UpdateOption(dhcpv4.OptRequestedIPAddress(tt.requested))
+			}
+			h.Handle(context.Background(), ipv4.NewPacketConn(conn), data.Packet{Peer: peer, Pkt: req, Md: &data.Metadata{CircuitID: tt.circuitID}})
+
+			got, err := client(pc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected no response, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.YourIPAddr.Equal(tt.want) {
+				t.Fatalf("yiaddr = %v, want %v", got.YourIPAddr, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleRequestNak(t *testing.T) {
+	a := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	b := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+	h := &Handler{IPAddr: netip.MustParseAddr("127.0.0.1"), Pools: testPools()}
+	if _, err := h.allocate(context.Background(), b, nil, netip.MustParseAddr("192.168.1.10"), false); err != nil {
+		t.Fatal(err)
+	}
+	bound := len(h.bindings)
+
+	conn, err := nettest.NewLocalPacketListener("udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a NAK is broadcast to the client port.
+	pc, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", dhcpv4.ClientPort))
+	if err != nil {
+		t.Skipf("can't listen on the DHCP client port: %v", err)
+	}
+	defer pc.Close()
+	peer := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: dhcpv4.ClientPort}
+
+	// client a requests the address of client b.
+	req := &dhcpv4.DHCPv4{
+		OpCode:       dhcpv4.OpcodeBootRequest,
+		ClientHWAddr: a,
+		Options: dhcpv4.OptionsFromList(
+			dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 1, 10}),
+		),
+	}
+	h.Handle(context.Background(), ipv4.NewPacketConn(conn), data.Packet{Peer: peer, Pkt: req, Md: &data.Metadata{}})
+
+	got, err := client(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MessageType() != dhcpv4.MessageTypeNak {
+		t.Fatalf("message type = %v, want %v", got.MessageType(), dhcpv4.MessageTypeNak)
+	}
+	if len(h.bindings) != bound {
+		t.Fatalf("%d addresses bound after the NAK, want %d", len(h.bindings), bound)
+	}
+	if _, ok := h.bindings[a.String()]; ok {
+		t.Fatal("address bound to a NAKed client")
+	}
+	if owner := h.byIP[netip.MustParseAddr("192.168.1.10")]; owner != b.String() {
+		t.Fatalf("192.168.1.10 is bound to %q, want %q", owner, b)
+	}
+}
+
+func TestHandleInform(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	other := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+	tests := map[string]struct {
+		ciaddr net.IP
+		bound  bool // whether ciaddr is bound to other before the INFORM.
+		want   net.IPMask
+	}{
+		"address in pool subnet":             {ciaddr: net.IP{192, 168, 1, 50}, want: net.IPv4Mask(255, 255, 255, 0)},
+		"address bound to another client":    {ciaddr: net.IP{192, 168, 1, 10}, bound: true, want: net.IPv4Mask(255, 255, 255, 0)},
+		"address not in a pool has no reply": {ciaddr: net.IP{172, 16, 0, 5}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{IPAddr: netip.MustParseAddr("127.0.0.1"), Pools: testPools()}
+			if tt.bound {
+				ip, _ := netip.AddrFromSlice(tt.ciaddr)
+				if _, err := h.allocate(context.Background(), other, nil, ip, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn, err := nettest.NewLocalPacketListener("udp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			pc, err := net.ListenPacket("udp4", ":0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			peer := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: pc.LocalAddr().(*net.UDPAddr).Port}
+
+			req := &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: mac,
+				ClientIPAddr: tt.ciaddr,
+				Options:      dhcpv4.OptionsFromList(dhcpv4.OptMessageType(dhcpv4.MessageTypeInform)),
+			}
+			h.Handle(context.Background(), ipv4.NewPacketConn(conn), data.Packet{Peer: peer, Pkt: req, Md: &data.Metadata{}})
+
+			got, err := client(pc)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected no response, got: %v", got)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.MessageType() != dhcpv4.MessageTypeAck {
+					t.Fatalf("message type = %v, want %v", got.MessageType(), dhcpv4.MessageTypeAck)
+				}
+				if !got.YourIPAddr.IsUnspecified() {
+					t.Fatalf("yiaddr = %v, want %v", got.YourIPAddr, net.IPv4zero)
+				}
+				if mask := got.SubnetMask(); mask.String() != tt.want.String() {
+					t.Fatalf("subnet mask = %v, want %v", mask, tt.want)
+				}
+			}
+
+			// an INFORM never allocates, see https://www.rfc-editor.org/rfc/rfc2131#section-4.3.5.
+			if _, ok := h.bindings[mac.String()]; ok {
+				t.Fatalf("INFORM bound an address: %v", h.bindings[mac.String()])
+			}
+			if tt.bound {
+				ip, _ := netip.AddrFromSlice(tt.ciaddr)
+				if owner := h.byIP[ip]; owner != other.String() {
+					t.Fatalf("%v is bound to %q, want %q", ip, owner, other)
+				}
+			}
+		})
+	}
+}
+
+func client(pc net.PacketConn) (*dhcpv4.DHCPv4, error) {
+	buf := make([]byte, 1024)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return dhcpv4.FromBytes(buf[:n])
+}
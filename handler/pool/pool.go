@@ -0,0 +1,133 @@
+// Package pool is the handler for responding to DHCPv4 messages with addresses from dynamic address pools.
+// Host reservations from a backend are always honored first.
+package pool
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/handler/reservation"
+	"github.com/tinkerbell/dhcp/metrics"
+)
+
+// defaultLeaseTime is used for pool addresses when the Pool does not define a lease time.
+const defaultLeaseTime = 3600
+
+// Handler holds the configuration details for running a DHCP server with dynamic address pools.
+type Handler struct {
+	// Backend is the backend to use for getting host reservations.
+	// A host reservation always takes precedence over a pool address.
+	// When nil, only pool addresses are handed out.
+	Backend handler.BackendReader
+
+	// IPAddr is the IP address to use in DHCP responses.
+	// Option 54 and the sname DHCP header.
+	// This could be a load balancer IP address or an ingress IP address or a local IP address.
+	IPAddr netip.Addr
+
+	// Log is used to log messages.
+	// `logr.Discard()` can be used if no logging is desired.
+	Log logr.Logger
+
+	// Netboot configuration.
+	Netboot reservation.Netboot
+
+	// OTELEnabled is used to determine if netboot options include otel naming.
+	// See reservation.Handler.OTELEnabled for details.
+	OTELEnabled bool
+
+	// SyslogAddr is the address to send syslog messages to. DHCP Option 7.
+	SyslogAddr netip.Addr
+
+	// Pools are the address ranges used for clients without a host reservation.
+	// For relayed requests, the first Pool whose subnet contains the giaddr is used.
+	// Otherwise, Pools are used in order until a free address is found.
+	Pools []Pool
+
+	// AllowNetboot determines whether clients that get a pool address are provided netboot options.
+	AllowNetboot bool
+
+	// Leases is the store that acknowledged, released and declined leases are recorded in.
+	// When set, pool addresses with an active lease in the store are not handed out to other clients,
+	// and clients get their leased address back after a restart.
+	Leases handler.LeaseStore
+
+	// Metrics records hardware lookups, replies sent and netboot decisions.
+	// When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
+
+	// AlwaysSend are the DHCP options sent to a client even when it doesn't request them in option 55.
+	// See reservation.Handler.AlwaysSend for details.
+	AlwaysSend []dhcpv4.OptionCode
+
+	mu       sync.Mutex
+	bindings map[string]binding       // keyed by MAC address string.
+	byIP     map[netip.Addr]string    // IP address to MAC address string, for all bindings.
+	declined map[netip.Addr]time.Time // IP addresses declined by clients, not handed out until the time.
+	inUse    map[netip.Addr]time.Time // IP addresses that can't be used according to the lease store or the backend, not looked up again until the time.
+}
+
+// Pool is a range of IP addresses and the DHCP options handed out with them.
+type Pool struct {
+	// Start is the first IP address in the pool.
+	Start netip.Addr
+	// End is the last IP address in the pool.
+	End netip.Addr
+	// Options are the DHCP options handed out with an address from this pool.
+	// MACAddress and IPAddress are ignored.
+	// A LeaseTime of 0 uses a default lease time of 1 hour.
+	Options data.DHCP
+}
+
+// binding is an address handed out from a pool to a client.
+type binding struct {
+	ip      netip.Addr
+	pool    int // index into Handler.Pools.
+	expires time.Time
+}
+
+// subnet returns the network that the pool belongs to, determined from the Start address and the subnet mask.
+func (p Pool) subnet() netip.Prefix {
+	ones, bits := p.Options.SubnetMask.Size()
+	if bits != 32 {
+		ones = 32
+	}
+	pfx, err := p.Start.Prefix(ones)
+	if err != nil {
+		return netip.Prefix{}
+	}
+
+	return pfx
+}
+
+// contains returns true if ip is within the Start and End addresses of the pool.
+func (p Pool) contains(ip netip.Addr) bool {
+	return p.Start.Compare(ip) <= 0 && ip.Compare(p.End) <= 0
+}
+
+// leaseTime returns the lease time for addresses in the pool.
+func (p Pool) leaseTime() time.Duration {
+	if p.Options.LeaseTime == 0 {
+		return defaultLeaseTime * time.Second
+	}
+
+	return time.Duration(p.Options.LeaseTime) * time.Second
+}
+
+// dhcp returns the DHCP data for ip and mac using the pool options.
+func (p Pool) dhcp(mac net.HardwareAddr, ip netip.Addr) *data.DHCP {
+	d := p.Options
+	d.MACAddress = mac
+	d.IPAddress = ip
+	if d.LeaseTime == 0 {
+		d.LeaseTime = defaultLeaseTime
+	}
+
+	return &d
+}
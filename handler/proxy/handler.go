@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/backend/noop"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/handler/reservation"
+	"github.com/tinkerbell/dhcp/metrics"
+	oteldhcp "github.com/tinkerbell/dhcp/otel"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/ipv4"
+)
+
+const tracerName = "github.com/tinkerbell/dhcp/server"
+
+// pxeClient is the prefix of DHCP option 60 sent by PXE clients.
+const pxeClient = "PXEClient"
+
+// setDefaults will update the Handler struct to have default values so as
+// to avoid panic for nil pointers and such.
+func (h *Handler) setDefaults() {
+	if h.Backend == nil {
+		h.Backend = noop.Handler{}
+	}
+	if h.Log.GetSink() == nil {
+		h.Log = logr.Discard()
+	}
+}
+
+// Handle responds to DHCP discovers and PXE boot server requests from netboot clients with network boot options.
+// Responses never contain an IP address for the client.
+func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Packet) {
+	h.setDefaults()
+	if p.Pkt == nil {
+		h.Log.Error(errors.New("incoming packet is nil"), "not able to respond when the incoming packet is nil")
+		return
+	}
+	upeer, ok := p.Peer.(*net.UDPAddr)
+	if !ok {
+		h.Log.Error(errors.New("peer is not a UDP connection"), "not able to respond when the peer is not a UDP connection")
+		return
+	}
+	if upeer == nil {
+		h.Log.Error(errors.New("peer is nil"), "not able to respond when the peer is nil")
+		return
+	}
+	if conn == nil {
+		h.Log.Error(errors.New("connection is nil"), "not able to respond when the connection is nil")
+		return
+	}
+
+	var ifName string
+	if p.Md != nil {
+		ifName = p.Md.IfName
+	}
+	log := h.Log.WithValues("mac", p.Pkt.ClientHWAddr.String(), "xid", p.Pkt.TransactionID.String(), "interface", ifName)
+	tracer := otel.Tracer(tracerName)
+	var span trace.Span
+	ctx, span = tracer.Start(
+		ctx,
+		fmt.Sprintf("proxyDHCP Packet Received: %v", p.Pkt.MessageType().String()),
+		trace.WithAttributes(h.encodeToAttributes(p.Pkt, "request")...),
+		trace.WithAttributes(attribute.String("DHCP.peer", p.Peer.String())),
+		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+	)
+
+	defer span.End()
+
+	var mt dhcpv4.MessageType
+	switch p.Pkt.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		mt = dhcpv4.MessageTypeOffer
+	case dhcpv4.MessageTypeRequest:
+		if !h.isBootServerRequest(p.Pkt, p.Md) {
+			// The client is requesting an address from the DHCP server, which is not for us to answer.
+			span.SetStatus(codes.Ok, "not a boot server request")
+			return
+		}
+		mt = dhcpv4.MessageTypeAck
+	default:
+		log.V(1).Info("ignoring message type", "type", p.Pkt.MessageType().String())
+		span.SetStatus(codes.Ok, "ignored message type")
+
+		return
+	}
+
+	r := h.reservation()
+	if err := r.IsNetbootClient(p.Pkt); err != nil {
+		log.V(1).Info("ignoring packet, not a netboot client", "reason", err.Error())
+		span.SetStatus(codes.Ok, "not a netboot client")
+
+		return
+	}
+	d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr, p.Md)
+	if err != nil {
+		if hardwareNotFound(err) {
+			span.SetStatus(codes.Ok, "no hardware found")
+			return
+		}
+		log.Info("error reading from backend", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	if !n.AllowNetboot {
+		// Not answering leaves the client to any other netboot infrastructure on the network.
+		log.V(1).Info("ignoring packet, netboot not allowed")
+		h.Metrics.NetbootDecision(metrics.NetbootNotAllowed)
+		span.SetStatus(codes.Ok, "netboot not allowed")
+
+		return
+	}
+	log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+
+	reply := h.replyMsg(ctx, r, p.Pkt, d, n, mt)
+	if reply == nil {
+		log.Info("unable to create reply")
+		span.SetStatus(codes.Error, "unable to create reply")
+
+		return
+	}
+	log = log.WithValues("type", mt.String(), "bootFileName", reply.BootFileName, "nextServer", reply.ServerIPAddr.String())
+
+	dst := replyDestination(p.Peer, p.Pkt.GatewayIPAddr)
+	log = log.WithValues("destination", dst.String())
+	cm := &ipv4.ControlMessage{}
+	if p.Md != nil {
+		cm.IfIndex = p.Md.IfIndex
+	}
+
+	always := h.AlwaysSend
+	if always == nil {
+		always = reservation.DefaultAlwaysSend
+	}
+	b, dropped := reservation.EncodeReply(p.Pkt, reply, always)
+	if len(dropped) > 0 {
+		log.Info("DHCP options left out of response, they don't fit in the maximum message size", "options", dropped)
+	}
+
+	if _, err := conn.WriteTo(b, cm, dst); err != nil {
+		log.Error(err, "failed to send proxyDHCP")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+
+	log.Info("sent proxyDHCP response")
+	h.Metrics.ReplySent(reply.MessageType().String())
+	h.Metrics.NetbootDecision(metrics.NetbootServed)
+	span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
+	span.SetStatus(codes.Ok, "sent proxyDHCP response")
+}
+
+// reservation returns a reservation.Handler with the Handler's netboot configuration,
+// used to build the network boot options.
+func (h *Handler) reservation() *reservation.Handler {
+	n := h.Netboot
+	n.Enabled = true
+
+	return &reservation.Handler{
+		Backend:     h.Backend,
+		IPAddr:      h.IPAddr,
+		Log:         h.Log,
+		Netboot:     n,
+		OTELEnabled: h.OTELEnabled,
+	}
+}
+
+// isBootServerRequest returns true if pkt is a PXE boot server request for this server.
+//
+// A netboot client sends a boot server request, after it has an IP address from the DHCP server,
+// to UDP port 4011 with its IP address in ciaddr. A request with ciaddr received on another port is a client
+// renewing its lease with the DHCP server.
+// A request with option 54 set to a different server is the client selecting an offer from that server.
+func (h *Handler) isBootServerRequest(pkt *dhcpv4.DHCPv4, md *data.Metadata) bool {
+	port := h.BootServerPort
+	if port == 0 {
+		port = BootServerPort
+	}
+	if md == nil || md.LocalPort != port {
+		return false
+	}
+	if sid := pkt.ServerIdentifier(); sid != nil && !sid.Equal(h.IPAddr.AsSlice()) {
+		return false
+	}
+
+	return !pkt.ClientIPAddr.IsUnspecified() && pkt.ClientIPAddr != nil
+}
+
+// readBackend encapsulates the backend read and opentelemetry handling.
+// When there is no reservation for mac and the backend implements handler.CircuitReader,
+// the reservation for the relay agent circuit in md is used.
+func (h *Handler) readBackend(ctx context.Context, mac net.HardwareAddr, md *data.Metadata) (*data.DHCP, *data.Netboot, error) {
+	h.setDefaults()
+
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "Hardware data get")
+	defer span.End()
+
+	d, n, err := h.Backend.GetByMac(ctx, mac)
+	if cr, ok := h.Backend.(handler.CircuitReader); ok && hardwareNotFound(err) && md != nil && md.CircuitID != "" {
+		span.SetAttributes(attribute.String("DHCP.circuitID", md.CircuitID), attribute.String("DHCP.remoteID", md.RemoteID))
+		d, n, err = cr.GetByCircuitID(ctx, md.CircuitID, md.RemoteID)
+	}
+	h.Metrics.HardwareLookup(metrics.Result(err))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	span.SetAttributes(n.EncodeToAttributes()...)
+	span.SetStatus(codes.Ok, "done reading from backend")
+
+	return d, n, nil
+}
+
+// replyMsg creates a proxyDHCP reply of type msgType for pkt.
+// yiaddr is never set, the client gets its IP address from the DHCP server.
+// d is the DHCP data of the client, it is only used for the network boot options.
+func (h *Handler) replyMsg(ctx context.Context, r *reservation.Handler, pkt *dhcpv4.DHCPv4, d *data.DHCP, n *data.Netboot, msgType dhcpv4.MessageType) *dhcpv4.DHCPv4 {
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(msgType),
+		dhcpv4.WithGeneric(dhcpv4.OptionServerIdentifier, h.IPAddr.AsSlice()),
+		dhcpv4.WithServerIP(h.IPAddr.AsSlice()),
+		// PXE clients ignore proxyDHCP offers that don't have option 60 set to PXEClient.
+		// HTTP clients get option 60 set to HTTPClient with the network boot options.
+		func(d *dhcpv4.DHCPv4) {
+			if strings.HasPrefix(string(pkt.GetOneOption(dhcpv4.OptionClassIdentifier)), pxeClient) {
+				d.UpdateOption(dhcpv4.OptClassIdentifier(pxeClient))
+			}
+		},
+		r.SetNetworkBootOpts(ctx, pkt, d, n),
+		// The client machine identifier must be mirrored back to the client.
+		dhcpv4.WithOptionCopied(pkt, dhcpv4.OptionClientMachineIdentifier),
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(pkt, mods...)
+	if err != nil {
+		return nil
+	}
+
+	return reply
+}
+
+// replyDestination determines the destination address for the DHCP reply.
+// If the giaddr is set, then the reply should be sent to the giaddr.
+// Otherwise, the reply should be sent to the direct peer.
+func replyDestination(directPeer net.Addr, giaddr net.IP) net.Addr {
+	if !giaddr.IsUnspecified() && giaddr != nil {
+		return &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
+	}
+
+	return directPeer
+}
+
+// encodeToAttributes takes a DHCP packet and returns opentelemetry key/value attributes.
+func (h *Handler) encodeToAttributes(d *dhcpv4.DHCPv4, namespace string) []attribute.KeyValue {
+	h.setDefaults()
+	a := &oteldhcp.Encoder{Log: h.Log}
+
+	return a.Encode(d, namespace, oteldhcp.AllEncoders()...)
+}
+
+// hardwareNotFound returns true if the error is from a hardware record not being found.
+func hardwareNotFound(err error) bool {
+	type hardwareNotFound interface {
+		NotFound() bool
+	}
+	var te hardwareNotFound
+
+	return errors.As(err, &te) && te.NotFound()
+}
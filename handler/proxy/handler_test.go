@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler/reservation"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/nettest"
+)
+
+type hwNotFoundError struct{}
+
+func (hwNotFoundError) NotFound() bool { return true }
+func (hwNotFoundError) Error() string  { return "not found" }
+
+type mockBackend struct {
+	err          error
+	allowNetboot bool
+	circuitID    string
+}
+
+func (m *mockBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	return &data.DHCP{}, &data.Netboot{AllowNetboot: m.allowNetboot}, nil
+}
+
+func (m *mockBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (m *mockBackend) GetByCircuitID(_ context.Context, circuitID, _ string) (*data.DHCP, *data.Netboot, error) {
+	if m.circuitID == "" || circuitID != m.circuitID {
+		return nil, nil, hwNotFoundError{}
+	}
+	return &data.DHCP{}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+// netbootRequest returns a DHCP message of type mt from a netboot client with option 60 set to class.
+func netbootRequest(mt dhcpv4.MessageType, class string, opts ...dhcpv4.Option) *dhcpv4.DHCPv4 {
+	return &dhcpv4.DHCPv4{
+		OpCode:       dhcpv4.OpcodeBootRequest,
+		ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+		Options: dhcpv4.OptionsFromList(append(opts,
+			dhcpv4.OptMessageType(mt),
+			dhcpv4.OptClassIdentifier(class),
+			dhcpv4.OptClientArch(iana.EFI_X86_64),
+			dhcpv4.OptGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{0x01, 0x03, 0x10}),
+			dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, []byte{0x00, 0x02, 0x03, 0x04, 0x05, 0x06, 0x00, 0x02, 0x03, 0x04, 0x05, 0x06, 0x00, 0x02, 0x03, 0x04, 0x05}),
+		)...),
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := map[string]struct {
+		backend      *mockBackend
+		req          *dhcpv4.DHCPv4
+		ciaddr       net.IP
+		port         int
+		circuitID    string
+		alwaysSend   []dhcpv4.OptionCode
+		wantType     dhcpv4.MessageType
+		wantOpt60    string
+		wantBootFile string
+		wantNone     bool
+	}{
+		"pxe discover": {
+			req:          netbootRequest(dhcpv4.MessageTypeDiscover, "PXEClient:Arch:00007:UNDI:003016"),
+			wantType:     dhcpv4.MessageTypeOffer,
+			wantOpt60:    "PXEClient",
+			wantBootFile: "ipxe.efi",
+		},
+		"always send options": {
+			req:          netbootRequest(dhcpv4.MessageTypeDiscover, "PXEClient:Arch:00007:UNDI:003016", dhcpv4.OptParameterRequestList(dhcpv4.OptionBootfileName)),
+			alwaysSend:   []dhcpv4.OptionCode{dhcpv4.OptionVendorSpecificInformation, dhcpv4.OptionClientMachineIdentifier},
+			wantType:     dhcpv4.MessageTypeOffer,
+			wantBootFile: "ipxe.efi",
+		},
+		"http discover": {
+			req:          netbootRequest(dhcpv4.MessageTypeDiscover, "HTTPClient:Arch:00016:UNDI:003016"),
+			wantType:     dhcpv4.MessageTypeOffer,
+			wantOpt60:    "HTTPClient",
+			wantBootFile: "http://127.0.0.1:8080/ipxe.efi",
+		},
+		"boot server request": {
+			req:          netbootRequest(dhcpv4.MessageTypeRequest, "PXEClient:Arch:00007:UNDI:003016"),
+			ciaddr:       net.IP{192, 168, 1, 100},
+			port:         BootServerPort,
+			wantType:     dhcpv4.MessageTypeAck,
+			wantOpt60:    "PXEClient",
+			wantBootFile: "ipxe.efi",
+		},
+		"boot server request from ipxe": {
+			req:          netbootRequest(dhcpv4.MessageTypeRequest, "PXEClient:Arch:00007:UNDI:003016", dhcpv4.OptUserClass("Tinkerbell")),
+			ciaddr:       net.IP{192, 168, 1, 100},
+			port:         BootServerPort,
+			wantType:     dhcpv4.MessageTypeAck,
+			wantOpt60:    "PXEClient",
+			wantBootFile: "http://127.0.0.1:8080/auto.ipxe",
+		},
+		"request without ciaddr is for the DHCP server": {
+			req:      netbootRequest(dhcpv4.MessageTypeRequest, "PXEClient:Arch:00007:UNDI:003016"),
+			port:     BootServerPort,
+			wantNone: true,
+		},
+		"renewal on the DHCP server port is for the DHCP server": {
+			req:      netbootRequest(dhcpv4.MessageTypeRequest, "PXEClient:Arch:00007:UNDI:003016"),
+			ciaddr:   net.IP{192, 168, 1, 100},
+			port:     dhcpv4.ServerPort,
+			wantNone: true,
+		},
+		"request for a different server": {
+			req:      netbootRequest(dhcpv4.MessageTypeRequest, "PXEClient:Arch:00007:UNDI:003016", dhcpv4.OptServerIdentifier(net.IP{127, 0, 0, 2})),
+			ciaddr:   net.IP{192, 168, 1, 100},
+			port:     BootServerPort,
+			wantNone: true,
+		},
+		"not a netboot client": {
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				Options:      dhcpv4.OptionsFromList(dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover)),
+			},
+			wantNone: true,
+		},
+		"release is ignored": {
+			req:      netbootRequest(dhcpv4.MessageTypeRelease, "PXEClient:Arch:00007:UNDI:003016"),
+			wantNone: true,
+		},
+		"netboot not allowed": {
+			backend:  &mockBackend{},
+			req:      netbootRequest(dhcpv4.MessageTypeDiscover, "PXEClient:Arch:00007:UNDI:003016"),
+			wantNone: true,
+		},
+		"hardware not found": {
+			backend:  &mockBackend{err: hwNotFoundError{}},
+			req:      netbootRequest(dhcpv4.MessageTypeDiscover, "PXEClient:Arch:00007:UNDI:003016"),
+			wantNone: true,
+		},
+		"hardware found by circuit": {
+			backend:      &mockBackend{err: hwNotFoundError{}, circuitID: "eth0/1"},
+			req:          netbootRequest(dhcpv4.MessageTypeDiscover, "PXEClient:Arch:00007:UNDI:003016"),
+			circuitID:    "eth0/1",
+			wantType:     dhcpv4.MessageTypeOffer,
+			wantOpt60:    "PXEClient",
+			wantBootFile: "ipxe.efi",
+		},
+		"backend error": {
+			backend:  &mockBackend{err: errors.New("backend down")},
+			req:      netbootRequest(dhcpv4.MessageTypeDiscover, "PXEClient:Arch:00007:UNDI:003016"),
+			wantNone: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{
+				Backend: &mockBackend{allowNetboot: true},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+				Netboot: reservation.Netboot{
+					IPXEBinServerTFTP: netip.MustParseAddrPort("127.0.0.1:69"),
+					IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "127.0.0.1:8080"},
+					IPXEScriptURL: func(*dhcpv4.DHCPv4) *url.URL {
+						return &url.URL{Scheme: "http", Host: "127.0.0.1:8080", Path: "auto.ipxe"}
+					},
+				},
+			}
+			if tt.backend != nil {
+				h.Backend = tt.backend
+			}
+			h.AlwaysSend = tt.alwaysSend
+			tt.req.ClientIPAddr = tt.ciaddr
+			conn, err := nettest.NewLocalPacketListener("udp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			pc, err := net.ListenPacket("udp4", ":0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			peer := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: pc.LocalAddr().(*net.UDPAddr).Port}
+
+			h.Handle(context.Background(), ipv4.NewPacketConn(conn), data.Packet{Peer: peer, Pkt: tt.req, Md: &data.Metadata{CircuitID: tt.circuitID, LocalPort: tt.port}})
+
+			got, err := client(pc)
+			if tt.wantNone {
+				if err == nil {
+					t.Fatalf("expected no response, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MessageType() != tt.wantType {
+				t.Fatalf("message type = %v, want %v", got.MessageType(), tt.wantType)
+			}
+			if !got.YourIPAddr.IsUnspecified() {
+				t.Fatalf("yiaddr must never be set, got %v", got.YourIPAddr)
+			}
+			if got.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+				t.Fatal("lease time must not be set")
+			}
+			if opt60 := got.ClassIdentifier(); opt60 != tt.wantOpt60 {
+				t.Fatalf("option 60 = %q, want %q", opt60, tt.wantOpt60)
+			}
+			if got.BootFileName != tt.wantBootFile {
+				t.Fatalf("boot file = %q, want %q", got.BootFileName, tt.wantBootFile)
+			}
+			if !got.ServerIdentifier().Equal(net.IP{127, 0, 0, 1}) {
+				t.Fatalf("server identifier = %v, want 127.0.0.1", got.ServerIdentifier())
+			}
+			if string(got.GetOneOption(dhcpv4.OptionClientMachineIdentifier)) != string(tt.req.GetOneOption(dhcpv4.OptionClientMachineIdentifier)) {
+				t.Fatal("expected option 97 to be mirrored back to the client")
+			}
+		})
+	}
+}
+
+func TestReplyDestination(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IP{192, 168, 1, 100}, Port: 4011}
+	if got := replyDestination(peer, nil); got != peer {
+		t.Fatalf("replyDestination() = %v, want %v", got, peer)
+	}
+	want := &net.UDPAddr{IP: net.IP{192, 168, 2, 1}, Port: 67}
+	if got := replyDestination(peer, net.IP{192, 168, 2, 1}); got.String() != want.String() {
+		t.Fatalf("replyDestination() = %v, want %v", got, want)
+	}
+}
+
+func client(pc net.PacketConn) (*dhcpv4.DHCPv4, error) {
+	buf := make([]byte, 1024)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return dhcpv4.FromBytes(buf[:n])
+}
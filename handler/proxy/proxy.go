@@ -0,0 +1,61 @@
+// Package proxy is the handler for responding to DHCPv4 messages as a proxyDHCP server.
+//
+// A proxyDHCP server only provides network boot options to netboot clients.
+// It never hands out IP addresses, so it can run alongside an existing DHCP server that owns address assignment.
+// See section 2.2.4 and 2.2.5 of http://www.pix.net/software/pxeboot/archive/pxespec.pdf.
+package proxy
+
+import (
+	"net/netip"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/handler/reservation"
+	"github.com/tinkerbell/dhcp/metrics"
+)
+
+// BootServerPort is the UDP port that netboot clients send PXE boot server requests to.
+const BootServerPort = 4011
+
+// Handler holds the configuration details for running a proxyDHCP server.
+//
+// The Handler answers DHCP discovers from netboot clients with a proxyDHCP offer, as received on UDP port 67,
+// and PXE boot server requests with an ack, as received on UDP port 4011.
+// The same Handler should be used for a listener on each of those ports.
+// Requests are only answered when they are received on the BootServerPort, see data.Metadata.LocalPort.
+type Handler struct {
+	// Backend is the backend to use for getting netboot data.
+	// Only clients with a record in the backend that allows netbooting are answered.
+	Backend handler.BackendReader
+
+	// IPAddr is the IP address to use in DHCP responses.
+	// Option 54 and the siaddr DHCP header.
+	// This could be a load balancer IP address or an ingress IP address or a local IP address.
+	IPAddr netip.Addr
+
+	// Log is used to log messages.
+	// `logr.Discard()` can be used if no logging is desired.
+	Log logr.Logger
+
+	// Netboot configuration.
+	// Enabled is ignored, netboot options are always sent.
+	Netboot reservation.Netboot
+
+	// OTELEnabled is used to determine if netboot options include otel naming.
+	// See reservation.Handler.OTELEnabled for details.
+	OTELEnabled bool
+
+	// Metrics records hardware lookups, replies sent and netboot decisions.
+	// When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
+
+	// BootServerPort is the UDP port that the Handler receives PXE boot server requests on.
+	// Requests received on any other port, like renewals on the DHCP server port, are for the DHCP server.
+	// Defaults to BootServerPort, 4011.
+	BootServerPort int
+
+	// AlwaysSend are the DHCP options sent to a client even when it doesn't request them in option 55.
+	// See reservation.Handler.AlwaysSend for details.
+	AlwaysSend []dhcpv4.OptionCode
+}
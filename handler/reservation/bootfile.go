@@ -0,0 +1,191 @@
+package reservation
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+// BootFileSelector chooses the iPXE binary that a network boot client is sent.
+type BootFileSelector interface {
+	// BootFile returns the boot file for r. It returns false when there is no boot file for r,
+	// then the client is not allowed to network boot.
+	BootFile(r BootRequest) (string, bool)
+}
+
+// BootRequest is what a BootFileSelector chooses a boot file from.
+type BootRequest struct {
+	// Packet is the DHCPv4 message of the client. It is nil for DHCPv6 clients.
+	Packet *dhcpv4.DHCPv4
+	// Packet6 is the DHCPv6 message of the client. It is nil for DHCPv4 clients.
+	Packet6 *dhcpv6.Message
+	// MAC is the MAC address of the client. It can be nil for DHCPv6 clients.
+	MAC net.HardwareAddr
+	// Arch is the architecture of the client, from DHCP option 93 or DHCPv6 option 61,
+	// or from the backend when the client's architecture has no boot file.
+	Arch iana.Arch
+	// UserClass is DHCP option 77, or the first DHCPv6 user class (option 15).
+	UserClass UserClass
+	// VendorClass is DHCP option 60, or the first DHCPv6 vendor class (option 16) data.
+	VendorClass string
+	// DHCP and Netboot are the data of the client from the backend. DHCP can be nil.
+	DHCP    *data.DHCP
+	Netboot *data.Netboot
+}
+
+// ArchBootFiles is a BootFileSelector that chooses the boot file by architecture only.
+type ArchBootFiles map[iana.Arch]string
+
+// BootFile returns the boot file for the architecture of r.
+func (a ArchBootFiles) BootFile(r BootRequest) (string, bool) {
+	f, ok := a[r.Arch]
+
+	return f, ok
+}
+
+// DefaultBootFiles returns the boot files used when Netboot.BootFileSelector is nil.
+// It maps supported hardware PXE architecture types to iPXE binary files.
+func DefaultBootFiles() ArchBootFiles {
+	return ArchBootFiles{
+		iana.INTEL_X86PC:       "undionly.kpxe",
+		iana.NEC_PC98:          "undionly.kpxe",
+		iana.EFI_ITANIUM:       "undionly.kpxe",
+		iana.DEC_ALPHA:         "undionly.kpxe",
+		iana.ARC_X86:           "undionly.kpxe",
+		iana.INTEL_LEAN_CLIENT: "undionly.kpxe",
+		iana.EFI_IA32:          "ipxe.efi",
+		iana.EFI_X86_64:        "ipxe.efi",
+		iana.EFI_XSCALE:        "ipxe.efi",
+		iana.EFI_BC:            "ipxe.efi",
+		iana.EFI_ARM32:         "snp.efi",
+		iana.EFI_ARM64:         "snp.efi",
+		iana.EFI_X86_HTTP:      "ipxe.efi",
+		iana.EFI_X86_64_HTTP:   "ipxe.efi",
+		iana.EFI_ARM32_HTTP:    "snp.efi",
+		iana.EFI_ARM64_HTTP:    "snp.efi",
+		iana.UBOOT_ARM32:       "snp.efi",
+		iana.UBOOT_ARM64:       "snp.efi",
+		iana.Arch(41):          "snp.efi", // arm rpiboot: https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml#processor-architecture
+	}
+}
+
+// defaultBootFiles is used when Netboot.BootFileSelector is nil. It must not be modified.
+var defaultBootFiles = DefaultBootFiles()
+
+// BootFileRule is a rule of a RuleSelector. A request matches a rule when it matches all of the rule's non-empty fields.
+type BootFileRule struct {
+	// Arch matches requests with one of these architectures.
+	Arch []iana.Arch
+	// VendorClass matches requests with a vendor class that starts with it, for example "HTTPClient".
+	VendorClass string
+	// UserClass matches requests with this user class.
+	UserClass UserClass
+	// OUI matches requests from a MAC address that starts with it, for example "b4:96:91".
+	// Case and separators don't matter, "B4-96-91" and "b49691" match the same MAC addresses.
+	OUI string
+	// Facility matches requests for machines with this facility in the backend.
+	Facility string
+	// BackendArch matches requests for machines with this arch in the backend, for example "aarch64".
+	BackendArch string
+
+	// BootFile is the boot file for requests that match the rule.
+	BootFile string
+}
+
+// RuleSelector is a BootFileSelector that chooses the boot file of the first rule that matches a request.
+// Requests that match no rule get the boot file from Default.
+type RuleSelector struct {
+	Rules []BootFileRule
+	// Default chooses the boot file for requests that match no rule.
+	// When nil, requests that match no rule get no boot file.
+	Default BootFileSelector
+}
+
+// BootFile returns the boot file of the first rule that matches r, or the boot file from Default.
+func (s RuleSelector) BootFile(r BootRequest) (string, bool) {
+	for _, rule := range s.Rules {
+		if rule.matches(r) {
+			return rule.BootFile, true
+		}
+	}
+	if s.Default == nil {
+		return "", false
+	}
+
+	return s.Default.BootFile(r)
+}
+
+// matches returns true if r matches all non-empty fields of the rule.
+func (b BootFileRule) matches(r BootRequest) bool {
+	if len(b.Arch) > 0 && !slices.Contains(b.Arch, r.Arch) {
+		return false
+	}
+	if b.VendorClass != "" && !strings.HasPrefix(r.VendorClass, b.VendorClass) {
+		return false
+	}
+	if b.UserClass != "" && r.UserClass != b.UserClass {
+		return false
+	}
+	if b.OUI != "" && !strings.HasPrefix(normalizeMAC(r.MAC.String()), normalizeMAC(b.OUI)) {
+		return false
+	}
+	if b.Facility != "" && (r.Netboot == nil || r.Netboot.Facility != b.Facility) {
+		return false
+	}
+	if b.BackendArch != "" && (r.DHCP == nil || r.DHCP.Arch != b.BackendArch) {
+		return false
+	}
+
+	return true
+}
+
+// normalizeMAC returns the lowercase hex digits of a MAC address or OUI, without separators.
+func normalizeMAC(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '-', '.':
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// bootFileSelector returns the BootFileSelector of the handler.
+func (h *Handler) bootFileSelector() BootFileSelector {
+	if h.Netboot.BootFileSelector != nil {
+		return h.Netboot.BootFileSelector
+	}
+
+	return defaultBootFiles
+}
+
+// bootFile returns the boot file for r from the BootFileSelector of the handler.
+// A boot file name in the netboot data of the backend overrides the selector.
+// When there is none for the architecture of the client, the arch from the backend is tried, see parseArch.
+// The returned BootRequest has the architecture that the boot file was chosen for.
+func (h *Handler) bootFile(r BootRequest) (string, BootRequest, bool) {
+	if r.Netboot != nil && r.Netboot.BootFileName != "" {
+		return r.Netboot.BootFileName, r, true
+	}
+	sel := h.bootFileSelector()
+	if f, ok := sel.BootFile(r); ok {
+		return f, r, true
+	}
+	if r.DHCP == nil {
+		return "", r, false
+	}
+	a, ok := parseArch(r.DHCP.Arch)
+	if !ok || a == r.Arch {
+		return "", r, false
+	}
+	r.Arch = a
+	f, ok := sel.BootFile(r)
+
+	return f, r, ok
+}
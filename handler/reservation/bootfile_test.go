@@ -0,0 +1,85 @@
+package reservation
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+func TestBootFile(t *testing.T) {
+	tests := map[string]struct {
+		r        BootRequest
+		want     string
+		wantArch iana.Arch
+		wantOK   bool
+	}{
+		"client arch":                 {r: BootRequest{Arch: iana.EFI_ARM64, DHCP: &data.DHCP{Arch: "x86_64"}}, want: "snp.efi", wantArch: iana.EFI_ARM64, wantOK: true},
+		"unknown arch, no backend":    {r: BootRequest{Arch: iana.Arch(255)}, wantArch: iana.Arch(255)},
+		"unknown arch, alias":         {r: BootRequest{Arch: iana.Arch(255), DHCP: &data.DHCP{Arch: "x86_64"}}, want: "ipxe.efi", wantArch: iana.EFI_X86_64, wantOK: true},
+		"unknown arch, number":        {r: BootRequest{Arch: iana.Arch(255), DHCP: &data.DHCP{Arch: "0"}}, want: "undionly.kpxe", wantArch: iana.INTEL_X86PC, wantOK: true},
+		"arch without boot file":      {r: BootRequest{Arch: iana.EFI_RISCV64, DHCP: &data.DHCP{Arch: "arm64"}}, want: "snp.efi", wantArch: iana.EFI_ARM64, wantOK: true},
+		"backend arch unknown":        {r: BootRequest{Arch: iana.EFI_RISCV64, DHCP: &data.DHCP{Arch: "riscv64"}}, wantArch: iana.EFI_RISCV64},
+		"backend arch same as client": {r: BootRequest{Arch: iana.EFI_RISCV64, DHCP: &data.DHCP{Arch: "27"}}, wantArch: iana.EFI_RISCV64},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, r, ok := (&Handler{}).bootFile(tt.r)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(r.Arch, tt.wantArch); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestRuleSelector(t *testing.T) {
+	s := RuleSelector{
+		Rules: []BootFileRule{
+			{Arch: []iana.Arch{iana.EFI_X86_64_HTTP}, VendorClass: "HTTPClient", BootFile: "ipxe-http.efi"},
+			{UserClass: Tinkerbell, BootFile: "tink.ipxe"},
+			{OUI: "B4:96:91", BootFile: "intel.efi"},
+			{Facility: "onprem", Arch: []iana.Arch{iana.EFI_X86_64}, BootFile: "onprem.efi"},
+			{BackendArch: "aarch64", BootFile: "arm.efi"},
+		},
+		Default: DefaultBootFiles(),
+	}
+	tests := map[string]struct {
+		sel    RuleSelector
+		r      BootRequest
+		want   string
+		wantOK bool
+	}{
+		"arch and vendor class":     {sel: s, r: BootRequest{Arch: iana.EFI_X86_64_HTTP, VendorClass: "HTTPClient:Arch:00016:UNDI:003001"}, want: "ipxe-http.efi", wantOK: true},
+		"arch without vendor class": {sel: s, r: BootRequest{Arch: iana.EFI_X86_64_HTTP}, want: "ipxe.efi", wantOK: true},
+		"user class":                {sel: s, r: BootRequest{Arch: iana.EFI_X86_64, UserClass: Tinkerbell}, want: "tink.ipxe", wantOK: true},
+		"oui":                       {sel: s, r: BootRequest{Arch: iana.EFI_X86_64, MAC: net.HardwareAddr{0xb4, 0x96, 0x91, 0x6f, 0x33, 0xd0}}, want: "intel.efi", wantOK: true},
+		"oui with dashes":           {sel: RuleSelector{Rules: []BootFileRule{{OUI: "aa-bb-cc", BootFile: "dash.efi"}}}, r: BootRequest{MAC: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x01, 0x02, 0x03}}, want: "dash.efi", wantOK: true},
+		"oui with mixed case":       {sel: RuleSelector{Rules: []BootFileRule{{OUI: "Aa:bB:Cc", BootFile: "mixed.efi"}}}, r: BootRequest{MAC: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x01, 0x02, 0x03}}, want: "mixed.efi", wantOK: true},
+		"oui with dashes and case":  {sel: RuleSelector{Rules: []BootFileRule{{OUI: "AA-BB-CC", BootFile: "dash.efi"}}}, r: BootRequest{MAC: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x01, 0x02, 0x03}}, want: "dash.efi", wantOK: true},
+		"other oui":                 {sel: RuleSelector{Rules: []BootFileRule{{OUI: "AA-BB-CD", BootFile: "dash.efi"}}}, r: BootRequest{MAC: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x01, 0x02, 0x03}}},
+		"facility":                  {sel: s, r: BootRequest{Arch: iana.EFI_X86_64, Netboot: &data.Netboot{Facility: "onprem"}}, want: "onprem.efi", wantOK: true},
+		"facility, other arch":      {sel: s, r: BootRequest{Arch: iana.INTEL_X86PC, Netboot: &data.Netboot{Facility: "onprem"}}, want: "undionly.kpxe", wantOK: true},
+		"backend arch":              {sel: s, r: BootRequest{Arch: iana.EFI_X86_64, DHCP: &data.DHCP{Arch: "aarch64"}}, want: "arm.efi", wantOK: true},
+		"default":                   {sel: s, r: BootRequest{Arch: iana.EFI_ARM64}, want: "snp.efi", wantOK: true},
+		"no default":                {sel: RuleSelector{Rules: s.Rules}, r: BootRequest{Arch: iana.EFI_ARM64}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := tt.sel.BootFile(tt.r)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/tinkerbell/dhcp/backend/noop"
 	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/metrics"
 	oteldhcp "github.com/tinkerbell/dhcp/otel"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -71,9 +74,12 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 	defer span.End()
 
 	var reply *dhcpv4.DHCPv4
+	var bound *data.Lease
+	var dst net.Addr
+	var host *data.DHCP // the backend data the reply is built from.
 	switch mt := p.Pkt.MessageType(); mt {
 	case dhcpv4.MessageTypeDiscover:
-		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr)
+		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr, p.Md)
 		if err != nil {
 			if hardwareNotFound(err) {
 				span.SetStatus(codes.Ok, "no reservation found")
@@ -86,9 +92,17 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		}
 		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
 		reply = h.updateMsg(ctx, p.Pkt, d, n, dhcpv4.MessageTypeOffer)
+		host = d
 		log = log.WithValues("type", dhcpv4.MessageTypeOffer.String())
 	case dhcpv4.MessageTypeRequest:
-		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr)
+		if sid := p.Pkt.ServerIdentifier(); sid != nil && !sid.Equal(h.IPAddr.AsSlice()) {
+			// The client selected an offer from a different server.
+			log.V(1).Info("received DHCP request for a different server, no response required", "type", p.Pkt.MessageType().String(), "serverIdentifier", sid.String())
+			span.SetStatus(codes.Ok, "request for a different server")
+
+			return
+		}
+		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr, p.Md)
 		if err != nil {
 			if hardwareNotFound(err) {
 				span.SetStatus(codes.Ok, "no reservation found")
@@ -100,16 +114,69 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 			return
 		}
 		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+		if ip := requestedAddr(p.Pkt); ip.IsValid() && ip != d.IPAddress.Unmap() {
+			// The client asked for an address that is not its reservation,
+			// it must restart the DHCP exchange to get the reserved address.
+			reason := fmt.Sprintf("requested address %v is not the reserved address", ip)
+			if !d.IPAddress.IsValid() {
+				// the backend has no address for the client, like a pool that can't give it the requested address.
+				reason = fmt.Sprintf("requested address %v is not available", ip)
+			}
+			log.Info("sending NAK", "reason", reason, "requestedIPAddress", ip.String(), "reservedIPAddress", d.IPAddress.String())
+			span.AddEvent("requested address not reserved", trace.WithAttributes(
+				attribute.String("DHCP.requestedIPAddress", ip.String()),
+				attribute.String("DHCP.reservedIPAddress", d.IPAddress.String()),
+			))
+			reply = h.nakMsg(p.Pkt, reason)
+			dst = nakDestination(p.Pkt.GatewayIPAddr)
+			log = log.WithValues("type", dhcpv4.MessageTypeNak.String())
+
+			break
+		}
 		reply = h.updateMsg(ctx, p.Pkt, d, n, dhcpv4.MessageTypeAck)
+		host = d
+		log = log.WithValues("type", dhcpv4.MessageTypeAck.String())
+		bound = boundLease(p.Pkt, d)
+	case dhcpv4.MessageTypeInform:
+		// The client already has an address and only wants the other configuration options.
+		// See https://www.rfc-editor.org/rfc/rfc2131#section-4.3.5.
+		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr, p.Md)
+		if err != nil {
+			if hardwareNotFound(err) {
+				span.SetStatus(codes.Ok, "no reservation found")
+				return
+			}
+			log.Info("error reading from backend", "error", err)
+			span.SetStatus(codes.Error, err.Error())
+
+			return
+		}
+		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+		reply = h.informMsg(ctx, p.Pkt, d, n)
+		host = d
 		log = log.WithValues("type", dhcpv4.MessageTypeAck.String())
 	case dhcpv4.MessageTypeRelease:
 		// Since the design of this DHCP server is that all IP addresses are
 		// Host reservations, when a client releases an address, the server
-		// doesn't have anything to do. This case is included for clarity of this
+		// doesn't have anything to do other than recording it. This case is included for clarity of this
 		// design decision.
 		log.Info("received DHCP release packet, no response required, all IPs are host reservations", "type", p.Pkt.MessageType().String())
+		h.putLease(ctx, log, endedLease(p.Pkt, p.Pkt.ClientIPAddr, data.LeaseStateReleased))
 		span.SetStatus(codes.Ok, "received release, no response required")
 
+		return
+	case dhcpv4.MessageTypeDecline:
+		// The client detected that the address is already in use by another host on the network.
+		// This means there is an address conflict that needs to be resolved outside of DHCP.
+		ip := p.Pkt.RequestedIPAddress()
+		log.Info("received DHCP decline packet, address conflict detected, no response required", "type", p.Pkt.MessageType().String(), "ipAddress", ip.String(), "message", p.Pkt.Message())
+		span.AddEvent("address conflict", trace.WithAttributes(
+			attribute.String("DHCP.ipAddress", ip.String()),
+			attribute.String("DHCP.mac", p.Pkt.ClientHWAddr.String()),
+		))
+		h.putLease(ctx, log, endedLease(p.Pkt, ip, data.LeaseStateDeclined))
+		span.SetStatus(codes.Ok, "received decline, no response required")
+
 		return
 	default:
 		log.Info("received unknown message type", "type", p.Pkt.MessageType().String())
@@ -125,14 +192,25 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		log = log.WithValues("nextServer", ns.String())
 	}
 
-	dst := replyDestination(p.Peer, p.Pkt.GatewayIPAddr)
+	if dst == nil {
+		dst = replyDestination(p.Peer, p.Pkt.GatewayIPAddr)
+	}
 	log = log.WithValues("ipAddress", reply.YourIPAddr.String(), "destination", dst.String())
 	cm := &ipv4.ControlMessage{}
 	if p.Md != nil {
 		cm.IfIndex = p.Md.IfIndex
 	}
 
-	if _, err := conn.WriteTo(reply.ToBytes(), cm, dst); err != nil {
+	always := h.AlwaysSend
+	if always == nil {
+		always = DefaultAlwaysSend
+	}
+	b, dropped := EncodeReply(p.Pkt, reply, withCustomOptions(always, host))
+	if len(dropped) > 0 {
+		log.Info("DHCP options left out of response, they don't fit in the maximum message size", "options", dropped)
+	}
+
+	if _, err := conn.WriteTo(b, cm, dst); err != nil {
 		log.Error(err, "failed to send DHCP")
 		span.SetStatus(codes.Error, err.Error())
 
@@ -140,6 +218,10 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 	}
 
 	log.Info("sent DHCP response")
+	h.Metrics.ReplySent(reply.MessageType().String())
+	if bound != nil {
+		h.putLease(ctx, log, bound)
+	}
 	span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
 	span.SetStatus(codes.Ok, "sent DHCP response")
 }
@@ -160,8 +242,39 @@ func replyDestination(directPeer net.Addr, giaddr net.IP) net.Addr {
 	return directPeer
 }
 
+// nakDestination determines the destination address for a DHCP NAK.
+// If the giaddr is set, then the NAK is sent to the giaddr.
+// Otherwise, the NAK is broadcast, as the client might not have a usable address.
+//
+// From page 32 of https://www.ietf.org/rfc/rfc2131.txt:
+// "If 'giaddr' is 0x0 in the DHCPREQUEST message, the server broadcasts
+// the DHCPNAK message to 0xffffffff.".
+func nakDestination(giaddr net.IP) net.Addr {
+	if !giaddr.IsUnspecified() && giaddr != nil {
+		return &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
+	}
+
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+}
+
+// requestedAddr returns the address a client asks for in a DHCP request.
+// This is option 50 in the SELECTING and INIT-REBOOT states or
+// ciaddr in the RENEWING and REBINDING states.
+func requestedAddr(pkt *dhcpv4.DHCPv4) netip.Addr {
+	if ip, ok := netip.AddrFromSlice(pkt.RequestedIPAddress().To4()); ok && !ip.IsUnspecified() {
+		return ip
+	}
+	if ip, ok := netip.AddrFromSlice(pkt.ClientIPAddr.To4()); ok && !ip.IsUnspecified() {
+		return ip
+	}
+
+	return netip.Addr{}
+}
+
 // readBackend encapsulates the backend read and opentelemetry handling.
-func (h *Handler) readBackend(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+// When there is no reservation for mac and the backend implements handler.CircuitReader,
+// the reservation for the relay agent circuit in md is used.
+func (h *Handler) readBackend(ctx context.Context, mac net.HardwareAddr, md *data.Metadata) (*data.DHCP, *data.Netboot, error) {
 	h.setDefaults()
 
 	tracer := otel.Tracer(tracerName)
@@ -169,6 +282,11 @@ func (h *Handler) readBackend(ctx context.Context, mac net.HardwareAddr) (*data.
 	defer span.End()
 
 	d, n, err := h.Backend.GetByMac(ctx, mac)
+	if cr, ok := h.Backend.(handler.CircuitReader); ok && hardwareNotFound(err) && md != nil && md.CircuitID != "" {
+		span.SetAttributes(attribute.String("DHCP.circuitID", md.CircuitID), attribute.String("DHCP.remoteID", md.RemoteID))
+		d, n, err = cr.GetByCircuitID(ctx, md.CircuitID, md.RemoteID)
+	}
+	h.Metrics.HardwareLookup(metrics.Result(err))
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
 
@@ -192,8 +310,55 @@ func (h *Handler) updateMsg(ctx context.Context, pkt *dhcpv4.DHCPv4, d *data.DHC
 	}
 	mods = append(mods, h.setDHCPOpts(ctx, pkt, d)...)
 
-	if h.Netboot.Enabled && h.isNetbootClient(pkt) == nil {
-		mods = append(mods, h.setNetworkBootOpts(ctx, pkt, n))
+	if v, ok := h.isSwitchClient(pkt); ok {
+		mods = append(mods, h.setSwitchOpts(v, n))
+		if n.AllowNetboot {
+			h.Metrics.NetbootDecision(metrics.NetbootServed)
+		} else {
+			h.Metrics.NetbootDecision(metrics.NetbootNotAllowed)
+		}
+	} else if h.Netboot.Enabled && h.isNetbootClient(pkt, d) == nil {
+		mods = append(mods, h.SetNetworkBootOpts(ctx, pkt, d, n))
+		if n.AllowNetboot {
+			h.Metrics.NetbootDecision(metrics.NetbootServed)
+		} else {
+			h.Metrics.NetbootDecision(metrics.NetbootNotAllowed)
+		}
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(pkt, mods...)
+	if err != nil {
+		return nil
+	}
+
+	return reply
+}
+
+// informMsg handles creating a DHCP ACK for a DHCP inform with the data from the backend.
+// The client already has an address, so yiaddr and the lease time are not set.
+//
+// See https://www.rfc-editor.org/rfc/rfc2131#section-4.3.5.
+func (h *Handler) informMsg(ctx context.Context, pkt *dhcpv4.DHCPv4, d *data.DHCP, n *data.Netboot) *dhcpv4.DHCPv4 {
+	reply := h.updateMsg(ctx, pkt, d, n, dhcpv4.MessageTypeAck)
+	if reply == nil {
+		return nil
+	}
+	reply.YourIPAddr = net.IPv4zero
+	reply.ClientIPAddr = pkt.ClientIPAddr
+	reply.Options.Del(dhcpv4.OptionIPAddressLeaseTime)
+
+	return reply
+}
+
+// nakMsg creates a DHCP NAK for a DHCP request, with reason in option 56.
+func (h *Handler) nakMsg(pkt *dhcpv4.DHCPv4, reason string) *dhcpv4.DHCPv4 {
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeNak),
+		dhcpv4.WithGeneric(dhcpv4.OptionServerIdentifier, h.IPAddr.AsSlice()),
+		dhcpv4.WithOption(dhcpv4.OptMessage(reason)),
+	}
+	if !pkt.GatewayIPAddr.IsUnspecified() && pkt.GatewayIPAddr != nil {
+		// the relay agent must broadcast the NAK to the client.
+		mods = append(mods, dhcpv4.WithBroadcast(true))
 	}
 	reply, err := dhcpv4.NewReplyFromRequest(pkt, mods...)
 	if err != nil {
@@ -203,19 +368,26 @@ func (h *Handler) updateMsg(ctx context.Context, pkt *dhcpv4.DHCPv4, d *data.DHC
 	return reply
 }
 
-// isNetbootClient returns true if the client is a valid netboot client.
+// IsNetbootClient returns an error if the client is not a valid netboot client.
 //
 // A valid netboot client will have the following in its DHCP request:
 // 1. is a DHCP discovery/request message type.
 // 2. option 93 is set.
 // 3. option 94 is set.
 // 4. option 97 is correct length.
-// 5. option 60 is set with this format: "PXEClient:Arch:xxxxx:UNDI:yyyzzz" or "HTTPClient:Arch:xxxxx:UNDI:yyyzzz".
+// 5. option 60 is set with this format: "PXEClient:Arch:xxxxx:UNDI:yyyzzz" or "HTTPClient:Arch:xxxxx:UNDI:yyyzzz",
+// or, for U-Boot clients, "U-Boot.armv8" or similar.
 //
 // See: http://www.pix.net/software/pxeboot/archive/pxespec.pdf
 //
 // See: https://www.rfc-editor.org/rfc/rfc4578.html
-func (h *Handler) isNetbootClient(pkt *dhcpv4.DHCPv4) error {
+func (h *Handler) IsNetbootClient(pkt *dhcpv4.DHCPv4) error {
+	return h.isNetbootClient(pkt, nil)
+}
+
+// isNetbootClient is IsNetbootClient for a client with DHCP data d from the backend.
+// Option 93 is not required when the Arch of d is known, it is used to choose the boot file instead.
+func (h *Handler) isNetbootClient(pkt *dhcpv4.DHCPv4, d *data.DHCP) error {
 	h.setDefaults()
 	var err error
 	// only response to DISCOVER and REQUEST packets
@@ -228,15 +400,15 @@ func (h *Handler) isNetbootClient(pkt *dhcpv4.DHCPv4) error {
 		// h.Log.Info("not a netboot client", "reason", "option 60 not set", "mac", pkt.ClientHWAddr.String())
 		err = fmt.Errorf("%w: option 60 not set", err)
 	}
-	// option 60 must start with PXEClient or HTTPClient
+	// option 60 must start with PXEClient, HTTPClient or U-Boot
 	opt60 := pkt.GetOneOption(dhcpv4.OptionClassIdentifier)
-	if !strings.HasPrefix(string(opt60), string(pxeClient)) && !strings.HasPrefix(string(opt60), string(httpClient)) {
-		// h.Log.Info("not a netboot client", "reason", "option 60 not PXEClient or HTTPClient", "mac", pkt.ClientHWAddr.String(), "option 60", string(opt60))
-		err = fmt.Errorf("%w: option 60 not PXEClient or HTTPClient", err)
+	if !strings.HasPrefix(string(opt60), string(pxeClient)) && !strings.HasPrefix(string(opt60), string(httpClient)) && !strings.HasPrefix(string(opt60), string(uBootClient)) {
+		// h.Log.Info("not a netboot client", "reason", "option 60 not PXEClient, HTTPClient or U-Boot", "mac", pkt.ClientHWAddr.String(), "option 60", string(opt60))
+		err = fmt.Errorf("%w: option 60 not PXEClient, HTTPClient or U-Boot", err)
 	}
 
-	// option 93 must be set
-	if !pkt.Options.Has(dhcpv4.OptionClientSystemArchitectureType) {
+	// option 93 must be set, unless the backend has the arch of the client
+	if !pkt.Options.Has(dhcpv4.OptionClientSystemArchitectureType) && (d == nil || !h.knownArch(d)) {
 		// h.Log.Info("not a netboot client", "reason", "option 93 not set", "mac", pkt.ClientHWAddr.String())
 		err = fmt.Errorf("%w: option 93 not set", err)
 	}
@@ -282,6 +454,7 @@ func hardwareNotFound(err error) bool {
 	type hardwareNotFound interface {
 		NotFound() bool
 	}
-	te, ok := err.(hardwareNotFound)
-	return ok && te.NotFound()
+	var te hardwareNotFound
+
+	return errors.As(err, &te) && te.NotFound()
 }
@@ -0,0 +1,474 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/ipv6"
+)
+
+// enterpriseTianoCore is the IANA enterprise number that UEFI HTTP boot clients use in the
+// DHCPv6 vendor class option (16). See section 24.7.2 of the UEFI specification.
+const enterpriseTianoCore = 343
+
+// errNoClientHardwareAddr is returned when a DHCPv6 client has no reservation for its DUID
+// and no MAC address can be derived from the DUID or the relay agent.
+var errNoClientHardwareAddr = noClientHardwareAddrError{}
+
+// noClientHardwareAddrError implements the NotFound() method, a client without a MAC address can't have a reservation.
+type noClientHardwareAddrError struct{}
+
+func (noClientHardwareAddrError) NotFound() bool { return true }
+
+func (noClientHardwareAddrError) Error() string { return "no MAC address for client" }
+
+// Handle6 responds to DHCPv6 messages with DHCPv6 server options.
+//
+// SOLICIT is answered with an ADVERTISE, or a REPLY when the client asks for rapid commit (option 14).
+// REQUEST, RENEW, REBIND and INFORMATION-REQUEST are answered with a REPLY.
+// RELEASE and DECLINE are acknowledged with a REPLY, addresses are host reservations so there is nothing else to do.
+// Clients are looked up by DUID, see handler.DUIDReader, and then by MAC address.
+func (h *Handler) Handle6(ctx context.Context, conn *ipv6.PacketConn, p data.Packet6) {
+	h.setDefaults()
+	if p.Pkt == nil {
+		h.Log.Error(errors.New("incoming packet is nil"), "not able to respond when the incoming packet is nil")
+		return
+	}
+	if p.Peer == nil {
+		h.Log.Error(errors.New("peer is nil"), "not able to respond when the peer is nil")
+		return
+	}
+	if conn == nil {
+		h.Log.Error(errors.New("connection is nil"), "not able to respond when the connection is nil")
+		return
+	}
+	if h.DUID == nil {
+		h.Log.Error(errors.New("server DUID is nil"), "not able to respond to DHCPv6 messages without a server DUID")
+		return
+	}
+	msg, err := p.Pkt.GetInnerMessage()
+	if err != nil {
+		h.Log.Error(err, "not able to respond when the relay message has no inner message")
+		return
+	}
+
+	var ifName string
+	if p.Md != nil {
+		ifName = p.Md.IfName
+	}
+	log := h.Log.WithValues("duid", duidString(msg.Options.ClientID()), "xid", msg.TransactionID.String(), "interface", ifName)
+	tracer := otel.Tracer(tracerName)
+	var span trace.Span
+	ctx, span = tracer.Start(
+		ctx,
+		fmt.Sprintf("DHCPv6 Packet Received: %v", msg.Type().String()),
+		trace.WithAttributes(encodeToAttributes6(msg, "request")...),
+		trace.WithAttributes(attribute.String("DHCP.peer", p.Peer.String())),
+		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+	)
+
+	defer span.End()
+
+	switch mt := msg.Type(); mt {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRebind:
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline, dhcpv6.MessageTypeInformationRequest:
+		// A server identifier is required, except in an information-request where it is optional.
+		sid := msg.Options.ServerID()
+		if sid == nil && mt == dhcpv6.MessageTypeInformationRequest {
+			break
+		}
+		if sid == nil || !sid.Equal(h.DUID) {
+			// The client selected a different server.
+			log.V(1).Info("received DHCPv6 message for a different server, no response required", "type", mt.String(), "serverID", duidString(sid))
+			span.SetStatus(codes.Ok, "message for a different server")
+
+			return
+		}
+	default:
+		log.Info("received unknown message type", "type", mt.String())
+		span.SetStatus(codes.Error, "received unknown message type")
+
+		return
+	}
+
+	d, n, err := h.readBackend6(ctx, p.Pkt, p.Md)
+	if err != nil {
+		if hardwareNotFound(err) {
+			span.SetStatus(codes.Ok, "no reservation found")
+			return
+		}
+		log.Info("error reading from backend", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	log.Info("received DHCPv6 packet", "type", msg.Type().String())
+
+	reply, err := h.replyMsg6(ctx, msg, d, n)
+	if err != nil {
+		log.Error(err, "unable to create reply")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	log = log.WithValues("type", reply.Type().String())
+	if bf := reply.Options.BootFileURL(); bf != "" {
+		log = log.WithValues("bootFileURL", bf)
+	}
+	if d.IPv6Address.IsValid() {
+		log = log.WithValues("ipAddress", d.IPv6Address.String())
+	}
+
+	var out dhcpv6.DHCPv6 = reply
+	if relay, ok := p.Pkt.(*dhcpv6.RelayMessage); ok {
+		if out, err = dhcpv6.NewRelayReplFromRelayForw(relay, reply); err != nil {
+			log.Error(err, "unable to create relay reply")
+			span.SetStatus(codes.Error, err.Error())
+
+			return
+		}
+	}
+	log = log.WithValues("destination", p.Peer.String())
+	cm := &ipv6.ControlMessage{}
+	if p.Md != nil {
+		cm.IfIndex = p.Md.IfIndex
+	}
+
+	if _, err := conn.WriteTo(out.ToBytes(), cm, p.Peer); err != nil {
+		log.Error(err, "failed to send DHCPv6")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+
+	log.Info("sent DHCPv6 response")
+	h.Metrics.ReplySent(reply.Type().String())
+	span.SetAttributes(encodeToAttributes6(reply, "reply")...)
+	span.SetStatus(codes.Ok, "sent DHCPv6 response")
+}
+
+// readBackend6 encapsulates the backend read and opentelemetry handling for a DHCPv6 message.
+// The client is looked up by its DUID when the backend implements handler.DUIDReader,
+// then by the MAC address from its DUID or the relay agent,
+// and then by the relay agent circuit in md when the backend implements handler.CircuitReader.
+func (h *Handler) readBackend6(ctx context.Context, pkt dhcpv6.DHCPv6, md *data.Metadata) (*data.DHCP, *data.Netboot, error) {
+	h.setDefaults()
+
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "Hardware data get")
+	defer span.End()
+
+	msg, err := pkt.GetInnerMessage()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	duid := msg.Options.ClientID()
+	if duid == nil {
+		err := errors.New("no client identifier")
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	var d *data.DHCP
+	var n *data.Netboot
+	err = errNoClientHardwareAddr
+	if dr, ok := h.Backend.(handler.DUIDReader); ok {
+		d, n, err = dr.GetByDUID(ctx, duid.ToBytes())
+	}
+	if mac := clientHardwareAddr6(pkt); hardwareNotFound(err) && mac != nil {
+		span.SetAttributes(attribute.String("DHCP.mac", mac.String()))
+		d, n, err = h.Backend.GetByMac(ctx, mac)
+	}
+	if cr, ok := h.Backend.(handler.CircuitReader); ok && hardwareNotFound(err) && md != nil && md.CircuitID != "" {
+		span.SetAttributes(attribute.String("DHCP.circuitID", md.CircuitID), attribute.String("DHCP.remoteID", md.RemoteID))
+		d, n, err = cr.GetByCircuitID(ctx, md.CircuitID, md.RemoteID)
+	}
+	h.Metrics.HardwareLookup(metrics.Result(err))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	span.SetAttributes(d.EncodeToAttributes()...)
+	span.SetAttributes(n.EncodeToAttributes()...)
+	span.SetStatus(codes.Ok, "done reading from backend")
+
+	return d, n, nil
+}
+
+// replyMsg6 creates the reply to msg with the data from the backend.
+func (h *Handler) replyMsg6(ctx context.Context, msg *dhcpv6.Message, d *data.DHCP, n *data.Netboot) (*dhcpv6.Message, error) {
+	mods := []dhcpv6.Modifier{dhcpv6.WithServerID(h.DUID)}
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRelease:
+		mods = append(mods, dhcpv6.WithOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess}))
+
+		return dhcpv6.NewReplyFromMessage(msg, mods...)
+	case dhcpv6.MessageTypeDecline:
+		mods = append(mods, dhcpv6.WithOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess}))
+
+		return replyFromDecline(msg, mods...)
+	case dhcpv6.MessageTypeInformationRequest:
+	default:
+		mods = append(mods, withIANA(msg, d))
+	}
+	mods = append(mods, h.setDHCPOpts6(d)...)
+	if h.Netboot.Enabled && h.IsNetbootClient6(msg) == nil {
+		mods = append(mods, h.setNetworkBootOpts6(ctx, msg, d, n))
+		if n.AllowNetboot {
+			h.Metrics.NetbootDecision(metrics.NetbootServed)
+		} else {
+			h.Metrics.NetbootDecision(metrics.NetbootNotAllowed)
+		}
+	}
+
+	if msg.Type() == dhcpv6.MessageTypeSolicit && msg.GetOneOption(dhcpv6.OptionRapidCommit) == nil {
+		return dhcpv6.NewAdvertiseFromSolicit(msg, mods...)
+	}
+
+	return dhcpv6.NewReplyFromMessage(msg, mods...)
+}
+
+// replyFromDecline creates the REPLY to a DECLINE, see RFC 8415 section 18.3.8.
+// dhcpv6.NewReplyFromMessage doesn't create replies to a DECLINE.
+func replyFromDecline(msg *dhcpv6.Message, mods ...dhcpv6.Modifier) (*dhcpv6.Message, error) {
+	cid := msg.Options.ClientID()
+	if cid == nil {
+		return nil, errors.New("client ID cannot be nil when building REPLY")
+	}
+	reply, err := dhcpv6.NewMessage(append([]dhcpv6.Modifier{dhcpv6.WithClientID(cid)}, mods...)...)
+	if err != nil {
+		return nil, err
+	}
+	reply.MessageType = dhcpv6.MessageTypeReply
+	reply.TransactionID = msg.TransactionID
+
+	return reply, nil
+}
+
+// withIANA returns a modifier that adds an IA_NA (option 3), with the IAID of the client's IA_NA, for the reserved address.
+// No IA_NA is added when the client's message has none, like a client that only asks for prefixes or other configuration.
+// The IA_NA has the NoAddrsAvail status when there is no reserved IPv6 address.
+// The lease time is used as the preferred and valid lifetime, T1 and T2 are 50% and 80% of it.
+func withIANA(msg *dhcpv6.Message, d *data.DHCP) dhcpv6.Modifier {
+	return func(reply dhcpv6.DHCPv6) {
+		c := msg.Options.OneIANA()
+		if c == nil {
+			return
+		}
+		ia := &dhcpv6.OptIANA{IaId: c.IaId}
+		if !d.IPv6Address.IsValid() {
+			ia.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail, StatusMessage: "no IPv6 address reserved"})
+			reply.AddOption(ia)
+
+			return
+		}
+		lt := time.Duration(d.LeaseTime) * time.Second
+		ia.T1 = lt / 2
+		ia.T2 = lt * 4 / 5
+		ia.Options.Add(&dhcpv6.OptIAAddress{
+			IPv6Addr:          d.IPv6Address.AsSlice(),
+			PreferredLifetime: lt,
+			ValidLifetime:     lt,
+		})
+		reply.AddOption(ia)
+	}
+}
+
+// setDHCPOpts6 takes data (typically from a backend) and creates a slice of DHCPv6 packet modifiers.
+func (h *Handler) setDHCPOpts6(d *data.DHCP) []dhcpv6.Modifier {
+	var mods []dhcpv6.Modifier
+	if len(d.IPv6NameServers) > 0 {
+		mods = append(mods, dhcpv6.WithDNS(d.IPv6NameServers...))
+	}
+	if len(d.DomainSearch) > 0 {
+		mods = append(mods, dhcpv6.WithDomainSearchList(d.DomainSearch...))
+	}
+
+	return mods
+}
+
+// setNetworkBootOpts6 returns a modifier that sets the boot file URL (option 59).
+// DHCPv6 netboot clients boot over HTTP, so the boot file URL is either the iPXE binary from the iPXE binary HTTP server
+// or, for clients already running our iPXE, the iPXE script.
+// The vendor class (option 16) is set to HTTPClient when the client sent it, UEFI HTTP boot clients require it.
+func (h *Handler) setNetworkBootOpts6(ctx context.Context, m *dhcpv6.Message, d *data.DHCP, n *data.Netboot) dhcpv6.Modifier {
+	return func(reply dhcpv6.DHCPv6) {
+		if isHTTPClient6(m) {
+			reply.AddOption(&dhcpv6.OptVendorClass{EnterpriseNumber: enterpriseTianoCore, Data: [][]byte{[]byte(httpClient)}})
+		}
+		bootfile := "/netboot-not-allowed"
+		defer func() {
+			reply.AddOption(dhcpv6.OptBootFileURL(bootfile))
+		}()
+		if !n.AllowNetboot {
+			return
+		}
+		var uClass UserClass
+		if uc := m.Options.UserClasses(); len(uc) > 0 {
+			uClass = UserClass(uc[0])
+		}
+		var vClass string
+		if vc := m.Options.VendorClasses(); len(vc) > 0 && len(vc[0].Data) > 0 {
+			vClass = string(vc[0].Data[0])
+		}
+		bin, br, found := h.bootFile(BootRequest{
+			Packet6:     m,
+			MAC:         clientHardwareAddr6(m),
+			Arch:        arch6(m),
+			UserClass:   uClass,
+			VendorClass: vClass,
+			DHCP:        d,
+			Netboot:     n,
+		})
+		if !found {
+			h.Log.Error(fmt.Errorf("unable to find bootfile for arch"), "network boot not allowed", "arch", br.Arch, "archInt", int(br.Arch), "duid", duidString(m.Options.ClientID()))
+			return
+		}
+		_, httpBin := h.bootServers(n)
+		if n.DirectBoot {
+			if httpBin == nil {
+				h.Log.Error(fmt.Errorf("no boot file HTTP server"), "network boot not allowed", "duid", duidString(m.Options.ClientID()))
+				return
+			}
+			bootfile = httpBin.JoinPath(bin).String()
+			return
+		}
+		inIPXE := uClass == Tinkerbell || (h.Netboot.UserClass != "" && uClass == h.Netboot.UserClass)
+		if !inIPXE && httpBin == nil {
+			h.Log.Error(fmt.Errorf("no iPXE binary HTTP server"), "network boot not allowed", "duid", duidString(m.Options.ClientID()))
+			return
+		}
+		var ipxeScript *url.URL
+		if h.Netboot.IPXEScriptURL6 != nil {
+			ipxeScript = h.Netboot.IPXEScriptURL6(m)
+		}
+		if n.IPXEScriptURL != nil {
+			ipxeScript = n.IPXEScriptURL
+		}
+		bootfile, _ = h.bootfileAndNextServer(ctx, uClass, httpClient.String(), bin, h.Netboot.IPXEBinServerTFTP, httpBin, ipxeScript)
+	}
+}
+
+// IsNetbootClient6 returns an error if the client is not a valid DHCPv6 netboot client.
+//
+// A valid DHCPv6 netboot client will have the following in its DHCPv6 message:
+// 1. is a SOLICIT, REQUEST, RENEW, REBIND or INFORMATION-REQUEST message type.
+// 2. option 59 (boot file URL) is requested in option 6.
+// 3. option 61 (client architecture) is set.
+//
+// See: https://www.rfc-editor.org/rfc/rfc5970.html
+func (h *Handler) IsNetbootClient6(msg *dhcpv6.Message) error {
+	var err error
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeInformationRequest:
+	default:
+		err = errors.New("message type must be one of Solicit, Request, Renew, Rebind or Information-request")
+	}
+	if !msg.IsOptionRequested(dhcpv6.OptionBootfileURL) {
+		err = fmt.Errorf("%w: option 59 not requested", err)
+	}
+	if len(msg.Options.ArchTypes()) == 0 {
+		err = fmt.Errorf("%w: option 61 not set", err)
+	}
+
+	return err
+}
+
+// arch6 returns the arch of the client pulled from DHCPv6 option 61.
+func arch6(m *dhcpv6.Message) iana.Arch {
+	for _, a := range m.Options.ArchTypes() {
+		if !strings.Contains(a.String(), "unknown") {
+			return a
+		}
+	}
+
+	return iana.Arch(255) // unknown arch
+}
+
+// isHTTPClient6 returns true if the client sent HTTPClient in the vendor class (option 16).
+func isHTTPClient6(m *dhcpv6.Message) bool {
+	for _, vc := range m.Options.VendorClasses() {
+		for _, d := range vc.Data {
+			if strings.HasPrefix(string(d), httpClient.String()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// clientHardwareAddr6 returns the MAC address of a DHCPv6 client.
+// It is taken from the client's DUID, when it is a DUID-LL or DUID-LLT of an ethernet interface,
+// or else from the client link-layer address (option 79) of the relay agent closest to the client.
+func clientHardwareAddr6(pkt dhcpv6.DHCPv6) net.HardwareAddr {
+	var mac net.HardwareAddr
+	for r, ok := pkt.(*dhcpv6.RelayMessage); ok; r, ok = r.Options.RelayMessage().(*dhcpv6.RelayMessage) {
+		if ht, lla := r.Options.ClientLinkLayerAddress(); ht == iana.HWTypeEthernet && lla != nil {
+			mac = lla
+		}
+	}
+	msg, err := pkt.GetInnerMessage()
+	if err != nil {
+		return mac
+	}
+	switch duid := msg.Options.ClientID().(type) {
+	case *dhcpv6.DUIDLL:
+		if duid.HWType == iana.HWTypeEthernet {
+			return duid.LinkLayerAddr
+		}
+	case *dhcpv6.DUIDLLT:
+		if duid.HWType == iana.HWTypeEthernet {
+			return duid.LinkLayerAddr
+		}
+	}
+
+	return mac
+}
+
+// duidString returns the DUID as colon separated hex bytes, the format used by backends.
+func duidString(d dhcpv6.DUID) string {
+	if d == nil {
+		return ""
+	}
+
+	return net.HardwareAddr(d.ToBytes()).String()
+}
+
+// encodeToAttributes6 takes a DHCPv6 message and returns opentelemetry key/value attributes.
+func encodeToAttributes6(m *dhcpv6.Message, namespace string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String(fmt.Sprintf("DHCP.%v.Header.xid", namespace), m.TransactionID.String()),
+		attribute.String(fmt.Sprintf("DHCP.%v.Header.type", namespace), m.Type().String()),
+		attribute.String(fmt.Sprintf("DHCP.%v.Opt1.ClientID", namespace), duidString(m.Options.ClientID())),
+	}
+	if ia := m.Options.OneIANA(); ia != nil {
+		if a := ia.Options.OneAddress(); a != nil {
+			attrs = append(attrs, attribute.String(fmt.Sprintf("DHCP.%v.Opt3.IANA.Address", namespace), a.IPv6Addr.String()))
+		}
+	}
+	if bf := m.Options.BootFileURL(); bf != "" {
+		attrs = append(attrs, attribute.String(fmt.Sprintf("DHCP.%v.Opt59.BootFileURL", namespace), bf))
+	}
+
+	return attrs
+}
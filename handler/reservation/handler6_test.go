@@ -0,0 +1,343 @@
+package reservation
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/handler"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	serverDUID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}}
+	clientMAC  = net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	clientDUID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: clientMAC}
+	// enDUID is a DUID that a MAC address can't be derived from.
+	enDUID = &dhcpv6.DUIDEN{EnterpriseNumber: 32473, EnterpriseIdentifier: []byte{0x01, 0x02}}
+)
+
+// mockBackend6 has a reservation with an IPv6 address for mac and, when set, for duid.
+type mockBackend6 struct {
+	mac          net.HardwareAddr
+	duid         []byte
+	allowNetboot bool
+	// netboot, when set, is returned instead of the netboot data from allowNetboot.
+	netboot *data.Netboot
+}
+
+func (m *mockBackend6) record() (*data.DHCP, *data.Netboot, error) {
+	d := &data.DHCP{
+		MACAddress:      m.mac,
+		IPv6Address:     netip.MustParseAddr("2001:db8::100"),
+		IPv6NameServers: []net.IP{net.ParseIP("2001:db8::53")},
+		LeaseTime:       3600,
+		DomainSearch:    []string{"mydomain.com"},
+	}
+
+	if m.netboot != nil {
+		return d, m.netboot, nil
+	}
+
+	return d, &data.Netboot{AllowNetboot: m.allowNetboot}, nil
+}
+
+func (m *mockBackend6) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if !bytes.Equal(mac, m.mac) {
+		return nil, nil, hwNotFoundError{}
+	}
+
+	return m.record()
+}
+
+func (m *mockBackend6) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, hwNotFoundError{}
+}
+
+func (m *mockBackend6) GetByDUID(_ context.Context, duid []byte) (*data.DHCP, *data.Netboot, error) {
+	if m.duid == nil || !bytes.Equal(duid, m.duid) {
+		return nil, nil, hwNotFoundError{}
+	}
+
+	return m.record()
+}
+
+// reply6 is the part of a DHCPv6 reply that tests compare.
+type reply6 struct {
+	Relayed bool
+	Type    dhcpv6.MessageType
+	Address string
+	Status  iana.StatusCode
+	// ReplyStatus is the status code option of the message, not of its IA_NA.
+	ReplyStatus string
+	BootFileURL string
+	VendorClass bool
+}
+
+func msg6(t *testing.T, mt dhcpv6.MessageType, duid dhcpv6.DUID, mods ...dhcpv6.Modifier) *dhcpv6.Message {
+	t.Helper()
+	m, err := dhcpv6.NewMessage(append([]dhcpv6.Modifier{dhcpv6.WithClientID(duid), dhcpv6.WithIAID([4]byte{0, 0, 0, 1})}, mods...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.MessageType = mt
+
+	return m
+}
+
+// withoutIANA removes the IA_NA from m.
+func withoutIANA(m *dhcpv6.Message) *dhcpv6.Message {
+	m.Options.Del(dhcpv6.OptionIANA)
+
+	return m
+}
+
+func TestHandle6(t *testing.T) {
+	netbootMods := []dhcpv6.Modifier{
+		dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL),
+		dhcpv6.WithArchType(iana.EFI_X86_64_HTTP),
+		dhcpv6.WithOption(&dhcpv6.OptVendorClass{EnterpriseNumber: enterpriseTianoCore, Data: [][]byte{[]byte("HTTPClient:Arch:00016:UNDI:003001")}}),
+	}
+	relayed := func(m *dhcpv6.Message) dhcpv6.DHCPv6 {
+		r, err := dhcpv6.EncapsulateRelay(m, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AddOption(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, clientMAC))
+
+		return r
+	}
+	tests := map[string]struct {
+		backend handler.BackendReader
+		netboot Netboot
+		pkt     dhcpv6.DHCPv6
+		want    *reply6
+	}{
+		"solicit": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100"},
+		},
+		"solicit with rapid commit": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID, dhcpv6.WithRapidCommit),
+			want:    &reply6{Type: dhcpv6.MessageTypeReply, Address: "2001:db8::100"},
+		},
+		"solicit by duid": {
+			backend: &mockBackend6{duid: enDUID.ToBytes()},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, enDUID),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100"},
+		},
+		"request": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeRequest, clientDUID, dhcpv6.WithServerID(serverDUID)),
+			want:    &reply6{Type: dhcpv6.MessageTypeReply, Address: "2001:db8::100"},
+		},
+		"request for a different server": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeRequest, clientDUID, dhcpv6.WithServerID(clientDUID)),
+		},
+		"release": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeRelease, clientDUID, dhcpv6.WithServerID(serverDUID)),
+			want:    &reply6{Type: dhcpv6.MessageTypeReply, ReplyStatus: iana.StatusSuccess.String()},
+		},
+		"decline": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeDecline, clientDUID, dhcpv6.WithServerID(serverDUID)),
+			want:    &reply6{Type: dhcpv6.MessageTypeReply, ReplyStatus: iana.StatusSuccess.String()},
+		},
+		"solicit without ia_na": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     withoutIANA(msg6(t, dhcpv6.MessageTypeSolicit, clientDUID)),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise},
+		},
+		"information request": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     withoutIANA(msg6(t, dhcpv6.MessageTypeInformationRequest, clientDUID)),
+			want:    &reply6{Type: dhcpv6.MessageTypeReply},
+		},
+		"no ipv6 address reserved": {
+			backend: &mockBackend{},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise, Status: iana.StatusNoAddrsAvail},
+		},
+		"unknown client": {
+			backend: &mockBackend6{mac: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x07}},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID),
+		},
+		"unknown client without mac": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, enDUID),
+		},
+		"relayed solicit by client link-layer address": {
+			backend: &mockBackend6{mac: clientMAC},
+			pkt:     relayed(msg6(t, dhcpv6.MessageTypeSolicit, enDUID)),
+			want:    &reply6{Relayed: true, Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100"},
+		},
+		"netboot http client": {
+			backend: &mockBackend6{mac: clientMAC, allowNetboot: true},
+			netboot: Netboot{Enabled: true, IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "[2001:db8::2]:8080", Path: "/ipxe"}},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID, netbootMods...),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100", BootFileURL: "http://[2001:db8::2]:8080/ipxe/ipxe.efi", VendorClass: true},
+		},
+		"netboot ipxe script": {
+			backend: &mockBackend6{mac: clientMAC, allowNetboot: true},
+			netboot: Netboot{
+				Enabled: true,
+				IPXEScriptURL6: func(*dhcpv6.Message) *url.URL {
+					return &url.URL{Scheme: "http", Host: "[2001:db8::2]", Path: "/auto.ipxe"}
+				},
+			},
+			pkt:  msg6(t, dhcpv6.MessageTypeSolicit, clientDUID, append(netbootMods, dhcpv6.WithUserClass([]byte(Tinkerbell)))...),
+			want: &reply6{Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100", BootFileURL: "http://[2001:db8::2]/auto.ipxe", VendorClass: true},
+		},
+		"netboot direct boot": {
+			backend: &mockBackend6{mac: clientMAC, netboot: &data.Netboot{
+				AllowNetboot: true,
+				BootFileName: "shimx64.efi",
+				HTTPBootURL:  &url.URL{Scheme: "http", Host: "[2001:db8::5]", Path: "/grub"},
+				DirectBoot:   true,
+			}},
+			netboot: Netboot{Enabled: true, IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "[2001:db8::2]:8080", Path: "/ipxe"}},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID, append(netbootMods, dhcpv6.WithUserClass([]byte(Tinkerbell)))...),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100", BootFileURL: "http://[2001:db8::5]/grub/shimx64.efi", VendorClass: true},
+		},
+		"netboot not allowed": {
+			backend: &mockBackend6{mac: clientMAC},
+			netboot: Netboot{Enabled: true, IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "[2001:db8::2]:8080", Path: "/ipxe"}},
+			pkt:     msg6(t, dhcpv6.MessageTypeSolicit, clientDUID, netbootMods...),
+			want:    &reply6{Type: dhcpv6.MessageTypeAdvertise, Address: "2001:db8::100", BootFileURL: "/netboot-not-allowed", VendorClass: true},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Backend: tt.backend, Log: logr.Discard(), Netboot: tt.netboot, DUID: serverDUID}
+
+			conn, err := net.ListenPacket("udp6", "[::1]:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			pc, err := net.ListenPacket("udp6", "[::1]:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+
+			h.Handle6(context.Background(), ipv6.NewPacketConn(conn), data.Packet6{Peer: pc.LocalAddr(), Pkt: tt.pkt, Md: &data.Metadata{}})
+
+			got := client6(t, pc)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// client6 reads a DHCPv6 reply from pc. It returns nil when no reply is received.
+func client6(t *testing.T, pc net.PacketConn) *reply6 {
+	t.Helper()
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		return nil
+	}
+	d, err := dhcpv6.FromBytes(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := d.GetInnerMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid := m.Options.ServerID(); sid == nil || !sid.Equal(serverDUID) {
+		t.Fatalf("server ID = %v, want %v", sid, serverDUID)
+	}
+	r := &reply6{Relayed: d.IsRelay(), Type: m.Type(), BootFileURL: m.Options.BootFileURL(), VendorClass: len(m.Options.VendorClasses()) > 0}
+	if ia := m.Options.OneIANA(); ia != nil {
+		if a := ia.Options.OneAddress(); a != nil {
+			r.Address = a.IPv6Addr.String()
+		}
+		if s := ia.Options.Status(); s != nil {
+			r.Status = s.StatusCode
+		}
+	}
+	if s := m.Options.Status(); s != nil {
+		r.ReplyStatus = s.StatusCode.String()
+	}
+
+	return r
+}
+
+func TestIsNetbootClient6(t *testing.T) {
+	tests := map[string]struct {
+		mods    []dhcpv6.Modifier
+		mt      dhcpv6.MessageType
+		wantErr bool
+	}{
+		"netboot client": {
+			mt:   dhcpv6.MessageTypeSolicit,
+			mods: []dhcpv6.Modifier{dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL), dhcpv6.WithArchType(iana.EFI_X86_64)},
+		},
+		"no option 59 requested": {
+			mt:      dhcpv6.MessageTypeSolicit,
+			mods:    []dhcpv6.Modifier{dhcpv6.WithArchType(iana.EFI_X86_64)},
+			wantErr: true,
+		},
+		"no option 61": {
+			mt:      dhcpv6.MessageTypeSolicit,
+			mods:    []dhcpv6.Modifier{dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL)},
+			wantErr: true,
+		},
+		"release": {
+			mt:      dhcpv6.MessageTypeRelease,
+			mods:    []dhcpv6.Modifier{dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL), dhcpv6.WithArchType(iana.EFI_X86_64)},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{}
+			if err := h.IsNetbootClient6(msg6(t, tt.mt, clientDUID, tt.mods...)); (err != nil) != tt.wantErr {
+				t.Fatalf("IsNetbootClient6() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientHardwareAddr6(t *testing.T) {
+	relay, err := dhcpv6.EncapsulateRelay(msg6(t, dhcpv6.MessageTypeSolicit, enDUID), dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.AddOption(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, clientMAC))
+
+	tests := map[string]struct {
+		pkt  dhcpv6.DHCPv6
+		want net.HardwareAddr
+	}{
+		"duid-ll":                 {pkt: msg6(t, dhcpv6.MessageTypeSolicit, clientDUID), want: clientMAC},
+		"duid-llt":                {pkt: msg6(t, dhcpv6.MessageTypeSolicit, &dhcpv6.DUIDLLT{HWType: iana.HWTypeEthernet, Time: 1, LinkLayerAddr: clientMAC}), want: clientMAC},
+		"duid-en":                 {pkt: msg6(t, dhcpv6.MessageTypeSolicit, enDUID)},
+		"relay client link-layer": {pkt: relay, want: clientMAC},
+		"duid-ll not ethernet":    {pkt: msg6(t, dhcpv6.MessageTypeSolicit, &dhcpv6.DUIDLL{HWType: iana.HWTypeInfiniband, LinkLayerAddr: clientMAC})},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(clientHardwareAddr6(tt.pkt), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/netip"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,7 +20,10 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/metrics"
 	"github.com/tinkerbell/dhcp/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/ipv4"
@@ -81,13 +85,32 @@ func (m *mockBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboo
 	return nil, nil, errors.New("not implemented")
 }
 
+// mockCircuitBackend is a mockBackend that only has a reservation for the relay agent circuit circuitID.
+type mockCircuitBackend struct {
+	mockBackend
+	circuitID string
+}
+
+func (m *mockCircuitBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, hwNotFoundError{}
+}
+
+func (m *mockCircuitBackend) GetByCircuitID(ctx context.Context, circuitID, _ string) (*data.DHCP, *data.Netboot, error) {
+	if circuitID != m.circuitID {
+		return nil, nil, hwNotFoundError{}
+	}
+
+	return m.mockBackend.GetByMac(ctx, nil)
+}
+
 func TestHandle(t *testing.T) {
 	tests := map[string]struct {
-		server  Handler
-		req     *dhcpv4.DHCPv4
-		want    *dhcpv4.DHCPv4
-		wantErr error
-		nilPeer bool
+		server    Handler
+		req       *dhcpv4.DHCPv4
+		circuitID string
+		want      *dhcpv4.DHCPv4
+		wantErr   error
+		nilPeer   bool
 	}{
 		"success discover message type with netboot options": {
 			server: Handler{
@@ -251,12 +274,145 @@ func TestHandle(t *testing.T) {
 			req: &dhcpv4.DHCPv4{
 				OpCode:       dhcpv4.OpcodeBootRequest,
 				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer),
+				),
+			},
+			wantErr: errBadBackend,
+		},
+		"success inform message type": {
+			server: Handler{
+				Backend: &mockBackend{},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				ClientIPAddr: []byte{192, 168, 1, 100},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeInform),
+				),
+			},
+			want: &dhcpv4.DHCPv4{
+				OpCode:        dhcpv4.OpcodeBootReply,
+				ClientHWAddr:  []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				ClientIPAddr:  []byte{192, 168, 1, 100},
+				YourIPAddr:    []byte{0, 0, 0, 0},
+				ServerIPAddr:  []byte{127, 0, 0, 1},
+				GatewayIPAddr: []byte{0, 0, 0, 0},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeAck),
+					dhcpv4.OptServerIdentifier(net.IP{127, 0, 0, 1}),
+					dhcpv4.OptSubnetMask(net.IPMask(net.IP{255, 255, 255, 0}.To4())),
+					dhcpv4.OptRouter([]net.IP{{192, 168, 1, 1}}...),
+					dhcpv4.OptDNS([]net.IP{{1, 1, 1, 1}}...),
+					dhcpv4.OptDomainName("mydomain.com"),
+					dhcpv4.OptHostName("test-host"),
+					dhcpv4.OptBroadcastAddress(net.IP{192, 168, 1, 255}),
+					dhcpv4.OptNTPServers([]net.IP{{132, 163, 96, 2}}...),
+					dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: []string{"mydomain.com"}}),
+				),
+			},
+		},
+		"success discover with circuit reservation": {
+			server: Handler{
+				Backend: &mockCircuitBackend{circuitID: "eth0/1"},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x07},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+					dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1"))),
+				),
+			},
+			circuitID: "eth0/1",
+			want: &dhcpv4.DHCPv4{
+				OpCode:        dhcpv4.OpcodeBootReply,
+				ClientHWAddr:  []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x07},
+				ClientIPAddr:  []byte{0, 0, 0, 0},
+				YourIPAddr:    []byte{192, 168, 1, 100},
+				ServerIPAddr:  []byte{127, 0, 0, 1},
+				GatewayIPAddr: []byte{0, 0, 0, 0},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer),
+					dhcpv4.OptServerIdentifier(net.IP{127, 0, 0, 1}),
+					dhcpv4.OptIPAddressLeaseTime(time.Minute),
+					dhcpv4.OptSubnetMask(net.IPMask(net.IP{255, 255, 255, 0}.To4())),
+					dhcpv4.OptRouter([]net.IP{{192, 168, 1, 1}}...),
+					dhcpv4.OptDNS([]net.IP{{1, 1, 1, 1}}...),
+					dhcpv4.OptDomainName("mydomain.com"),
+					dhcpv4.OptHostName("test-host"),
+					dhcpv4.OptBroadcastAddress(net.IP{192, 168, 1, 255}),
+					dhcpv4.OptNTPServers([]net.IP{{132, 163, 96, 2}}...),
+					dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: []string{"mydomain.com"}}),
+					// relay agent information must be echoed, see https://www.rfc-editor.org/rfc/rfc3046#section-2.2.
+					dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1"))),
+				),
+			},
+		},
+		"failure discover with unknown circuit": {
+			server: Handler{
+				Backend: &mockCircuitBackend{circuitID: "eth0/1"},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x07},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+					dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/2"))),
+				),
+			},
+			circuitID: "eth0/2",
+			wantErr:   errBadBackend,
+		},
+		"failure inform no hardware found": {
+			server: Handler{
+				Backend: &mockBackend{hardwareNotFound: true},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				ClientIPAddr: []byte{192, 168, 1, 100},
 				Options: dhcpv4.OptionsFromList(
 					dhcpv4.OptMessageType(dhcpv4.MessageTypeInform),
 				),
 			},
 			wantErr: errBadBackend,
 		},
+		"request for a different server": {
+			server: Handler{
+				Backend: &mockBackend{},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest),
+					dhcpv4.OptServerIdentifier(net.IP{127, 0, 0, 2}),
+				),
+			},
+			wantErr: errBadBackend,
+		},
+		"decline message type": {
+			server: Handler{
+				Backend: &mockBackend{},
+				IPAddr:  netip.MustParseAddr("127.0.0.1"),
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeDecline),
+					dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 1, 100}),
+				),
+			},
+			wantErr: errBadBackend,
+		},
 		"fail WriteTo": {
 			server: Handler{
 				Backend: &mockBackend{},
@@ -332,7 +488,7 @@ func TestHandle(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			s.Handle(context.Background(), con, data.Packet{Peer: peer, Pkt: tt.req, Md: &data.Metadata{IfName: n.Name, IfIndex: n.Index}})
+			s.Handle(context.Background(), con, data.Packet{Peer: peer, Pkt: tt.req, Md: &data.Metadata{IfName: n.Name, IfIndex: n.Index, CircuitID: tt.circuitID}})
 
 			msg, err := client(pc)
 			if !errors.Is(err, tt.wantErr) {
@@ -409,6 +565,35 @@ func TestUpdateMsg(t *testing.T) {
 				),
 			},
 		},
+		"onie switch": {
+			args: args{
+				m: &dhcpv4.DHCPv4{
+					OpCode:       dhcpv4.OpcodeBootRequest,
+					ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptClassIdentifier("onie_vendor:x86_64-accton_as7712_32x-r0"),
+						dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+					),
+				},
+				data:    &data.DHCP{IPAddress: netip.MustParseAddr("192.168.1.100"), SubnetMask: net.IPMask(net.IP{255, 255, 255, 0}.To4())},
+				netboot: &data.Netboot{AllowNetboot: true, ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.1.1", Path: "/onie-installer"}},
+				msg:     dhcpv4.MessageTypeDiscover,
+			},
+			want: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootReply,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				YourIPAddr:   []byte{192, 168, 1, 100},
+				ClientIPAddr: []byte{0, 0, 0, 0},
+				ServerIPAddr: []byte{127, 0, 0, 1},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+					dhcpv4.OptServerIdentifier(net.IP{127, 0, 0, 1}),
+					dhcpv4.OptIPAddressLeaseTime(3600),
+					dhcpv4.OptSubnetMask(net.IPMask(net.IP{255, 255, 255, 0}.To4())),
+					dhcpv4.OptGeneric(dhcpv4.OptionURL, []byte("http://192.168.1.1/onie-installer")),
+				),
+			},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -416,7 +601,8 @@ func TestUpdateMsg(t *testing.T) {
 				Log:    stdr.New(log.New(os.Stdout, "", log.Lshortfile)),
 				IPAddr: netip.MustParseAddr("127.0.0.1"),
 				Netboot: Netboot{
-					Enabled: true,
+					Enabled:            true,
+					SwitchProvisioning: true,
 				},
 				Backend: &mockBackend{
 					allowNetboot: true,
@@ -435,7 +621,7 @@ func TestUpdateMsg(t *testing.T) {
 func TestOne(t *testing.T) {
 	t.Skip()
 	h := &Handler{}
-	_, _, err := h.readBackend(context.Background(), nil)
+	_, _, err := h.readBackend(context.Background(), nil, nil)
 	t.Fatal(err)
 }
 
@@ -499,20 +685,45 @@ func TestReadBackend(t *testing.T) {
 				i := x.Compare(y)
 				return i == 0
 			})
-			gotDHCP, gotNetboot, err := s.readBackend(context.Background(), tt.input.ClientHWAddr)
+			gotDHCP, gotNetboot, err := s.readBackend(context.Background(), tt.input.ClientHWAddr, nil)
 			if !errors.Is(err, tt.wantErr) {
 				t.Fatalf("gotErr: %v, wantErr: %v", err, tt.wantErr)
 			}
 			if diff := cmp.Diff(gotDHCP, tt.wantDHCP, netaddrComparer); diff != "" {
 				t.Fatal(diff)
 			}
-			if diff := cmp.Diff(gotNetboot, tt.wantNetboot); diff != "" {
+			if diff := cmp.Diff(gotNetboot, tt.wantNetboot, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
 				t.Fatal(diff)
 			}
 		})
 	}
 }
 
+func TestReadBackendMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := &Handler{
+		Backend: &mockBackend{allowNetboot: true},
+		Metrics: metrics.New(reg),
+	}
+	if _, _, err := h.readBackend(context.Background(), net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Backend = &mockBackend{err: errBadBackend}
+	if _, _, err := h.readBackend(context.Background(), net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, nil); !errors.Is(err, errBadBackend) {
+		t.Fatalf("gotErr: %v, wantErr: %v", err, errBadBackend)
+	}
+
+	want := `
+# HELP dhcp_hardware_lookups_total Number of hardware lookups done by handlers, by result.
+# TYPE dhcp_hardware_lookups_total counter
+dhcp_hardware_lookups_total{result="error"} 1
+dhcp_hardware_lookups_total{result="found"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "dhcp_hardware_lookups_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestIsNetbootClient(t *testing.T) {
 	tests := map[string]struct {
 		input *dhcpv4.DHCPv4
@@ -554,12 +765,43 @@ func TestIsNetbootClient(t *testing.T) {
 			dhcpv4.OptGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}),
 			dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, []byte{}),
 		)}, want: nil},
+		"success u-boot": {input: &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(
+			dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.OptClassIdentifier("U-Boot.armv8"),
+			dhcpv4.OptClientArch(iana.UBOOT_ARM64),
+			dhcpv4.OptGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{0x01, 0x00, 0x00}),
+		)}, want: nil},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			s := &Handler{Log: logr.Discard()}
-			if err := s.isNetbootClient(tt.input); (err == nil) != (tt.want == nil) {
-				t.Errorf("isNetbootClient() = %v, want %v", err, tt.want)
+			if err := s.IsNetbootClient(tt.input); (err == nil) != (tt.want == nil) {
+				t.Errorf("IsNetbootClient() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNetbootClientBackendArch(t *testing.T) {
+	pkt := &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(
+		dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+		dhcpv4.OptClassIdentifier("PXEClient:Arch:xxxxx:UNDI:yyyzzz"),
+		dhcpv4.OptGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{0x01, 0x02, 0x03}),
+	)}
+	tests := map[string]struct {
+		d       *data.DHCP
+		wantErr bool
+	}{
+		"no backend data":       {wantErr: true},
+		"no arch":               {d: &data.DHCP{}, wantErr: true},
+		"arch without bootfile": {d: &data.DHCP{Arch: "riscv64"}, wantErr: true},
+		"arch":                  {d: &data.DHCP{Arch: "x86_64"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Handler{Log: logr.Discard()}
+			if err := s.isNetbootClient(pkt, tt.d); (err != nil) != tt.wantErr {
+				t.Fatalf("isNetbootClient() = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -627,3 +869,99 @@ func TestReplyDestination(t *testing.T) {
 		})
 	}
 }
+
+func TestNakDestination(t *testing.T) {
+	tests := map[string]struct {
+		giaddr net.IP
+		want   net.Addr
+	}{
+		"broadcast":             {want: &net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+		"broadcast unspecified": {giaddr: net.IPv4zero, want: &net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+		"giaddr":                {giaddr: net.IP{192, 168, 2, 1}, want: &net.UDPAddr{IP: net.IP{192, 168, 2, 1}, Port: 67}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := nakDestination(tt.giaddr)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestRequestedAddr(t *testing.T) {
+	tests := map[string]struct {
+		pkt  *dhcpv4.DHCPv4
+		want netip.Addr
+	}{
+		"none": {pkt: &dhcpv4.DHCPv4{}},
+		"option 50": {
+			pkt:  &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 1, 100}))},
+			want: netip.MustParseAddr("192.168.1.100"),
+		},
+		"ciaddr": {
+			pkt:  &dhcpv4.DHCPv4{ClientIPAddr: net.IP{192, 168, 1, 101}},
+			want: netip.MustParseAddr("192.168.1.101"),
+		},
+		"option 50 takes precedence": {
+			pkt:  &dhcpv4.DHCPv4{ClientIPAddr: net.IP{192, 168, 1, 101}, Options: dhcpv4.OptionsFromList(dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 1, 100}))},
+			want: netip.MustParseAddr("192.168.1.100"),
+		},
+		"unspecified ciaddr": {pkt: &dhcpv4.DHCPv4{ClientIPAddr: net.IPv4zero}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := requestedAddr(tt.pkt); got != tt.want {
+				t.Fatalf("requestedAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNakMsg(t *testing.T) {
+	tests := map[string]struct {
+		giaddr        net.IP
+		wantBroadcast bool
+	}{
+		"direct":  {},
+		"relayed": {giaddr: net.IP{192, 168, 2, 1}, wantBroadcast: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{IPAddr: netip.MustParseAddr("192.168.1.1")}
+			req := &dhcpv4.DHCPv4{
+				OpCode:        dhcpv4.OpcodeBootRequest,
+				ClientHWAddr:  net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				ClientIPAddr:  net.IP{192, 168, 1, 50},
+				GatewayIPAddr: tt.giaddr,
+				TransactionID: dhcpv4.TransactionID{0x01, 0x02, 0x03, 0x04},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest),
+					dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 1, 50}),
+				),
+			}
+			got := h.nakMsg(req, "requested address 192.168.1.50 is not the reserved address")
+			if got.MessageType() != dhcpv4.MessageTypeNak {
+				t.Fatalf("message type = %v, want %v", got.MessageType(), dhcpv4.MessageTypeNak)
+			}
+			if !got.ServerIdentifier().Equal(net.IP{192, 168, 1, 1}) {
+				t.Fatalf("server identifier = %v, want 192.168.1.1", got.ServerIdentifier())
+			}
+			if !got.YourIPAddr.IsUnspecified() || !got.ClientIPAddr.IsUnspecified() {
+				t.Fatalf("yiaddr and ciaddr must not be set, got yiaddr %v, ciaddr %v", got.YourIPAddr, got.ClientIPAddr)
+			}
+			if got.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+				t.Fatal("lease time must not be set")
+			}
+			if got.TransactionID != req.TransactionID {
+				t.Fatalf("xid = %v, want %v", got.TransactionID, req.TransactionID)
+			}
+			if got.IsBroadcast() != tt.wantBroadcast {
+				t.Fatalf("broadcast = %v, want %v", got.IsBroadcast(), tt.wantBroadcast)
+			}
+			if got.Message() == "" {
+				t.Fatal("expected option 56 to be set")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package reservation
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// putLease records l in the lease store, if there is one.
+// Failing to record a lease is logged but doesn't stop the DHCP exchange.
+func (h *Handler) putLease(ctx context.Context, log logr.Logger, l *data.Lease) {
+	if h.Leases == nil || l == nil {
+		return
+	}
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "Lease put")
+	defer span.End()
+
+	span.SetAttributes(l.EncodeToAttributes()...)
+	if err := h.Leases.Put(ctx, l); err != nil {
+		log.Error(err, "failed to record lease", "ipAddress", l.IPAddress.String(), "state", l.State)
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	span.SetStatus(codes.Ok, "recorded lease")
+}
+
+// boundLease returns the lease for an address acknowledged to the client that sent pkt.
+// The hostname from the backend is used, or else the hostname the client sent in option 12.
+func boundLease(pkt *dhcpv4.DHCPv4, d *data.DHCP) *data.Lease {
+	if d == nil || !d.IPAddress.IsValid() {
+		return nil
+	}
+	hostname := d.Hostname
+	if hostname == "" {
+		hostname = pkt.HostName()
+	}
+	now := time.Now()
+
+	return &data.Lease{
+		MACAddress: pkt.ClientHWAddr,
+		IPAddress:  d.IPAddress,
+		Hostname:   hostname,
+		State:      data.LeaseStateBound,
+		Expires:    now.Add(time.Duration(d.LeaseTime) * time.Second),
+		Updated:    now,
+	}
+}
+
+// endedLease returns the lease for ip that the client that sent pkt gave back.
+// It returns nil if ip is not set.
+func endedLease(pkt *dhcpv4.DHCPv4, ip net.IP, state data.LeaseState) *data.Lease {
+	addr, ok := netip.AddrFromSlice(ip.To4())
+	if !ok || addr.IsUnspecified() {
+		return nil
+	}
+	now := time.Now()
+
+	return &data.Lease{
+		MACAddress: pkt.ClientHWAddr,
+		IPAddress:  addr,
+		State:      state,
+		Expires:    now,
+		Updated:    now,
+	}
+}
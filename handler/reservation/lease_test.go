@@ -0,0 +1,141 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/nettest"
+)
+
+// mockLeases records all leases put into it.
+type mockLeases struct {
+	mu     sync.Mutex
+	leases []*data.Lease
+	err    error
+}
+
+func (m *mockLeases) Put(_ context.Context, l *data.Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.leases = append(m.leases, l)
+
+	return nil
+}
+
+func (m *mockLeases) GetByMac(context.Context, net.HardwareAddr) (*data.Lease, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockLeases) GetByIP(context.Context, net.IP, time.Time) (*data.Lease, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestHandleLeases(t *testing.T) {
+	mac := net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	tests := map[string]struct {
+		msgType  dhcpv4.MessageType
+		opts     []dhcpv4.Option
+		ciaddr   net.IP
+		storeErr error
+		want     []*data.Lease
+	}{
+		"ack is recorded": {
+			msgType: dhcpv4.MessageTypeRequest,
+			want:    []*data.Lease{{MACAddress: mac, IPAddress: netip.MustParseAddr("192.168.1.100"), Hostname: "test-host", State: data.LeaseStateBound}},
+		},
+		"offer is not recorded": {
+			msgType: dhcpv4.MessageTypeDiscover,
+		},
+		"release is recorded": {
+			msgType: dhcpv4.MessageTypeRelease,
+			ciaddr:  net.IP{192, 168, 1, 100},
+			want:    []*data.Lease{{MACAddress: mac, IPAddress: netip.MustParseAddr("192.168.1.100"), State: data.LeaseStateReleased}},
+		},
+		"release without ciaddr is not recorded": {
+			msgType: dhcpv4.MessageTypeRelease,
+		},
+		"decline is recorded": {
+			msgType: dhcpv4.MessageTypeDecline,
+			opts:    []dhcpv4.Option{dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 1, 100})},
+			want:    []*data.Lease{{MACAddress: mac, IPAddress: netip.MustParseAddr("192.168.1.100"), State: data.LeaseStateDeclined}},
+		},
+		"store error doesn't stop the ack": {
+			msgType:  dhcpv4.MessageTypeRequest,
+			storeErr: errors.New("disk full"),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			leases := &mockLeases{err: tt.storeErr}
+			h := &Handler{Backend: &mockBackend{}, IPAddr: netip.MustParseAddr("127.0.0.1"), Leases: leases}
+			conn, err := nettest.NewLocalPacketListener("udp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			pc, err := net.ListenPacket("udp4", ":0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			peer := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: pc.LocalAddr().(*net.UDPAddr).Port}
+
+			req := &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: mac,
+				ClientIPAddr: tt.ciaddr,
+				Options:      dhcpv4.OptionsFromList(append(tt.opts, dhcpv4.OptMessageType(tt.msgType))...),
+			}
+			h.Handle(context.Background(), ipv4.NewPacketConn(conn), data.Packet{Peer: peer, Pkt: req, Md: &data.Metadata{}})
+			if tt.msgType == dhcpv4.MessageTypeRequest {
+				if _, err := client(pc); err != nil {
+					t.Fatalf("expected an ack, got: %v", err)
+				}
+			}
+
+			opts := []cmp.Option{
+				cmpopts.IgnoreFields(data.Lease{}, "Expires", "Updated"),
+				cmp.Comparer(func(a, b netip.Addr) bool { return a == b }),
+			}
+			if diff := cmp.Diff(leases.leases, tt.want, opts...); diff != "" {
+				t.Fatal(diff)
+			}
+			for _, l := range leases.leases {
+				if l.State == data.LeaseStateBound && l.Expires.Sub(l.Updated) != time.Minute {
+					t.Fatalf("expected lease to expire after the lease time of 1m, got %v", l.Expires.Sub(l.Updated))
+				}
+			}
+		})
+	}
+}
+
+func TestBoundLease(t *testing.T) {
+	pkt := &dhcpv4.DHCPv4{
+		ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+		Options:      dhcpv4.OptionsFromList(dhcpv4.OptHostName("client-host")),
+	}
+	if got := boundLease(pkt, &data.DHCP{}); got != nil {
+		t.Fatalf("expected no lease without an IP address, got: %+v", got)
+	}
+	got := boundLease(pkt, &data.DHCP{IPAddress: netip.MustParseAddr("192.168.1.100")})
+	if got.Hostname != "client-host" {
+		t.Fatalf("Hostname = %q, want the hostname sent by the client", got.Hostname)
+	}
+	got = boundLease(pkt, &data.DHCP{IPAddress: netip.MustParseAddr("192.168.1.100"), Hostname: "backend-host"})
+	if got.Hostname != "backend-host" {
+		t.Fatalf("Hostname = %q, want the hostname from the backend", got.Hostname)
+	}
+}
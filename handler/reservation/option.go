@@ -6,12 +6,14 @@ import (
 	"net"
 	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/equinix-labs/otel-init-go/otelhelpers"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/tinkerbell/dhcp/data"
+	"github.com/tinkerbell/dhcp/ipxe"
 	"github.com/tinkerbell/dhcp/otel"
 )
 
@@ -24,6 +26,8 @@ type clientType string
 const (
 	pxeClient  clientType = "PXEClient"
 	httpClient clientType = "HTTPClient"
+	// uBootClient is the start of the vendor class of U-Boot, for example "U-Boot.armv8".
+	uBootClient clientType = "U-Boot"
 )
 
 // known user-class types. must correspond to DHCP option 77 - User-Class
@@ -42,24 +46,20 @@ const (
 )
 
 // ArchToBootFile maps supported hardware PXE architectures types to iPXE binary files.
-var ArchToBootFile = map[iana.Arch]string{
-	iana.INTEL_X86PC:       "undionly.kpxe",
-	iana.NEC_PC98:          "undionly.kpxe",
-	iana.EFI_ITANIUM:       "undionly.kpxe",
-	iana.DEC_ALPHA:         "undionly.kpxe",
-	iana.ARC_X86:           "undionly.kpxe",
-	iana.INTEL_LEAN_CLIENT: "undionly.kpxe",
-	iana.EFI_IA32:          "ipxe.efi",
-	iana.EFI_X86_64:        "ipxe.efi",
-	iana.EFI_XSCALE:        "ipxe.efi",
-	iana.EFI_BC:            "ipxe.efi",
-	iana.EFI_ARM32:         "snp.efi",
-	iana.EFI_ARM64:         "snp.efi",
-	iana.EFI_X86_HTTP:      "ipxe.efi",
-	iana.EFI_X86_64_HTTP:   "ipxe.efi",
-	iana.EFI_ARM32_HTTP:    "snp.efi",
-	iana.EFI_ARM64_HTTP:    "snp.efi",
-	iana.Arch(41):          "snp.efi", // arm rpiboot: https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml#processor-architecture
+// The Handler doesn't read it, changing it has no effect on the boot file clients are sent.
+//
+// Deprecated: use BootFileSelector / DefaultBootFiles.
+var ArchToBootFile map[iana.Arch]string = DefaultBootFiles()
+
+// archAliases maps architecture names used in backends to the PXE architecture types of DHCP option 93.
+var archAliases = map[string]iana.Arch{
+	"x86":     iana.INTEL_X86PC,
+	"i386":    iana.INTEL_X86PC,
+	"x86_64":  iana.EFI_X86_64,
+	"amd64":   iana.EFI_X86_64,
+	"aarch64": iana.EFI_ARM64,
+	"arm64":   iana.EFI_ARM64,
+	"arm":     iana.EFI_ARM32,
 }
 
 // String function for clientType.
@@ -104,14 +104,62 @@ func (h *Handler) setDHCPOpts(_ context.Context, _ *dhcpv4.DHCPv4, d *data.DHCP)
 	if d.DefaultGateway.Compare(netip.Addr{}) != 0 {
 		mods = append(mods, dhcpv4.WithRouter(d.DefaultGateway.AsSlice()))
 	}
+	if routes := classlessStaticRoutes(d); len(routes) > 0 {
+		b := routes.ToBytes()
+		mods = append(mods, dhcpv4.WithGeneric(dhcpv4.OptionClasslessStaticRoute, b))
+		// option 249 is the same as option 121, used by older Microsoft clients.
+		mods = append(mods, dhcpv4.WithGeneric(dhcpv4.GenericOptionCode(249), b))
+	}
 	if h.SyslogAddr.Compare(netip.Addr{}) != 0 {
 		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionLogServer, h.SyslogAddr.AsSlice())))
 	}
+	// custom options are set last, so that they override the options above.
+	for _, code := range d.OptionCodes() {
+		o := d.Options[code]
+		if err := o.Validate(code); err != nil {
+			h.Log.Info("not sending invalid custom DHCP option", "option", code, "error", err.Error())
+			continue
+		}
+		b, _ := o.Bytes()
+		mods = append(mods, dhcpv4.WithGeneric(dhcpv4.GenericOptionCode(code), b))
+	}
 
 	return mods
 }
 
-// setNetworkBootOpts purpose is to sets 3 or 4 values. 2 DHCP headers, option 43 and optionally option (60).
+// classlessStaticRoutes returns the classless static routes of d in the format of DHCP option 121.
+// Clients ignore the default gateway (option 3) when they receive option 121, see https://www.rfc-editor.org/rfc/rfc3442#page-5,
+// so a default route to the default gateway is added when d has one and the routes don't include a default route.
+func classlessStaticRoutes(d *data.DHCP) dhcpv4.Routes {
+	var routes dhcpv4.Routes
+	hasDefault := false
+	for _, r := range d.ClasslessStaticRoutes {
+		if !r.Destination.Addr().Is4() || !r.Router.Is4() {
+			continue
+		}
+		p := r.Destination.Masked()
+		if p.Bits() == 0 {
+			hasDefault = true
+		}
+		routes = append(routes, &dhcpv4.Route{
+			Dest:   &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), 32)},
+			Router: r.Router.AsSlice(),
+		})
+	}
+	if len(routes) > 0 && !hasDefault && d.DefaultGateway.Is4() {
+		routes = append(routes, &dhcpv4.Route{
+			Dest:   &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			Router: d.DefaultGateway.AsSlice(),
+		})
+	}
+
+	return routes
+}
+
+// SetNetworkBootOpts purpose is to sets 3 or 4 values. 2 DHCP headers, option 43 and optionally option (60).
+// d is the DHCP data of the client from the backend and can be nil. Its VLANID is sent in option 43 sub-option 116,
+// and its Arch is used to choose the boot file when the client's option 93 is missing or has no boot file.
+// The boot file is chosen by Netboot.BootFileSelector.
 // These headers and options are returned as a dhcvp4.Modifier that can be used to modify a dhcp response.
 // github.com/insomniacslk/dhcp uses this method to simplify packet manipulation.
 //
@@ -122,42 +170,65 @@ func (h *Handler) setDHCPOpts(_ context.Context, _ *dhcpv4.DHCPv4, d *data.DHCP)
 // DHCP option
 // option 60: Class Identifier. https://www.rfc-editor.org/rfc/rfc2132.html#section-9.13
 // option 60 is set if the client's option 60 (Class Identifier) starts with HTTPClient.
-func (h *Handler) setNetworkBootOpts(ctx context.Context, m *dhcpv4.DHCPv4, n *data.Netboot) dhcpv4.Modifier {
+func (h *Handler) SetNetworkBootOpts(ctx context.Context, m *dhcpv4.DHCPv4, d *data.DHCP, n *data.Netboot) dhcpv4.Modifier {
 	// m is a received DHCPv4 packet.
-	// d is the reply packet we are building.
-	withNetboot := func(d *dhcpv4.DHCPv4) {
+	// reply is the reply packet we are building.
+	withNetboot := func(reply *dhcpv4.DHCPv4) {
 		var opt60 string
 		// if the client sends opt 60 with HTTPClient then we need to respond with opt 60
 		if val := m.Options.Get(dhcpv4.OptionClassIdentifier); val != nil {
 			if strings.HasPrefix(string(val), httpClient.String()) {
-				d.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClassIdentifier, []byte(httpClient)))
+				reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClassIdentifier, []byte(httpClient)))
 				opt60 = httpClient.String()
 			}
 		}
-		d.BootFileName = "/netboot-not-allowed"
-		d.ServerIPAddr = net.IPv4(0, 0, 0, 0)
+		reply.BootFileName = "/netboot-not-allowed"
+		reply.ServerIPAddr = net.IPv4(0, 0, 0, 0)
+		if n.AllowNetboot && isRaspberryPi(m) {
+			tftp, _ := h.bootServers(n)
+			setRaspberryPiOpts(reply, tftp)
+			return
+		}
 		if n.AllowNetboot {
-			a := arch(m)
-			bin, found := ArchToBootFile[a]
+			uClass := UserClass(string(m.GetOneOption(dhcpv4.OptionUserClassInformation)))
+			bin, br, found := h.bootFile(BootRequest{
+				Packet:      m,
+				MAC:         m.ClientHWAddr,
+				Arch:        arch(m),
+				UserClass:   uClass,
+				VendorClass: string(m.GetOneOption(dhcpv4.OptionClassIdentifier)),
+				DHCP:        d,
+				Netboot:     n,
+			})
 			if !found {
-				h.Log.Error(fmt.Errorf("unable to find bootfile for arch"), "network boot not allowed", "arch", a, "archInt", int(a), "mac", m.ClientHWAddr)
+				h.Log.Error(fmt.Errorf("unable to find bootfile for arch"), "network boot not allowed", "arch", br.Arch, "archInt", int(br.Arch), "mac", m.ClientHWAddr)
 				return
 			}
-			uClass := UserClass(string(m.GetOneOption(dhcpv4.OptionUserClassInformation)))
-			var ipxeScript *url.URL
-			if h.Netboot.IPXEScriptURL != nil {
-				ipxeScript = h.Netboot.IPXEScriptURL(m)
-			}
-			if n.IPXEScriptURL != nil {
-				ipxeScript = n.IPXEScriptURL
+			tftp, httpBin := h.bootServers(n)
+			if n.DirectBoot {
+				reply.BootFileName, reply.ServerIPAddr = directBootfile(opt60, bin, tftp, httpBin)
+			} else {
+				var ipxeScript *url.URL
+				if h.Netboot.IPXEScriptURL != nil {
+					ipxeScript = h.Netboot.IPXEScriptURL(m)
+				}
+				if h.Netboot.IPXEScriptServer != nil && n.IPXEScript != "" {
+					ipxeScript = ipxe.ScriptURL(h.Netboot.IPXEScriptServer, m.ClientHWAddr)
+				}
+				if n.IPXEScriptURL != nil {
+					ipxeScript = n.IPXEScriptURL
+				}
+				reply.BootFileName, reply.ServerIPAddr = h.bootfileAndNextServer(ctx, uClass, opt60, bin, tftp, httpBin, ipxeScript)
 			}
-			d.BootFileName, d.ServerIPAddr = h.bootfileAndNextServer(ctx, uClass, opt60, bin, h.Netboot.IPXEBinServerTFTP, h.Netboot.IPXEBinServerHTTP, ipxeScript)
 			pxe := dhcpv4.Options{ // FYI, these are suboptions of option43. ref: https://datatracker.ietf.org/doc/html/rfc2132#section-8.4
 				// PXE Boot Server Discovery Control - bypass, just boot from filename.
 				6:  []byte{8},
 				69: otel.TraceparentFromContext(ctx),
 			}
-			d.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, pxe.ToBytes()))
+			if v := vlanID(d); v != "" {
+				pxe[116] = []byte(v)
+			}
+			reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, pxe.ToBytes()))
 		}
 	}
 
@@ -199,6 +270,42 @@ func (h *Handler) bootfileAndNextServer(ctx context.Context, uClass UserClass, o
 	return bootfile, nextServer
 }
 
+// bootServers returns the TFTP server and the HTTP server of the boot file.
+// The next server and HTTP boot URL in the netboot data of the backend override the servers of the handler.
+func (h *Handler) bootServers(n *data.Netboot) (netip.AddrPort, *url.URL) {
+	tftp, httpBin := h.Netboot.IPXEBinServerTFTP, h.Netboot.IPXEBinServerHTTP
+	if n.NextServer.IsValid() {
+		port := tftp.Port()
+		if port == 0 {
+			port = 69
+		}
+		tftp = netip.AddrPortFrom(n.NextServer, port)
+	}
+	if n.HTTPBootURL != nil {
+		httpBin = n.HTTPBootURL
+	}
+
+	return tftp, httpBin
+}
+
+// directBootfile returns the bootfile and next server for a client that boots bin directly, without chaining into an iPXE script.
+// This is used for boot loaders like GRUB or shim, and for switches, that don't run iPXE.
+// Unlike bootfileAndNextServer, no traceparent is appended to bin, as these boot loaders request the file name as is.
+func directBootfile(opt60, bin string, tftp netip.AddrPort, httpBin *url.URL) (string, net.IP) {
+	if clientType(opt60) == httpClient && httpBin != nil {
+		nextServer := net.ParseIP("0.0.0.0")
+		if n, err := netip.ParseAddrPort(httpBin.Host); err == nil {
+			nextServer = n.Addr().AsSlice()
+		} else if n2 := net.ParseIP(httpBin.Host); n2 != nil {
+			nextServer = n2
+		}
+
+		return httpBin.JoinPath(bin).String(), nextServer
+	}
+
+	return bin, net.IP(tftp.Addr().AsSlice())
+}
+
 // arch returns the arch of the client pulled from DHCP option 93.
 func arch(d *dhcpv4.DHCPv4) iana.Arch {
 	// get option 93 ; arch
@@ -224,3 +331,45 @@ func arch(d *dhcpv4.DHCPv4) iana.Arch {
 
 	return a
 }
+
+// parseArch returns the PXE architecture type for an Arch from the backend.
+// The Arch is either a decimal option 93 value, for example "7", or a name in archAliases, for example "x86_64".
+func parseArch(s string) (iana.Arch, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, false
+	}
+	if a, ok := archAliases[s]; ok {
+		return a, true
+	}
+	if v, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return iana.Arch(v), true
+	}
+
+	return 0, false
+}
+
+// knownArch returns true if the Arch of d is known and has a boot file.
+func (h *Handler) knownArch(d *data.DHCP) bool {
+	a, ok := parseArch(d.Arch)
+	if !ok {
+		return false
+	}
+	_, found := h.bootFileSelector().BootFile(BootRequest{Arch: a, DHCP: d})
+
+	return found
+}
+
+// vlanID returns the VLAN ID from the backend to send in option 43 sub-option 116.
+// An empty string is returned when there is no VLAN ID or it is not a valid VLAN ID (1-4094).
+func vlanID(d *data.DHCP) string {
+	if d == nil || d.VLANID == "" {
+		return ""
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(d.VLANID), 10, 16)
+	if err != nil || v < 1 || v > 4094 {
+		return ""
+	}
+
+	return strconv.FormatUint(v, 10)
+}
@@ -89,6 +89,40 @@ func TestSetDHCPOpts(t *testing.T) {
 				),
 			},
 		},
+		"custom options": {
+			server: Handler{Log: logr.Discard()},
+			args: args{
+				in0: context.Background(),
+				m:   &dhcpv4.DHCPv4{},
+				d: &data.DHCP{
+					IPAddress:   netip.MustParseAddr("192.168.4.4"),
+					NameServers: []net.IP{{8, 8, 8, 8}},
+					LeaseTime:   3600,
+					Options: map[uint8]data.Option{
+						6:   {Type: data.OptionTypeIPList, Value: "9.9.9.9"},
+						66:  {Type: data.OptionTypeString, Value: "tftp.example.com"},
+						150: {Type: data.OptionTypeIPList, Value: "192.168.4.1"},
+						54:  {Type: data.OptionTypeIP, Value: "192.168.4.1"},
+						67:  {Type: data.OptionTypeUint8, Value: "1"},
+					},
+				},
+			},
+			want: &dhcpv4.DHCPv4{
+				OpCode:        dhcpv4.OpcodeBootRequest,
+				HWType:        iana.HWTypeEthernet,
+				ClientHWAddr:  net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+				ClientIPAddr:  []byte{0, 0, 0, 0},
+				YourIPAddr:    []byte{192, 168, 4, 4},
+				ServerIPAddr:  []byte{0, 0, 0, 0},
+				GatewayIPAddr: []byte{0, 0, 0, 0},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptIPAddressLeaseTime(time.Hour),
+					dhcpv4.OptDNS(net.IP{9, 9, 9, 9}),
+					dhcpv4.OptTFTPServerName("tftp.example.com"),
+					dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(150), []byte{192, 168, 4, 1}),
+				),
+			},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -117,6 +151,54 @@ func TestSetDHCPOpts(t *testing.T) {
 	}
 }
 
+func TestClasslessStaticRoutes(t *testing.T) {
+	tests := map[string]struct {
+		d    *data.DHCP
+		want []byte
+	}{
+		"no routes": {d: &data.DHCP{DefaultGateway: netip.MustParseAddr("192.168.2.1")}},
+		"default route added": {
+			d: &data.DHCP{
+				DefaultGateway:        netip.MustParseAddr("192.168.2.1"),
+				ClasslessStaticRoutes: []data.Route{{Destination: netip.MustParsePrefix("10.0.0.0/8"), Router: netip.MustParseAddr("192.168.2.2")}},
+			},
+			want: []byte{8, 10, 192, 168, 2, 2, 0, 192, 168, 2, 1},
+		},
+		"default route in routes": {
+			d: &data.DHCP{
+				DefaultGateway: netip.MustParseAddr("192.168.2.1"),
+				ClasslessStaticRoutes: []data.Route{
+					{Destination: netip.MustParsePrefix("172.16.1.0/24"), Router: netip.MustParseAddr("192.168.2.2")},
+					{Destination: netip.MustParsePrefix("0.0.0.0/0"), Router: netip.MustParseAddr("192.168.2.3")},
+				},
+			},
+			want: []byte{24, 172, 16, 1, 192, 168, 2, 2, 0, 192, 168, 2, 3},
+		},
+		"no default gateway": {
+			d:    &data.DHCP{ClasslessStaticRoutes: []data.Route{{Destination: netip.MustParsePrefix("10.1.2.3/16"), Router: netip.MustParseAddr("192.168.2.2")}}},
+			want: []byte{16, 10, 1, 192, 168, 2, 2},
+		},
+		"ipv6 routes ignored": {
+			d: &data.DHCP{ClasslessStaticRoutes: []data.Route{{Destination: netip.MustParsePrefix("2001:db8::/32"), Router: netip.MustParseAddr("2001:db8::1")}}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Log: logr.Discard()}
+			reply, err := dhcpv4.New(h.setDHCPOpts(context.Background(), &dhcpv4.DHCPv4{}, tt.d)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(reply.Options.Get(dhcpv4.OptionClasslessStaticRoute), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(reply.Options.Get(dhcpv4.GenericOptionCode(249)), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestArch(t *testing.T) {
 	tests := map[string]struct {
 		pkt  *dhcpv4.DHCPv4
@@ -145,6 +227,26 @@ func TestArch(t *testing.T) {
 	}
 }
 
+func TestVLANID(t *testing.T) {
+	tests := map[string]struct {
+		d    *data.DHCP
+		want string
+	}{
+		"nil":          {},
+		"no vlan id":   {d: &data.DHCP{}},
+		"vlan id":      {d: &data.DHCP{VLANID: "100"}, want: "100"},
+		"out of range": {d: &data.DHCP{VLANID: "4095"}},
+		"not a number": {d: &data.DHCP{VLANID: "vlan100"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := vlanID(tt.d); got != tt.want {
+				t.Fatalf("vlanID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestBootfileAndNextServer(t *testing.T) {
 	type args struct {
 		mac     net.HardwareAddr
@@ -254,6 +356,7 @@ func TestSetNetworkBootOpts(t *testing.T) {
 	type args struct {
 		in0 context.Context
 		m   *dhcpv4.DHCPv4
+		d   *data.DHCP
 		n   *data.Netboot
 	}
 	tests := map[string]struct {
@@ -304,13 +407,229 @@ func TestSetNetworkBootOpts(t *testing.T) {
 					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
 					Options: dhcpv4.OptionsFromList(
 						dhcpv4.OptUserClass(Tinkerbell.String()),
-						dhcpv4.OptClientArch(iana.UBOOT_ARM64),
+						dhcpv4.OptClientArch(iana.EFI_RISCV64),
 					),
 				},
 				n: &data.Netboot{AllowNetboot: true},
 			},
 			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IPv4(0, 0, 0, 0), BootFileName: "/netboot-not-allowed"},
 		},
+		"inline script": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{
+				IPXEScriptURL: func(*dhcpv4.DHCPv4) *url.URL {
+					return &url.URL{Scheme: "http", Host: "localhost:8181", Path: "/auto.ipxe"}
+				},
+				IPXEScriptServer: &url.URL{Scheme: "http", Host: "192.168.2.1:8080"},
+			}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptUserClass(Tinkerbell.String()),
+						dhcpv4.OptClientArch(iana.EFI_X86_64),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true, IPXEScript: "#!ipxe\nautoboot"},
+			},
+			want: &dhcpv4.DHCPv4{BootFileName: "http://192.168.2.1:8080/01:02:03:04:05:06/auto.ipxe", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"arch unknown, arch from backend": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options:      dhcpv4.OptionsFromList(dhcpv4.OptClientArch(iana.EFI_RISCV64)),
+				},
+				d: &data.DHCP{Arch: "aarch64"},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, BootFileName: "snp.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"option 93 preferred over backend arch": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options:      dhcpv4.OptionsFromList(dhcpv4.OptClientArch(iana.INTEL_X86PC)),
+				},
+				d: &data.DHCP{Arch: "x86_64"},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, BootFileName: "undionly.kpxe", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"vlan id": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options:      dhcpv4.OptionsFromList(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+				},
+				d: &data.DHCP{VLANID: "100"},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, BootFileName: "ipxe.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:   []byte{8},
+					69:  oteldhcp.TraceparentFromContext(context.Background()),
+					116: []byte("100"),
+				}.ToBytes()),
+			)},
+		},
+		"raspberry pi": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0xdc, 0xa6, 0x32, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001"),
+						dhcpv4.OptClientArch(iana.INTEL_X86PC),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{3},
+					9:  append([]byte{0, 0, 17}, "Raspberry Pi Boot"...),
+					10: append([]byte{0}, "PXE"...),
+				}.ToBytes()),
+				dhcpv4.OptTFTPServerName("192.168.2.1"),
+				dhcpv4.OptClassIdentifier("PXEClient"),
+			)},
+		},
+		"raspberry pi, not allowed": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0xdc, 0xa6, 0x32, 0x04, 0x05, 0x06},
+					Options:      dhcpv4.OptionsFromList(dhcpv4.OptClientArch(iana.INTEL_X86PC)),
+				},
+				n: &data.Netboot{},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IPv4(0, 0, 0, 0), BootFileName: "/netboot-not-allowed"},
+		},
+		"u-boot": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptClassIdentifier("U-Boot.armv8"),
+						dhcpv4.OptClientArch(iana.UBOOT_ARM64),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, BootFileName: "snp.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"boot file selector": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{
+				IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69"),
+				BootFileSelector:  RuleSelector{Rules: []BootFileRule{{OUI: "01:02:03", BootFile: "custom.efi"}}},
+			}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options:      dhcpv4.OptionsFromList(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+				},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, BootFileName: "custom.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"boot file name and next server from backend": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptUserClass(IPXE.String()),
+						dhcpv4.OptClientArch(iana.EFI_RISCV64),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true, BootFileName: "custom.efi", NextServer: netip.MustParseAddr("192.168.2.5")},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 5}, BootFileName: "tftp://192.168.2.5:69/custom.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"direct boot": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.1:69")}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptUserClass(IPXE.String()),
+						dhcpv4.OptClientArch(iana.EFI_X86_64),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true, BootFileName: "grubx64.efi", DirectBoot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 1}, BootFileName: "grubx64.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+			)},
+		},
+		"direct boot, http client": {
+			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "192.168.2.1:8080"}}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016"),
+						dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true, BootFileName: "shimx64.efi", HTTPBootURL: &url.URL{Scheme: "http", Host: "192.168.2.5:8080", Path: "/grub"}, DirectBoot: true},
+			},
+			want: &dhcpv4.DHCPv4{ServerIPAddr: net.IP{192, 168, 2, 5}, BootFileName: "http://192.168.2.5:8080/grub/shimx64.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+				dhcpv4.OptClassIdentifier("HTTPClient"),
+			)},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -320,13 +639,15 @@ func TestSetNetworkBootOpts(t *testing.T) {
 					IPXEBinServerTFTP: tt.server.Netboot.IPXEBinServerTFTP,
 					IPXEBinServerHTTP: tt.server.Netboot.IPXEBinServerHTTP,
 					IPXEScriptURL:     tt.server.Netboot.IPXEScriptURL,
+					IPXEScriptServer:  tt.server.Netboot.IPXEScriptServer,
 					Enabled:           tt.server.Netboot.Enabled,
 					UserClass:         tt.server.Netboot.UserClass,
+					BootFileSelector:  tt.server.Netboot.BootFileSelector,
 				},
 				IPAddr:  tt.server.IPAddr,
 				Backend: tt.server.Backend,
 			}
-			gotFunc := s.setNetworkBootOpts(tt.args.in0, tt.args.m, tt.args.n)
+			gotFunc := s.SetNetworkBootOpts(tt.args.in0, tt.args.m, tt.args.d, tt.args.n)
 			got := new(dhcpv4.DHCPv4)
 			gotFunc(got)
 			if diff := cmp.Diff(tt.want, got); diff != "" {
@@ -335,3 +656,9 @@ func TestSetNetworkBootOpts(t *testing.T) {
 		})
 	}
 }
+
+func TestArchToBootFile(t *testing.T) {
+	if diff := cmp.Diff(ArchToBootFile, map[iana.Arch]string(DefaultBootFiles())); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,241 @@
+package reservation
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+const (
+	// minMaxMessageSize is the maximum DHCP message size that every client must accept,
+	// the 576 byte datagram of https://www.rfc-editor.org/rfc/rfc2131#section-2 without the IP and UDP headers.
+	minMaxMessageSize = 548
+	// ipUDPHeaderLen is the length of the IP and UDP headers, that are part of the maximum DHCP message size of option 57.
+	// See https://www.rfc-editor.org/rfc/rfc2132#section-9.10.
+	ipUDPHeaderLen = 28
+	// fixedLen is the length of the fixed DHCP header fields and the magic cookie.
+	fixedLen = 240
+	// bootpMinLen is the minimum length of a BOOTP message, replies are padded to this length.
+	bootpMinLen = 300
+	// offsets and lengths of the sname and file DHCP header fields.
+	snameOffset = 44
+	snameLen    = 64
+	fileOffset  = 108
+	fileLen     = 128
+
+	// values of DHCP option 52, https://www.rfc-editor.org/rfc/rfc2132#section-9.3.
+	overloadFile  = 1
+	overloadSname = 2
+)
+
+// DefaultAlwaysSend are the options sent to a client even when it doesn't request them in option 55.
+// 1 (subnet mask) and 3 (router) are needed by every client, 43, 60 and 97 by PXE clients.
+var DefaultAlwaysSend = []dhcpv4.OptionCode{
+	dhcpv4.OptionSubnetMask,
+	dhcpv4.OptionRouter,
+	dhcpv4.OptionVendorSpecificInformation,
+	dhcpv4.OptionClassIdentifier,
+	dhcpv4.OptionClientMachineIdentifier,
+}
+
+// requiredOptions are always sent when they are set in a reply, regardless of option 55 and the always send options.
+var requiredOptions = map[uint8]bool{
+	dhcpv4.OptionDHCPMessageType.Code():       true,
+	dhcpv4.OptionServerIdentifier.Code():      true,
+	dhcpv4.OptionIPAddressLeaseTime.Code():    true,
+	dhcpv4.OptionRenewTimeValue.Code():        true,
+	dhcpv4.OptionRebindingTimeValue.Code():    true,
+	dhcpv4.OptionMessage.Code():               true,
+	dhcpv4.OptionRelayAgentInformation.Code(): true,
+}
+
+// EncodeReply returns the wire format of reply, shaped for the client that sent req.
+//
+// When req has a parameter request list (option 55), only the requested options, the options in alwaysSend
+// and options the server must always send (53, 54, 51, 58, 59, 56 and 82) are included.
+// Requested options are encoded in the order the client requested them, see https://www.rfc-editor.org/rfc/rfc2132#section-9.8.
+// Without option 55, all options in reply are included.
+//
+// The reply is at most as large as the maximum DHCP message size (option 57) of req without the IP and UDP headers, or 548 bytes.
+// Options that don't fit in the options field are put in the file and sname header fields, when they are not used,
+// and option 52 (option overload) is set. See https://www.rfc-editor.org/rfc/rfc2131#section-4.1.
+// Options that don't fit at all are left out and returned.
+func EncodeReply(req, reply *dhcpv4.DHCPv4, alwaysSend []dhcpv4.OptionCode) ([]byte, []dhcpv4.OptionCode) {
+	maxSize := minMaxMessageSize
+	if req != nil {
+		if s, err := dhcpv4.GetUint16(dhcpv4.OptionMaximumDHCPMessageSize, req.Options); err == nil && int(s)-ipUDPHeaderLen > maxSize {
+			maxSize = int(s) - ipUDPHeaderLen
+		}
+	}
+
+	// the fixed header fields and the magic cookie, without options.
+	hdr := *reply
+	hdr.Options = dhcpv4.Options{}
+	b := hdr.ToBytes()[:fixedLen]
+
+	// encode all options in order, the option end is added below.
+	var opts []encodedOpt
+	for _, c := range replyOptionOrder(req, reply, alwaysSend) {
+		opts = append(opts, encodedOpt{code: c, b: encodeOpt(c, reply.Options[c])})
+	}
+	total := 0
+	for _, o := range opts {
+		total += len(o.b)
+	}
+	if fixedLen+total+1 <= maxSize {
+		for _, o := range opts {
+			b = append(b, o.b...)
+		}
+		return pad(append(b, dhcpv4.OptionEnd.Code())), nil
+	}
+
+	// options overflow into the file and sname fields, in that order.
+	// The option overload option itself must be in the options field.
+	fields := []*field{{capacity: maxSize - fixedLen - 1 - 3}}
+	if reply.BootFileName == "" {
+		fields = append(fields, &field{offset: fileOffset, capacity: fileLen - 1, overload: overloadFile})
+	}
+	if reply.ServerHostName == "" {
+		fields = append(fields, &field{offset: snameOffset, capacity: snameLen - 1, overload: overloadSname})
+	}
+	var dropped []dhcpv4.OptionCode
+	for _, o := range opts {
+		placed := false
+		for _, f := range fields {
+			if len(f.b)+len(o.b) <= f.capacity {
+				f.b = append(f.b, o.b...)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			dropped = append(dropped, dhcpv4.GenericOptionCode(o.code))
+		}
+	}
+
+	var overload uint8
+	for _, f := range fields[1:] {
+		if len(f.b) == 0 {
+			continue
+		}
+		overload |= f.overload
+		field := b[f.offset : f.offset+f.capacity+1]
+		clear(field)
+		copy(field, append(f.b, dhcpv4.OptionEnd.Code()))
+	}
+	b = append(b, fields[0].b...)
+	if overload != 0 {
+		b = append(b, dhcpv4.OptionOptionOverload.Code(), 1, overload)
+	}
+
+	return pad(append(b, dhcpv4.OptionEnd.Code())), dropped
+}
+
+// withCustomOptions returns always with the codes of the valid custom options of d.
+// Custom options are set for a host on purpose, so they are sent even when the client doesn't request them in option 55.
+func withCustomOptions(always []dhcpv4.OptionCode, d *data.DHCP) []dhcpv4.OptionCode {
+	if d == nil || len(d.Options) == 0 {
+		return always
+	}
+	codes := slices.Clone(always)
+	for _, c := range d.OptionCodes() {
+		if d.Options[c].Validate(c) == nil {
+			codes = append(codes, dhcpv4.GenericOptionCode(c))
+		}
+	}
+
+	return codes
+}
+
+// encodedOpt is a single option in wire format.
+type encodedOpt struct {
+	code uint8
+	b    []byte
+}
+
+// field is a part of a DHCP message that options are encoded in.
+type field struct {
+	offset   int
+	capacity int
+	overload uint8
+	b        []byte
+}
+
+// replyOptionOrder returns the codes of the options in reply that are sent to the client, in the order they are encoded.
+// The message type is first, then the options requested in option 55 in the requested order,
+// then the other options in code order, and the relay agent information last.
+func replyOptionOrder(req, reply *dhcpv4.DHCPv4, alwaysSend []dhcpv4.OptionCode) []uint8 {
+	var prl dhcpv4.OptionCodeList
+	if req != nil {
+		prl = req.ParameterRequestList()
+	}
+	send := func(c uint8) bool { return true }
+	if len(prl) > 0 {
+		allowed := make(map[uint8]bool)
+		for _, c := range prl {
+			allowed[c.Code()] = true
+		}
+		for _, c := range alwaysSend {
+			allowed[c.Code()] = true
+		}
+		send = func(c uint8) bool { return allowed[c] || requiredOptions[c] }
+	}
+
+	seen := make(map[uint8]bool)
+	var order []uint8
+	add := func(c uint8) {
+		if seen[c] || c == dhcpv4.OptionRelayAgentInformation.Code() || c == dhcpv4.OptionOptionOverload.Code() || c == dhcpv4.OptionPad.Code() || c == dhcpv4.OptionEnd.Code() {
+			return
+		}
+		if _, ok := reply.Options[c]; !ok || !send(c) {
+			return
+		}
+		seen[c] = true
+		order = append(order, c)
+	}
+	add(dhcpv4.OptionDHCPMessageType.Code())
+	for _, c := range prl {
+		add(c.Code())
+	}
+	rest := make([]uint8, 0, len(reply.Options))
+	for c := range reply.Options {
+		rest = append(rest, c)
+	}
+	sort.Slice(rest, func(i, j int) bool { return rest[i] < rest[j] })
+	for _, c := range rest {
+		add(c)
+	}
+	if _, ok := reply.Options[dhcpv4.OptionRelayAgentInformation.Code()]; ok {
+		order = append(order, dhcpv4.OptionRelayAgentInformation.Code())
+	}
+
+	return order
+}
+
+// encodeOpt returns the wire format of a single option.
+// Values longer than 255 bytes are split into multiple options, see https://www.rfc-editor.org/rfc/rfc3396.
+func encodeOpt(code uint8, v []byte) []byte {
+	if len(v) == 0 {
+		return []byte{code, 0}
+	}
+	var b []byte
+	for len(v) > 0 {
+		n := min(len(v), 255)
+		b = append(b, code, uint8(n))
+		b = append(b, v[:n]...)
+		v = v[n:]
+	}
+
+	return b
+}
+
+// pad pads b with the pad option to the minimum BOOTP message length.
+func pad(b []byte) []byte {
+	for len(b) < bootpMinLen {
+		b = append(b, dhcpv4.OptionPad.Code())
+	}
+
+	return b
+}
@@ -0,0 +1,224 @@
+package reservation
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+func TestEncodeReply(t *testing.T) {
+	reply := &dhcpv4.DHCPv4{
+		OpCode:       dhcpv4.OpcodeBootReply,
+		ClientHWAddr: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		Options: dhcpv4.OptionsFromList(
+			dhcpv4.OptMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.OptServerIdentifier(net.IP{192, 168, 2, 1}),
+			dhcpv4.OptIPAddressLeaseTime(3600e9),
+			dhcpv4.OptSubnetMask(net.IPMask{255, 255, 255, 0}),
+			dhcpv4.OptRouter(net.IP{192, 168, 2, 1}),
+			dhcpv4.OptDNS(net.IP{1, 1, 1, 1}),
+			dhcpv4.OptHostName("sm01"),
+			dhcpv4.OptDomainName("example.com"),
+			dhcpv4.OptNTPServers(net.IP{132, 163, 96, 2}),
+			dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0"))),
+		),
+	}
+	tests := map[string]struct {
+		prl        []dhcpv4.OptionCode
+		alwaysSend []dhcpv4.OptionCode
+		want       []uint8
+	}{
+		"no parameter request list": {want: []uint8{53, 1, 3, 6, 12, 15, 42, 51, 54, 82}},
+		"requested order": {
+			prl:  []dhcpv4.OptionCode{dhcpv4.OptionDomainNameServer, dhcpv4.OptionRouter, dhcpv4.OptionSubnetMask, dhcpv4.OptionHostName},
+			want: []uint8{53, 6, 3, 1, 12, 51, 54, 82},
+		},
+		"always send": {
+			prl:        []dhcpv4.OptionCode{dhcpv4.OptionHostName},
+			alwaysSend: []dhcpv4.OptionCode{dhcpv4.OptionDomainName, dhcpv4.OptionSubnetMask},
+			want:       []uint8{53, 12, 1, 15, 51, 54, 82},
+		},
+		"requested options not in reply": {
+			prl:  []dhcpv4.OptionCode{dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName},
+			want: []uint8{53, 51, 54, 82},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &dhcpv4.DHCPv4{Options: dhcpv4.Options{}}
+			if tt.prl != nil {
+				req.UpdateOption(dhcpv4.OptParameterRequestList(tt.prl...))
+			}
+			b, dropped := EncodeReply(req, reply, tt.alwaysSend)
+			if dropped != nil {
+				t.Fatalf("dropped options: %v", dropped)
+			}
+			if diff := cmp.Diff(optionCodes(b[fixedLen:]), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			got, err := dhcpv4.FromBytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range tt.want {
+				if !bytes.Equal(got.Options.Get(dhcpv4.GenericOptionCode(c)), reply.Options.Get(dhcpv4.GenericOptionCode(c))) {
+					t.Fatalf("option %d = %v, want %v", c, got.Options.Get(dhcpv4.GenericOptionCode(c)), reply.Options.Get(dhcpv4.GenericOptionCode(c)))
+				}
+			}
+		})
+	}
+}
+
+func TestWithCustomOptions(t *testing.T) {
+	d := &data.DHCP{Options: map[uint8]data.Option{
+		150: {Type: data.OptionTypeIPList, Value: "192.168.2.1"},
+		252: {Type: data.OptionTypeString, Value: "http://wpad.example.com/wpad.dat"},
+		54:  {Type: data.OptionTypeIP, Value: "192.168.2.1"},
+	}}
+	reply := &dhcpv4.DHCPv4{
+		OpCode: dhcpv4.OpcodeBootReply,
+		Options: dhcpv4.OptionsFromList(
+			dhcpv4.OptMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.OptServerIdentifier(net.IP{192, 168, 2, 1}),
+			dhcpv4.OptSubnetMask(net.IPMask{255, 255, 255, 0}),
+			dhcpv4.OptHostName("sm01"),
+			dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(150), []byte{192, 168, 2, 1}),
+			dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(252), []byte("http://wpad.example.com/wpad.dat")),
+		),
+	}
+	req := &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(dhcpv4.OptParameterRequestList(dhcpv4.OptionHostName))}
+
+	// custom options are sent although the client doesn't request them, invalid ones aren't added.
+	always := withCustomOptions([]dhcpv4.OptionCode{dhcpv4.OptionSubnetMask}, d)
+	if diff := cmp.Diff(always, []dhcpv4.OptionCode{dhcpv4.OptionSubnetMask, dhcpv4.GenericOptionCode(150), dhcpv4.GenericOptionCode(252)}, cmp.Comparer(func(a, b dhcpv4.OptionCode) bool { return a.Code() == b.Code() })); diff != "" {
+		t.Fatal(diff)
+	}
+	b, _ := EncodeReply(req, reply, always)
+	if diff := cmp.Diff(optionCodes(b[fixedLen:]), []uint8{53, 12, 1, 54, 150, 252}); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := withCustomOptions(DefaultAlwaysSend, nil); len(got) != len(DefaultAlwaysSend) {
+		t.Fatalf("got %v, want %v", got, DefaultAlwaysSend)
+	}
+}
+
+func TestEncodeReplySize(t *testing.T) {
+	// every large option is 92 bytes encoded, 4 of them don't fit in the options field of a 548 byte message.
+	large := func(code uint8) dhcpv4.Option {
+		return dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), bytes.Repeat([]byte{code}, 90))
+	}
+	tests := map[string]struct {
+		maxSize      uint16
+		bootFileName string
+		serverName   string
+		wantMain     []uint8
+		wantFile     []uint8
+		wantOverload uint8
+		wantDropped  []dhcpv4.OptionCode
+	}{
+		"fits in maximum message size": {maxSize: 1500, wantMain: []uint8{53, 54, 224, 225, 226, 227}},
+		"overflow into file": {
+			wantMain:     []uint8{53, 54, 224, 225, 226, 52},
+			wantFile:     []uint8{227},
+			wantOverload: overloadFile,
+		},
+		"maximum message size includes IP and UDP headers": {
+			maxSize:      620,
+			wantMain:     []uint8{53, 54, 224, 225, 226, 52},
+			wantFile:     []uint8{227},
+			wantOverload: overloadFile,
+		},
+		"maximum message size below 576": {
+			maxSize:      400,
+			wantMain:     []uint8{53, 54, 224, 225, 226, 52},
+			wantFile:     []uint8{227},
+			wantOverload: overloadFile,
+		},
+		"file used, sname too small": {
+			bootFileName: "ipxe.efi",
+			wantMain:     []uint8{53, 54, 224, 225, 226},
+			wantDropped:  []dhcpv4.OptionCode{dhcpv4.GenericOptionCode(227)},
+		},
+		"file and sname used": {
+			bootFileName: "ipxe.efi",
+			serverName:   "boot",
+			wantMain:     []uint8{53, 54, 224, 225, 226},
+			wantDropped:  []dhcpv4.OptionCode{dhcpv4.GenericOptionCode(227)},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &dhcpv4.DHCPv4{Options: dhcpv4.Options{}}
+			if tt.maxSize != 0 {
+				req.UpdateOption(dhcpv4.OptMaxMessageSize(tt.maxSize))
+			}
+			reply := &dhcpv4.DHCPv4{
+				OpCode:         dhcpv4.OpcodeBootReply,
+				BootFileName:   tt.bootFileName,
+				ServerHostName: tt.serverName,
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer),
+					dhcpv4.OptServerIdentifier(net.IP{192, 168, 2, 1}),
+					large(224), large(225), large(226), large(227),
+				),
+			}
+			b, dropped := EncodeReply(req, reply, nil)
+			limit := int(tt.maxSize) - ipUDPHeaderLen
+			if limit < minMaxMessageSize {
+				limit = minMaxMessageSize
+			}
+			if len(b) > limit {
+				t.Fatalf("reply is %d bytes, want at most %d", len(b), limit)
+			}
+			if diff := cmp.Diff(dropped, tt.wantDropped); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(optionCodes(b[fixedLen:]), tt.wantMain); diff != "" {
+				t.Fatal(diff)
+			}
+			got, err := dhcpv4.FromBytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var overload uint8
+			if v := got.Options.Get(dhcpv4.OptionOptionOverload); len(v) == 1 {
+				overload = v[0]
+			}
+			if overload != tt.wantOverload {
+				t.Fatalf("option overload = %d, want %d", overload, tt.wantOverload)
+			}
+			if tt.wantFile != nil {
+				if diff := cmp.Diff(optionCodes(b[fileOffset:fileOffset+fileLen]), tt.wantFile); diff != "" {
+					t.Fatal(diff)
+				}
+			} else if got.BootFileName != tt.bootFileName {
+				t.Fatalf("file = %q, want %q", got.BootFileName, tt.bootFileName)
+			}
+			if got.ServerHostName != tt.serverName {
+				t.Fatalf("sname = %q, want %q", got.ServerHostName, tt.serverName)
+			}
+		})
+	}
+}
+
+// optionCodes returns the codes of the options in b, in order, until the end option.
+func optionCodes(b []byte) []uint8 {
+	var codes []uint8
+	for i := 0; i < len(b); {
+		switch c := b[i]; c {
+		case dhcpv4.OptionEnd.Code():
+			return codes
+		case dhcpv4.OptionPad.Code():
+			i++
+		default:
+			codes = append(codes, c)
+			i += 2 + int(b[i+1])
+		}
+	}
+
+	return codes
+}
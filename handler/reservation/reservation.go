@@ -7,7 +7,9 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/tinkerbell/dhcp/handler"
+	"github.com/tinkerbell/dhcp/metrics"
 )
 
 // Handler holds the configuration details for the running the DHCP server.
@@ -35,6 +37,22 @@ type Handler struct {
 
 	// SyslogAddr is the address to send syslog messages to. DHCP Option 7.
 	SyslogAddr netip.Addr
+
+	// Leases is the store that acknowledged, released and declined leases are recorded in.
+	// When nil, leases are not recorded.
+	Leases handler.LeaseStore
+
+	// Metrics records hardware lookups, replies sent and netboot decisions.
+	// When nil, no metrics are recorded.
+	Metrics *metrics.Metrics
+
+	// AlwaysSend are the DHCP options sent to a client even when it doesn't request them in option 55.
+	// When nil, DefaultAlwaysSend is used. See EncodeReply for how replies are shaped.
+	AlwaysSend []dhcpv4.OptionCode
+
+	// DUID is the server identifier used in DHCPv6 replies, DHCPv6 option 2.
+	// It is required to respond to DHCPv6 messages, see Handle6.
+	DUID dhcpv6.DUID
 }
 
 // Netboot holds the netboot configuration details used in running a DHCP server.
@@ -48,9 +66,25 @@ type Netboot struct {
 	// IPXEScriptURL is the URL to the IPXE script to use.
 	IPXEScriptURL func(*dhcpv4.DHCPv4) *url.URL
 
+	// IPXEScriptURL6 is the URL to the IPXE script to use for DHCPv6 clients.
+	IPXEScriptURL6 func(*dhcpv6.Message) *url.URL
+
+	// IPXEScriptServer is the base URL of an ipxe.Handler serving the inline iPXE scripts of machines.
+	// When set, DHCPv4 clients with an inline script (data.Netboot.IPXEScript) and without their own script URL
+	// are pointed at their script on this server, instead of at IPXEScriptURL.
+	IPXEScriptServer *url.URL
+
 	// Enabled is whether to enable sending netboot DHCP options.
 	Enabled bool
 
 	// UserClass (for network booting) allows a custom DHCP option 77 to be used to break out of an iPXE loop.
 	UserClass UserClass
+
+	// BootFileSelector chooses the iPXE binary for network boot clients.
+	// When nil, the binary is chosen by architecture, see DefaultBootFiles.
+	BootFileSelector BootFileSelector
+
+	// SwitchProvisioning is whether to send the ONIE installer and ZTP script URLs of the backend to network switches.
+	// Switches are recognized by their vendor class, see SwitchVendor. It doesn't depend on Enabled.
+	SwitchProvisioning bool
 }
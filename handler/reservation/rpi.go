@@ -0,0 +1,72 @@
+package reservation
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// raspberryPiBoot is the PXE boot menu item that the Raspberry Pi boot ROM looks for in option 43.
+const raspberryPiBoot = "Raspberry Pi Boot"
+
+// archRPiBoot is the client architecture of the Raspberry Pi boot ROM,
+// see https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml#processor-architecture.
+const archRPiBoot iana.Arch = 41
+
+// raspberryPiOUIs are the MAC address prefixes of Raspberry Pi boards.
+var raspberryPiOUIs = [][]byte{
+	{0xb8, 0x27, 0xeb},
+	{0xdc, 0xa6, 0x32},
+	{0xe4, 0x5f, 0x01},
+	{0xd8, 0x3a, 0xdd},
+	{0x28, 0xcd, 0xc1},
+	{0x2c, 0xcf, 0x67},
+}
+
+// isRaspberryPi returns true if pkt is from the network boot ROM of a Raspberry Pi.
+// The boot ROM of the Raspberry Pi 3 and 4 sends the x86 BIOS architecture (0) in option 93,
+// so it is recognized by its MAC address. Clients that send the rpiboot architecture (41) are Raspberry Pis too.
+// A Raspberry Pi that runs UEFI firmware sends the EFI ARM64 architecture and is not a boot ROM client.
+func isRaspberryPi(pkt *dhcpv4.DHCPv4) bool {
+	// arch ignores architectures unknown to the iana package, like rpiboot, so option 93 is read here.
+	if slices.Contains(pkt.ClientArch(), archRPiBoot) {
+		return true
+	}
+	if arch(pkt) != iana.INTEL_X86PC || len(pkt.ClientHWAddr) < 3 {
+		return false
+	}
+	for _, oui := range raspberryPiOUIs {
+		if bytes.Equal(pkt.ClientHWAddr[:3], oui) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setRaspberryPiOpts sets the options that the Raspberry Pi boot ROM requires to network boot.
+// The boot ROM only boots when option 43 has a PXE boot menu item of "Raspberry Pi Boot".
+// It loads its firmware files over TFTP, from the server in option 66, or the next server when option 66 is not set.
+// No boot file is sent, the boot ROM chooses the files itself.
+func setRaspberryPiOpts(reply *dhcpv4.DHCPv4, tftp netip.AddrPort) {
+	pxe := dhcpv4.Options{ // suboptions of option 43, see the PXE specification, section 2.4.
+		// PXE Boot Server Discovery Control - disable broadcast and multicast discovery.
+		6: []byte{3},
+		// PXE Boot Menu - a single item of boot server type 0.
+		9: append([]byte{0, 0, byte(len(raspberryPiBoot))}, raspberryPiBoot...),
+		// PXE Boot Prompt - no timeout.
+		10: append([]byte{0}, "PXE"...),
+	}
+	reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, pxe.ToBytes()))
+	reply.BootFileName = ""
+	if tftp.Addr().IsValid() {
+		reply.ServerIPAddr = net.IP(tftp.Addr().AsSlice())
+		reply.UpdateOption(dhcpv4.OptTFTPServerName(tftp.Addr().String()))
+	}
+	// the boot ROM ignores replies without the PXEClient vendor class.
+	reply.UpdateOption(dhcpv4.OptClassIdentifier(string(pxeClient)))
+}
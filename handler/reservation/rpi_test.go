@@ -0,0 +1,31 @@
+package reservation
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestIsRaspberryPi(t *testing.T) {
+	pi := net.HardwareAddr{0xe4, 0x5f, 0x01, 0x04, 0x05, 0x06}
+	tests := map[string]struct {
+		mac  net.HardwareAddr
+		arch iana.Arch
+		want bool
+	}{
+		"boot rom":         {mac: pi, arch: iana.INTEL_X86PC, want: true},
+		"rpiboot":          {mac: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, arch: archRPiBoot, want: true},
+		"uefi firmware":    {mac: pi, arch: iana.EFI_ARM64},
+		"other x86 client": {mac: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, arch: iana.INTEL_X86PC},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt := &dhcpv4.DHCPv4{ClientHWAddr: tt.mac, Options: dhcpv4.OptionsFromList(dhcpv4.OptClientArch(tt.arch))}
+			if got := isRaspberryPi(pkt); got != tt.want {
+				t.Fatalf("isRaspberryPi() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package reservation
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+// SwitchVendor is the provisioning method of a network switch client.
+type SwitchVendor string
+
+// known switch provisioning methods.
+const (
+	// ONIE is the Open Network Install Environment of whitebox switches.
+	// ONIE clients get the URL of their installer in DHCP option 114, see https://opencomputeproject.github.io/onie/design-spec/discovery.html.
+	ONIE SwitchVendor = "onie"
+	// Arista is Arista EOS Zero Touch Provisioning.
+	Arista SwitchVendor = "arista"
+	// Cisco is Cisco Zero Touch Provisioning and Power On Auto Provisioning.
+	Cisco SwitchVendor = "cisco"
+)
+
+// IANA private enterprise numbers of the switch vendors, sent in DHCP option 124 and 125.
+// https://www.iana.org/assignments/enterprise-numbers/
+const (
+	enterpriseCisco  uint32 = 9
+	enterpriseArista uint32 = 30065
+	enterpriseONIE   uint32 = 42623
+)
+
+// onieUserClass is the DHCP option 77 that ONIE sends.
+const onieUserClass = "onie_dhcp_user_class"
+
+// switchVendorClasses maps the start of DHCP option 60 to the switch provisioning method.
+var switchVendorClasses = []struct {
+	prefix string
+	vendor SwitchVendor
+}{
+	{prefix: "onie_vendor:", vendor: ONIE},
+	{prefix: "Arista", vendor: Arista},
+	{prefix: "ciscopnp", vendor: Cisco},
+	{prefix: "Cisco", vendor: Cisco},
+}
+
+// switchEnterprises maps the enterprise numbers of DHCP option 124 and 125 to the switch provisioning method.
+var switchEnterprises = map[uint32]SwitchVendor{
+	enterpriseONIE:   ONIE,
+	enterpriseArista: Arista,
+	enterpriseCisco:  Cisco,
+}
+
+// switchVendor returns the provisioning method of a network switch client.
+// Switches are recognized by DHCP option 60, 77, 124 or 125. It returns false if pkt is not from a switch.
+func switchVendor(pkt *dhcpv4.DHCPv4) (SwitchVendor, bool) {
+	opt60 := string(pkt.GetOneOption(dhcpv4.OptionClassIdentifier))
+	for _, c := range switchVendorClasses {
+		if strings.HasPrefix(opt60, c.prefix) {
+			return c.vendor, true
+		}
+	}
+	if strings.Contains(string(pkt.GetOneOption(dhcpv4.OptionUserClassInformation)), onieUserClass) {
+		return ONIE, true
+	}
+	for _, code := range []dhcpv4.OptionCode{dhcpv4.OptionVendorIdentifyingVendorClass, dhcpv4.OptionVendorIdentifyingVendorSpecific} {
+		for _, e := range enterprises(pkt.GetOneOption(code)) {
+			if v, ok := switchEnterprises[e]; ok {
+				return v, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// enterprises returns the enterprise numbers in a V-I vendor class (option 124) or V-I vendor-specific information (option 125).
+// Both are a list of a 4 byte enterprise number, a 1 byte data length and the data, see https://www.rfc-editor.org/rfc/rfc3925.
+func enterprises(b []byte) []uint32 {
+	var es []uint32
+	for len(b) >= 5 {
+		es = append(es, binary.BigEndian.Uint32(b))
+		l := int(b[4])
+		if len(b) < 5+l {
+			break
+		}
+		b = b[5+l:]
+	}
+
+	return es
+}
+
+// isSwitchClient returns the provisioning method of pkt when switch provisioning is enabled and pkt is from a network switch.
+func (h *Handler) isSwitchClient(pkt *dhcpv4.DHCPv4) (SwitchVendor, bool) {
+	if !h.Netboot.SwitchProvisioning {
+		return "", false
+	}
+	if pkt.MessageType() != dhcpv4.MessageTypeDiscover && pkt.MessageType() != dhcpv4.MessageTypeRequest {
+		return "", false
+	}
+
+	return switchVendor(pkt)
+}
+
+// setSwitchOpts returns a modifier that sets the provisioning options of a network switch.
+// ONIE clients get the ONIE installer URL in option 114.
+// Zero Touch Provisioning clients get the ZTP script URL in option 67 and the boot file name header,
+// for TFTP URLs the server is sent in option 66 and the path in option 67.
+// Nothing is set when netboot is not allowed or the backend has no URL for the switch.
+func (h *Handler) setSwitchOpts(v SwitchVendor, n *data.Netboot) dhcpv4.Modifier {
+	return func(reply *dhcpv4.DHCPv4) {
+		if !n.AllowNetboot {
+			return
+		}
+		switch v {
+		case ONIE:
+			if n.ONIEInstallerURL == nil {
+				h.Log.Info("no ONIE installer URL", "mac", reply.ClientHWAddr.String())
+				return
+			}
+			reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionURL, []byte(n.ONIEInstallerURL.String())))
+		default:
+			if n.ZTPScriptURL == nil {
+				h.Log.Info("no ZTP script URL", "mac", reply.ClientHWAddr.String(), "vendor", v)
+				return
+			}
+			script := n.ZTPScriptURL.String()
+			if n.ZTPScriptURL.Scheme == "tftp" {
+				script = strings.TrimPrefix(n.ZTPScriptURL.Path, "/")
+				reply.UpdateOption(dhcpv4.OptTFTPServerName(n.ZTPScriptURL.Host))
+			}
+			reply.BootFileName = script
+			reply.UpdateOption(dhcpv4.OptBootFileName(script))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package reservation
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+func TestSwitchVendor(t *testing.T) {
+	tests := map[string]struct {
+		opts   []dhcpv4.Option
+		want   SwitchVendor
+		wantOK bool
+	}{
+		"onie vendor class": {opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("onie_vendor:x86_64-accton_as7712_32x-r0")}, want: ONIE, wantOK: true},
+		"onie user class":   {opts: []dhcpv4.Option{dhcpv4.OptGeneric(dhcpv4.OptionUserClassInformation, []byte("onie_dhcp_user_class"))}, want: ONIE, wantOK: true},
+		"arista":            {opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("Arista;DCS-7050SX3-48YC8;01.00;JPE12345678")}, want: Arista, wantOK: true},
+		"cisco pnp":         {opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("ciscopnp")}, want: Cisco, wantOK: true},
+		"cisco option 124":  {opts: []dhcpv4.Option{dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorClass, []byte{0, 0, 0, 9, 3, 'N', '9', 'K'})}, want: Cisco, wantOK: true},
+		"onie option 125":   {opts: []dhcpv4.Option{dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, []byte{0, 0, 0, 1, 0, 0, 0, 0xa6, 0x7f, 0})}, want: ONIE, wantOK: true},
+		"truncated 125":     {opts: []dhcpv4.Option{dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, []byte{0, 0, 0, 1, 9, 0})}},
+		"pxe client":        {opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003001")}},
+		"no vendor class":   {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := switchVendor(&dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(tt.opts...)})
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestSetSwitchOpts(t *testing.T) {
+	mac := net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	tests := map[string]struct {
+		vendor SwitchVendor
+		n      *data.Netboot
+		want   *dhcpv4.DHCPv4
+	}{
+		"onie": {
+			vendor: ONIE,
+			n:      &data.Netboot{AllowNetboot: true, ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.2.1", Path: "/onie-installer"}},
+			want: &dhcpv4.DHCPv4{ClientHWAddr: mac, Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionURL, []byte("http://192.168.2.1/onie-installer")),
+			)},
+		},
+		"arista http": {
+			vendor: Arista,
+			n:      &data.Netboot{AllowNetboot: true, ZTPScriptURL: &url.URL{Scheme: "http", Host: "192.168.2.1", Path: "/bootstrap"}},
+			want: &dhcpv4.DHCPv4{ClientHWAddr: mac, BootFileName: "http://192.168.2.1/bootstrap", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptBootFileName("http://192.168.2.1/bootstrap"),
+			)},
+		},
+		"cisco tftp": {
+			vendor: Cisco,
+			n:      &data.Netboot{AllowNetboot: true, ZTPScriptURL: &url.URL{Scheme: "tftp", Host: "192.168.2.1", Path: "/poap.py"}},
+			want: &dhcpv4.DHCPv4{ClientHWAddr: mac, BootFileName: "poap.py", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptTFTPServerName("192.168.2.1"),
+				dhcpv4.OptBootFileName("poap.py"),
+			)},
+		},
+		"not allowed": {
+			vendor: ONIE,
+			n:      &data.Netboot{ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.2.1", Path: "/onie-installer"}},
+			want:   &dhcpv4.DHCPv4{ClientHWAddr: mac, Options: dhcpv4.Options{}},
+		},
+		"no url": {
+			vendor: Arista,
+			n:      &data.Netboot{AllowNetboot: true, ONIEInstallerURL: &url.URL{Scheme: "http", Host: "192.168.2.1", Path: "/onie-installer"}},
+			want:   &dhcpv4.DHCPv4{ClientHWAddr: mac, Options: dhcpv4.Options{}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Log: logr.Discard()}
+			got := &dhcpv4.DHCPv4{ClientHWAddr: mac, Options: dhcpv4.Options{}}
+			h.setSwitchOpts(tt.vendor, tt.n)(got)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package ipxe serves the inline iPXE scripts of machines (data.Netboot.IPXEScript) over HTTP.
+//
+// Every script is served at /<MAC address>/auto.ipxe, for example /b4:96:91:6f:33:d0/auto.ipxe.
+// The DHCP handlers point clients that are already running iPXE at this URL, see ScriptURL.
+package ipxe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp/handler"
+)
+
+// ScriptName is the file name that scripts are served at.
+const ScriptName = "auto.ipxe"
+
+// Handler is an http.Handler that serves the inline iPXE script of a machine from a backend.
+type Handler struct {
+	// Backend is the backend to get the script of a machine from.
+	Backend handler.BackendReader
+
+	// Log is used to log requests that fail.
+	// `logr.Discard()` can be used if no logging is desired.
+	Log logr.Logger
+}
+
+// ScriptURL returns the URL that a Handler serving at base serves the script of mac at.
+func ScriptURL(base *url.URL, mac net.HardwareAddr) *url.URL {
+	return base.JoinPath(mac.String(), ScriptName)
+}
+
+// ServeHTTP serves the inline iPXE script of the machine with the MAC address in the request path.
+// Machines without a script, that are not allowed to netboot, or that are not in the backend get a 404.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Log.GetSink() == nil {
+		h.Log = logr.Discard()
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	mac, err := parsePath(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if h.Backend == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	script, err := h.script(r.Context(), mac)
+	if err != nil {
+		if hardwareNotFound(err) {
+			http.NotFound(w, r)
+			return
+		}
+		h.Log.Error(err, "failed to get iPXE script from backend", "mac", mac.String())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if script == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(script))
+}
+
+// script returns the inline iPXE script of mac, or an empty string when mac has none or is not allowed to netboot.
+func (h *Handler) script(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	_, n, err := h.Backend.GetByMac(ctx, mac)
+	if err != nil {
+		return "", err
+	}
+	if n == nil || !n.AllowNetboot {
+		return "", nil
+	}
+
+	return n.IPXEScript, nil
+}
+
+// parsePath returns the MAC address in a script path, /<MAC address>/auto.ipxe.
+func parsePath(p string) (net.HardwareAddr, error) {
+	dir, file, ok := strings.Cut(strings.Trim(p, "/"), "/")
+	if !ok || file != ScriptName {
+		return nil, errors.New("not a script path")
+	}
+
+	return net.ParseMAC(dir)
+}
+
+// hardwareNotFound returns true if err is from a backend that has no data for a machine.
+func hardwareNotFound(err error) bool {
+	type hardwareNotFound interface {
+		NotFound() bool
+	}
+	var te hardwareNotFound
+
+	return errors.As(err, &te) && te.NotFound()
+}
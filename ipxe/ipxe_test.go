@@ -0,0 +1,80 @@
+package ipxe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+type hwNotFoundError struct{}
+
+func (hwNotFoundError) NotFound() bool { return true }
+func (hwNotFoundError) Error() string  { return "not found" }
+
+type mockBackend struct {
+	err error
+	n   *data.Netboot
+}
+
+func (m *mockBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return &data.DHCP{}, m.n, m.err
+}
+
+func (m *mockBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func TestServeHTTP(t *testing.T) {
+	script := "#!ipxe\nchain http://boot.netboot.xyz"
+	tests := map[string]struct {
+		backend    *mockBackend
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		"script":              {backend: &mockBackend{n: &data.Netboot{AllowNetboot: true, IPXEScript: script}}, path: "/b4:96:91:6f:33:d0/auto.ipxe", wantStatus: http.StatusOK, wantBody: script},
+		"netboot not allowed": {backend: &mockBackend{n: &data.Netboot{IPXEScript: script}}, path: "/b4:96:91:6f:33:d0/auto.ipxe", wantStatus: http.StatusNotFound},
+		"no script":           {backend: &mockBackend{n: &data.Netboot{AllowNetboot: true}}, path: "/b4:96:91:6f:33:d0/auto.ipxe", wantStatus: http.StatusNotFound},
+		"hardware not found":  {backend: &mockBackend{err: hwNotFoundError{}}, path: "/b4:96:91:6f:33:d0/auto.ipxe", wantStatus: http.StatusNotFound},
+		"backend error":       {backend: &mockBackend{err: errors.New("boom")}, path: "/b4:96:91:6f:33:d0/auto.ipxe", wantStatus: http.StatusInternalServerError},
+		"invalid mac":         {backend: &mockBackend{}, path: "/b4:96:91/auto.ipxe", wantStatus: http.StatusNotFound},
+		"wrong file name":     {backend: &mockBackend{}, path: "/b4:96:91:6f:33:d0/boot.ipxe", wantStatus: http.StatusNotFound},
+		"wrong method":        {backend: &mockBackend{}, method: http.MethodPost, path: "/b4:96:91:6f:33:d0/auto.ipxe", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Backend: tt.backend, Log: logr.Discard()}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(method, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if diff := cmp.Diff(w.Body.String(), tt.wantBody); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestScriptURL(t *testing.T) {
+	base := &url.URL{Scheme: "http", Host: "192.168.2.1:8080"}
+	got := ScriptURL(base, net.HardwareAddr{0xb4, 0x96, 0x91, 0x6f, 0x33, 0xd0})
+	if diff := cmp.Diff(got.String(), "http://192.168.2.1:8080/b4:96:91:6f:33:d0/auto.ipxe"); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,426 @@
+// Package lease records the leases handed out by the DHCP handlers so that they survive restarts
+// and can be looked up later, for example to answer which client had an IP address at a given time.
+package lease
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const tracerName = "github.com/tinkerbell/dhcp"
+
+// Errors used by the lease journal.
+var (
+	errLeaseNotFound = leaseNotFoundError{}
+	errClosed        = errors.New("lease journal is closed")
+	errInvalidLease  = errors.New("lease must have a MAC address and an IP address")
+)
+
+// leaseNotFoundError is returned when no lease is found in the journal.
+// It implements the NotFound() method that handlers use to tell a missing lease apart from a failing store.
+type leaseNotFoundError struct{}
+
+func (leaseNotFoundError) NotFound() bool { return true }
+
+func (leaseNotFoundError) Error() string { return "lease not found" }
+
+// record is the structure of a single line in the journal file.
+type record struct {
+	MACAddress string          `json:"macAddress"`
+	IPAddress  netip.Addr      `json:"ipAddress"`
+	Hostname   string          `json:"hostname,omitempty"`
+	State      data.LeaseState `json:"state"`
+	Expires    time.Time       `json:"expires"`
+	Updated    time.Time       `json:"updated"`
+}
+
+// Journal is a lease store that appends every lease to a file, one JSON object per line.
+// The file is replayed into memory when the Journal is opened, so lookups never read the file.
+type Journal struct {
+	// Log is the logger to be used in the Journal.
+	Log logr.Logger
+
+	// wmu serializes writes to the journal file, it is held while a lease is synced to disk.
+	// When both are held, wmu is locked before mu.
+	wmu  sync.Mutex
+	file *os.File // protected by wmu.
+
+	mu    sync.RWMutex // protects all fields below.
+	path  string
+	log   []*data.Lease        // all leases in the order they were recorded.
+	byMAC map[string][]int     // MAC address string to indexes into log.
+	byIP  map[netip.Addr][]int // IP address to indexes into log.
+}
+
+// NewJournal opens the journal file at path, creating it if it doesn't exist, and replays it into memory.
+// A malformed line, such as a partial write from a crash, is logged and skipped.
+func NewJournal(l logr.Logger, path string) (*Journal, error) {
+	if l.GetSink() == nil {
+		l = logr.Discard()
+	}
+	j := &Journal{Log: l, path: filepath.Clean(path)}
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+
+	return j, nil
+}
+
+// replay reads all leases from the journal file into memory.
+func (j *Journal) replay() error {
+	j.reset()
+	b, err := os.ReadFile(j.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			j.Log.Info("skipping malformed lease journal line", "file", j.path, "line", line, "error", err)
+			continue
+		}
+		l, err := r.lease()
+		if err != nil {
+			j.Log.Info("skipping malformed lease journal line", "file", j.path, "line", line, "error", err)
+			continue
+		}
+		j.add(l)
+	}
+
+	return s.Err()
+}
+
+// reset clears the in memory leases.
+func (j *Journal) reset() {
+	j.log = nil
+	j.byMAC = make(map[string][]int)
+	j.byIP = make(map[netip.Addr][]int)
+}
+
+// add appends l to the in memory leases.
+func (j *Journal) add(l *data.Lease) {
+	j.log = append(j.log, l)
+	i := len(j.log) - 1
+	j.byMAC[l.MACAddress.String()] = append(j.byMAC[l.MACAddress.String()], i)
+	j.byIP[l.IPAddress] = append(j.byIP[l.IPAddress], i)
+}
+
+// Put is the implementation of the handler.LeaseStore interface.
+// The lease is written to the journal file before it is visible to lookups.
+// A zero Updated time is set to the current time.
+//
+// Put returns after the journal file is synced to disk, so a recorded lease survives a crash.
+// Puts are serialized and every one of them waits for an fsync, which takes from well under a millisecond
+// to tens of milliseconds depending on the disk. This bounds the number of leases recorded per second,
+// but lookups don't wait for a sync.
+func (j *Journal) Put(ctx context.Context, l *data.Lease) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "lease.journal.Put")
+	defer span.End()
+
+	if l == nil || len(l.MACAddress) == 0 || !l.IPAddress.IsValid() {
+		span.SetStatus(codes.Error, errInvalidLease.Error())
+		return errInvalidLease
+	}
+	c := *l
+	c.MACAddress = append(net.HardwareAddr(nil), l.MACAddress...)
+	c.IPAddress = l.IPAddress.Unmap()
+	if c.Updated.IsZero() {
+		c.Updated = time.Now()
+	}
+	span.SetAttributes(c.EncodeToAttributes()...)
+	b, err := json.Marshal(newRecord(&c))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	j.wmu.Lock()
+	defer j.wmu.Unlock()
+	if j.file == nil {
+		span.SetStatus(codes.Error, errClosed.Error())
+		return errClosed
+	}
+	if _, err := j.file.Write(append(b, '\n')); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to write lease journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to sync lease journal: %w", err)
+	}
+	j.mu.Lock()
+	j.add(&c)
+	j.mu.Unlock()
+	span.SetStatus(codes.Ok, "lease recorded")
+
+	return nil
+}
+
+// GetByMac is the implementation of the handler.LeaseStore interface.
+func (j *Journal) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.Lease, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "lease.journal.GetByMac")
+	defer span.End()
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	idx := j.byMAC[mac.String()]
+	if len(idx) == 0 {
+		span.SetStatus(codes.Error, errLeaseNotFound.Error())
+		return nil, errLeaseNotFound
+	}
+	l := *j.log[idx[len(idx)-1]]
+	span.SetAttributes(l.EncodeToAttributes()...)
+	span.SetStatus(codes.Ok, "lease found")
+
+	return &l, nil
+}
+
+// GetByIP is the implementation of the handler.LeaseStore interface.
+// The lease recorded last for ip before t is returned if it was active at t
+// and wasn't superseded by a later lease for the same client before t.
+func (j *Journal) GetByIP(ctx context.Context, ip net.IP, t time.Time) (*data.Lease, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "lease.journal.GetByIP")
+	defer span.End()
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		span.SetStatus(codes.Error, errLeaseNotFound.Error())
+		return nil, errLeaseNotFound
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if l := j.at(addr.Unmap(), t); l != nil {
+		c := *l
+		span.SetAttributes(c.EncodeToAttributes()...)
+		span.SetStatus(codes.Ok, "lease found")
+
+		return &c, nil
+	}
+	span.SetStatus(codes.Error, errLeaseNotFound.Error())
+
+	return nil, errLeaseNotFound
+}
+
+// at returns the lease that was active for ip at time t, or nil if there was none.
+// The Journal's mutex must be held when calling at.
+func (j *Journal) at(ip netip.Addr, t time.Time) *data.Lease {
+	idx := j.byIP[ip]
+	for k := len(idx) - 1; k >= 0; k-- {
+		l := j.log[idx[k]]
+		if l.Updated.After(t) {
+			continue
+		}
+		if !l.Active(t) {
+			return nil
+		}
+		// the client might have moved to a different address before t.
+		macIdx := j.byMAC[l.MACAddress.String()]
+		for m := len(macIdx) - 1; m >= 0 && macIdx[m] > idx[k]; m-- {
+			if !j.log[macIdx[m]].Updated.After(t) {
+				return nil
+			}
+		}
+
+		return l
+	}
+
+	return nil
+}
+
+// Compact rewrites the journal file without the leases that no longer matter after time before.
+// Lookups for any time after before return the same result as they did prior to compaction.
+func (j *Journal) Compact(ctx context.Context, before time.Time) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "lease.journal.Compact")
+	defer span.End()
+
+	// no leases are recorded while the journal is compacted, lookups only wait for the leases to be swapped.
+	j.wmu.Lock()
+	defer j.wmu.Unlock()
+	if j.file == nil {
+		span.SetStatus(codes.Error, errClosed.Error())
+		return errClosed
+	}
+	j.mu.RLock()
+	// Lookups for a time after before only ever need the leases recorded after before,
+	// and the last lease of every client and of every IP address recorded before it.
+	keepIdx := make([]bool, len(j.log))
+	seenMAC := make(map[string]bool)
+	seenIP := make(map[netip.Addr]bool)
+	for i := len(j.log) - 1; i >= 0; i-- {
+		l := j.log[i]
+		if l.Updated.After(before) {
+			keepIdx[i] = true
+			continue
+		}
+		if mac := l.MACAddress.String(); !seenMAC[mac] {
+			seenMAC[mac] = true
+			keepIdx[i] = true
+		}
+		if !seenIP[l.IPAddress] {
+			seenIP[l.IPAddress] = true
+			keepIdx[i] = true
+		}
+	}
+	var keep []*data.Lease
+	for i, l := range j.log {
+		if keepIdx[i] {
+			keep = append(keep, l)
+		}
+	}
+	j.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // the file doesn't exist anymore after a successful rename.
+	w := bufio.NewWriter(tmp)
+	for _, l := range keep {
+		b, err := json.Marshal(newRecord(l))
+		if err != nil {
+			tmp.Close()
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		_, _ = w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	// the new file is opened before it replaces the old one, so that the journal keeps a file to append to
+	// when either fails.
+	f, err := os.OpenFile(tmp.Name(), os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		f.Close()
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	// the rename is only durable once the directory is synced, a crash before could leave the old journal in place.
+	// The new file is the journal after the rename, so it is used even when the sync fails.
+	syncErr := syncDir(filepath.Dir(j.path))
+	j.file.Close()
+	j.file = f
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.reset()
+	for _, l := range keep {
+		j.add(l)
+	}
+	if syncErr != nil {
+		span.SetStatus(codes.Error, syncErr.Error())
+		return fmt.Errorf("failed to sync lease journal directory: %w", syncErr)
+	}
+	j.Log.V(1).Info("compacted lease journal", "file", j.path, "leases", len(keep))
+	span.SetStatus(codes.Ok, "lease journal compacted")
+
+	return nil
+}
+
+// Close closes the journal file. Leases can't be recorded after the Journal is closed.
+func (j *Journal) Close() error {
+	j.wmu.Lock()
+	defer j.wmu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+// syncDir syncs the directory at path, so that the files renamed in it survive a crash.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// newRecord returns the journal record for l.
+func newRecord(l *data.Lease) record {
+	return record{
+		MACAddress: l.MACAddress.String(),
+		IPAddress:  l.IPAddress,
+		Hostname:   l.Hostname,
+		State:      l.State,
+		Expires:    l.Expires.UTC(),
+		Updated:    l.Updated.UTC(),
+	}
+}
+
+// lease returns the lease for a journal record.
+func (r record) lease() (*data.Lease, error) {
+	mac, err := net.ParseMAC(r.MACAddress)
+	if err != nil {
+		return nil, err
+	}
+	if !r.IPAddress.IsValid() {
+		return nil, errInvalidLease
+	}
+	switch r.State {
+	case data.LeaseStateBound, data.LeaseStateReleased, data.LeaseStateDeclined:
+	default:
+		return nil, fmt.Errorf("unknown lease state %q", r.State)
+	}
+
+	return &data.Lease{
+		MACAddress: mac,
+		IPAddress:  r.IPAddress.Unmap(),
+		Hostname:   r.Hostname,
+		State:      r.State,
+		Expires:    r.Expires,
+		Updated:    r.Updated,
+	}, nil
+}
@@ -0,0 +1,196 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+var (
+	mac1 = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	mac2 = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+	ip1  = netip.MustParseAddr("192.168.2.10")
+	ip2  = netip.MustParseAddr("192.168.2.11")
+	t0   = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// history returns leases for two clients that move between two addresses.
+func history() []*data.Lease {
+	return []*data.Lease{
+		{MACAddress: mac1, IPAddress: ip1, Hostname: "one", State: data.LeaseStateBound, Updated: t0, Expires: t0.Add(time.Hour)},
+		{MACAddress: mac1, IPAddress: ip1, State: data.LeaseStateReleased, Updated: t0.Add(10 * time.Minute), Expires: t0.Add(10 * time.Minute)},
+		{MACAddress: mac2, IPAddress: ip1, State: data.LeaseStateBound, Updated: t0.Add(20 * time.Minute), Expires: t0.Add(80 * time.Minute)},
+		{MACAddress: mac2, IPAddress: ip2, State: data.LeaseStateBound, Updated: t0.Add(30 * time.Minute), Expires: t0.Add(90 * time.Minute)},
+		{MACAddress: mac1, IPAddress: ip2, State: data.LeaseStateDeclined, Updated: t0.Add(40 * time.Minute), Expires: t0.Add(40 * time.Minute)},
+	}
+}
+
+func newJournal(t *testing.T, leases ...*data.Lease) *Journal {
+	t.Helper()
+	j, err := NewJournal(logr.Discard(), filepath.Join(t.TempDir(), "leases.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	for _, l := range leases {
+		if err := j.Put(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return j
+}
+
+func TestGetByIP(t *testing.T) {
+	tests := map[string]struct {
+		ip      netip.Addr
+		at      time.Time
+		want    net.HardwareAddr
+		wantErr error
+	}{
+		"before any lease":             {ip: ip1, at: t0.Add(-time.Minute), wantErr: errLeaseNotFound},
+		"bound":                        {ip: ip1, at: t0.Add(5 * time.Minute), want: mac1},
+		"released":                     {ip: ip1, at: t0.Add(15 * time.Minute), wantErr: errLeaseNotFound},
+		"bound to another client":      {ip: ip1, at: t0.Add(25 * time.Minute), want: mac2},
+		"client moved to another ip":   {ip: ip1, at: t0.Add(35 * time.Minute), wantErr: errLeaseNotFound},
+		"declined by another client":   {ip: ip2, at: t0.Add(45 * time.Minute), wantErr: errLeaseNotFound},
+		"bound before decline":         {ip: ip2, at: t0.Add(35 * time.Minute), want: mac2},
+		"expired":                      {ip: ip2, at: t0.Add(100 * time.Minute), wantErr: errLeaseNotFound},
+		"unknown ip":                   {ip: netip.MustParseAddr("192.168.2.12"), at: t0, wantErr: errLeaseNotFound},
+		"ipv4 mapped ipv6 is the same": {ip: netip.AddrFrom16(ip1.As16()), at: t0, want: mac1},
+	}
+	j := newJournal(t, history()...)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := j.GetByIP(context.Background(), tt.ip.AsSlice(), tt.at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetByIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(got.MACAddress, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestGetByMac(t *testing.T) {
+	j := newJournal(t, history()...)
+	got, err := j.GetByMac(context.Background(), mac1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != data.LeaseStateDeclined || got.IPAddress != ip2 {
+		t.Fatalf("GetByMac() = %+v, want the declined lease for %v", got, ip2)
+	}
+	if _, err := j.GetByMac(context.Background(), net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}); !errors.Is(err, errLeaseNotFound) {
+		t.Fatalf("GetByMac() error = %v, wantErr %v", err, errLeaseNotFound)
+	}
+}
+
+func TestPut(t *testing.T) {
+	tests := map[string]struct {
+		lease   *data.Lease
+		wantErr error
+	}{
+		"valid":          {lease: &data.Lease{MACAddress: mac1, IPAddress: ip1, State: data.LeaseStateBound}},
+		"nil lease":      {wantErr: errInvalidLease},
+		"no mac address": {lease: &data.Lease{IPAddress: ip1}, wantErr: errInvalidLease},
+		"no ip address":  {lease: &data.Lease{MACAddress: mac1}, wantErr: errInvalidLease},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			j := newJournal(t)
+			if err := j.Put(context.Background(), tt.lease); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Put() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	j := newJournal(t)
+	j.Close()
+	if err := j.Put(context.Background(), history()[0]); !errors.Is(err, errClosed) {
+		t.Fatalf("Put() error = %v, wantErr %v", err, errClosed)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	j := newJournal(t, history()...)
+	j.Close()
+	// simulate a partial write from a crash.
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"macAddress":"00:01:02:03:04:05","ipAddr`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	got, err := NewJournal(logr.Discard(), j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close()
+	if diff := cmp.Diff(got.log, j.log, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	j := newJournal(t, history()...)
+	before := t0.Add(32 * time.Minute)
+	type lookup struct {
+		ip netip.Addr
+		at time.Time
+	}
+	want := map[lookup]error{}
+	for _, at := range []time.Time{before, t0.Add(35 * time.Minute), t0.Add(45 * time.Minute), t0.Add(85 * time.Minute)} {
+		for _, ip := range []netip.Addr{ip1, ip2} {
+			_, err := j.GetByIP(context.Background(), ip.AsSlice(), at)
+			want[lookup{ip: ip, at: at}] = err
+		}
+	}
+
+	if err := j.Compact(context.Background(), before); err != nil {
+		t.Fatal(err)
+	}
+	if len(j.log) != 4 {
+		t.Fatalf("expected 4 leases after compaction, got %d", len(j.log))
+	}
+	// leases recorded after the compaction are appended to the compacted file.
+	mac3 := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
+	if err := j.Put(context.Background(), &data.Lease{MACAddress: mac3, IPAddress: netip.MustParseAddr("192.168.2.3"), State: data.LeaseStateReleased, Updated: t0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// lookups after the compaction time must not change, even after a restart.
+	j.Close()
+	r, err := NewJournal(logr.Discard(), j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.GetByMac(context.Background(), mac3); err != nil {
+		t.Fatalf("lease recorded after compaction not found: %v", err)
+	}
+	for l, w := range want {
+		if _, err := r.GetByIP(context.Background(), l.ip.AsSlice(), l.at); !errors.Is(err, w) {
+			t.Fatalf("GetByIP(%v, %v) error = %v, want %v", l.ip, l.at, err, w)
+		}
+	}
+	if err := r.Put(context.Background(), history()[0]); err != nil {
+		t.Fatal(err)
+	}
+}
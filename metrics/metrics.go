@@ -0,0 +1,201 @@
+// Package metrics holds the Prometheus metrics for the DHCP server, handlers and backends.
+//
+// All methods are safe to call on a nil *Metrics, in which case they do nothing.
+// This allows components to record metrics without checking whether metrics are enabled.
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "dhcp"
+
+// Results of a hardware lookup.
+const (
+	ResultFound    = "found"
+	ResultNotFound = "not_found"
+	ResultError    = "error"
+)
+
+// Netboot decisions made for a netboot client.
+const (
+	// NetbootServed is the decision to send netboot options, including a boot file, to a client.
+	NetbootServed = "bootfile_served"
+	// NetbootNotAllowed is the decision not to netboot a client because its hardware data doesn't allow it.
+	NetbootNotAllowed = "netboot_not_allowed"
+)
+
+// Results of a reload of the data of a backend.
+const (
+	// ReloadSuccess is a reload of valid data, the backend serves it.
+	ReloadSuccess = "success"
+	// ReloadFailed is a reload of data that failed validation, the backend keeps serving its last valid data.
+	ReloadFailed = "failed"
+)
+
+// Metrics holds all Prometheus collectors.
+type Metrics struct {
+	packetsReceived *prometheus.CounterVec
+	packetsInvalid  prometheus.Counter
+	packetsDropped  prometheus.Counter
+	repliesSent     *prometheus.CounterVec
+	lookups         *prometheus.CounterVec
+	backendLookups  *prometheus.HistogramVec
+	netboot         *prometheus.CounterVec
+	reloads         *prometheus.CounterVec
+	records         *prometheus.GaugeVec
+	loaded          *prometheus.GaugeVec
+}
+
+// New creates all collectors and registers them with r.
+func New(r prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		packetsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_received_total",
+			Help:      "Number of DHCP messages received, by message type and interface.",
+		}, []string{"message_type", "interface"}),
+		packetsInvalid: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_invalid_total",
+			Help:      "Number of packets received that could not be parsed as a DHCP message.",
+		}),
+		packetsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_dropped_total",
+			Help:      "Number of DHCP messages dropped because the queue was full.",
+		}),
+		repliesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "replies_sent_total",
+			Help:      "Number of DHCP replies sent, by message type.",
+		}, []string{"message_type"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "hardware_lookups_total",
+			Help:      "Number of hardware lookups done by handlers, by result.",
+		}, []string{"result"}),
+		backendLookups: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_lookup_duration_seconds",
+			Help:      "Duration of backend lookups, by backend, method and result.",
+			Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}, []string{"backend", "method", "result"}),
+		netboot: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "netboot_decisions_total",
+			Help:      "Number of netboot decisions made for netboot clients, by decision.",
+		}, []string{"decision"}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_reloads_total",
+			Help:      "Number of reloads of the data of a backend, by backend and result.",
+		}, []string{"backend", "result"}),
+		records: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_records",
+			Help:      "Number of records in the data served by a backend, by backend.",
+		}, []string{"backend"}),
+		loaded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_data_loaded_timestamp_seconds",
+			Help:      "Unix time the data served by a backend was loaded, by backend.",
+		}, []string{"backend"}),
+	}
+	r.MustRegister(m.packetsReceived, m.packetsInvalid, m.packetsDropped, m.repliesSent, m.lookups, m.backendLookups, m.netboot, m.reloads, m.records, m.loaded)
+
+	return m
+}
+
+// PacketReceived records a DHCP message of type msgType received on the interface ifName.
+func (m *Metrics) PacketReceived(msgType, ifName string) {
+	if m == nil {
+		return
+	}
+	m.packetsReceived.WithLabelValues(msgType, ifName).Inc()
+}
+
+// PacketInvalid records a packet that could not be parsed as a DHCP message.
+func (m *Metrics) PacketInvalid() {
+	if m == nil {
+		return
+	}
+	m.packetsInvalid.Inc()
+}
+
+// PacketDropped records a DHCP message that was dropped without being handled.
+func (m *Metrics) PacketDropped() {
+	if m == nil {
+		return
+	}
+	m.packetsDropped.Inc()
+}
+
+// ReplySent records a DHCP reply of type msgType that was sent.
+func (m *Metrics) ReplySent(msgType string) {
+	if m == nil {
+		return
+	}
+	m.repliesSent.WithLabelValues(msgType).Inc()
+}
+
+// HardwareLookup records the result of a hardware lookup done by a handler, see Result.
+func (m *Metrics) HardwareLookup(result string) {
+	if m == nil {
+		return
+	}
+	m.lookups.WithLabelValues(result).Inc()
+}
+
+// BackendLookup records the duration and result of a backend lookup, see Result.
+func (m *Metrics) BackendLookup(backend, method, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.backendLookups.WithLabelValues(backend, method, result).Observe(d.Seconds())
+}
+
+// NetbootDecision records a netboot decision, one of NetbootServed or NetbootNotAllowed.
+func (m *Metrics) NetbootDecision(decision string) {
+	if m == nil {
+		return
+	}
+	m.netboot.WithLabelValues(decision).Inc()
+}
+
+// BackendReload records a reload of the data of a backend, one of ReloadSuccess or ReloadFailed.
+func (m *Metrics) BackendReload(backend, result string) {
+	if m == nil {
+		return
+	}
+	m.reloads.WithLabelValues(backend, result).Inc()
+}
+
+// BackendData records the number of records in the data served by a backend and the time it was loaded.
+func (m *Metrics) BackendData(backend string, records int, loaded time.Time) {
+	if m == nil {
+		return
+	}
+	m.records.WithLabelValues(backend).Set(float64(records))
+	m.loaded.WithLabelValues(backend).Set(float64(loaded.Unix()))
+}
+
+// Result returns the result label for a lookup that returned err.
+// Errors for hardware that doesn't exist implement a NotFound() bool method that returns true.
+func Result(err error) string {
+	if err == nil {
+		return ResultFound
+	}
+	type notFound interface {
+		NotFound() bool
+	}
+	var nf notFound
+	if errors.As(err, &nf) && nf.NotFound() {
+		return ResultNotFound
+	}
+
+	return ResultError
+}
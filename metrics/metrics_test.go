@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type notFoundError struct{}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (notFoundError) Error() string { return "not found" }
+
+func TestResult(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want string
+	}{
+		"found":             {want: ResultFound},
+		"not found":         {err: notFoundError{}, want: ResultNotFound},
+		"wrapped not found": {err: fmt.Errorf("lookup: %w", notFoundError{}), want: ResultNotFound},
+		"error":             {err: errors.New("backend down"), want: ResultError},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := Result(tt.err); got != tt.want {
+				t.Fatalf("Result() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	m.PacketReceived("DISCOVER", "eth0")
+	m.PacketReceived("DISCOVER", "eth0")
+	m.PacketReceived("REQUEST", "eth0")
+	m.PacketInvalid()
+	m.PacketDropped()
+	m.ReplySent("OFFER")
+	m.HardwareLookup(ResultNotFound)
+	m.BackendLookup("file", "GetByMac", ResultFound, time.Millisecond)
+	m.NetbootDecision(NetbootServed)
+	m.BackendReload("file", ReloadFailed)
+	m.BackendData("file", 3, time.Unix(1700000000, 0))
+
+	tests := map[string]struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		"discovers received": {c: m.packetsReceived.WithLabelValues("DISCOVER", "eth0"), want: 2},
+		"requests received":  {c: m.packetsReceived.WithLabelValues("REQUEST", "eth0"), want: 1},
+		"invalid":            {c: m.packetsInvalid, want: 1},
+		"dropped":            {c: m.packetsDropped, want: 1},
+		"offers sent":        {c: m.repliesSent.WithLabelValues("OFFER"), want: 1},
+		"lookups not found":  {c: m.lookups.WithLabelValues(ResultNotFound), want: 1},
+		"lookups found":      {c: m.lookups.WithLabelValues(ResultFound), want: 0},
+		"bootfile served":    {c: m.netboot.WithLabelValues(NetbootServed), want: 1},
+		"reloads failed":     {c: m.reloads.WithLabelValues("file", ReloadFailed), want: 1},
+		"backend records":    {c: m.records.WithLabelValues("file"), want: 3},
+		"data loaded":        {c: m.loaded.WithLabelValues("file"), want: 1700000000},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := testutil.ToFloat64(tt.c); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := testutil.CollectAndCount(m.backendLookups, "dhcp_backend_lookup_duration_seconds"); got != 1 {
+		t.Fatalf("got %d backend lookup series, want 1", got)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.PacketReceived("DISCOVER", "eth0")
+	m.PacketInvalid()
+	m.PacketDropped()
+	m.ReplySent("OFFER")
+	m.HardwareLookup(ResultFound)
+	m.BackendLookup("file", "GetByMac", ResultFound, time.Millisecond)
+	m.NetbootDecision(NetbootNotAllowed)
+	m.BackendReload("file", ReloadSuccess)
+	m.BackendData("file", 1, time.Now())
+}
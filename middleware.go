@@ -0,0 +1,52 @@
+package dhcp
+
+import (
+	"context"
+
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as a Handler.
+type HandlerFunc func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet)
+
+// Handle calls f(ctx, conn, d).
+func (f HandlerFunc) Handle(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+	f(ctx, conn, d)
+}
+
+// Middleware wraps a Handler with behavior that runs before and/or after it handles a DHCP message.
+// A Middleware can also decide not to call the wrapped Handler, for example to drop a message.
+// See the middleware package for built in implementations.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with all of m. The first Middleware is the outermost one,
+// so it sees a DHCP message first and it is the last to return.
+func Chain(h Handler, m ...Middleware) Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+
+	return h
+}
+
+// HandlerFunc6 is an adapter to allow the use of ordinary functions as a Handler6.
+type HandlerFunc6 func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6)
+
+// Handle6 calls f(ctx, conn, d).
+func (f HandlerFunc6) Handle6(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+	f(ctx, conn, d)
+}
+
+// Middleware6 is the Middleware of a Handler6.
+type Middleware6 func(Handler6) Handler6
+
+// Chain6 wraps h with all of m. The first Middleware6 is the outermost one, see Chain.
+func Chain6(h Handler6, m ...Middleware6) Handler6 {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+
+	return h
+}
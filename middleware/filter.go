@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+)
+
+// Allow returns a Middleware that drops DHCP messages from all clients except the ones with one of the given MAC addresses.
+// Dropped messages are logged with l.
+func Allow(l logr.Logger, macs ...net.HardwareAddr) dhcp.Middleware {
+	allowed := macSet(macs)
+
+	return filter(l, func(mac string) bool {
+		_, ok := allowed[mac]
+		return ok
+	}, "not in allow list")
+}
+
+// Deny returns a Middleware that drops DHCP messages from clients with one of the given MAC addresses.
+// Dropped messages are logged with l.
+func Deny(l logr.Logger, macs ...net.HardwareAddr) dhcp.Middleware {
+	denied := macSet(macs)
+
+	return filter(l, func(mac string) bool {
+		_, ok := denied[mac]
+		return !ok
+	}, "in deny list")
+}
+
+// filter returns a Middleware that only calls the wrapped Handler for DHCP messages from a client
+// whose MAC address passes keep. Messages without a DHCP packet are passed on, handlers deal with them.
+func filter(l logr.Logger, keep func(mac string) bool, reason string) dhcp.Middleware {
+	return func(next dhcp.Handler) dhcp.Handler {
+		return dhcp.HandlerFunc(func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+			if d.Pkt != nil && !keep(d.Pkt.ClientHWAddr.String()) {
+				l.V(1).Info("dropping DHCP message", withPacket(d, "reason", reason)...)
+				return
+			}
+			next.Handle(ctx, conn, d)
+		})
+	}
+}
+
+// macSet returns the normalized string form of macs as a set.
+func macSet(macs []net.HardwareAddr) map[string]struct{} {
+	s := make(map[string]struct{}, len(macs))
+	for _, m := range macs {
+		s[m.String()] = struct{}{}
+	}
+
+	return s
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+)
+
+func TestFilter(t *testing.T) {
+	listed := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	other := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+	tests := map[string]struct {
+		middleware dhcp.Middleware
+		pkt        data.Packet
+		wantCalled bool
+	}{
+		"allow listed":         {middleware: Allow(logr.Discard(), listed), pkt: packet(listed), wantCalled: true},
+		"allow not listed":     {middleware: Allow(logr.Discard(), listed), pkt: packet(other)},
+		"allow nothing":        {middleware: Allow(logr.Discard()), pkt: packet(listed)},
+		"deny listed":          {middleware: Deny(logr.Discard(), listed), pkt: packet(listed)},
+		"deny not listed":      {middleware: Deny(logr.Discard(), listed), pkt: packet(other), wantCalled: true},
+		"nil packet passed on": {middleware: Allow(logr.Discard(), listed), pkt: data.Packet{}, wantCalled: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &counter{}
+			tt.middleware(c).Handle(context.Background(), nil, tt.pkt)
+			if got := c.n == 1; got != tt.wantCalled {
+				t.Fatalf("handler called = %v, want %v", got, tt.wantCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package middleware holds dhcp.Middleware implementations for policy that applies to all handlers,
+// like panic recovery, rate limiting, MAC address filtering, logging and timeouts.
+//
+// Middleware is set on a dhcp.Server, or applied to a single dhcp.Handler with dhcp.Chain.
+// The functions ending in 6, like Recover6, return the same dhcp.Middleware6 for a dhcp.Server6.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+)
+
+// Recover returns a Middleware that recovers from a panic in the wrapped Handler and logs it with l.
+// Without it, a panic while handling a single DHCP message stops the whole server.
+func Recover(l logr.Logger) dhcp.Middleware {
+	return func(next dhcp.Handler) dhcp.Handler {
+		return dhcp.HandlerFunc(func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+			defer func() {
+				if r := recover(); r != nil {
+					l.Error(fmt.Errorf("panic: %v", r), "recovered from panic while handling DHCP message", withPacket(d, "stack", string(debug.Stack()))...)
+				}
+			}()
+			next.Handle(ctx, conn, d)
+		})
+	}
+}
+
+// Logging returns a Middleware that logs every DHCP message with l, and how long the wrapped Handler took to handle it.
+func Logging(l logr.Logger) dhcp.Middleware {
+	return func(next dhcp.Handler) dhcp.Handler {
+		return dhcp.HandlerFunc(func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+			start := time.Now()
+			l.Info("received DHCP message", withPacket(d)...)
+			next.Handle(ctx, conn, d)
+			l.Info("handled DHCP message", withPacket(d, "duration", time.Since(start).String())...)
+		})
+	}
+}
+
+// Timeout returns a Middleware that cancels the context of the wrapped Handler after t.
+// Handlers stop at the next operation that honors the context, like a backend call.
+// Handlers that are still running when t is exceeded are logged with l.
+func Timeout(l logr.Logger, t time.Duration) dhcp.Middleware {
+	return func(next dhcp.Handler) dhcp.Handler {
+		return dhcp.HandlerFunc(func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+			ctx, cancel := context.WithTimeout(ctx, t)
+			defer cancel()
+			next.Handle(ctx, conn, d)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				l.Info("handling DHCP message exceeded the timeout", withPacket(d, "timeout", t.String())...)
+			}
+		})
+	}
+}
+
+// withPacket returns kv with the identifying key/value pairs of d added, for use in log messages.
+func withPacket(d data.Packet, kv ...interface{}) []interface{} {
+	if d.Pkt != nil {
+		kv = append(kv, "mac", d.Pkt.ClientHWAddr.String(), "xid", d.Pkt.TransactionID.String(), "type", d.Pkt.MessageType().String())
+	}
+	if d.Peer != nil {
+		kv = append(kv, "peer", d.Peer.String())
+	}
+	if d.Md != nil {
+		kv = append(kv, "interface", d.Md.IfName)
+	}
+
+	return kv
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
+)
+
+// Recover6 is Recover for a dhcp.Handler6.
+func Recover6(l logr.Logger) dhcp.Middleware6 {
+	return func(next dhcp.Handler6) dhcp.Handler6 {
+		return dhcp.HandlerFunc6(func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+			defer func() {
+				if r := recover(); r != nil {
+					l.Error(fmt.Errorf("panic: %v", r), "recovered from panic while handling DHCPv6 message", withPacket6(d, "stack", string(debug.Stack()))...)
+				}
+			}()
+			next.Handle6(ctx, conn, d)
+		})
+	}
+}
+
+// Logging6 is Logging for a dhcp.Handler6.
+func Logging6(l logr.Logger) dhcp.Middleware6 {
+	return func(next dhcp.Handler6) dhcp.Handler6 {
+		return dhcp.HandlerFunc6(func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+			start := time.Now()
+			l.Info("received DHCPv6 message", withPacket6(d)...)
+			next.Handle6(ctx, conn, d)
+			l.Info("handled DHCPv6 message", withPacket6(d, "duration", time.Since(start).String())...)
+		})
+	}
+}
+
+// Timeout6 is Timeout for a dhcp.Handler6.
+func Timeout6(l logr.Logger, t time.Duration) dhcp.Middleware6 {
+	return func(next dhcp.Handler6) dhcp.Handler6 {
+		return dhcp.HandlerFunc6(func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+			ctx, cancel := context.WithTimeout(ctx, t)
+			defer cancel()
+			next.Handle6(ctx, conn, d)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				l.Info("handling DHCPv6 message exceeded the timeout", withPacket6(d, "timeout", t.String())...)
+			}
+		})
+	}
+}
+
+// RateLimit6 is RateLimit for a dhcp.Handler6. Clients are identified by their DUID.
+// Messages without a DUID are passed on, handlers deal with them.
+func RateLimit6(l logr.Logger, r rate.Limit, b int) dhcp.Middleware6 {
+	if r == rate.Inf {
+		return func(next dhcp.Handler6) dhcp.Handler6 { return next }
+	}
+	rl := newLimiter(r, b)
+
+	return func(next dhcp.Handler6) dhcp.Handler6 {
+		return dhcp.HandlerFunc6(func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+			if duid := clientID6(d); duid != "" && !rl.allow(duid) {
+				l.V(1).Info("dropping DHCPv6 message", withPacket6(d, "reason", "rate limit exceeded")...)
+				return
+			}
+			next.Handle6(ctx, conn, d)
+		})
+	}
+}
+
+// clientID6 returns the DUID of the client of d, empty when it has none.
+func clientID6(d data.Packet6) string {
+	if d.Pkt == nil {
+		return ""
+	}
+	msg, err := d.Pkt.GetInnerMessage()
+	if err != nil {
+		return ""
+	}
+	if cid := msg.Options.ClientID(); cid != nil {
+		return cid.String()
+	}
+
+	return ""
+}
+
+// withPacket6 returns kv with the identifying key/value pairs of d added, for use in log messages.
+func withPacket6(d data.Packet6, kv ...interface{}) []interface{} {
+	if d.Pkt != nil {
+		if msg, err := d.Pkt.GetInnerMessage(); err == nil {
+			kv = append(kv, "duid", clientID6(d), "xid", msg.TransactionID.String(), "type", msg.Type().String())
+		}
+	}
+	if d.Peer != nil {
+		kv = append(kv, "peer", d.Peer.String())
+	}
+	if d.Md != nil {
+		kv = append(kv, "interface", d.Md.IfName)
+	}
+
+	return kv
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tonglil/buflogr"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
+)
+
+// counter6 is a dhcp.Handler6 that counts the DHCPv6 messages it handles.
+type counter6 struct {
+	n int
+}
+
+func (c *counter6) Handle6(context.Context, *ipv6.PacketConn, data.Packet6) {
+	c.n++
+}
+
+func packet6(t *testing.T, mac net.HardwareAddr) data.Packet6 {
+	t.Helper()
+	m, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{LinkLayerAddr: mac}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data.Packet6{Peer: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 546}, Pkt: m, Md: &data.Metadata{IfName: "eth0"}}
+}
+
+func TestRecover6(t *testing.T) {
+	var buf bytes.Buffer
+	h := dhcp.HandlerFunc6(func(context.Context, *ipv6.PacketConn, data.Packet6) {
+		panic("boom")
+	})
+
+	Recover6(buflogr.NewWithBuffer(&buf))(h).Handle6(context.Background(), nil, packet6(t, net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}))
+	if !strings.Contains(buf.String(), "panic: boom") || !strings.Contains(buf.String(), "SOLICIT") {
+		t.Fatalf("expected the panic to be logged, got: %s", buf.String())
+	}
+}
+
+func TestTimeout6(t *testing.T) {
+	var deadline bool
+	h := dhcp.HandlerFunc6(func(ctx context.Context, _ *ipv6.PacketConn, _ data.Packet6) {
+		_, deadline = ctx.Deadline()
+	})
+
+	Timeout6(logr.Discard(), time.Minute)(h).Handle6(context.Background(), nil, packet6(t, net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}))
+	if !deadline {
+		t.Fatal("expected the handler context to have a deadline")
+	}
+}
+
+func TestRateLimit6(t *testing.T) {
+	c := &counter6{}
+	h := RateLimit6(logr.Discard(), rate.Every(time.Hour), 2)(c)
+	mac1 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	mac2 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+
+	for i := 0; i < 3; i++ {
+		h.Handle6(context.Background(), nil, packet6(t, mac1))
+	}
+	h.Handle6(context.Background(), nil, packet6(t, mac2))
+	if c.n != 3 {
+		t.Fatalf("expected 2 messages from the first client and 1 from the second to be handled, got %d", c.n)
+	}
+}
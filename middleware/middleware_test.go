@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+	"github.com/tonglil/buflogr"
+	"golang.org/x/net/ipv4"
+)
+
+// counter is a dhcp.Handler that counts the DHCP messages it handles.
+type counter struct {
+	n int
+}
+
+func (c *counter) Handle(context.Context, *ipv4.PacketConn, data.Packet) {
+	c.n++
+}
+
+func packet(mac net.HardwareAddr) data.Packet {
+	return data.Packet{
+		Peer: &net.UDPAddr{IP: net.IPv4bcast, Port: 68},
+		Pkt: &dhcpv4.DHCPv4{
+			OpCode:       dhcpv4.OpcodeBootRequest,
+			ClientHWAddr: mac,
+			Options:      dhcpv4.OptionsFromList(dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover)),
+		},
+		Md: &data.Metadata{IfName: "eth0"},
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	h := dhcp.HandlerFunc(func(context.Context, *ipv4.PacketConn, data.Packet) {
+		panic("boom")
+	})
+
+	Recover(buflogr.NewWithBuffer(&buf))(h).Handle(context.Background(), nil, packet(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}))
+	if !strings.Contains(buf.String(), "panic: boom") || !strings.Contains(buf.String(), "00:01:02:03:04:05") {
+		t.Fatalf("expected the panic to be logged, got: %s", buf.String())
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	c := &counter{}
+
+	Logging(buflogr.NewWithBuffer(&buf))(c).Handle(context.Background(), nil, packet(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}))
+	if c.n != 1 {
+		t.Fatalf("expected the handler to be called once, got %d", c.n)
+	}
+	for _, want := range []string{"received DHCP message", "handled DHCP message", "00:01:02:03:04:05", "DISCOVER", "eth0"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in log, got: %s", want, buf.String())
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := map[string]struct {
+		timeout time.Duration
+		wantLog bool
+	}{
+		"handler finishes in time": {timeout: time.Minute},
+		"handler exceeds timeout":  {timeout: time.Millisecond, wantLog: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var deadline bool
+			h := dhcp.HandlerFunc(func(ctx context.Context, _ *ipv4.PacketConn, _ data.Packet) {
+				_, deadline = ctx.Deadline()
+				select {
+				case <-ctx.Done():
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+
+			Timeout(buflogr.NewWithBuffer(&buf), tt.timeout)(h).Handle(context.Background(), nil, packet(nil))
+			if !deadline {
+				t.Fatal("expected the handler context to have a deadline")
+			}
+			if got := strings.Contains(buf.String(), "exceeded the timeout"); got != tt.wantLog {
+				t.Fatalf("logged timeout = %v, want %v: %s", got, tt.wantLog, buf.String())
+			}
+		})
+	}
+}
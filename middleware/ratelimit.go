@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/dhcp"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit returns a Middleware that drops DHCP messages from a client that sends more than r messages per second,
+// with bursts of up to b messages. Clients are identified by their MAC address.
+// Dropped messages are logged with l. A limit of rate.Inf allows all messages.
+func RateLimit(l logr.Logger, r rate.Limit, b int) dhcp.Middleware {
+	if r == rate.Inf {
+		return func(next dhcp.Handler) dhcp.Handler { return next }
+	}
+	rl := newLimiter(r, b)
+
+	return func(next dhcp.Handler) dhcp.Handler {
+		return dhcp.HandlerFunc(func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+			if d.Pkt != nil && !rl.allow(d.Pkt.ClientHWAddr.String()) {
+				l.V(1).Info("dropping DHCP message", withPacket(d, "reason", "rate limit exceeded")...)
+				return
+			}
+			next.Handle(ctx, conn, d)
+		})
+	}
+}
+
+// limiter holds a token bucket per client.
+type limiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[string]*client
+	pruned  time.Time
+	now     func() time.Time
+}
+
+type client struct {
+	bucket *rate.Limiter
+	seen   time.Time
+}
+
+func newLimiter(r rate.Limit, b int) *limiter {
+	return &limiter{limit: r, burst: b, clients: make(map[string]*client), now: time.Now}
+}
+
+// allow reports whether a message from the client identified by key is within its rate limit.
+func (rl *limiter) allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.prune(now)
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &client{bucket: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.seen = now
+
+	return c.bucket.AllowN(now, 1)
+}
+
+// prune forgets clients whose bucket is full again, they are no different from a client that was never seen.
+// This keeps memory bounded by the number of recently active clients.
+func (rl *limiter) prune(now time.Time) {
+	if rl.limit <= 0 {
+		// buckets never refill, forgetting a client would reset its limit.
+		return
+	}
+	full := time.Duration(float64(rl.burst) / float64(rl.limit) * float64(time.Second))
+	if now.Sub(rl.pruned) < full {
+		return
+	}
+	for k, c := range rl.clients {
+		if now.Sub(c.seen) >= full {
+			delete(rl.clients, k)
+		}
+	}
+	rl.pruned = now
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimit(t *testing.T) {
+	c := &counter{}
+	h := RateLimit(logr.Discard(), rate.Every(time.Hour), 2)(c)
+	mac1 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	mac2 := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+
+	for i := 0; i < 3; i++ {
+		h.Handle(context.Background(), nil, packet(mac1))
+	}
+	h.Handle(context.Background(), nil, packet(mac2))
+	if c.n != 3 {
+		t.Fatalf("expected 2 messages from the first client and 1 from the second to be handled, got %d", c.n)
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newLimiter(1, 2)
+	rl.now = func() time.Time { return now }
+
+	for _, want := range []bool{true, true, false} {
+		if got := rl.allow("a"); got != want {
+			t.Fatalf("allow() = %v, want %v", got, want)
+		}
+	}
+	rl.allow("b")
+
+	// after the time it takes to refill a bucket, idle clients are forgotten.
+	now = now.Add(2 * time.Second)
+	if !rl.allow("b") {
+		t.Fatal("expected the bucket to be refilled")
+	}
+	if _, ok := rl.clients["a"]; ok {
+		t.Fatal("expected the idle client to be pruned")
+	}
+	if len(rl.clients) != 1 {
+		t.Fatalf("expected 1 client, got %d", len(rl.clients))
+	}
+}
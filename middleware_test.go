@@ -0,0 +1,55 @@
+package dhcp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/dhcp/data"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestChain(t *testing.T) {
+	var got []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, conn *ipv4.PacketConn, d data.Packet) {
+				got = append(got, name+" before")
+				next.Handle(ctx, conn, d)
+				got = append(got, name+" after")
+			})
+		}
+	}
+	h := HandlerFunc(func(context.Context, *ipv4.PacketConn, data.Packet) {
+		got = append(got, "handler")
+	})
+
+	Chain(h, record("first"), record("second")).Handle(context.Background(), nil, data.Packet{})
+	want := []string{"first before", "second before", "handler", "second after", "first after"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestChain6(t *testing.T) {
+	var got []string
+	record := func(name string) Middleware6 {
+		return func(next Handler6) Handler6 {
+			return HandlerFunc6(func(ctx context.Context, conn *ipv6.PacketConn, d data.Packet6) {
+				got = append(got, name+" before")
+				next.Handle6(ctx, conn, d)
+				got = append(got, name+" after")
+			})
+		}
+	}
+	h := HandlerFunc6(func(context.Context, *ipv6.PacketConn, data.Packet6) {
+		got = append(got, "handler")
+	})
+
+	Chain6(h, record("first"), record("second")).Handle6(context.Background(), nil, data.Packet6{})
+	want := []string{"first before", "second before", "handler", "second after", "first after"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -69,8 +69,9 @@ func AllEncoders() []func(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValu
 		EncodeOpt1, EncodeOpt3, EncodeOpt6,
 		EncodeOpt12, EncodeOpt15, EncodeOpt28,
 		EncodeOpt42, EncodeOpt51, EncodeOpt53,
-		EncodeOpt54, EncodeOpt60, EncodeOpt93,
-		EncodeOpt94, EncodeOpt97, EncodeOpt119,
+		EncodeOpt54, EncodeOpt60, EncodeOpt82,
+		EncodeOpt93, EncodeOpt94, EncodeOpt97,
+		EncodeOpt119, EncodeOpt121,
 	}
 }
 
@@ -237,6 +238,40 @@ func EncodeOpt60(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 	return attribute.KeyValue{}, &notFoundError{optName: key}
 }
 
+// EncodeOpt82 takes the circuit ID and remote ID sub-options of DHCP Opt 82 from a DHCP packet and returns an OTEL key/value pair.
+// See https://www.rfc-editor.org/rfc/rfc3046#section-2.0.
+func EncodeOpt82(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
+	key := fmt.Sprintf("%v.%v.Opt82.RelayAgentInformation", keyNamespace, namespace)
+	if d != nil {
+		if rai := d.RelayAgentInfo(); rai != nil {
+			var r []string
+			if c := rai.Get(dhcpv4.AgentCircuitIDSubOption); len(c) > 0 {
+				r = append(r, "CircuitID:"+printable(c))
+			}
+			if c := rai.Get(dhcpv4.AgentRemoteIDSubOption); len(c) > 0 {
+				r = append(r, "RemoteID:"+printable(c))
+			}
+			if len(r) > 0 {
+				return attribute.String(key, strings.Join(r, ",")), nil
+			}
+		}
+	}
+
+	return attribute.KeyValue{}, &notFoundError{optName: key}
+}
+
+// printable returns b as a string if all of its bytes are printable ASCII characters, otherwise as hex.
+// Relay agents are free to use binary values for the sub-options of DHCP Opt 82.
+func printable(b []byte) string {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Sprintf("%x", b)
+		}
+	}
+
+	return string(b)
+}
+
 // EncodeOpt93 takes DHCP Opt 93 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt93(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
@@ -300,6 +335,23 @@ func EncodeOpt119(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error
 	return attribute.KeyValue{}, &notFoundError{optName: key}
 }
 
+// EncodeOpt121 takes DHCP Opt 121 from a DHCP packet and returns an OTEL key/value pair.
+// See https://www.rfc-editor.org/rfc/rfc3442
+func EncodeOpt121(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
+	key := fmt.Sprintf("%v.%v.Opt121.ClasslessStaticRoutes", keyNamespace, namespace)
+	if d != nil {
+		var routes []string
+		for _, r := range d.ClasslessStaticRoute() {
+			routes = append(routes, fmt.Sprintf("%v via %v", r.Dest, r.Router))
+		}
+		if len(routes) > 0 {
+			return attribute.String(key, strings.Join(routes, ",")), nil
+		}
+	}
+
+	return attribute.KeyValue{}, &notFoundError{optName: key}
+}
+
 // EncodeYIADDR takes the yiaddr header from a DHCP packet and returns an OTEL
 // key/value pair. See https://datatracker.ietf.org/doc/html/rfc2131#page-9
 func EncodeYIADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
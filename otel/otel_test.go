@@ -359,6 +359,48 @@ func TestSetOpt60(t *testing.T) {
 	}
 }
 
+func TestSetOpt82(t *testing.T) {
+	tests := map[string]struct {
+		input   *dhcpv4.DHCPv4
+		want    attribute.KeyValue
+		wantErr error
+	}{
+		"success": {
+			input: &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptRelayAgentInfo(
+					dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("Ethernet1/1")),
+					dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte("switch01")),
+				),
+			)},
+			want: attribute.String("DHCP.testing.Opt82.RelayAgentInformation", "CircuitID:Ethernet1/1,RemoteID:switch01"),
+		},
+		"success binary circuit id": {
+			input: &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte{0x00, 0x04, 0x00, 0x0a, 0x01, 0x02})),
+			)},
+			want: attribute.String("DHCP.testing.Opt82.RelayAgentInformation", "CircuitID:0004000a0102"),
+		},
+		"error no circuit or remote id": {
+			input: &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{192, 168, 1, 0})),
+			)},
+			wantErr: &notFoundError{},
+		},
+		"error": {wantErr: &notFoundError{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := EncodeOpt82(tt.input, "testing")
+			if tt.wantErr != nil && !OptNotFound(err) {
+				t.Fatalf("setOpt82() error (type: %T) = %[1]v, wantErr (type: %T) %[2]v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want, cmpopts.IgnoreUnexported(attribute.Value{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestSetOpt93(t *testing.T) {
 	tests := map[string]struct {
 		input   *dhcpv4.DHCPv4
@@ -470,6 +512,36 @@ func TestSetOpt119(t *testing.T) {
 	}
 }
 
+func TestSetOpt121(t *testing.T) {
+	tests := map[string]struct {
+		input   *dhcpv4.DHCPv4
+		want    attribute.KeyValue
+		wantErr error
+	}{
+		"success": {
+			input: &dhcpv4.DHCPv4{Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptClasslessStaticRoute(
+					&dhcpv4.Route{Dest: &net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}, Router: net.IP{192, 168, 2, 2}},
+					&dhcpv4.Route{Dest: &net.IPNet{IP: net.IP{0, 0, 0, 0}, Mask: net.CIDRMask(0, 32)}, Router: net.IP{192, 168, 2, 1}},
+				),
+			)},
+			want: attribute.String("DHCP.testing.Opt121.ClasslessStaticRoutes", "10.0.0.0/8 via 192.168.2.2,0.0.0.0/0 via 192.168.2.1"),
+		},
+		"error": {wantErr: &notFoundError{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := EncodeOpt121(tt.input, "testing")
+			if tt.wantErr != nil && !OptNotFound(err) {
+				t.Fatalf("setOpt121() error (type: %T) = %[1]v, wantErr (type: %T) %[2]v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want, cmpopts.IgnoreUnexported(attribute.Value{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestSetHeaderFlags(t *testing.T) {
 	tests := map[string]struct {
 		input   *dhcpv4.DHCPv4
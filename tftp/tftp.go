@@ -0,0 +1,301 @@
+// Package tftp is a read-only TFTP server, for serving iPXE binaries to netboot clients.
+//
+// It implements RFC 1350 with the option extension (RFC 2347) and the blksize (RFC 2348), timeout and tsize (RFC 2349)
+// and windowsize (RFC 7440) options. Only read requests in octet mode are supported.
+//
+// Files are served from an fs.FS, for example os.DirFS or an embed.FS.
+// The OpenTelemetry traceparent that the DHCP handlers append to boot file names, when enabled,
+// is removed from requested file names and used as the parent of the span of the transfer.
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/tinkerbell/dhcp"
+
+// TFTP opcodes, https://www.rfc-editor.org/rfc/rfc1350#section-5 and https://www.rfc-editor.org/rfc/rfc2347.
+const (
+	opRRQ   uint16 = 1
+	opWRQ   uint16 = 2
+	opDATA  uint16 = 3
+	opACK   uint16 = 4
+	opERROR uint16 = 5
+	opOACK  uint16 = 6
+)
+
+// TFTP error codes, https://www.rfc-editor.org/rfc/rfc1350#page-10 and https://www.rfc-editor.org/rfc/rfc2347.
+const (
+	errCodeNotDefined       uint16 = 0
+	errCodeFileNotFound     uint16 = 1
+	errCodeAccessViolation  uint16 = 2
+	errCodeIllegalOperation uint16 = 4
+	errCodeUnknownTID       uint16 = 5
+)
+
+const (
+	// defaultBlockSize is the block size when a client doesn't send the blksize option.
+	defaultBlockSize = 512
+	// minBlockSize and maxBlockSize are the limits of the blksize option.
+	minBlockSize = 8
+	maxBlockSize = 65464
+	// maxWindowSize is the largest window size the server accepts, larger windowsize options are lowered to it.
+	// A window is kept in memory until it is acknowledged.
+	maxWindowSize = 64
+	// maxRequestSize is the size of the largest request the server reads.
+	maxRequestSize = 1500
+)
+
+// Defaults for the Server fields.
+const (
+	DefaultTimeout      = 3 * time.Second
+	DefaultRetries      = 5
+	DefaultMaxBlockSize = 1468
+)
+
+// traceparentSuffix matches a file name with a W3C traceparent appended, for example
+// "ipxe.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01".
+var traceparentSuffix = regexp.MustCompile(`^(.+)-(00)-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// Server is a read-only TFTP server.
+type Server struct {
+	// Conn is the listener that read requests are received on.
+	// Every transfer uses its own connection, as required by RFC 1350.
+	Conn net.PacketConn
+	// FS holds the files that are served.
+	FS fs.FS
+	// Logger is used to log failed transfers.
+	Logger logr.Logger
+
+	// Timeout is the time to wait for an acknowledgement before a block is sent again.
+	// Clients can change it with the timeout option. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Retries is the number of times a block is sent again before a transfer is aborted. Defaults to DefaultRetries.
+	Retries int
+	// MaxBlockSize is the largest block size that clients can request with the blksize option. Defaults to DefaultMaxBlockSize,
+	// which fits in a single ethernet frame.
+	MaxBlockSize int
+}
+
+// NewServer initializes and returns a new Server listening on addr and serving the files in fsys.
+func NewServer(addr *net.UDPAddr, fsys fs.FS) (*Server, error) {
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{Conn: conn, FS: fsys, Logger: logr.Discard()}, nil
+}
+
+// Serve serves read requests until ctx is done. Running transfers are aborted when ctx is done.
+func (s *Server) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	s.Logger.Info("TFTP server listening on", "addr", s.Conn.LocalAddr())
+
+	buf := make([]byte, maxRequestSize)
+	for {
+		n, peer, err := s.Conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			return err
+		}
+		upeer, ok := peer.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		pkt := make([]byte, n)
+		copy(pkt, buf[:n])
+		go s.handle(ctx, upeer, pkt)
+	}
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.Conn.Close()
+}
+
+// request is a parsed read request.
+type request struct {
+	filename string
+	mode     string
+	options  map[string]string
+	// traceparent is the W3C traceparent that was appended to the file name, if any.
+	traceparent string
+}
+
+// errRequest is a request that is answered with a TFTP error.
+type errRequest struct {
+	code uint16
+	msg  string
+}
+
+func (e *errRequest) Error() string {
+	return e.msg
+}
+
+// handle answers a single request from peer.
+func (s *Server) handle(ctx context.Context, peer *net.UDPAddr, pkt []byte) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP(s.Conn)})
+	if err != nil {
+		s.Logger.Error(err, "failed to create a TFTP transfer connection", "peer", peer.String())
+		return
+	}
+	defer conn.Close()
+	// a running transfer is aborted when the server stops.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	req, err := parseRequest(pkt)
+	if err != nil {
+		var er *errRequest
+		if !errors.As(err, &er) {
+			er = &errRequest{code: errCodeIllegalOperation, msg: err.Error()}
+		}
+		_, _ = conn.WriteTo(errorPacket(er.code, er.msg), peer)
+		return
+	}
+	log := s.Logger.WithValues("peer", peer.String(), "filename", req.filename)
+
+	if req.traceparent != "" {
+		ctx = contextWithTraceparent(ctx, req.traceparent)
+	}
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "TFTP read",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("TFTP.filename", req.filename), attribute.String("TFTP.peer", peer.String())),
+	)
+	defer span.End()
+
+	t, err := s.newTransfer(conn, peer, req)
+	if err != nil {
+		var er *errRequest
+		if !errors.As(err, &er) {
+			er = &errRequest{code: errCodeNotDefined, msg: err.Error()}
+		}
+		_, _ = conn.WriteTo(errorPacket(er.code, er.msg), peer)
+		log.V(1).Info("TFTP read request refused", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	defer t.close()
+
+	if err := t.run(ctx); err != nil {
+		log.Info("TFTP transfer failed", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	log.V(1).Info("TFTP transfer done", "size", t.size)
+	span.SetAttributes(attribute.Int64("TFTP.size", t.size))
+	span.SetStatus(codes.Ok, "done")
+}
+
+// parseRequest parses a request packet. Only read requests are accepted.
+func parseRequest(pkt []byte) (*request, error) {
+	if len(pkt) < 2 {
+		return nil, &errRequest{code: errCodeIllegalOperation, msg: "packet too short"}
+	}
+	switch op := binary.BigEndian.Uint16(pkt); op {
+	case opRRQ:
+	case opWRQ:
+		return nil, &errRequest{code: errCodeAccessViolation, msg: "write requests are not supported"}
+	default:
+		return nil, &errRequest{code: errCodeIllegalOperation, msg: fmt.Sprintf("unexpected opcode %d", op)}
+	}
+
+	fields := bytes.Split(pkt[2:], []byte{0})
+	// a well formed request ends with a 0 byte, so the last field is empty.
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, &errRequest{code: errCodeIllegalOperation, msg: "malformed read request"}
+	}
+	fields = fields[:len(fields)-1]
+	if len(fields)%2 != 0 {
+		return nil, &errRequest{code: errCodeIllegalOperation, msg: "malformed read request options"}
+	}
+	req := &request{
+		filename: string(fields[0]),
+		mode:     strings.ToLower(string(fields[1])),
+		options:  make(map[string]string),
+	}
+	for i := 2; i < len(fields); i += 2 {
+		req.options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	if req.mode != "octet" {
+		return nil, &errRequest{code: errCodeIllegalOperation, msg: fmt.Sprintf("unsupported mode %q, only octet is supported", req.mode)}
+	}
+	if m := traceparentSuffix.FindStringSubmatch(req.filename); m != nil {
+		req.filename = m[1]
+		req.traceparent = strings.Join(m[2:], "-")
+	}
+
+	return req, nil
+}
+
+// contextWithTraceparent returns a copy of ctx with the remote span in the W3C traceparent tp.
+// ctx is returned as is when tp is not valid.
+func contextWithTraceparent(ctx context.Context, tp string) context.Context {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 {
+		return ctx
+	}
+	tid, err := trace.TraceIDFromHex(parts[1])
+	if err != nil {
+		return ctx
+	}
+	sid, err := trace.SpanIDFromHex(parts[2])
+	if err != nil {
+		return ctx
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return ctx
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.TraceFlags(flags),
+		Remote:     true,
+	})
+
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// errorPacket returns a TFTP error packet.
+func errorPacket(code uint16, msg string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, opERROR)
+	b = binary.BigEndian.AppendUint16(b, code)
+	b = append(b, msg...)
+
+	return append(b, 0)
+}
+
+// localIP returns the IP address that conn listens on, transfers use the same address.
+func localIP(conn net.PacketConn) net.IP {
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return a.IP
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// client is a minimal TFTP client for testing.
+type client struct {
+	t    *testing.T
+	conn *net.UDPConn
+	srv  *net.UDPAddr
+	// peer is the transfer address of the server, set from the first reply.
+	peer *net.UDPAddr
+}
+
+func newClient(t *testing.T, srv net.Addr) *client {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &client{t: t, conn: conn, srv: srv.(*net.UDPAddr)}
+}
+
+func (c *client) rrq(filename string, opts ...string) {
+	c.t.Helper()
+	b := binary.BigEndian.AppendUint16(nil, opRRQ)
+	for _, f := range append([]string{filename, "octet"}, opts...) {
+		b = append(append(b, f...), 0)
+	}
+	if _, err := c.conn.WriteTo(b, c.srv); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() []byte {
+	c.t.Helper()
+	buf := make([]byte, 70000)
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, addr, err := c.conn.ReadFrom(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.peer = addr.(*net.UDPAddr)
+
+	return buf[:n]
+}
+
+func (c *client) ack(block uint16) {
+	c.t.Helper()
+	b := binary.BigEndian.AppendUint16(nil, opACK)
+	b = binary.BigEndian.AppendUint16(b, block)
+	if _, err := c.conn.WriteTo(b, c.peer); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func serve(t *testing.T, s *Server) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx)
+}
+
+func newTestServer(t *testing.T, fsys fstest.MapFS) *Server {
+	t.Helper()
+	s, err := NewServer(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Timeout = 200 * time.Millisecond
+	serve(t, s)
+
+	return s
+}
+
+func TestRead(t *testing.T) {
+	file := bytes.Repeat([]byte("0123456789"), 205)
+	tests := map[string]struct {
+		filename   string
+		opts       []string
+		wantOACK   string
+		wantBlocks int
+		window     int
+	}{
+		"no options":                  {filename: "ipxe.efi", wantBlocks: 5, window: 1},
+		"leading slash":               {filename: "/ipxe.efi", wantBlocks: 5, window: 1},
+		"blksize and tsize":           {filename: "ipxe.efi", opts: []string{"blksize", "1024", "tsize", "0"}, wantOACK: "blksize\x001024\x00tsize\x002050\x00", wantBlocks: 3, window: 1},
+		"blksize above maximum":       {filename: "ipxe.efi", opts: []string{"blksize", "65464"}, wantOACK: "blksize\x001468\x00", wantBlocks: 2, window: 1},
+		"windowsize":                  {filename: "ipxe.efi", opts: []string{"windowsize", "4", "timeout", "1"}, wantOACK: "timeout\x001\x00windowsize\x004\x00", wantBlocks: 5, window: 4},
+		"traceparent suffix":          {filename: "ipxe.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", wantBlocks: 5, window: 1},
+		"unknown options are ignored": {filename: "ipxe.efi", opts: []string{"multicast", ""}, wantBlocks: 5, window: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, fstest.MapFS{"ipxe.efi": {Data: file}})
+			c := newClient(t, s.Conn.LocalAddr())
+			c.rrq(tt.filename, tt.opts...)
+			if tt.wantOACK != "" {
+				oack := c.read()
+				if diff := cmp.Diff(string(oack[2:]), tt.wantOACK); diff != "" || binary.BigEndian.Uint16(oack) != opOACK {
+					t.Fatalf("unexpected option acknowledgement: %v", diff)
+				}
+				c.ack(0)
+			}
+
+			var got []byte
+			var blocks int
+			for done := false; !done; {
+				for i := 0; i < tt.window && !done; i++ {
+					pkt := c.read()
+					if binary.BigEndian.Uint16(pkt) != opDATA {
+						t.Fatalf("got opcode %d, want %d", binary.BigEndian.Uint16(pkt), opDATA)
+					}
+					blocks++
+					if n := binary.BigEndian.Uint16(pkt[2:]); int(n) != blocks {
+						t.Fatalf("got block %d, want %d", n, blocks)
+					}
+					got = append(got, pkt[4:]...)
+					done = len(pkt)-4 < blockSize(tt.wantOACK)
+				}
+				c.ack(uint16(blocks))
+			}
+			if blocks != tt.wantBlocks {
+				t.Fatalf("got %d blocks, want %d", blocks, tt.wantBlocks)
+			}
+			if !bytes.Equal(got, file) {
+				t.Fatal("file content differs")
+			}
+		})
+	}
+}
+
+// blockSize returns the block size in an option acknowledgement.
+func blockSize(oack string) int {
+	switch {
+	case strings.HasPrefix(oack, "blksize\x001024"):
+		return 1024
+	case strings.HasPrefix(oack, "blksize\x001468"):
+		return 1468
+	default:
+		return defaultBlockSize
+	}
+}
+
+func TestReadRetransmit(t *testing.T) {
+	s := newTestServer(t, fstest.MapFS{"undionly.kpxe": {Data: []byte("short")}})
+	c := newClient(t, s.Conn.LocalAddr())
+	c.rrq("undionly.kpxe")
+	first := c.read()
+	// no acknowledgement, the block is sent again after the timeout.
+	again := c.read()
+	if !bytes.Equal(first, again) {
+		t.Fatalf("retransmitted block %v differs from %v", again, first)
+	}
+	c.ack(1)
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := map[string]struct {
+		pkt      []byte
+		wantCode uint16
+	}{
+		"file not found":   {pkt: []byte("\x00\x01snp.efi\x00octet\x00"), wantCode: errCodeFileNotFound},
+		"path traversal":   {pkt: []byte("\x00\x01../etc/passwd\x00octet\x00"), wantCode: errCodeAccessViolation},
+		"directory":        {pkt: []byte("\x00\x01dir\x00octet\x00"), wantCode: errCodeFileNotFound},
+		"write request":    {pkt: []byte("\x00\x02ipxe.efi\x00octet\x00"), wantCode: errCodeAccessViolation},
+		"netascii mode":    {pkt: []byte("\x00\x01ipxe.efi\x00netascii\x00"), wantCode: errCodeIllegalOperation},
+		"malformed":        {pkt: []byte("\x00\x01ipxe.efi"), wantCode: errCodeIllegalOperation},
+		"unknown opcode":   {pkt: []byte("\x00\x09"), wantCode: errCodeIllegalOperation},
+		"ack as a request": {pkt: []byte("\x00\x04\x00\x01"), wantCode: errCodeIllegalOperation},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, fstest.MapFS{"ipxe.efi": {Data: []byte("ipxe")}, "dir/ipxe.efi": {Data: []byte("ipxe")}})
+			c := newClient(t, s.Conn.LocalAddr())
+			if _, err := c.conn.WriteTo(tt.pkt, c.srv); err != nil {
+				t.Fatal(err)
+			}
+			pkt := c.read()
+			if binary.BigEndian.Uint16(pkt) != opERROR {
+				t.Fatalf("got opcode %d, want %d", binary.BigEndian.Uint16(pkt), opERROR)
+			}
+			if code := binary.BigEndian.Uint16(pkt[2:]); code != tt.wantCode {
+				t.Fatalf("got error code %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	req, err := parseRequest([]byte("\x00\x01ipxe.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01\x00OCTET\x00BLKSIZE\x001468\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &request{
+		filename:    "ipxe.efi",
+		mode:        "octet",
+		options:     map[string]string{"blksize": "1468"},
+		traceparent: "00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
+	}
+	if diff := cmp.Diff(req, want, cmp.AllowUnexported(request{})); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,220 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transfer is a single file sent to a client.
+type transfer struct {
+	conn *net.UDPConn
+	peer *net.UDPAddr
+
+	r    io.ReaderAt
+	file fs.File
+	size int64
+
+	blockSize  int
+	windowSize int
+	timeout    time.Duration
+	retries    int
+	// oack holds the accepted options, in the order they are sent in the option acknowledgement.
+	oack []string
+}
+
+// newTransfer opens the requested file and negotiates the options of req.
+func (s *Server) newTransfer(conn *net.UDPConn, peer *net.UDPAddr, req *request) (*transfer, error) {
+	name := strings.TrimLeft(req.filename, "/")
+	if !fs.ValidPath(name) || s.FS == nil {
+		return nil, &errRequest{code: errCodeAccessViolation, msg: "invalid file name"}
+	}
+	f, err := s.FS.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &errRequest{code: errCodeFileNotFound, msg: "file not found"}
+		}
+		return nil, &errRequest{code: errCodeAccessViolation, msg: err.Error()}
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, &errRequest{code: errCodeFileNotFound, msg: "file not found"}
+	}
+
+	t := &transfer{
+		conn:       conn,
+		peer:       peer,
+		file:       f,
+		size:       fi.Size(),
+		blockSize:  defaultBlockSize,
+		windowSize: 1,
+		timeout:    s.Timeout,
+		retries:    s.Retries,
+	}
+	if t.timeout <= 0 {
+		t.timeout = DefaultTimeout
+	}
+	if t.retries <= 0 {
+		t.retries = DefaultRetries
+	}
+	if ra, ok := f.(io.ReaderAt); ok {
+		t.r = ra
+	} else {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, &errRequest{code: errCodeAccessViolation, msg: err.Error()}
+		}
+		t.r = bytes.NewReader(b)
+		t.size = int64(len(b))
+	}
+	t.negotiate(req.options, s.maxBlockSize())
+
+	return t, nil
+}
+
+// maxBlockSize returns the largest block size that clients can request.
+func (s *Server) maxBlockSize() int {
+	if s.MaxBlockSize >= minBlockSize && s.MaxBlockSize <= maxBlockSize {
+		return s.MaxBlockSize
+	}
+
+	return DefaultMaxBlockSize
+}
+
+// negotiate accepts the options that the server supports. Invalid option values are ignored.
+func (t *transfer) negotiate(opts map[string]string, maxBlock int) {
+	if v, err := strconv.Atoi(opts["blksize"]); err == nil && v >= minBlockSize {
+		t.blockSize = min(v, maxBlock)
+		t.oack = append(t.oack, "blksize", strconv.Itoa(t.blockSize))
+	}
+	if v, err := strconv.Atoi(opts["timeout"]); err == nil && v >= 1 && v <= 255 {
+		t.timeout = time.Duration(v) * time.Second
+		t.oack = append(t.oack, "timeout", strconv.Itoa(v))
+	}
+	if _, ok := opts["tsize"]; ok {
+		t.oack = append(t.oack, "tsize", strconv.FormatInt(t.size, 10))
+	}
+	if v, err := strconv.Atoi(opts["windowsize"]); err == nil && v >= 1 && v <= 65535 {
+		t.windowSize = min(v, maxWindowSize)
+		t.oack = append(t.oack, "windowsize", strconv.Itoa(t.windowSize))
+	}
+}
+
+// close closes the file of the transfer.
+func (t *transfer) close() {
+	_ = t.file.Close()
+}
+
+// run sends the option acknowledgement, when options were accepted, and then the file.
+func (t *transfer) run(ctx context.Context) error {
+	if len(t.oack) > 0 {
+		oack := binary.BigEndian.AppendUint16(nil, opOACK)
+		for _, o := range t.oack {
+			oack = append(append(oack, o...), 0)
+		}
+		// the option acknowledgement is acknowledged with block 0.
+		if _, err := t.sendWindow(ctx, [][]byte{oack}, -1, 0); err != nil {
+			return err
+		}
+	}
+
+	// the last block is shorter than the block size, it is empty when the size is a multiple of the block size.
+	blocks := t.size/int64(t.blockSize) + 1
+	var acked int64
+	buf := make([]byte, t.blockSize)
+	for acked < blocks {
+		last := min(acked+int64(t.windowSize), blocks)
+		window := make([][]byte, 0, last-acked)
+		for n := acked + 1; n <= last; n++ {
+			pkt, err := t.dataPacket(n, buf)
+			if err != nil {
+				return err
+			}
+			window = append(window, pkt)
+		}
+		n, err := t.sendWindow(ctx, window, acked, last)
+		if err != nil {
+			return err
+		}
+		acked = n
+	}
+
+	return nil
+}
+
+// dataPacket returns the data packet for block n, counting from 1. Block numbers roll over to 0 after 65535.
+func (t *transfer) dataPacket(n int64, buf []byte) ([]byte, error) {
+	c, err := t.r.ReadAt(buf, (n-1)*int64(t.blockSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	pkt := make([]byte, 0, 4+c)
+	pkt = binary.BigEndian.AppendUint16(pkt, opDATA)
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(n))
+
+	return append(pkt, buf[:c]...), nil
+}
+
+// sendWindow sends the packets of blocks acked+1..last and waits for an acknowledgement.
+// It returns the block that was acknowledged, which is before last when the client missed a block of the window.
+// The window is sent again when no acknowledgement arrives within the timeout.
+func (t *transfer) sendWindow(ctx context.Context, window [][]byte, acked, last int64) (int64, error) {
+	buf := make([]byte, maxRequestSize)
+	for try := 0; try <= t.retries; try++ {
+		for _, pkt := range window {
+			if _, err := t.conn.WriteTo(pkt, t.peer); err != nil {
+				return 0, err
+			}
+		}
+		if err := t.conn.SetReadDeadline(time.Now().Add(t.timeout)); err != nil {
+			return 0, err
+		}
+		for {
+			n, addr, err := t.conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return 0, ctx.Err()
+				}
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return 0, err
+			}
+			if a, ok := addr.(*net.UDPAddr); !ok || !a.IP.Equal(t.peer.IP) || a.Port != t.peer.Port {
+				_, _ = t.conn.WriteTo(errorPacket(errCodeUnknownTID, "unknown transfer ID"), addr)
+				continue
+			}
+			if n < 4 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(buf) {
+			case opACK:
+				b := binary.BigEndian.Uint16(buf[2:])
+				// an acknowledgement of a block in the window before last means the client missed the blocks after it,
+				// the next window starts after it, see RFC 7440 section 4.
+				// Acknowledgements of blocks before the window are duplicates and are ignored,
+				// sending the window again for them would double the traffic, see RFC 1350 section 6.
+				for k := last; k > acked; k-- {
+					if uint16(k) == b {
+						return k, nil
+					}
+				}
+			case opERROR:
+				return 0, fmt.Errorf("client error %d: %s", binary.BigEndian.Uint16(buf[2:]), strings.TrimRight(string(buf[4:n]), "\x00"))
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no acknowledgement after %d retries", t.retries)
+}